@@ -2,31 +2,57 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"github.com/amankumarsingh77/search_engine/config"
 	"github.com/amankumarsingh77/search_engine/internal/common/database"
 	"github.com/amankumarsingh77/search_engine/internal/crawler"
 	"github.com/amankumarsingh77/search_engine/internal/indexer"
+	"github.com/amankumarsingh77/search_engine/internal/lemmatizer"
 	"github.com/amankumarsingh77/search_engine/internal/query"
+	"github.com/amankumarsingh77/search_engine/internal/telemetry"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
 	"github.com/amankumarsingh77/search_engine/pkg/search"
+	"github.com/exaring/otelpgx"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/template/html/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
+// indexCheckpointKey holds the id of the last crawled page the indexer has
+// committed along with every page before it.
+const indexCheckpointKey = "last_indexed_object_id"
+
 func main() {
 	var (
 		configFile = flag.String("config", "crawler.yaml", "Path to configuration file")
-		mode       = flag.String("mode", "crawl", "Mode: crawl, tfidf, search, indexer or seed")
+		mode       = flag.String("mode", "crawl", "Mode: crawl, tfidf, search, indexer, seed, lemma-report, politeness-report, discover-seeds, failed, save-job, list-jobs, schedule, stats, prune-terms, delete, anchors, maintain, migrate, index-stats, verify, export, import or es-export")
 		workers    = flag.Int("workers", 3, "Number of worker goroutines")
 		seedFile   = flag.String("seedfile", "seed_urls.csv", "Path to seed URLs file")
+		sampleSize = flag.Int("sample", 1000, "Number of crawled documents to analyze in lemma-report mode")
+		jobName    = flag.String("job", "", "Crawl job to use in crawl, seed, discover-seeds, failed, stats, delete, anchors and es-export modes; empty uses the config")
+		jobFile    = flag.String("jobfile", "crawl_job.json", "Path to a crawl job definition in save-job mode")
+		reason     = flag.String("reason", "", "Only failed items whose reason contains this, in failed mode")
+		host       = flag.String("host", "", "Only failed items from this host or its subdomains, in failed mode")
+		limit      = flag.Int("limit", 100, "Maximum failed items to list in failed mode (0 lists all), largest terms in index-stats mode, or examples per check in verify mode")
+		requeue    = flag.Bool("requeue", false, "Requeue the matching failed items instead of listing them, in failed mode")
+		format     = flag.String("format", "json", "Report format in stats mode: json or csv; index-stats is always json")
+		outFile    = flag.String("out", "", "File to write the stats, index-stats or verify report or the export dump to; empty writes to stdout")
+		urlFile    = flag.String("urls", "", "File of URLs to remove from the index in delete mode, one per line")
+		inFile     = flag.String("in", "", "Index dump to load in import mode")
+		repair     = flag.Bool("repair", false, "Fix the problems found, in verify mode")
+		fromCrawl  = flag.Bool("from-crawl", false, "Also remove crawled pages that now fail or are noindex, in delete mode")
 	)
 	flag.Parse()
 
@@ -46,6 +72,18 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shutdownTracing, err := telemetry.Setup(ctx, &cfg.Tracing, *mode)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
@@ -56,40 +94,148 @@ func main() {
 
 	switch *mode {
 	case "crawl":
-		webCrawler, err := crawler.NewWebCrawler(ctx, cfg)
+		webCrawler, err := newCrawler(ctx, cfg, *jobName)
 		if err != nil {
 			log.Fatalf("Failed to initialize the crawler: %v", err)
 		}
 		webCrawler.RunCrawler(ctx)
 
 	case "seed":
-		webCrawler, err := crawler.NewWebCrawler(ctx, cfg)
+		webCrawler, err := newCrawler(ctx, cfg, *jobName)
 		if err != nil {
 			log.Fatalf("Failed to initialize the crawler: %v", err)
 		}
-		webCrawler.SeedUrls(*seedFile)
+		if *jobName != "" {
+			webCrawler.SeedJob()
+		} else {
+			webCrawler.SeedUrls(*seedFile)
+		}
+
+	case "save-job":
+		data, err := os.ReadFile(*jobFile)
+		if err != nil {
+			log.Fatalf("Failed to read crawl job: %v", err)
+		}
+		var job models.CrawlJob
+		if err = json.Unmarshal(data, &job); err != nil {
+			log.Fatalf("Failed to parse crawl job: %v", err)
+		}
+		if err = crawler.ValidateCrawlJob(&job); err != nil {
+			log.Fatal(err)
+		}
+		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = mongoClient.SaveCrawlJob(ctx, &job); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Saved crawl job %s (pages go to %s)", job.Name, job.PagesCollection())
+
+	case "list-jobs":
+		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jobs, err := mongoClient.ListCrawlJobs(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := json.MarshalIndent(jobs, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+		os.Stdout.WriteString("\n")
 
 	case "indexer":
-		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		// Search reads a Badger index as it was when opened, and not while
+		// the indexer has it, so indexing that never finishes would never be
+		// searchable.
+		if cfg.Index.Backend == indexer.BackendBadger {
+			if cfg.Stream.Enabled {
+				log.Fatal("Stream.Enabled can't be used with Index.Backend badger; index in runs, or use sqlite")
+			}
+			if cfg.Index.TTL > 0 || len(cfg.Index.DomainTTLs) > 0 {
+				log.Fatal("Index.TTL and Index.DomainTTLs can't be used with Index.Backend badger; use sqlite or postgres")
+			}
+		}
+		adapter, err := indexer.OpenStore(&cfg.Index)
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer adapter.Close()
 
-		batchProcessor := indexer.NewBatchProcessor(adapter)
+		analyzer, err := textproc.New(&cfg.Analyzer)
+		if err != nil {
+			log.Fatalf("Invalid analyzer config: %v", err)
+		}
+		batchProcessor := indexer.NewBatchProcessor(adapter, &cfg.Index, analyzer)
 		idx := indexer.NewIndexer(&cfg.Index, adapter, batchProcessor)
-		defer idx.Close()
+		if cfg.Index.TTL > 0 || len(cfg.Index.DomainTTLs) > 0 {
+			webCrawler, err := newCrawler(ctx, cfg, *jobName)
+			if err != nil {
+				log.Fatalf("Failed to initialize the crawler for recrawls: %v", err)
+			}
+			idx.OnExpired(func(ctx context.Context, docs []indexer.ExpiredDocument) error {
+				urls := make([]string, len(docs))
+				ids := make([]int64, len(docs))
+				for n, doc := range docs {
+					urls[n], ids[n] = doc.URL, doc.ID
+				}
+				if cfg.Index.SearchURL != "" {
+					if err := indexer.InvalidateSearchCaches(ctx, cfg.Index.SearchURL, cfg.Search.AdminAPIKey, ids); err != nil {
+						log.Printf("Expired documents may be served from cache until it expires: %v", err)
+					}
+				}
+				queued, err := webCrawler.Recrawl(ctx, urls)
+				log.Printf("Queued %d of %d expired documents for a recrawl", queued, len(urls))
+				return err
+			})
+		}
+		if cfg.Index.MetricsAddr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", idx.MetricsHandler())
+			metricsServer := &http.Server{Addr: cfg.Index.MetricsAddr, Handler: mux}
+			go func() {
+				if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log.Printf("Indexer metrics server stopped: %v", err)
+				}
+			}()
+			defer metricsServer.Close()
+		}
 
-		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+		redisClient, err := crawler.NewRedisClient(ctx, &cfg.Redis)
 		if err != nil {
 			log.Fatal(err)
 		}
-		redisClient, err := crawler.NewRedisClient(ctx, &cfg.Redis)
+		if cfg.Stream.Enabled {
+			consumer := indexer.NewStreamConsumer(redisClient, &cfg.Stream, cfg.Index.BatchSize)
+			idx.SetCheckpoint(consumer.Ack)
+			indexed := make(chan struct{})
+			go func() {
+				defer close(indexed)
+				idx.Start()
+			}()
+			log.Println("Indexing pages from the crawl stream")
+			if err := consumer.Run(ctx, idx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Stream consumer stopped: %v", err)
+			}
+			log.Println("Stopping indexer ....")
+			idx.Close()
+			<-indexed
+			if skipped := idx.Skipped(); len(skipped) > 0 {
+				log.Printf("Pages skipped by the quality gate: %v", skipped)
+			}
+			return
+		}
+
+		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
 		if err != nil {
 			log.Fatal(err)
 		}
 		var lastID *primitive.ObjectID
-		ID, err := redisClient.Get(ctx, "last_indexed_object_id").Result()
+		ID, err := redisClient.Get(ctx, indexCheckpointKey).Result()
 		if err == nil && ID != "" {
 			oid, err := primitive.ObjectIDFromHex(ID)
 			if err == nil {
@@ -97,39 +243,33 @@ func main() {
 				log.Printf("Received last process id : %s", lastID.Hex())
 			}
 		}
+		// The indexer saves the checkpoint itself once a page and all pages
+		// before it are committed; lastID below is only how far we've read.
+		idx.SetCheckpoint(func(ctx context.Context, id primitive.ObjectID) error {
+			return redisClient.Set(ctx, indexCheckpointKey, id.Hex(), 0).Err()
+		})
 
+		indexed := make(chan struct{})
 		go func() {
+			defer close(indexed)
 			defer func() {
 				if r := recover(); r != nil {
 					log.Printf("Recovered in indexer goroutine: %v", r)
-					//cancel()
-				}
-				if lastID != nil {
-					log.Println("Saving last indexed ID")
-					err = redisClient.Set(ctx, "last_indexed_object_id", lastID.Hex(), 0).Err()
-					if err != nil {
-						log.Printf("Failed to save lastID to Redis: %v", err)
-					}
 				}
 			}()
 			idx.Start()
 		}()
+		// Queued documents are committed, and the checkpoint saved, before
+		// the indexer exits.
+		stop := func() {
+			idx.Close()
+			<-indexed
+		}
 		for {
 			select {
 			case <-ctx.Done():
 				log.Println("Context cancelled, stopping indexer ....")
-				if lastID != nil {
-					log.Println("Saving last indexed ID")
-					redisCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-					defer cancel()
-
-					err := redisClient.Set(redisCtx, "last_indexed_object_id", lastID.Hex(), 0).Err()
-					if err != nil {
-						log.Printf("Failed to save lastID to Redis: %v", err)
-					} else {
-						log.Println("Successfully saved lastID to Redis.")
-					}
-				}
+				stop()
 				return
 			default:
 				docs, lastProcessedID, err := mongoClient.GetBatchWebPage(cfg.Index.BatchSize, lastID, true)
@@ -140,45 +280,538 @@ func main() {
 				}
 				if len(docs) == 0 {
 					log.Println("No more documents to process.")
-					//time.Sleep(5 * time.Second)
-					//continue
+					stop()
+					if skipped := idx.Skipped(); len(skipped) > 0 {
+						log.Printf("Pages skipped by the quality gate: %v", skipped)
+					}
 					return
 				}
+				enqueued := true
 				for _, doc := range docs {
-					idx.AddDocument(doc)
+					for {
+						err := idx.AddDocument(ctx, doc)
+						if errors.Is(err, indexer.ErrQueueFull) {
+							log.Printf("Indexer is falling behind, retrying: %v", err)
+							continue
+						}
+						if err != nil {
+							enqueued = false
+						}
+						break
+					}
+					if !enqueued {
+						break
+					}
+				}
+				if enqueued {
+					lastID = lastProcessedID
 				}
-				lastID = lastProcessedID
 			}
 
 		}
 
-	case "tfidf":
-		log.Println("TF-IDF mode selected (not yet implemented).")
+	case "lemma-report":
+		lemmas, err := lemmatizer.New(cfg.Index.LemmaLang)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		docs, _, err := mongoClient.GetBatchWebPage(*sampleSize, nil, false)
+		if err != nil {
+			log.Fatalf("Failed to load sample documents: %v", err)
+		}
+		report := indexer.CompareAnalyzers(docs, lemmas)
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+		os.Stdout.WriteString("\n")
 
-	case "search":
-		if cfg.Index.DBURL == "" {
-			log.Fatal("DBURL is empty in config")
+	case "politeness-report":
+		webCrawler, err := crawler.NewWebCrawler(ctx, cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize the crawler: %v", err)
 		}
+		report, err := webCrawler.PolitenessReport(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+		os.Stdout.WriteString("\n")
 
-		pgConfig, err := pgxpool.ParseConfig(cfg.Index.DBURL)
+	case "stats":
+		if *format != "json" && *format != "csv" {
+			log.Fatalf("Unknown report format %q", *format)
+		}
+		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer mongoClient.Disconnect()
+		if *jobName != "" {
+			job, err := mongoClient.GetCrawlJob(ctx, *jobName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			mongoClient = mongoClient.WithCrawlerColl(job.PagesCollection())
+		}
+		stats := indexer.NewCrawlStats()
+		err = mongoClient.EachWebPage(ctx, func(page *models.WebPage) error {
+			stats.Add(page)
+			return nil
+		})
 		if err != nil {
-			log.Fatalf("failed to parse PostgreSQL config: %w", err)
+			log.Fatalf("Failed to scan crawled pages: %v", err)
 		}
+		stats.Finish()
 
-		pgConfig.MaxConns = int32(cfg.Workers * 2)
-		pgConfig.MinConns = 1
+		out := os.Stdout
+		if *outFile != "" {
+			if out, err = os.Create(*outFile); err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+		}
+		if *format == "csv" {
+			err = stats.WriteCSV(out)
+		} else {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(stats)
+		}
+		if err != nil {
+			log.Fatalf("Failed to write stats report: %v", err)
+		}
 
+	case "failed":
+		webCrawler, err := newCrawler(ctx, cfg, *jobName)
+		if err != nil {
+			log.Fatalf("Failed to initialize the crawler: %v", err)
+		}
+		filter := crawler.FailedFilter{Reason: *reason, Host: *host}
+		if *requeue {
+			n, err := webCrawler.RequeueFailed(ctx, filter)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Requeued %d failed items", n)
+			return
+		}
+		items, err := webCrawler.FailedItems(ctx, filter, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+		os.Stdout.WriteString("\n")
+
+	case "schedule":
+		scheduler, err := crawler.NewScheduler(ctx, cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize the scheduler: %v", err)
+		}
+		scheduler.Run(ctx)
+
+	case "discover-seeds":
+		webCrawler, err := newCrawler(ctx, cfg, *jobName)
+		if err != nil {
+			log.Fatalf("Failed to initialize the crawler: %v", err)
+		}
+		n, err := webCrawler.DiscoverSeeds(ctx)
+		if err != nil {
+			log.Fatalf("Seed discovery failed: %v", err)
+		}
+		log.Printf("Queued %d seed candidates for review", n)
+
+	case "prune-terms":
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		pruned, err := adapter.PruneTerms(ctx, indexer.PruneOptions{
+			MinDocFreq: cfg.Index.PruneMinDocFreq,
+			MinAge:     cfg.Index.PruneMinAge,
+			BatchSize:  cfg.Index.PruneBatchSize,
+		})
+		if err != nil {
+			log.Printf("Term pruning stopped after %d terms: %v", pruned, err)
+			return
+		}
+		log.Printf("Pruned %d terms", pruned)
+
+	case "delete":
+		var urls []string
+		if *urlFile != "" {
+			f, err := os.Open(*urlFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			urls, err = indexer.ReadURLList(f)
+			f.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *fromCrawl {
+			mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer mongoClient.Disconnect()
+			if *jobName != "" {
+				job, err := mongoClient.GetCrawlJob(ctx, *jobName)
+				if err != nil {
+					log.Fatal(err)
+				}
+				mongoClient = mongoClient.WithCrawlerColl(job.PagesCollection())
+			}
+			err = mongoClient.EachWebPage(ctx, func(page *models.WebPage) error {
+				if page.IsErrorStatus() || page.NoIndex {
+					urls = append(urls, page.URL)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Fatalf("Failed to scan crawled pages: %v", err)
+			}
+		}
+		if len(urls) == 0 {
+			log.Fatal("Nothing to delete; pass -urls or -from-crawl")
+		}
+
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		deleted, err := adapter.DeleteDocuments(ctx, urls, nil)
+		if err != nil {
+			log.Printf("Deletion stopped after %d documents: %v", len(deleted), err)
+		} else {
+			log.Printf("Deleted %d of %d listed documents", len(deleted), len(urls))
+		}
+		if len(deleted) > 0 && cfg.Index.SearchURL != "" {
+			if err := indexer.InvalidateSearchCaches(ctx, cfg.Index.SearchURL, cfg.Search.AdminAPIKey, deleted); err != nil {
+				log.Printf("Deleted documents may be served from cache until it expires: %v", err)
+			}
+		}
+
+	case "anchors":
+		// Records the anchor text of every crawled page's internal links, so
+		// a full index build sees inbound anchors even for pages indexed
+		// before the pages linking to them.
+		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer mongoClient.Disconnect()
+		if *jobName != "" {
+			job, err := mongoClient.GetCrawlJob(ctx, *jobName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			mongoClient = mongoClient.WithCrawlerColl(job.PagesCollection())
+		}
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+
+		var pages []*models.WebPage
+		var recorded int
+		flush := func() error {
+			if err := adapter.ReplaceAnchors(ctx, pages); err != nil {
+				return err
+			}
+			recorded += len(pages)
+			pages = pages[:0]
+			return nil
+		}
+		err = mongoClient.EachWebPage(ctx, func(page *models.WebPage) error {
+			pages = append(pages, page)
+			if len(pages) < 500 {
+				return nil
+			}
+			return flush()
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			log.Fatalf("Recording anchors stopped after %d pages: %v", recorded, err)
+		}
+		log.Printf("Recorded the anchors of %d pages", recorded)
+
+	case "maintain":
+		// One pass of the housekeeping the indexer does on a schedule, for
+		// indexes that aren't being written to.
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		var purged int64
+		for {
+			n, err := adapter.PurgeDeletedDocuments(ctx, cfg.Index.PurgeBatchSize)
+			if err != nil {
+				log.Fatal(err)
+			}
+			purged += n
+			if n == 0 {
+				break
+			}
+		}
+		log.Printf("Purged %d deleted documents", purged)
+		if err := adapter.RefreshTermFrequencies(ctx); err != nil {
+			log.Fatal(err)
+		}
+		if err := adapter.Analyze(ctx); err != nil {
+			log.Fatal(err)
+		}
+		rebuilt, err := adapter.RebuildBloatedIndexes(ctx, indexer.ReindexOptions{
+			BloatRatio: cfg.Index.ReindexBloatRatio,
+			MinBytes:   cfg.Index.ReindexMinBytes,
+		})
+		for _, b := range rebuilt {
+			log.Printf("Rebuilt index %s, %d bytes at %.1fx its estimated size", b.Name, b.Bytes, b.Ratio)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Maintenance done, %d indexes rebuilt", len(rebuilt))
+
+	case "migrate":
+		// Opening the index applies any pending migrations.
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		migrations, err := adapter.Migrations(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range migrations {
+			log.Printf("%04d_%s applied %s", m.Version, m.Name, m.AppliedAt.Format(time.RFC3339))
+		}
+
+	case "index-stats":
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		stats, err := adapter.IndexStats(ctx, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out := os.Stdout
+		if *outFile != "" {
+			if out, err = os.Create(*outFile); err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			log.Fatalf("Failed to write index stats: %v", err)
+		}
+
+	case "verify":
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		report, deleted, err := adapter.Verify(ctx, indexer.VerifyOptions{Samples: *limit, Repair: *repair})
+		if len(deleted) > 0 && cfg.Index.SearchURL != "" {
+			if err := indexer.InvalidateSearchCaches(ctx, cfg.Index.SearchURL, cfg.Search.AdminAPIKey, deleted); err != nil {
+				log.Printf("Deleted documents may be served from cache until it expires: %v", err)
+			}
+		}
+		if err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		out := os.Stdout
+		if *outFile != "" {
+			if out, err = os.Create(*outFile); err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("Failed to write verify report: %v", err)
+		}
+		switch {
+		case report.Problems() == 0:
+			log.Println("Index is consistent")
+		case *repair:
+			log.Printf("Repaired %d problems", report.Problems())
+		default:
+			log.Printf("Found %d problems; run with -repair to fix them", report.Problems())
+		}
+
+	case "export":
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		out := os.Stdout
+		if *outFile != "" {
+			if out, err = os.Create(*outFile); err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+		}
+		counts, err := adapter.Export(ctx, out)
+		if err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		log.Printf("Exported %v", counts)
+
+	case "es-export":
+		mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer mongoClient.Disconnect()
+		if *jobName != "" {
+			job, err := mongoClient.GetCrawlJob(ctx, *jobName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			mongoClient = mongoClient.WithCrawlerColl(job.PagesCollection())
+		}
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		exporter, err := indexer.NewElasticExporter(adapter, &cfg.Elastic)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := exporter.CreateIndex(ctx, query.FieldBoosts(&cfg.Query)); err != nil {
+			log.Fatal(err)
+		}
+		err = mongoClient.EachWebPage(ctx, func(page *models.WebPage) error {
+			return exporter.Add(ctx, page)
+		})
+		if err == nil {
+			err = exporter.Flush(ctx)
+		}
+		if err != nil {
+			log.Fatalf("Export to Elasticsearch stopped after %d documents: %v", exporter.Exported, err)
+		}
+		log.Printf("Exported %d documents to Elasticsearch, skipped %d pages that aren't indexed", exporter.Exported, exporter.Skipped)
+
+	case "import":
+		if *inFile == "" {
+			log.Fatal("import mode needs -in")
+		}
+		f, err := os.Open(*inFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		adapter, err := indexer.NewPostgresClient(&cfg.Index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer adapter.Close()
+		counts, err := adapter.Import(ctx, f)
+		if err != nil {
+			log.Fatalf("Import failed after %v: %v", counts, err)
+		}
+		log.Printf("Imported %v", counts)
+
+	case "tfidf":
+		log.Println("TF-IDF mode selected (not yet implemented).")
+
+	case "search":
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		dbPool, err := pgxpool.NewWithConfig(ctx, pgConfig)
-		if err != nil {
-			log.Fatalf("failed to create PostgreSQL connection pool: %w", err)
+		// Postgres is searched on a pool of its own, which also serves the
+		// index stats; the embedded backends are opened read-only.
+		var index query.Index
+		var indexStorage *indexer.Storage
+		if cfg.Index.Backend == "" || cfg.Index.Backend == indexer.BackendPostgres {
+			if cfg.Index.DBURL == "" {
+				log.Fatal("DBURL is empty in config")
+			}
+
+			pgConfig, err := pgxpool.ParseConfig(cfg.Index.DBURL)
+			if err != nil {
+				log.Fatalf("failed to parse PostgreSQL config: %v", err)
+			}
+
+			pgConfig.MaxConns = int32(cfg.Workers * 2)
+			pgConfig.MinConns = 1
+			pgConfig.ConnConfig.Tracer = otelpgx.NewTracer()
+
+			dbPool, err := pgxpool.NewWithConfig(ctx, pgConfig)
+			if err != nil {
+				log.Fatalf("failed to create PostgreSQL connection pool: %v", err)
+			}
+			defer dbPool.Close()
+			index = query.NewPostgresIndex(dbPool)
+			indexStorage = indexer.NewStorage(dbPool)
+		} else {
+			searchIndex, err := indexer.OpenSearchIndex(&cfg.Index)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer searchIndex.Close()
+			index = searchIndex
 		}
-		defer dbPool.Close()
 		log.Println("Search mode selected (not yet implemented).")
-		searchAPI := search.NewSearchAPI(dbPool, &cfg.Query)
-		queryEngine := query.NewQueryEngine(dbPool, &cfg.Query)
+		analyzer, err := textproc.New(&cfg.Analyzer)
+		if err != nil {
+			log.Fatalf("Invalid analyzer config: %v", err)
+		}
+		if err := query.CheckRanker(cfg.Query.Ranker); err != nil {
+			log.Fatalf("Invalid query config: %v", err)
+		}
+		queryEngine := query.NewQueryEngine(index, &cfg.Query, analyzer)
+		if cfg.Query.RemoteCache.Enabled {
+			redisClient, err := crawler.NewRedisClient(ctx, &cfg.Redis)
+			if err != nil {
+				log.Fatalf("failed to connect to the remote query cache: %v", err)
+			}
+			defer redisClient.Close()
+			queryEngine.UseRemoteCache(context.Background(), redisClient, &cfg.Query.RemoteCache)
+		}
+		searchAPI := search.NewSearchAPI(queryEngine, &cfg.Search)
+		if indexStorage != nil {
+			searchAPI.SetIndexStorage(indexStorage)
+		}
+
+		if cfg.Query.CacheSnapshotPath != "" {
+			loaded, err := queryEngine.LoadCacheSnapshot(cfg.Query.CacheSnapshotPath)
+			if err != nil {
+				log.Printf("Failed to load cache snapshot: %v", err)
+			} else {
+				log.Printf("Loaded %d cache entries from snapshot", loaded)
+			}
+		}
 
 		// Initialize Fiber app
 		app := fiber.New(fiber.Config{
@@ -188,16 +821,14 @@ func main() {
 			Views:        html.New("./views", ".html"),
 		})
 		app.Use(cors.New())
+		app.Use(requestid.New())
 
 		// Register routes
 		searchAPI.RegisterRoutes(app) // Make sure this is adapted for *fiber.App
 
-		// Warm up cache
+		// Warm up cache in the background; /readyz reports unready until done
 		if cfg.Search.WarmCache {
-			log.Println("Warming up query cache...")
-			if err := queryEngine.WarmCache(context.Background(), 1000); err != nil {
-				log.Printf("Cache warm-up failed: %v", err)
-			}
+			searchAPI.Warmup(context.Background())
 		}
 
 		// Run server in goroutine
@@ -222,8 +853,25 @@ func main() {
 			log.Printf("Fiber shutdown failed: %v", err)
 		}
 
+		if cfg.Query.CacheSnapshotPath != "" {
+			if err := queryEngine.SaveCacheSnapshot(cfg.Query.CacheSnapshotPath); err != nil {
+				log.Printf("Failed to save cache snapshot: %v", err)
+			} else {
+				log.Println("Saved cache snapshot")
+			}
+		}
+
 		log.Println("Server exited properly")
 	default:
-		log.Fatalf("Unknown mode: %s. Use crawl, tfidf, search, indexer, or seed.", *mode)
+		log.Fatalf("Unknown mode: %s. Use crawl, tfidf, search, indexer, seed, lemma-report, politeness-report, discover-seeds, failed, save-job, list-jobs, schedule, or prune-terms.", *mode)
+	}
+}
+
+// newCrawler builds the spider for a named crawl job, or for the crawl the
+// config describes when no job is given.
+func newCrawler(ctx context.Context, cfg *config.CrawlerConfig, job string) (*crawler.Spider, error) {
+	if job == "" {
+		return crawler.NewWebCrawler(ctx, cfg)
 	}
+	return crawler.NewJobCrawler(ctx, cfg, job)
 }
@@ -8,24 +8,350 @@ type CrawlerConfig struct {
 	MaxDepth     int64
 	Workers      int
 	APIADDR      int
+	MetricsAddr  string
+	AdminAddr    string
+	AdminAPIKey  string
 	Redis        RedisConfig
 	DB           PostgresConfig
 	Mongo        MongoConfig
 	Index        IndexerConfig
+	Elastic      ElasticConfig
+	Stream       StreamConfig
 	Query        QueryEngineConfig
 	Search       SearchAPIConfig
+	Traps        TrapConfig
+	HTTP         HTTPConfig
+	Cluster      ClusterConfig
+	Politeness   PolitenessConfig
+	Frontier     FrontierConfig
+	Render       RenderConfig
+	Filters      URLFilterConfig
+	Discovery    SeedDiscoveryConfig
+	Scheduler    SchedulerConfig
+	Worker       WorkerConfig
+	Tracing      TracingConfig
+	Analyzer     AnalyzerConfig
+
+	// ShutdownTimeout bounds how long an interrupted crawl waits for
+	// in-flight fetches before checkpointing.
+	ShutdownTimeout time.Duration
+}
+
+// StreamConfig streams crawled pages to the indexer through a Redis Stream
+// instead of the indexer polling Mongo. When Enabled the crawler appends
+// each page it stores to Key, trimmed to about MaxLen entries, and indexer
+// mode reads Key as Consumer of Group, acknowledging a page once it is
+// committed to the index. Block is how long a read waits for new pages.
+type StreamConfig struct {
+	Enabled  bool
+	Key      string
+	Group    string
+	Consumer string
+	MaxLen   int64
+	Block    time.Duration
+}
+
+// SeedDiscoveryConfig sets how often an external host must be linked from
+// crawled pages before it is proposed as a seed.
+type SeedDiscoveryConfig struct {
+	MinLinkingPages int
+	MinLinkingHosts int
+	// Interval reruns discovery during crawls; zero leaves it to the
+	// discover-seeds mode.
+	Interval time.Duration
+}
+
+// AnalyzerConfig is the text analysis pipeline shared by the indexer and
+// the query engine: char filters rewrite the raw text, the tokenizer splits
+// it and token filters drop or rewrite tokens, each in the order listed.
+// Left empty, each part uses the built-in defaults.
+type AnalyzerConfig struct {
+	CharFilters  []FilterConfig
+	Tokenizer    string
+	TokenFilters []FilterConfig
+	Morphology   MorphologyConfig
+}
+
+// MorphologyConfig picks how each field's words become index terms: "stem"
+// (Porter), "lemma" (dictionary lemmas for Lang) or "none". Setting either
+// field replaces the porter_stem and lemmatize token filters; an empty
+// field then stems.
+type MorphologyConfig struct {
+	Title string
+	Body  string
+	Lang  string
+}
+
+// FilterConfig selects a filter by Type; the other fields are its options
+// and only the ones a filter uses are read. Stage limits a token filter to
+// "index" or "query" time; empty runs it at both.
+type FilterConfig struct {
+	Type        string
+	Stage       string
+	Pattern     string
+	Replacement string
+	Words       []string
+	Remove      []string
+	File        string
+	Min         int
+	Max         int
+	Lang        string
+}
+
+// TracingConfig exports OpenTelemetry spans over OTLP/HTTP. Endpoint is a
+// host:port such as localhost:4318.
+type TracingConfig struct {
+	Enabled     bool
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	// SampleRatio is the fraction of new traces recorded; traces started
+	// upstream follow the caller's sampling decision.
+	SampleRatio float64
+}
+
+// WorkerConfig sets how many URLs each worker claims per batch and how many
+// of them it fetches at once. Domains lowers the concurrency for particular
+// domains and their subdomains.
+type WorkerConfig struct {
+	BatchSize   int
+	Concurrency int
+	Domains     []WorkerDomainConfig
+
+	// FollowExternalLinks seeds links to other hosts as well as internal
+	// ones. The URL filters still apply.
+	FollowExternalLinks bool
+}
+
+type WorkerDomainConfig struct {
+	Domain      string
+	Concurrency int
+}
+
+// SchedulerConfig lists the crawl jobs the scheduler mode runs and when.
+// Jobs not listed here still run if their stored definition has a schedule.
+type SchedulerConfig struct {
+	Jobs []ScheduledJobConfig
+	// MaxRunTime stops a scheduled crawl that has not drained its frontier.
+	MaxRunTime time.Duration
+}
+
+type ScheduledJobConfig struct {
+	Job      string
+	Schedule string
+}
+
+type URLFilterConfig struct {
+	AllowDomains []string
+	BlockDomains []string
+	AllowPaths   []string
+	BlockPaths   []string
+}
+
+// RenderConfig controls the headless browser used for JavaScript-heavy sites.
+// Only hosts listed in Domains (and their subdomains) are rendered.
+type RenderConfig struct {
+	Enabled    bool
+	ChromePath string
+	Domains    []string
+	Timeout    time.Duration
+	JSBudget   time.Duration
+	UserAgent  string
+}
+
+type FrontierConfig struct {
+	// MaxPendingAge is how long a URL may wait in pending before it is
+	// considered stale. Zero disables expiry.
+	MaxPendingAge time.Duration
+	// StalePolicy is "drop" or "requeue".
+	StalePolicy string
+	// HostBatchLimit caps how many URLs from one host a single NextBatch
+	// returns.
+	HostBatchLimit int
+}
+
+type PolitenessConfig struct {
+	MinDelay      time.Duration
+	MaxDelay      time.Duration
+	InitialDelay  time.Duration
+	TargetLatency time.Duration
+	MaxErrorRate  float64
+	Window        int
+	// Audit records per-host request rates, 429s and robots.txt
+	// compliance for the politeness-report mode.
+	Audit bool
+}
+
+type ClusterConfig struct {
+	Enabled           bool
+	NodeID            string
+	Slots             int
+	VirtualNodes      int
+	HeartbeatInterval time.Duration
+	NodeTTL           time.Duration
+}
+
+type HTTPConfig struct {
+	MaxBodySize         int64
+	AllowedContentTypes []string
+	HeadCheck           bool
+	DNSCacheTTL         time.Duration
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	MaxRedirects        int
+	EnableHTTP2         bool
+	KeepAlive           time.Duration
+	// CookieJar keeps cookies set by each host for later requests to it.
+	CookieJar bool
+	// DomainHeaders adds headers to requests for a domain and its
+	// subdomains.
+	DomainHeaders []DomainHeaderConfig
+
+	// Timeout bounds a whole request, body included (10s by default).
+	// Timeouts overrides it for slow domains and their subdomains.
+	Timeout  time.Duration
+	Timeouts []HostTimeoutConfig
+
+	// CABundle is a PEM file of CAs trusted on top of the system roots.
+	// InsecureSkipVerify lists domains, such as staging hosts with
+	// self-signed certificates, whose certificates are not verified.
+	// MinTLSVersion is "1.0" to "1.3" and defaults to 1.2.
+	CABundle           string
+	InsecureSkipVerify []string
+	MinTLSVersion      string
+}
+
+type HostTimeoutConfig struct {
+	Domain  string
+	Timeout time.Duration
+}
+
+// DomainHeaderConfig is a list entry rather than a map keyed by domain
+// because viper splits keys on dots.
+type DomainHeaderConfig struct {
+	Domain  string
+	Headers map[string]string
+}
+
+type TrapConfig struct {
+	MaxURLLength      int
+	MaxSegmentRepeats int
+	MaxURLsPerHost    int64
+	MaxCalendarDepth  int
+	// HostCountWindow is how long MaxURLsPerHost counts a host's URLs for
+	// before its count starts over.
+	HostCountWindow time.Duration
 }
 
 type IndexerConfig struct {
+	// Backend selects the index store: "postgres" (the default),
+	// "badger", an embedded index kept in the directory Path, or "sqlite",
+	// the Postgres tables in the file Path. Badger lets one process open it
+	// at a time, so index first, then serve searches; SQLite serves
+	// searches while the indexer writes.
+	Backend   string
+	Path      string
 	DBURL     string
 	PoolSize  int
 	Workers   int
 	BatchSize int
+	// Quality gate: pages analyzing to fewer than MinTokens tokens, or with
+	// an empty title when RequireTitle is set, are not indexed. Failed
+	// fetches, error statuses and noindex pages never are.
+	MinTokens    int
+	RequireTitle bool
+	// TermCacheSize bounds the term ids the indexer keeps in memory
+	// (default 500000).
+	TermCacheSize int
+	// LemmaLang is the language lemma-report compares against. Only "en"
+	// has a lemma table; other values are rejected.
+	LemmaLang string
+	// IndexPassages records each paragraph's token range so queries can
+	// return the best-matching passage of a document.
+	IndexPassages bool
+	// BulkLoad writes postings with COPY through an unlogged staging
+	// table instead of batched INSERTs. Much faster for initial builds.
+	BulkLoad bool
+
+	// QueueSize bounds the documents waiting for a worker (default twice
+	// BatchSize). A producer blocked for EnqueueTimeout gets an error and
+	// retries. MaxBatchBytes cuts a batch short once its text reaches it,
+	// and MaxInFlightBatches caps the batches processed at once (default
+	// Workers).
+	QueueSize          int
+	EnqueueTimeout     time.Duration
+	MaxBatchBytes      int64
+	MaxInFlightBatches int
+
+	PruneMinDocFreq int
+	PruneMinAge     time.Duration
+	PruneBatchSize  int
+
+	// PurgeInterval is how often the indexer removes the rows of deleted
+	// documents, PurgeBatchSize documents at a time until none are left.
+	PurgeInterval  time.Duration
+	PurgeBatchSize int
+
+	// TTL expires documents that long after they were last indexed: every
+	// ExpiryInterval the indexer deletes them and queues their URLs for a
+	// recrawl. A domain listed in DomainTTLs, or one of its subdomains, uses
+	// that entry's TTL instead, the longest matching domain winning. Zero,
+	// globally or for a domain, never expires.
+	TTL            time.Duration
+	DomainTTLs     []DomainTTLConfig
+	ExpiryInterval time.Duration
+
+	// Maintenance while indexing: term_frequencies is refreshed every
+	// RefreshInterval, the tables are analyzed once AnalyzeAfterDocs
+	// documents were indexed since the last time, and every ReindexInterval
+	// indexes at least ReindexMinBytes large and ReindexBloatRatio times
+	// their estimated size are rebuilt.
+	RefreshInterval   time.Duration
+	AnalyzeAfterDocs  int
+	ReindexInterval   time.Duration
+	ReindexBloatRatio float64
+	ReindexMinBytes   int64
+
+	// MetricsAddr serves the indexer's Prometheus metrics at /metrics in
+	// indexer mode; empty disables them.
+	MetricsAddr string
+
+	// SearchURL is the search API told about deleted documents so it drops
+	// them from its caches; empty leaves them to expire.
+	SearchURL string
+}
+
+type DomainTTLConfig struct {
+	Domain string
+	TTL    time.Duration
+}
+
+// ElasticConfig is the Elasticsearch or OpenSearch cluster es-export writes
+// to. Username and Password use basic auth; APIKey is sent instead when set.
+type ElasticConfig struct {
+	URL       string
+	Index     string
+	Username  string
+	Password  string
+	APIKey    string
+	BatchSize int
 }
 
 type SearchAPIConfig struct {
-	WarmCache bool
-	HTTPAddr  string
+	WarmCache   bool
+	HTTPAddr    string
+	AdminAPIKey string
+
+	// WarmupTimeout caps how long /readyz waits on stats and cache warm-up.
+	WarmupTimeout time.Duration
+
+	// DuplicateQueryLimit is how many identical requests a client may make
+	// within DuplicateQueryWindow before being served its previous response
+	// with a growing delay. Zero disables throttling.
+	DuplicateQueryLimit    int
+	DuplicateQueryWindow   time.Duration
+	DuplicateQueryMaxDelay time.Duration
 }
 
 type RedisConfig struct {
@@ -46,13 +372,61 @@ type QueryEngineConfig struct {
 	BatchSize         int
 	CacheRefreshTime  time.Duration
 	DocumentCacheSize int
+	CacheSnapshotPath string
+	SnapshotMinHits   int64
+	ResultCacheSize   int
+	ResultCacheTTL    time.Duration
+
+	// SlowQueryThreshold logs searches slower than this; zero disables it.
+	SlowQueryThreshold time.Duration
+
+	// MatchTimeout, RankTimeout and FetchTimeout bound the stages of a
+	// search: resolving its terms and, separately, finding the matching
+	// documents; scoring them; and loading the page's details and
+	// passages. Zero keeps the defaults (2s, 2s, 1s); negative removes the
+	// bound.
+	MatchTimeout time.Duration
+	RankTimeout  time.Duration
+	FetchTimeout time.Duration
+
+	// FieldBoosts weight a term occurrence by the field it is in (title,
+	// description, keywords, headings, body, anchor) when scoring. Fields left out
+	// keep their default.
+	FieldBoosts map[string]float64
+
+	// Ranker is the ranking profile results are scored with: bm25 (the
+	// default), tfidf, cosine or hybrid. Requests can override it.
+	Ranker string
+
+	// SpellVocabularySize is how many of the most common terms "did you
+	// mean" suggestions are drawn from; negative disables suggestions.
+	SpellVocabularySize int
+	// SpellMaxDocFreq is the document frequency at or below which a query
+	// term is taken to be misspelled.
+	SpellMaxDocFreq int64
+
+	// RemoteCache shares the term, posting and result caches between
+	// search instances through Redis.
+	RemoteCache RemoteCacheConfig
+}
+
+// RemoteCacheConfig puts a Redis cache, reached through the top-level
+// Redis settings, behind each search instance's local caches.
+type RemoteCacheConfig struct {
+	Enabled bool
+	// Prefix starts every key, so deployments can share a Redis.
+	Prefix string
+	// TTL is how long entries live in Redis.
+	TTL time.Duration
 }
 
 type MongoConfig struct {
-	URI         string
-	Local       bool
-	DBName      string
-	CrawlerColl string
+	URI          string
+	Local        bool
+	DBName       string
+	CrawlerColl  string
+	URLEquivColl string
+	CrawlJobColl string
 }
 
 type PostgresConfig struct {
@@ -0,0 +1,206 @@
+// Package cache holds the in-memory LRU cache shared by the indexer and the
+// query engine.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type cacheItem struct {
+	key       interface{}
+	value     interface{}
+	expiresAt time.Time
+	hits      int64
+}
+
+// Entry is a live cache entry and how often it was read.
+type Entry struct {
+	Key   interface{}
+	Value interface{}
+	Hits  int64
+}
+
+// LRUCache is a size-bounded cache that evicts the least recently used
+// entry, with an optional TTL. It is safe for concurrent use.
+type LRUCache struct {
+	capacity int
+	ttl      time.Duration
+	cache    map[interface{}]*list.Element
+	list     *list.List
+	mu       sync.RWMutex
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// Stats counts the cache's lookups since it was created.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Size      int
+	Capacity  int
+}
+
+// HitRate is the share of lookups that found a live entry.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+	c := &LRUCache{
+		capacity: capacity,
+		ttl:      ttl,
+		cache:    make(map[interface{}]*list.Element),
+		list:     list.New(),
+	}
+
+	go c.cleanup()
+	return c
+}
+
+func (c *LRUCache) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.cache[key]; ok {
+		item := elem.Value.(*cacheItem)
+		if c.ttl > 0 && time.Now().After(item.expiresAt) {
+			c.removeElement(elem)
+			c.misses.Add(1)
+			return nil, false
+		}
+
+		c.list.MoveToFront(elem)
+		item.hits++
+		c.hits.Add(1)
+		return item.value, true
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+func (c *LRUCache) Put(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	expiresAt := now.Add(c.ttl)
+	if c.ttl == 0 {
+		expiresAt = time.Time{}
+	}
+
+	if elem, ok := c.cache[key]; ok {
+		c.list.MoveToFront(elem)
+		item := elem.Value.(*cacheItem)
+		item.value = value
+		item.expiresAt = expiresAt
+		return
+	}
+
+	if c.list.Len() >= c.capacity {
+		elem := c.list.Back()
+		if elem != nil {
+			c.removeElement(elem)
+			c.evictions.Add(1)
+		}
+	}
+
+	item := &cacheItem{key, value, expiresAt, 0}
+	elem := c.list.PushFront(item)
+	c.cache[key] = elem
+}
+
+func (c *LRUCache) Delete(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.cache[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *LRUCache) removeElement(elem *list.Element) {
+	delete(c.cache, elem.Value.(*cacheItem).key)
+	c.list.Remove(elem)
+}
+
+func (c *LRUCache) cleanup() {
+	if c.ttl == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.ttl / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mu.Lock()
+		now := time.Now()
+		var toRemove []*list.Element
+
+		for elem := c.list.Back(); elem != nil; elem = elem.Prev() {
+			item := elem.Value.(*cacheItem)
+			if now.After(item.expiresAt) {
+				toRemove = append(toRemove, elem)
+			} else {
+				break
+			}
+		}
+
+		for _, elem := range toRemove {
+			c.removeElement(elem)
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *LRUCache) Size() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list.Len()
+}
+
+func (c *LRUCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = make(map[interface{}]*list.Element)
+	c.list.Init()
+}
+
+// Entries returns the live entries with at least minHits hits, most recently
+// used first.
+func (c *LRUCache) Entries(minHits int64) []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	var entries []Entry
+	for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+		item := elem.Value.(*cacheItem)
+		if c.ttl > 0 && now.After(item.expiresAt) {
+			continue
+		}
+		if item.hits < minHits {
+			continue
+		}
+		entries = append(entries, Entry{Key: item.key, Value: item.value, Hits: item.hits})
+	}
+	return entries
+}
+
+// Stats returns the cache's hit, miss and eviction counts and its size.
+func (c *LRUCache) Stats() Stats {
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      c.Size(),
+		Capacity:  c.capacity,
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"time"
@@ -11,8 +12,11 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
+var ErrCrawlJobNotFound = errors.New("crawl job not found")
+
 type MongoClient struct {
 	Client *mongo.Client
 	DB     *mongo.Database
@@ -23,7 +27,7 @@ func NewMongoClient(ctx context.Context, cfg *config.MongoConfig) (*MongoClient,
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI).SetMonitor(otelmongo.NewMonitor()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
@@ -67,8 +71,11 @@ func (m *MongoClient) AddWebPage(page *models.WebPage) (primitive.ObjectID, erro
 	return oid, nil
 }
 
-func (m *MongoClient) AddBatchWebPage(pages []*models.WebPage) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (m *MongoClient) AddBatchWebPage(ctx context.Context, pages []*models.WebPage) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	coll := m.DB.Collection(m.cfg.CrawlerColl)
 
@@ -82,10 +89,16 @@ func (m *MongoClient) AddBatchWebPage(pages []*models.WebPage) error {
 		docs[i] = page
 	}
 
-	_, err := coll.InsertMany(ctx, docs)
+	res, err := coll.InsertMany(ctx, docs)
 	if err != nil {
 		return fmt.Errorf("failed to insert webpages: %w", err)
 	}
+	// Pages streamed to the indexer carry the id they were stored under.
+	for i, id := range res.InsertedIDs {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			pages[i].ID = oid
+		}
+	}
 	return nil
 }
 
@@ -133,6 +146,186 @@ func (m *MongoClient) GetBatchWebPage(
 	return webPages, newLastID, nil
 }
 
+func (m *MongoClient) urlEquivColl() *mongo.Collection {
+	name := m.cfg.URLEquivColl
+	if name == "" {
+		name = "url_equivalence"
+	}
+	return m.DB.Collection(name)
+}
+
+// UpsertURLEquivalences records raw URL forms against their normalized and
+// canonical URLs, replacing any previous mapping for the same raw form.
+func (m *MongoClient) UpsertURLEquivalences(ctx context.Context, entries []models.URLEquivalence) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	writes := make([]mongo.WriteModel, len(entries))
+	for i := range entries {
+		entries[i].UpdatedAt = now
+		writes[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"raw_url": entries[i].RawURL}).
+			SetReplacement(entries[i]).
+			SetUpsert(true)
+	}
+	if _, err := m.urlEquivColl().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to upsert url equivalences: %w", err)
+	}
+	return nil
+}
+
+// ResolveURL finds the equivalence record for any known form of a URL: raw,
+// normalized, canonical or post-redirect.
+func (m *MongoClient) ResolveURL(ctx context.Context, url string) (*models.URLEquivalence, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"raw_url": url},
+		bson.M{"normalized_url": url},
+		bson.M{"canonical_url": url},
+		bson.M{"final_url": url},
+	}}
+	var entry models.URLEquivalence
+	if err := m.urlEquivColl().FindOne(ctx, filter).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("failed to resolve url %s: %w", url, err)
+	}
+	return &entry, nil
+}
+
+// EachURLEquivalence streams the whole table to fn, e.g. to re-derive the
+// normalized form after a normalization rule change.
+func (m *MongoClient) EachURLEquivalence(ctx context.Context, fn func(models.URLEquivalence) error) error {
+	cursor, err := m.urlEquivColl().Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to scan url equivalences: %w", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var entry models.URLEquivalence
+		if err := cursor.Decode(&entry); err != nil {
+			return fmt.Errorf("failed to decode url equivalence: %w", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// EachPageLinks streams the URL and external links of every crawled page to
+// fn, loading nothing else.
+func (m *MongoClient) EachPageLinks(ctx context.Context, fn func(url string, externalLinks []string) error) error {
+	opts := options.Find().SetProjection(bson.M{"url": 1, "external_links": 1})
+	cursor, err := m.DB.Collection(m.cfg.CrawlerColl).Find(ctx, bson.M{"external_links.0": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to scan page links: %w", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var page struct {
+			URL           string   `bson:"url"`
+			ExternalLinks []string `bson:"external_links"`
+		}
+		if err := cursor.Decode(&page); err != nil {
+			return fmt.Errorf("failed to decode page links: %w", err)
+		}
+		if err := fn(page.URL, page.ExternalLinks); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// EachWebPage streams every crawled page to fn in insertion order. Links,
+// headings and structured data are left out to keep the scan light.
+func (m *MongoClient) EachWebPage(ctx context.Context, fn func(*models.WebPage) error) error {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"internal_links": 0, "external_links": 0, "headings": 0, "structured": 0})
+	cursor, err := m.DB.Collection(m.cfg.CrawlerColl).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to scan pages: %w", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var page models.WebPage
+		if err := cursor.Decode(&page); err != nil {
+			return fmt.Errorf("failed to decode page: %w", err)
+		}
+		if err := fn(&page); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m *MongoClient) crawlJobColl() *mongo.Collection {
+	name := m.cfg.CrawlJobColl
+	if name == "" {
+		name = "crawl_jobs"
+	}
+	return m.DB.Collection(name)
+}
+
+// SaveCrawlJob creates or replaces the job with the same name.
+func (m *MongoClient) SaveCrawlJob(ctx context.Context, job *models.CrawlJob) error {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	job.UpdatedAt = now
+	var existing models.CrawlJob
+	err := m.crawlJobColl().FindOne(ctx, bson.M{"name": job.Name}).Decode(&existing)
+	switch {
+	case err == nil:
+		job.ID = existing.ID
+		job.CreatedAt = existing.CreatedAt
+	case errors.Is(err, mongo.ErrNoDocuments):
+		job.CreatedAt = now
+	default:
+		return fmt.Errorf("failed to look up crawl job %s: %w", job.Name, err)
+	}
+	_, err = m.crawlJobColl().ReplaceOne(ctx, bson.M{"name": job.Name}, job, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save crawl job %s: %w", job.Name, err)
+	}
+	return nil
+}
+
+// GetCrawlJob loads a job by name, returning ErrCrawlJobNotFound if there is
+// none.
+func (m *MongoClient) GetCrawlJob(ctx context.Context, name string) (*models.CrawlJob, error) {
+	var job models.CrawlJob
+	err := m.crawlJobColl().FindOne(ctx, bson.M{"name": name}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %s", ErrCrawlJobNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load crawl job %s: %w", name, err)
+	}
+	return &job, nil
+}
+
+func (m *MongoClient) ListCrawlJobs(ctx context.Context) ([]models.CrawlJob, error) {
+	cursor, err := m.crawlJobColl().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list crawl jobs: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var jobs []models.CrawlJob
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode crawl jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// WithCrawlerColl returns a client sharing this connection that reads and
+// writes crawled pages in coll instead of the configured collection.
+func (m *MongoClient) WithCrawlerColl(coll string) *MongoClient {
+	cfg := *m.cfg
+	cfg.CrawlerColl = coll
+	return &MongoClient{Client: m.Client, DB: m.DB, cfg: &cfg}
+}
+
 func (m *MongoClient) Disconnect() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,193 @@
+package crawler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// adminAPI exposes runtime control of a running crawl: pausing and resuming
+// workers, inspecting their state and the frontier, and resizing the pool.
+type adminAPI struct {
+	pool      *workerPool
+	settings  *workerSettings
+	frontier  URLFrontier
+	discovery *seedDiscovery
+	apiKey    string
+}
+
+func (a *adminAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/stats", a.stats)
+	mux.HandleFunc("GET /admin/workers", a.workers)
+	mux.HandleFunc("PUT /admin/workers", a.resize)
+	mux.HandleFunc("GET /admin/worker-settings", a.workerSettings)
+	mux.HandleFunc("PUT /admin/worker-settings", a.updateWorkerSettings)
+	mux.HandleFunc("GET /admin/frontier", a.frontierStats)
+	mux.HandleFunc("GET /admin/failed", a.failedItems)
+	mux.HandleFunc("POST /admin/failed/requeue", a.requeueFailed)
+	mux.HandleFunc("POST /admin/pause", a.pause)
+	mux.HandleFunc("POST /admin/resume", a.resume)
+	mux.HandleFunc("GET /admin/seed-candidates", a.seedCandidates)
+	mux.HandleFunc("POST /admin/seed-candidates/{host}/approve", a.approveSeed)
+	mux.HandleFunc("POST /admin/seed-candidates/{host}/reject", a.rejectSeed)
+	return a.authorize(mux)
+}
+
+// authorize requires the X-API-Key header when a key is configured.
+func (a *adminAPI) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.apiKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(a.apiKey)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin API key"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *adminAPI) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.frontier.FrontierStats(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"paused":   a.pool.gate.Paused(),
+		"workers":  a.pool.States(),
+		"frontier": stats,
+	})
+}
+
+func (a *adminAPI) workers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, a.pool.States())
+}
+
+// resize sets the number of running workers from the count query parameter.
+func (a *adminAPI) resize(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "count must be an integer"})
+		return
+	}
+	if err = a.pool.Resize(n); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"workers": a.pool.Size()})
+}
+
+func (a *adminAPI) workerSettings(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, a.settings.Get())
+}
+
+// updateWorkerSettings replaces the worker limits with the JSON body. Fields
+// left out keep their current value; domain_concurrency, when given,
+// replaces the whole map.
+func (a *adminAPI) updateWorkerSettings(w http.ResponseWriter, r *http.Request) {
+	next := a.settings.Get()
+	if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid worker settings: " + err.Error()})
+		return
+	}
+	if err := a.settings.Set(next); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, a.settings.Get())
+}
+
+func (a *adminAPI) frontierStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.frontier.FrontierStats(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// failedItems lists failed URLs, filtered by the reason and host query
+// parameters and capped by limit (default 100).
+func (a *adminAPI) failedItems(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be an integer"})
+			return
+		}
+		limit = n
+	}
+	items, err := a.frontier.FailedItems(r.Context(), failedFilter(r), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// requeueFailed moves failed URLs matching the reason and host query
+// parameters back to pending.
+func (a *adminAPI) requeueFailed(w http.ResponseWriter, r *http.Request) {
+	n, err := a.frontier.RequeueFailed(r.Context(), failedFilter(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"requeued": n})
+}
+
+func failedFilter(r *http.Request) FailedFilter {
+	q := r.URL.Query()
+	return FailedFilter{Reason: q.Get("reason"), Host: q.Get("host")}
+}
+
+func (a *adminAPI) pause(w http.ResponseWriter, _ *http.Request) {
+	a.pool.gate.Pause()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+func (a *adminAPI) resume(w http.ResponseWriter, _ *http.Request) {
+	a.pool.gate.Resume()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+}
+
+func (a *adminAPI) seedCandidates(w http.ResponseWriter, r *http.Request) {
+	candidates, err := a.discovery.Candidates(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, candidates)
+}
+
+func (a *adminAPI) approveSeed(w http.ResponseWriter, r *http.Request) {
+	candidate, err := a.discovery.Approve(r.Context(), r.PathValue("host"))
+	if err != nil {
+		writeJSON(w, candidateErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, candidate)
+}
+
+func (a *adminAPI) rejectSeed(w http.ResponseWriter, r *http.Request) {
+	if err := a.discovery.Reject(r.Context(), r.PathValue("host")); err != nil {
+		writeJSON(w, candidateErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func candidateErrorStatus(err error) int {
+	if errors.Is(err, ErrUnknownCandidate) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -16,15 +16,17 @@ const (
 
 type BloomFilter struct {
 	client *redisbloom.Client
+	name   string
 }
 
-func NewRedisBloomFilter(cfg *config.RedisConfig) (*BloomFilter, error) {
+func NewRedisBloomFilter(cfg *config.RedisConfig, keys keyspace) (*BloomFilter, error) {
 	client := redisbloom.NewClient(
 		cfg.Host,
 		"",
 		nil,
 	)
-	if err := client.Reserve(bloomFilterName, errorRate, approxItems); err != nil {
+	name := keys.key(bloomFilterName)
+	if err := client.Reserve(name, errorRate, approxItems); err != nil {
 		if strings.Contains(err.Error(), "item exists") {
 			log.Println("Skipping : Bloom filter already reserved")
 		} else {
@@ -32,17 +34,48 @@ func NewRedisBloomFilter(cfg *config.RedisConfig) (*BloomFilter, error) {
 		}
 	}
 	return &BloomFilter{
-		client,
+		client: client,
+		name:   name,
 	}, nil
 }
 
 func (r *BloomFilter) Add(url string) error {
-	_, err := r.client.Add(bloomFilterName, url)
+	_, err := r.client.Add(r.name, url)
 	return err
 }
 
+// ExistsMulti checks many URLs in one round trip.
+func (r *BloomFilter) ExistsMulti(urls []string) ([]bool, error) {
+	res, err := r.client.BfExistsMulti(r.name, urls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bloom filter : %w", err)
+	}
+	exists := make([]bool, len(res))
+	for i, v := range res {
+		exists[i] = v == 1
+	}
+	return exists, nil
+}
+
+// AddMulti adds many URLs in one round trip.
+func (r *BloomFilter) AddMulti(urls []string) error {
+	_, err := r.client.BfAddMulti(r.name, urls)
+	return err
+}
+
+// Count returns how many URLs have been added to the filter. Adds of URLs
+// that were already present aren't counted, so it approximates the number of
+// distinct URLs seen.
+func (r *BloomFilter) Count() (int64, error) {
+	info, err := r.client.Info(r.name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read bloom filter info : %w", err)
+	}
+	return info["Number of items inserted"], nil
+}
+
 func (r *BloomFilter) Exists(url string) (bool, error) {
-	exists, err := r.client.Exists(bloomFilterName, url)
+	exists, err := r.client.Exists(r.name, url)
 	if err != nil {
 		return false, fmt.Errorf("failed to check bloom filter : %w", err)
 	}
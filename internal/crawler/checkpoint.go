@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	checkpointKey = "crawler:checkpoint"
+
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// crawlCheckpoint persists per-worker progress across restarts, so counters
+// and the last URL each worker handled survive an interrupted crawl.
+type crawlCheckpoint struct {
+	redisClient *redis.Client
+	keys        keyspace
+}
+
+func (cp *crawlCheckpoint) Save(ctx context.Context, states []WorkerState) error {
+	if len(states) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(states))
+	for _, state := range states {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to marshal worker state: %w", err)
+		}
+		fields[state.ID] = data
+	}
+	return cp.redisClient.HSet(ctx, cp.keys.key(checkpointKey), fields).Err()
+}
+
+func (cp *crawlCheckpoint) Load(ctx context.Context) (map[string]WorkerState, error) {
+	raw, err := cp.redisClient.HGetAll(ctx, cp.keys.key(checkpointKey)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crawl checkpoint: %w", err)
+	}
+	states := make(map[string]WorkerState, len(raw))
+	for id, data := range raw {
+		var state WorkerState
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			continue
+		}
+		states[id] = state
+	}
+	return states, nil
+}
+
+// requeueClaimed hands every item still claimed by a worker back to pending.
+// In cluster mode other nodes' workers share the processing queues, so only
+// the given workers are released; otherwise any leftover claim is an orphan
+// of an earlier run and is released too.
+func (c *Spider) requeueClaimed(ctx context.Context, workerIDs []string) {
+	if c.partitioner == nil {
+		ids, err := c.frontier.ProcessingWorkers(ctx)
+		if err != nil {
+			c.log.Printf("failed to list processing queues: %v", err)
+		} else {
+			workerIDs = ids
+		}
+	}
+	for _, id := range workerIDs {
+		n, err := c.frontier.Requeue(ctx, id)
+		if err != nil {
+			c.log.Printf("failed to requeue items claimed by %s: %v", id, err)
+			continue
+		}
+		if n > 0 {
+			c.log.Printf("returned %d unprocessed items from %s to pending", n, id)
+		}
+	}
+}
+
+// checkpoint waits for workers to flush their in-flight batch, returns any
+// claimed but unprocessed items to pending and saves per-worker progress.
+func (c *Spider) checkpoint(pool *workerPool, done <-chan struct{}) {
+	timeout := c.cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		c.log.Printf("workers did not finish within %v; checkpointing anyway", timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.requeueClaimed(ctx, pool.IDs())
+	if err := (&crawlCheckpoint{redisClient: c.redisClient, keys: c.keys}).Save(ctx, pool.States()); err != nil {
+		c.log.Printf("failed to save crawl checkpoint: %v", err)
+		return
+	}
+	c.log.Println("crawl checkpoint saved")
+}
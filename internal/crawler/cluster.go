@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	clusterWorkersKey   = "crawler:workers"
+	clusterTakeoverLock = "crawler:takeover:"
+)
+
+// workerRegistry records a heartbeat for every worker in the cluster and lets
+// live nodes take over the claims of workers whose heartbeat has lapsed,
+// whether because the worker's node died or the worker was removed.
+type workerRegistry struct {
+	redisClient *redis.Client
+	frontier    URLFrontier
+	partitioner *Partitioner
+	workers     func() []string
+	logger      *log.Logger
+	keys        keyspace
+}
+
+// Start heartbeats this node's workers and sweeps for stale ones until ctx is
+// done.
+func (r *workerRegistry) Start(ctx context.Context) {
+	r.sweep(ctx)
+	go func() {
+		ticker := time.NewTicker(r.partitioner.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.sweep(ctx)
+			}
+		}
+	}()
+}
+
+func (r *workerRegistry) sweep(ctx context.Context) {
+	if err := r.heartbeat(ctx); err != nil {
+		r.logger.Printf("worker heartbeat failed: %v", err)
+	}
+	if err := r.takeOverStale(ctx); err != nil {
+		r.logger.Printf("stale worker takeover failed: %v", err)
+	}
+}
+
+func (r *workerRegistry) heartbeat(ctx context.Context) error {
+	ids := r.workers()
+	if len(ids) == 0 {
+		return nil
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	fields := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		fields[id] = now
+	}
+	return r.redisClient.HSet(ctx, r.keys.key(clusterWorkersKey), fields).Err()
+}
+
+// takeOverStale returns the claimed items of workers that stopped
+// heartbeating to pending, where the partitioner routes them to whichever
+// node now owns their host. A short lock keeps two nodes from doing the same
+// takeover at once.
+func (r *workerRegistry) takeOverStale(ctx context.Context) error {
+	raw, err := r.redisClient.HGetAll(ctx, r.keys.key(clusterWorkersKey)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read worker registry: %w", err)
+	}
+	cutoff := time.Now().Add(-r.partitioner.ttl).Unix()
+	for id, v := range raw {
+		last, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && last >= cutoff {
+			continue
+		}
+		locked, err := r.redisClient.SetNX(ctx, r.keys.key(clusterTakeoverLock+id), r.partitioner.NodeID(), r.partitioner.ttl).Result()
+		if err != nil {
+			return fmt.Errorf("failed to lock worker %s for takeover: %w", id, err)
+		}
+		if !locked {
+			continue
+		}
+		n, err := r.frontier.Requeue(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to requeue items of stale worker %s: %w", id, err)
+		}
+		if err = r.redisClient.HDel(ctx, r.keys.key(clusterWorkersKey), id).Err(); err != nil {
+			return fmt.Errorf("failed to deregister stale worker %s: %w", id, err)
+		}
+		r.logger.Printf("took over stale worker %s: returned %d items to pending", id, n)
+	}
+	return nil
+}
+
+// Deregister removes this node's workers after a clean shutdown, once their
+// claims have been released.
+func (r *workerRegistry) Deregister(ctx context.Context) {
+	ids := r.workers()
+	if len(ids) == 0 {
+		return
+	}
+	if err := r.redisClient.HDel(ctx, r.keys.key(clusterWorkersKey), ids...).Err(); err != nil {
+		r.logger.Printf("failed to deregister workers: %v", err)
+	}
+}
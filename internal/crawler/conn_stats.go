@@ -0,0 +1,75 @@
+package crawler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// ConnStat counts how requests to a host got their connection. A high
+// Reused/New ratio means keep-alive (or HTTP/2 multiplexing) is paying off.
+type ConnStat struct {
+	New    int64
+	Reused int64
+	HTTP2  int64
+}
+
+type connStats struct {
+	mu    sync.Mutex
+	hosts map[string]*ConnStat
+}
+
+func newConnStats() *connStats {
+	return &connStats{hosts: make(map[string]*ConnStat)}
+}
+
+func (s *connStats) statFor(host string) *ConnStat {
+	stat, ok := s.hosts[host]
+	if !ok {
+		stat = &ConnStat{}
+		s.hosts[host] = stat
+	}
+	return stat
+}
+
+func (s *connStats) clientTrace() *httptrace.ClientTrace {
+	var host string
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			host = hostPort
+			if h, _, err := net.SplitHostPort(hostPort); err == nil {
+				host = h
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			stat := s.statFor(host)
+			if info.Reused {
+				stat.Reused++
+			} else {
+				stat.New++
+			}
+		},
+	}
+}
+
+func (s *connStats) recordProto(resp *http.Response) {
+	if resp.ProtoMajor != 2 {
+		return
+	}
+	s.mu.Lock()
+	s.statFor(resp.Request.URL.Hostname()).HTTP2++
+	s.mu.Unlock()
+}
+
+func (s *connStats) snapshot() map[string]ConnStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]ConnStat, len(s.hosts))
+	for host, stat := range s.hosts {
+		out[host] = *stat
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+const maxDerivedTitleLen = 120
+
+// contentHandler extracts a WebPage from a response body of one media type.
+// The caller fills in URL, fetch metadata and source quality afterwards.
+type contentHandler func(c *httpCrawler, url string, resp *Response) (*models.WebPage, error)
+
+// contentHandlers maps media types to extractors. Anything not listed is
+// treated as HTML, which is also what the allow-list admits by default.
+var contentHandlers = map[string]contentHandler{
+	"text/html":             (*httpCrawler).parseHTML,
+	"application/xhtml+xml": (*httpCrawler).parseHTML,
+	"text/plain":            (*httpCrawler).parsePlainText,
+	"application/pdf":       (*httpCrawler).parsePDF,
+}
+
+func (c *httpCrawler) parsePlainText(url string, resp *Response) (*models.WebPage, error) {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response : %v", err)
+	}
+	if !utf8.Valid(data) {
+		data = []byte(strings.ToValidUTF8(string(data), " "))
+	}
+	return textDocument("", string(data), resp), nil
+}
+
+func (c *httpCrawler) parsePDF(url string, resp *Response) (*models.WebPage, error) {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response : %v", err)
+	}
+	title, text, err := extractPDFText(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract pdf text: %w", err)
+	}
+	return textDocument(title, text, resp), nil
+}
+
+// textDocument builds a page from unstructured text. Blank lines separate
+// paragraphs, and without an explicit title the first line stands in for one.
+// The text goes into Paragraphs only: the indexer reads the body field as
+// BodyText followed by Paragraphs, so filling both would count every term
+// twice.
+func textDocument(title, text string, resp *Response) *models.WebPage {
+	var paras []string
+	for _, block := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		block = strings.Join(strings.Fields(block), " ")
+		if block == "" {
+			continue
+		}
+		if title == "" {
+			title = block
+			if len(title) > maxDerivedTitleLen {
+				title = strings.ToValidUTF8(title[:maxDerivedTitleLen], "")
+			}
+		}
+		if normalized := textproc.Normalize(block); normalized != "" {
+			paras = append(paras, normalized)
+		}
+	}
+	contentLanguage := strings.TrimSpace(resp.Header.Get("Content-Language"))
+	return &models.WebPage{
+		Title:           strings.TrimSpace(title),
+		Paragraphs:      paras,
+		ContentLanguage: contentLanguage,
+		LanguageHint:    languageHint("", contentLanguage),
+	}
+}
@@ -0,0 +1,46 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+// Job names end up in Redis keys and Mongo collection names, so they are
+// kept to a safe alphabet.
+var jobNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ValidateCrawlJob checks a job definition before it is saved.
+func ValidateCrawlJob(job *models.CrawlJob) error {
+	if !jobNamePattern.MatchString(job.Name) {
+		return fmt.Errorf("invalid crawl job name %q: use lowercase letters, digits, - and _", job.Name)
+	}
+	if len(job.Seeds) == 0 {
+		return errors.New("crawl job needs at least one seed url")
+	}
+	for _, seed := range job.Seeds {
+		if _, err := normalizeUrl(seed); err != nil {
+			return fmt.Errorf("invalid seed url %s: %w", seed, err)
+		}
+	}
+	if job.MaxDepth < 0 {
+		return errors.New("crawl job max depth cannot be negative")
+	}
+	filters := jobFilters(job)
+	if _, err := NewURLFilter(&filters); err != nil {
+		return fmt.Errorf("invalid crawl job filters: %w", err)
+	}
+	return nil
+}
+
+func jobFilters(job *models.CrawlJob) config.URLFilterConfig {
+	return config.URLFilterConfig{
+		AllowDomains: job.Filters.AllowDomains,
+		BlockDomains: job.Filters.BlockDomains,
+		AllowPaths:   job.Filters.AllowPaths,
+		BlockPaths:   job.Filters.BlockPaths,
+	}
+}
@@ -0,0 +1,118 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/internal/metrics"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+const queueMetricsInterval = 15 * time.Second
+
+type crawlMetrics struct {
+	registry        *metrics.Registry
+	pagesFetched    *metrics.CounterVec
+	fetchErrors     *metrics.CounterVec
+	bytesDownloaded *metrics.CounterVec
+	frontierPending *metrics.GaugeVec
+	frontierFailed  *metrics.GaugeVec
+	processing      *metrics.GaugeVec
+	frontierSeen    *metrics.GaugeVec
+	enqueueRate     *metrics.GaugeVec
+	dequeueRate     *metrics.GaugeVec
+	fetchLatency    *metrics.HistogramVec
+}
+
+func newCrawlMetrics() *crawlMetrics {
+	r := metrics.NewRegistry()
+	return &crawlMetrics{
+		registry:        r,
+		pagesFetched:    r.NewCounterVec("crawler_pages_fetched_total", "Pages fetched successfully."),
+		fetchErrors:     r.NewCounterVec("crawler_fetch_errors_total", "Failed fetches by error type.", "type"),
+		bytesDownloaded: r.NewCounterVec("crawler_bytes_downloaded_total", "Decoded response body bytes downloaded."),
+		frontierPending: r.NewGaugeVec("crawler_frontier_pending", "URLs waiting in the pending queues."),
+		frontierFailed:  r.NewGaugeVec("crawler_frontier_failed", "URLs in the failed queue."),
+		processing:      r.NewGaugeVec("crawler_processing_queue_size", "URLs claimed by each worker.", "worker"),
+		frontierSeen:    r.NewGaugeVec("crawler_frontier_seen_urls", "Estimated distinct URLs seen, from the bloom filter."),
+		enqueueRate:     r.NewGaugeVec("crawler_frontier_enqueue_rate", "URLs enqueued per second over the last five minutes."),
+		dequeueRate:     r.NewGaugeVec("crawler_frontier_dequeue_rate", "URLs claimed by workers per second over the last five minutes."),
+		fetchLatency:    r.NewHistogramVec("crawler_fetch_duration_seconds", "Fetch latency per host.", metrics.DefBuckets, "host"),
+	}
+}
+
+// observeFetch records the outcome of a single crawl.
+func (m *crawlMetrics) observeFetch(host string, page *models.WebPage, err error, elapsed time.Duration) {
+	if host != "" {
+		m.fetchLatency.WithLabelValues(host).Observe(elapsed.Seconds())
+	}
+	if page != nil && page.Fetch != nil && page.Fetch.ContentLength > 0 {
+		m.bytesDownloaded.WithLabelValues().Add(float64(page.Fetch.ContentLength))
+	}
+	if err != nil {
+		m.fetchErrors.WithLabelValues(fetchErrorType(page, err)).Inc()
+		return
+	}
+	m.pagesFetched.WithLabelValues().Inc()
+}
+
+// fetchErrorType buckets crawl errors into a small, fixed set of label values.
+func fetchErrorType(page *models.WebPage, err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrRedirectLoop):
+		return failReasonRedirectLoop
+	case errors.Is(err, ErrTooManyRedirects):
+		return failReasonTooManyRedirects
+	case errors.Is(err, ErrBodyTooLarge):
+		return "body_too_large"
+	case errors.Is(err, ErrUnsupportedContentType):
+		return "content_type"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case page != nil && page.Fetch != nil && page.Fetch.StatusCode >= 400:
+		return "status_" + strconv.Itoa(page.Fetch.StatusCode/100) + "xx"
+	case page != nil && page.Fetch != nil:
+		return "parse"
+	default:
+		return "connection"
+	}
+}
+
+// refreshFrontierStats polls the frontier stats until ctx is done.
+func (m *crawlMetrics) refreshFrontierStats(ctx context.Context, frontier URLFrontier) {
+	ticker := time.NewTicker(queueMetricsInterval)
+	defer ticker.Stop()
+	for {
+		if stats, err := frontier.FrontierStats(ctx); err == nil {
+			m.frontierPending.WithLabelValues().Set(float64(stats.Pending))
+			m.frontierFailed.WithLabelValues().Set(float64(stats.Failed))
+			m.frontierSeen.WithLabelValues().Set(float64(stats.SeenEstimate))
+			m.enqueueRate.WithLabelValues().Set(stats.EnqueueRate)
+			m.dequeueRate.WithLabelValues().Set(stats.DequeueRate)
+			m.processing.Reset()
+			for id, n := range stats.PerWorker {
+				m.processing.WithLabelValues(id).Set(float64(n))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *crawlMetrics) handler() http.Handler {
+	return m.registry.Handler()
+}
@@ -0,0 +1,87 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultDNSCacheTTL = 5 * time.Minute
+
+type dnsEntry struct {
+	addrs     []string
+	expiresAt time.Time
+}
+
+// dnsCache memoizes host lookups so that crawling thousands of pages from the
+// same handful of domains doesn't hit the resolver for every new connection.
+type dnsCache struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+	mu       sync.RWMutex
+	entries  map[string]dnsEntry
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	if ttl <= 0 {
+		ttl = defaultDNSCacheTTL
+	}
+	return &dnsCache{
+		resolver: net.DefaultResolver,
+		ttl:      ttl,
+		entries:  make(map[string]dnsEntry),
+	}
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.RLock()
+	entry, ok := c.entries[host]
+	c.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+func (c *dnsCache) invalidate(host string) {
+	c.mu.Lock()
+	delete(c.entries, host)
+	c.mu.Unlock()
+}
+
+func (c *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		// The cached addresses may have gone stale before the TTL expired.
+		c.invalidate(host)
+		return nil, fmt.Errorf("failed to dial %s: %w", host, lastErr)
+	}
+}
@@ -0,0 +1,128 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// failedScanChunk is how many failed entries are read from Redis at a time.
+const failedScanChunk = 1000
+
+// FailedItem is a URL in the failed queue with the reason it failed.
+type FailedItem struct {
+	URL    string `json:"url"`
+	Host   string `json:"host"`
+	Depth  int64  `json:"depth"`
+	Reason string `json:"reason"`
+
+	raw  string
+	item crawlItem
+}
+
+// FailedFilter selects failed items. Reason matches case-insensitively
+// anywhere in the stored reason, so "timeout" finds every kind of timeout;
+// Host matches the host and its subdomains. Empty fields match everything.
+type FailedFilter struct {
+	Reason string
+	Host   string
+}
+
+func (ff FailedFilter) matches(item *FailedItem) bool {
+	if ff.Reason != "" && !strings.Contains(strings.ToLower(item.Reason), strings.ToLower(ff.Reason)) {
+		return false
+	}
+	if ff.Host != "" {
+		host := strings.ToLower(strings.TrimPrefix(ff.Host, "."))
+		if item.Host != host && !strings.HasSuffix(item.Host, "."+host) {
+			return false
+		}
+	}
+	return true
+}
+
+// scanFailed walks the failed queue newest first and calls fn for each item
+// the filter accepts until fn returns false.
+func (f *urlFrontier) scanFailed(ctx context.Context, filter FailedFilter, fn func(*FailedItem) bool) error {
+	key := f.keys.key(failedQueue)
+	for start := int64(0); ; start += failedScanChunk {
+		entries, err := f.redisClient.LRange(ctx, key, start, start+failedScanChunk-1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read failed queue: %w", err)
+		}
+		for _, raw := range entries {
+			var entry struct {
+				Item   crawlItem `json:"item"`
+				Reason string    `json:"reason"`
+			}
+			if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+				continue
+			}
+			item := &FailedItem{
+				URL:    entry.Item.Url,
+				Depth:  entry.Item.Depth,
+				Reason: entry.Reason,
+				raw:    raw,
+				item:   entry.Item,
+			}
+			if u, err := neturl.Parse(entry.Item.Url); err == nil {
+				item.Host = strings.ToLower(u.Hostname())
+			}
+			if filter.matches(item) && !fn(item) {
+				return nil
+			}
+		}
+		if len(entries) < failedScanChunk {
+			return nil
+		}
+	}
+}
+
+// FailedItems lists up to limit failed items matching filter, most recent
+// failures first. A limit of zero or less lists them all.
+func (f *urlFrontier) FailedItems(ctx context.Context, filter FailedFilter, limit int) ([]FailedItem, error) {
+	var items []FailedItem
+	err := f.scanFailed(ctx, filter, func(item *FailedItem) bool {
+		items = append(items, *item)
+		return limit <= 0 || len(items) < limit
+	})
+	return items, err
+}
+
+// RequeueFailed moves the failed items matching filter back to pending with a
+// fresh enqueue time and returns how many were moved.
+func (f *urlFrontier) RequeueFailed(ctx context.Context, filter FailedFilter) (int64, error) {
+	var matched []*FailedItem
+	if err := f.scanFailed(ctx, filter, func(item *FailedItem) bool {
+		matched = append(matched, item)
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	if len(matched) == 0 {
+		return 0, nil
+	}
+	// Removal and requeue are done after the scan so the list isn't shifted
+	// under the paginated read.
+	key := f.keys.key(failedQueue)
+	now := time.Now().Unix()
+	pipe := f.redisClient.TxPipeline()
+	for _, failed := range matched {
+		item := failed.item
+		item.EnqueuedAt = now
+		item.Requeued = false
+		data, err := json.Marshal(item)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal crawl item: %w", err)
+		}
+		pipe.LRem(ctx, key, 1, failed.raw)
+		f.pushPending(ctx, pipe, item.Url, data, false)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("redis transaction failed: %w", err)
+	}
+	return int64(len(matched)), nil
+}
@@ -6,8 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	neturl "net/url"
+	"strconv"
+	"time"
 
 	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/pkg"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -21,6 +27,9 @@ func NewRedisClient(ctx context.Context, cfg *config.RedisConfig) (*redis.Client
 	if err != nil {
 		return nil, fmt.Errorf("error pinging the redis : %w", err)
 	}
+	if err = redisotel.InstrumentTracing(client); err != nil {
+		return nil, fmt.Errorf("failed to instrument redis : %w", err)
+	}
 	return client, nil
 }
 
@@ -29,34 +38,164 @@ type URLFrontier interface {
 	NextBatch(ctx context.Context, workerID string, count int) ([]*crawlItem, error)
 	Done(ctx context.Context, item *crawlItem, workerID string) error
 	Fail(ctx context.Context, crawlData *crawlItem, workerID, reason string) error
-	Size(ctx context.Context) (int64, error)
+	FrontierStats(ctx context.Context) (*FrontierStats, error)
+	FailedItems(ctx context.Context, filter FailedFilter, limit int) ([]FailedItem, error)
+	RequeueFailed(ctx context.Context, filter FailedFilter) (int64, error)
+	ProcessingWorkers(ctx context.Context) ([]string, error)
+	Requeue(ctx context.Context, workerID string) (int64, error)
 	UpdateLastIndexedItem(ctx context.Context, id string) error
 	GetLastIndexedItem(ctx context.Context) (string, error)
 	Seed(ctx context.Context, url string, depth int64) error
+	SeedBatch(ctx context.Context, urls []string, depth int64) (int, error)
+	Refresh(ctx context.Context, url string) error
+	SetSourceQuality(ctx context.Context, url string, quality float64) error
+	SourceQuality(ctx context.Context, url string) (float64, error)
+	ExpiredCounts(ctx context.Context) (map[string]int64, error)
 	Close() error
 }
 
 const (
 	pendingQueue    = "pending"
+	pendingHostList = "pending:host:"
+	pendingHosts    = "pending:hosts"
 	failedQueue     = "failed"
 	processingQueue = "processing:"
+	sourceQuality   = "source_quality"
+	expiredCounts   = "frontier:expired"
+)
+
+const defaultHostBatchLimit = 5
+
+const (
+	StalePolicyDrop    = "drop"
+	StalePolicyRequeue = "requeue"
 )
 
 type urlFrontier struct {
 	redisClient      *redis.Client
 	redisBloomClient *BloomFilter
+	trapDetector     *TrapDetector
+	partitioner      *Partitioner
+	filter           *URLFilter
+	maxPendingAge    time.Duration
+	stalePolicy      string
+	hostBatchLimit   int
+	keys             keyspace
 }
 
 type crawlItem struct {
-	Url   string `json:"url"`
-	Depth int64  `json:"depth"`
+	Url        string `json:"url"`
+	Depth      int64  `json:"depth"`
+	EnqueuedAt int64  `json:"enqueued_at,omitempty"`
+	Requeued   bool   `json:"requeued,omitempty"`
 }
 
-func NewURLFrontier(redisClient *redis.Client, redisBloomClient *BloomFilter) URLFrontier {
+func NewURLFrontier(redisClient *redis.Client, redisBloomClient *BloomFilter, trapDetector *TrapDetector, partitioner *Partitioner, filter *URLFilter, cfg *config.FrontierConfig, keys keyspace) URLFrontier {
+	stalePolicy := StalePolicyDrop
+	if cfg.StalePolicy == StalePolicyRequeue {
+		stalePolicy = StalePolicyRequeue
+	}
+	hostBatchLimit := defaultHostBatchLimit
+	if cfg.HostBatchLimit > 0 {
+		hostBatchLimit = cfg.HostBatchLimit
+	}
 	return &urlFrontier{
 		redisClient:      redisClient,
 		redisBloomClient: redisBloomClient,
+		trapDetector:     trapDetector,
+		partitioner:      partitioner,
+		filter:           filter,
+		maxPendingAge:    cfg.MaxPendingAge,
+		stalePolicy:      stalePolicy,
+		hostBatchLimit:   hostBatchLimit,
+		keys:             keys,
+	}
+}
+
+// isStale reports whether an item has waited in pending longer than the
+// configured max age. Items queued before timestamps existed never expire.
+func (f *urlFrontier) isStale(item *crawlItem, now time.Time) bool {
+	if f.maxPendingAge <= 0 || item.EnqueuedAt == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(item.EnqueuedAt, 0)) > f.maxPendingAge
+}
+
+func (f *urlFrontier) pendingSlotQueue(slot int) string {
+	return f.keys.key(pendingQueue + ":slot:" + strconv.Itoa(slot))
+}
+
+// Pending URLs live in one list per host (pending:host:<host>). A sorted set
+// of hosts with pending work, scored by when each was last served, acts as
+// the host scheduler: NextBatch walks it oldest first and takes at most
+// hostBatchLimit URLs per host, so a batch spreads across sites instead of
+// draining one. With a partitioner the scheduler is split by slot so each
+// node only rotates through the hosts it owns.
+func (f *urlFrontier) hostQueueKey(host string) string {
+	return f.keys.key(pendingHostList + host)
+}
+
+func (f *urlFrontier) processingKey(workerID string) string {
+	return f.keys.key(processingQueue + workerID)
+}
+
+func (f *urlFrontier) schedulerKeyFor(host string) string {
+	if f.partitioner == nil {
+		return f.keys.key(pendingHosts)
+	}
+	return f.keys.key(pendingHosts + ":slot:" + strconv.Itoa(f.partitioner.SlotFor(host)))
+}
+
+func (f *urlFrontier) schedulerKeys() []string {
+	if f.partitioner == nil {
+		return []string{f.keys.key(pendingHosts)}
+	}
+	slots := f.partitioner.OwnedSlots()
+	keys := make([]string, len(slots))
+	for i, slot := range slots {
+		keys[i] = f.keys.key(pendingHosts + ":slot:" + strconv.Itoa(slot))
+	}
+	return keys
+}
+
+// pushPending queues an encoded item on its host's list and makes sure the
+// host is scheduled. front puts it next in line rather than last.
+func (f *urlFrontier) pushPending(ctx context.Context, pipe redis.Pipeliner, rawUrl string, data interface{}, front bool) {
+	host := "unknown"
+	if u, err := neturl.Parse(rawUrl); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	// Lists are consumed from the right.
+	if front {
+		pipe.RPush(ctx, f.hostQueueKey(host), data)
+	} else {
+		pipe.LPush(ctx, f.hostQueueKey(host), data)
+	}
+	pipe.ZAddNX(ctx, f.schedulerKeyFor(host), redis.Z{Score: float64(time.Now().UnixNano()), Member: host})
+	f.countRate(ctx, pipe, enqueuedCounter, 1)
+}
+
+// unscheduleIfEmpty drops a host from the scheduler once its list is empty.
+// It runs as a script so a concurrent push cannot slip in between the check
+// and the removal and leave URLs on an unscheduled list.
+var unscheduleIfEmpty = redis.NewScript(`
+if redis.call('LLEN', KEYS[2]) == 0 then
+	return redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0`)
+
+// pendingKeys returns the single-list pending queues used before per-host
+// queues existed. They are still drained so upgrades lose nothing.
+func (f *urlFrontier) pendingKeys() []string {
+	if f.partitioner == nil {
+		return []string{f.keys.key(pendingQueue)}
+	}
+	slots := f.partitioner.OwnedSlots()
+	keys := make([]string, len(slots))
+	for i, slot := range slots {
+		keys[i] = f.pendingSlotQueue(slot)
 	}
+	return keys
 }
 
 func (f *urlFrontier) Seed(ctx context.Context, url string, depth int64) error {
@@ -64,6 +203,9 @@ func (f *urlFrontier) Seed(ctx context.Context, url string, depth int64) error {
 	if err != nil {
 		return err
 	}
+	if !f.filter.Allowed(normalizedUrl) {
+		return nil
+	}
 	exists, err := f.redisBloomClient.Exists(normalizedUrl)
 	if err != nil {
 		return fmt.Errorf("failed to check the bloom filter: %w", err)
@@ -71,9 +213,15 @@ func (f *urlFrontier) Seed(ctx context.Context, url string, depth int64) error {
 	if exists {
 		return nil
 	}
+	if _, trapped, err := f.trapDetector.Check(ctx, normalizedUrl); err != nil {
+		return fmt.Errorf("failed to run trap detection: %w", err)
+	} else if trapped {
+		return nil
+	}
 	item := crawlItem{
-		normalizedUrl,
-		depth,
+		Url:        normalizedUrl,
+		Depth:      depth,
+		EnqueuedAt: time.Now().Unix(),
 	}
 	data, err := json.Marshal(item)
 	if err != nil {
@@ -83,19 +231,138 @@ func (f *urlFrontier) Seed(ctx context.Context, url string, depth int64) error {
 		return fmt.Errorf("failed to add url to bloom filter: %w", err)
 	}
 
-	if err = f.redisClient.LPush(ctx, pendingQueue, data).Err(); err != nil {
+	pipe := f.redisClient.TxPipeline()
+	f.pushPending(ctx, pipe, normalizedUrl, data, false)
+	if _, err = pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("failed to push seed URL to pending queue: %w", err)
 	}
 
 	return nil
 }
 
+// SeedBatch is Seed for many URLs at one depth, such as the links of a
+// crawled page, using a handful of Redis round trips rather than several per
+// URL. It returns how many URLs were queued.
+func (f *urlFrontier) SeedBatch(ctx context.Context, urls []string, depth int64) (int, error) {
+	seen := make(map[string]bool, len(urls))
+	var candidates []string
+	for _, url := range urls {
+		normalizedUrl, err := normalizeUrl(url)
+		if err != nil || seen[normalizedUrl] || !f.filter.Allowed(normalizedUrl) {
+			continue
+		}
+		seen[normalizedUrl] = true
+		candidates = append(candidates, normalizedUrl)
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+	exists, err := f.redisBloomClient.ExistsMulti(candidates)
+	if err != nil {
+		return 0, err
+	}
+	fresh := candidates[:0]
+	for i, url := range candidates {
+		if !exists[i] {
+			fresh = append(fresh, url)
+		}
+	}
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+	fresh, err = f.trapDetector.CheckBatch(ctx, fresh)
+	if err != nil {
+		return 0, fmt.Errorf("failed to run trap detection: %w", err)
+	}
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+	if err = f.redisBloomClient.AddMulti(fresh); err != nil {
+		return 0, fmt.Errorf("failed to add urls to bloom filter: %w", err)
+	}
+	now := time.Now().Unix()
+	pipe := f.redisClient.TxPipeline()
+	for _, url := range fresh {
+		data, err := json.Marshal(crawlItem{Url: url, Depth: depth, EnqueuedAt: now})
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal crawl item: %w", err)
+		}
+		f.pushPending(ctx, pipe, url, data, false)
+	}
+	if _, err = pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to push links to pending queue: %w", err)
+	}
+	return len(fresh), nil
+}
+
+// Refresh queues url at depth 0 even if it was crawled before, so scheduled
+// runs pick up changes to their seed pages.
+func (f *urlFrontier) Refresh(ctx context.Context, url string) error {
+	normalizedUrl, err := normalizeUrl(url)
+	if err != nil {
+		return err
+	}
+	if !f.filter.Allowed(normalizedUrl) {
+		return nil
+	}
+	data, err := json.Marshal(crawlItem{Url: normalizedUrl, EnqueuedAt: time.Now().Unix()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal crawl item: %w", err)
+	}
+	if err = f.redisBloomClient.Add(normalizedUrl); err != nil {
+		return fmt.Errorf("failed to add url to bloom filter: %w", err)
+	}
+	pipe := f.redisClient.TxPipeline()
+	f.pushPending(ctx, pipe, normalizedUrl, data, false)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to push refresh URL to pending queue: %w", err)
+	}
+	return nil
+}
+
+func hostKey(rawUrl string) (string, error) {
+	normalizedUrl, err := normalizeUrl(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	u, err := neturl.Parse(normalizedUrl)
+	if err != nil {
+		return "", fmt.Errorf("error parsing URL: %w", err)
+	}
+	return u.Hostname(), nil
+}
+
+// SetSourceQuality records the seed-level quality rating for the URL's host.
+// Every page later crawled from that host inherits it.
+func (f *urlFrontier) SetSourceQuality(ctx context.Context, url string, quality float64) error {
+	host, err := hostKey(url)
+	if err != nil {
+		return err
+	}
+	return f.redisClient.HSet(ctx, f.keys.key(sourceQuality), host, quality).Err()
+}
+
+func (f *urlFrontier) SourceQuality(ctx context.Context, url string) (float64, error) {
+	host, err := hostKey(url)
+	if err != nil {
+		return 0, err
+	}
+	quality, err := f.redisClient.HGet(ctx, f.keys.key(sourceQuality), host).Float64()
+	if errors.Is(err, redis.Nil) {
+		return pkg.DefaultSourceQuality, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read source quality: %w", err)
+	}
+	return quality, nil
+}
+
 func (f *urlFrontier) UpdateLastIndexedItem(ctx context.Context, id string) error {
-	return f.redisClient.Set(ctx, "last_indexed_object_id", id, 0).Err()
+	return f.redisClient.Set(ctx, f.keys.key("last_indexed_object_id"), id, 0).Err()
 }
 
 func (f *urlFrontier) GetLastIndexedItem(ctx context.Context) (string, error) {
-	val, err := f.redisClient.Get(ctx, "last_indexed_object_id").Result()
+	val, err := f.redisClient.Get(ctx, f.keys.key("last_indexed_object_id")).Result()
 	if err != nil {
 		return "", err
 	}
@@ -107,6 +374,9 @@ func (f *urlFrontier) Visit(ctx context.Context, url string) error {
 	if err != nil {
 		return fmt.Errorf("failed to normalize url: %w", err)
 	}
+	if !f.filter.Allowed(normalizedUrl) {
+		return fmt.Errorf("%w: %s", ErrURLFiltered, normalizedUrl)
+	}
 
 	exists, err := f.redisBloomClient.Exists(normalizedUrl)
 	if err != nil {
@@ -128,7 +398,7 @@ func (f *urlFrontier) NextBatch(ctx context.Context, workerID string, count int)
 	if count <= 0 {
 		return nil, errors.New("invalid batch size")
 	}
-	processingKey := processingQueue + workerID
+	processingKey := f.processingKey(workerID)
 	processingItems, err := f.redisClient.LRange(ctx, processingKey, 0, int64(count-1)).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read processing queue: %w", err)
@@ -147,31 +417,124 @@ func (f *urlFrontier) NextBatch(ctx context.Context, workerID string, count int)
 		}
 		return crawlItems, nil
 	}
-	resp, err := f.redisClient.LRange(ctx, pendingQueue, int64(-count), -1).Result()
+	crawlItems, err = f.nextFromHosts(ctx, workerID, count)
+	if err != nil {
+		return nil, err
+	}
+	keys := f.pendingKeys()
+	offset := 0
+	if len(keys) > 1 {
+		offset = rand.Intn(len(keys))
+	}
+	for i := 0; i < len(keys) && len(crawlItems) < count; i++ {
+		key := keys[(offset+i)%len(keys)]
+		items, err := f.popPending(ctx, key, workerID, count-len(crawlItems))
+		if err != nil {
+			return nil, err
+		}
+		crawlItems = append(crawlItems, items...)
+	}
+	if len(crawlItems) == 0 {
+		return nil, errors.New("frontier is empty")
+	}
+
+	return crawlItems, nil
+}
+
+// nextFromHosts fills a batch by rotating through scheduled hosts, least
+// recently served first, taking at most hostBatchLimit URLs from each.
+func (f *urlFrontier) nextFromHosts(ctx context.Context, workerID string, count int) ([]*crawlItem, error) {
+	var crawlItems []*crawlItem
+	keys := f.schedulerKeys()
+	offset := 0
+	if len(keys) > 1 {
+		offset = rand.Intn(len(keys))
+	}
+	for i := 0; i < len(keys) && len(crawlItems) < count; i++ {
+		schedKey := keys[(offset+i)%len(keys)]
+		hosts, err := f.redisClient.ZRange(ctx, schedKey, 0, int64(count-len(crawlItems)-1)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host scheduler: %w", err)
+		}
+		for _, host := range hosts {
+			if len(crawlItems) >= count {
+				break
+			}
+			queueKey := f.hostQueueKey(host)
+			items, err := f.popPending(ctx, queueKey, workerID, min(f.hostBatchLimit, count-len(crawlItems)))
+			if err != nil {
+				return nil, err
+			}
+			crawlItems = append(crawlItems, items...)
+			// Served hosts move to the back of the rotation.
+			if err = f.redisClient.ZAddXX(ctx, schedKey, redis.Z{Score: float64(time.Now().UnixNano()), Member: host}).Err(); err != nil {
+				return nil, fmt.Errorf("failed to reschedule host %s: %w", host, err)
+			}
+			if err = unscheduleIfEmpty.Run(ctx, f.redisClient, []string{schedKey, queueKey}, host).Err(); err != nil {
+				return nil, fmt.Errorf("failed to unschedule host %s: %w", host, err)
+			}
+		}
+	}
+	return crawlItems, nil
+}
+
+func (f *urlFrontier) popPending(ctx context.Context, key, workerID string, count int) ([]*crawlItem, error) {
+	resp, err := f.redisClient.LRange(ctx, key, int64(-count), -1).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read pending queue: %w", err)
 	}
+	if len(resp) == 0 {
+		return nil, nil
+	}
 
+	var crawlItems, requeued []*crawlItem
+	var dropped int64
+	now := time.Now()
 	for _, itemStr := range resp {
 		var item crawlItem
 		if err := json.Unmarshal([]byte(itemStr), &item); err != nil {
 			fmt.Printf("failed to unmarshal item %s : skipping\n", itemStr)
 			continue
 		}
+		if f.isStale(&item, now) {
+			// A stale item gets one more pass at the back of the queue under
+			// the requeue policy; if it goes stale again it is dropped.
+			if f.stalePolicy == StalePolicyRequeue && !item.Requeued {
+				item.Requeued = true
+				item.EnqueuedAt = now.Unix()
+				requeued = append(requeued, &item)
+			} else {
+				dropped++
+			}
+			continue
+		}
 		crawlItems = append(crawlItems, &item)
 	}
-	if len(crawlItems) == 0 {
-		return nil, errors.New("frontier is empty")
-	}
 
 	pipe := f.redisClient.TxPipeline()
-	pipe.LTrim(ctx, pendingQueue, 0, int64(-(count + 1)))
+	pipe.LTrim(ctx, key, 0, int64(-(count + 1)))
+	for _, item := range requeued {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal crawl item: %w", err)
+		}
+		pipe.LPush(ctx, key, data)
+	}
+	if len(requeued) > 0 {
+		pipe.HIncrBy(ctx, f.keys.key(expiredCounts), StalePolicyRequeue, int64(len(requeued)))
+	}
+	if dropped > 0 {
+		pipe.HIncrBy(ctx, f.keys.key(expiredCounts), StalePolicyDrop, dropped)
+	}
 	for _, item := range crawlItems {
 		data, err := json.Marshal(item)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal crawl item: %w", err)
 		}
-		pipe.LPush(ctx, processingQueue+workerID, data)
+		pipe.LPush(ctx, f.processingKey(workerID), data)
+	}
+	if len(crawlItems) > 0 {
+		f.countRate(ctx, pipe, dequeuedCounter, int64(len(crawlItems)))
 	}
 	_, err = pipe.Exec(ctx)
 	if err != nil {
@@ -190,7 +553,7 @@ func (f *urlFrontier) Done(ctx context.Context, item *crawlItem, workerID string
 	if err != nil {
 		log.Printf("failed to add url to bloom filter : %v", err)
 	}
-	return f.redisClient.LRem(ctx, processingQueue+workerID, 0, data).Err()
+	return f.redisClient.LRem(ctx, f.processingKey(workerID), 0, data).Err()
 }
 
 func (f *urlFrontier) Fail(ctx context.Context, crawlData *crawlItem, workerID, reason string) error {
@@ -210,15 +573,97 @@ func (f *urlFrontier) Fail(ctx context.Context, crawlData *crawlItem, workerID,
 	}
 
 	pipe := f.redisClient.TxPipeline()
-	pipe.LRem(ctx, processingQueue+workerID, 0, dataToRemove)
-	pipe.LPush(ctx, failedQueue, jsonData)
+	pipe.LRem(ctx, f.processingKey(workerID), 0, dataToRemove)
+	pipe.LPush(ctx, f.keys.key(failedQueue), jsonData)
 	_, err = pipe.Exec(ctx)
 
 	return err
 }
 
-func (f *urlFrontier) Size(ctx context.Context) (int64, error) {
-	return f.redisClient.LLen(ctx, pendingQueue).Result()
+// pendingSize counts the URLs waiting in every pending queue this node owns.
+func (f *urlFrontier) pendingSize(ctx context.Context) (int64, error) {
+	keys := f.pendingKeys()
+	for _, schedKey := range f.schedulerKeys() {
+		hosts, err := f.redisClient.ZRange(ctx, schedKey, 0, -1).Result()
+		if err != nil {
+			return 0, err
+		}
+		for _, host := range hosts {
+			keys = append(keys, f.hostQueueKey(host))
+		}
+	}
+	pipe := f.redisClient.Pipeline()
+	lens := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		lens[i] = pipe.LLen(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, n := range lens {
+		total += n.Val()
+	}
+	return total, nil
+}
+
+// ExpiredCounts returns how many stale pending items have been dropped or
+// requeued, keyed by policy.
+func (f *urlFrontier) ExpiredCounts(ctx context.Context) (map[string]int64, error) {
+	raw, err := f.redisClient.HGetAll(ctx, f.keys.key(expiredCounts)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read expired counts: %w", err)
+	}
+	counts := make(map[string]int64, len(raw))
+	for policy, v := range raw {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		counts[policy] = n
+	}
+	return counts, nil
+}
+
+// ProcessingWorkers lists the workers that currently hold claimed items,
+// including workers from earlier runs that never released them.
+func (f *urlFrontier) ProcessingWorkers(ctx context.Context) ([]string, error) {
+	var workers []string
+	prefix := f.processingKey("")
+	iter := f.redisClient.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		workers = append(workers, iter.Val()[len(prefix):])
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan processing queues: %w", err)
+	}
+	return workers, nil
+}
+
+// Requeue returns every item claimed by workerID to the front of its pending
+// queue, so it is the next thing crawled once the crawl resumes.
+func (f *urlFrontier) Requeue(ctx context.Context, workerID string) (int64, error) {
+	processingKey := f.processingKey(workerID)
+	items, err := f.redisClient.LRange(ctx, processingKey, 0, -1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read processing queue: %w", err)
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	pipe := f.redisClient.TxPipeline()
+	for _, itemStr := range items {
+		var item crawlItem
+		if err := json.Unmarshal([]byte(itemStr), &item); err != nil {
+			continue
+		}
+		f.pushPending(ctx, pipe, item.Url, itemStr, true)
+	}
+	pipe.Del(ctx, processingKey)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("redis transaction failed: %w", err)
+	}
+	return int64(len(items)), nil
 }
 
 func (f *urlFrontier) Close() error {
@@ -0,0 +1,101 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	enqueuedCounter = "frontier:enqueued:"
+	dequeuedCounter = "frontier:dequeued:"
+	// Rates are averaged over the last few whole minutes, counted in one
+	// Redis bucket per minute so every crawler node adds to the same total.
+	rateWindowMinutes = 5
+	rateBucketTTL     = 2 * rateWindowMinutes * time.Minute
+)
+
+// FrontierStats is a snapshot of the frontier. PerWorker covers every worker
+// holding claimed URLs, including ones left over from earlier runs. Rates are
+// URLs per second over the last five whole minutes.
+type FrontierStats struct {
+	Pending      int64            `json:"pending"`
+	Failed       int64            `json:"failed"`
+	Processing   int64            `json:"processing"`
+	PerWorker    map[string]int64 `json:"processing_per_worker"`
+	SeenEstimate int64            `json:"seen_estimate"`
+	EnqueueRate  float64          `json:"enqueue_rate"`
+	DequeueRate  float64          `json:"dequeue_rate"`
+}
+
+func (f *urlFrontier) rateBucketKey(counter string, t time.Time) string {
+	return f.keys.key(counter + strconv.FormatInt(t.Unix()/60, 10))
+}
+
+// countRate adds n to the current minute's bucket of counter.
+func (f *urlFrontier) countRate(ctx context.Context, pipe redis.Pipeliner, counter string, n int64) {
+	key := f.rateBucketKey(counter, time.Now())
+	pipe.IncrBy(ctx, key, n)
+	pipe.Expire(ctx, key, rateBucketTTL)
+}
+
+func (f *urlFrontier) FrontierStats(ctx context.Context) (*FrontierStats, error) {
+	pending, err := f.pendingSize(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pending urls: %w", err)
+	}
+	workers, err := f.ProcessingWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen, err := f.redisBloomClient.Count()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := f.redisClient.Pipeline()
+	failed := pipe.LLen(ctx, f.keys.key(failedQueue))
+	processing := make([]*redis.IntCmd, len(workers))
+	for i, id := range workers {
+		processing[i] = pipe.LLen(ctx, f.processingKey(id))
+	}
+	now := time.Now()
+	var enqueued, dequeued []*redis.StringCmd
+	for i := 1; i <= rateWindowMinutes; i++ {
+		t := now.Add(-time.Duration(i) * time.Minute)
+		enqueued = append(enqueued, pipe.Get(ctx, f.rateBucketKey(enqueuedCounter, t)))
+		dequeued = append(dequeued, pipe.Get(ctx, f.rateBucketKey(dequeuedCounter, t)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to read frontier stats: %w", err)
+	}
+
+	stats := &FrontierStats{
+		Pending:      pending,
+		Failed:       failed.Val(),
+		PerWorker:    make(map[string]int64, len(workers)),
+		SeenEstimate: seen,
+		EnqueueRate:  windowRate(enqueued),
+		DequeueRate:  windowRate(dequeued),
+	}
+	for i, id := range workers {
+		stats.PerWorker[id] = processing[i].Val()
+		stats.Processing += processing[i].Val()
+	}
+	return stats, nil
+}
+
+func windowRate(buckets []*redis.StringCmd) float64 {
+	var total int64
+	for _, b := range buckets {
+		n, err := b.Int64()
+		if err == nil {
+			total += n
+		}
+	}
+	return float64(total) / (rateWindowMinutes * 60)
+}
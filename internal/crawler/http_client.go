@@ -1,26 +1,161 @@
 package crawler
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/amankumarsingh77/search_engine/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
+	"strings"
 	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	defaultMaxBodySize  = 5 << 20
+	defaultMaxRedirects = 10
+	sniffLen            = 512
 )
 
+var (
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrBodyTooLarge           = errors.New("response body too large")
+	ErrRedirectLoop           = errors.New("redirect loop")
+	ErrTooManyRedirects       = errors.New("too many redirects")
+)
+
+var defaultAllowedContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// Response is the outcome of a fetch. On failure Visit still returns the
+// response metadata it gathered (status, redirects, headers) with a nil Body.
+type Response struct {
+	Body          io.ReadCloser
+	Header        http.Header
+	StatusCode    int
+	ContentType   string
+	FinalURL      string
+	RedirectChain []string
+	ContentLength int64
+}
+
+// MediaType returns the lower-cased media type of the body without parameters.
+func (r *Response) MediaType() string {
+	mediaType, _, err := mime.ParseMediaType(r.ContentType)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(mediaType)
+}
+
+// BytesRead reports how many decoded body bytes have been consumed so far.
+func (r *Response) BytesRead() int64 {
+	if body, ok := r.Body.(*limitedBody); ok {
+		return body.read
+	}
+	return 0
+}
+
+type redirectTraceKey struct{}
+
+type redirectTrace struct {
+	chain []string
+	seen  map[string]bool
+}
+
+// checkRedirect follows 3xx responses up to maxRedirects hops. Each hop's
+// normalized URL is tracked so that a chain which comes back to a URL it has
+// already visited fails with ErrRedirectLoop instead of burning the budget.
+func (h *HttpClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	trace, _ := req.Context().Value(redirectTraceKey{}).(*redirectTrace)
+	if trace == nil {
+		trace = &redirectTrace{}
+	}
+	if trace.seen == nil {
+		trace.seen = make(map[string]bool)
+		if key, err := normalizeUrl(via[0].URL.String()); err == nil {
+			trace.seen[key] = true
+		}
+	}
+	trace.chain = append(trace.chain, via[len(via)-1].URL.String())
+
+	target := req.URL.String()
+	if key, err := normalizeUrl(target); err == nil {
+		if trace.seen[key] {
+			return fmt.Errorf("%w: %s", ErrRedirectLoop, target)
+		}
+		trace.seen[key] = true
+	}
+	if len(via) >= h.maxRedirects {
+		return fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, h.maxRedirects)
+	}
+	// Headers are copied from the first request, so a redirect to another
+	// domain needs that domain's headers added.
+	h.domainHeaders.apply(req)
+	return nil
+}
+
 type HttpClient struct {
-	client  *http.Client
-	headers http.Header
+	client              *http.Client
+	headers             http.Header
+	maxBodySize         int64
+	allowedContentTypes map[string]bool
+	headCheck           bool
+	maxRedirects        int
+	conns               *connStats
+	domainHeaders       domainHeaders
+	timeout             time.Duration
+	timeouts            domainTimeouts
 }
 
-func NewHttpClient(cfg *config.CrawlerConfig) *HttpClient {
+func NewHttpClient(cfg *config.CrawlerConfig) (*HttpClient, error) {
+	maxIdleConnsPerHost := 10
+	if cfg.HTTP.MaxIdleConnsPerHost > 0 {
+		maxIdleConnsPerHost = cfg.HTTP.MaxIdleConnsPerHost
+	}
+	idleConnTimeout := 90 * time.Second
+	if cfg.HTTP.IdleConnTimeout > 0 {
+		idleConnTimeout = cfg.HTTP.IdleConnTimeout
+	}
+	keepAlive := 30 * time.Second
+	if cfg.HTTP.KeepAlive > 0 {
+		keepAlive = cfg.HTTP.KeepAlive
+	}
+	tlsConfig, err := newTLSConfig(&cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: keepAlive,
+	}
 	transport := &http.Transport{
-		MaxIdleConnsPerHost: 10,
+		DialContext:         newDNSCache(cfg.HTTP.DNSCacheTTL).dialContext(dialer),
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.HTTP.MaxConnsPerHost,
 		MaxIdleConns:        100,
-		IdleConnTimeout:     90 * time.Second,
+		IdleConnTimeout:     idleConnTimeout,
 		DisableKeepAlives:   false,
-		DisableCompression:  false,
+		// A custom DialContext turns off HTTP/2 unless it is asked for
+		// explicitly. MaxConnsPerHost still caps concurrent streams' parent
+		// connections, so politeness limits hold either way.
+		ForceAttemptHTTP2: cfg.HTTP.EnableHTTP2,
+		TLSClientConfig:   tlsConfig,
+		// Accept-Encoding is set explicitly below so brotli can be negotiated;
+		// that disables the transport's transparent gzip handling, so every
+		// encoding is decoded in decodeBody instead.
+		DisableCompression: true,
 	}
 	if cfg.ProxyEnabled {
 		proxyUrl, err := url.Parse(cfg.ProxyUrl)
@@ -30,39 +165,278 @@ func NewHttpClient(cfg *config.CrawlerConfig) *HttpClient {
 			fmt.Printf("failed to load the proxy : %s. Please check the config file", cfg.ProxyUrl)
 		}
 	}
+	// Requests are bounded per host by requestContext rather than by a
+	// single client-wide timeout.
 	client := &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Second,
+		Transport: otelhttp.NewTransport(transport),
+	}
+	if cfg.HTTP.CookieJar {
+		// Without a public suffix list the jar refuses cookies scoped to a
+		// parent domain, which keeps one site's cookies from leaking to
+		// another under a shared suffix.
+		jar, err := cookiejar.New(nil)
+		if err == nil {
+			client.Jar = jar
+		}
 	}
 	headers := http.Header{
 		"User-Agent":      []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36"},
-		"Accept":          []string{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		"Accept":          []string{"text/html,application/xhtml+xml,application/xml;q=0.9,application/pdf;q=0.8,text/plain;q=0.8,*/*;q=0.7"},
 		"Accept-Language": []string{"en-US,en;q=0.5"},
+		"Accept-Encoding": []string{"gzip, deflate, br"},
 		"Connection":      []string{"keep-alive"},
 	}
-	return &HttpClient{
-		client:  client,
-		headers: headers,
+
+	maxBodySize := int64(defaultMaxBodySize)
+	if cfg.HTTP.MaxBodySize > 0 {
+		maxBodySize = cfg.HTTP.MaxBodySize
+	}
+	contentTypes := cfg.HTTP.AllowedContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultAllowedContentTypes
+	}
+	allowed := make(map[string]bool, len(contentTypes))
+	for _, ct := range contentTypes {
+		allowed[strings.ToLower(strings.TrimSpace(ct))] = true
+	}
+
+	timeout := defaultRequestTimeout
+	if cfg.HTTP.Timeout > 0 {
+		timeout = cfg.HTTP.Timeout
+	}
+
+	maxRedirects := defaultMaxRedirects
+	if cfg.HTTP.MaxRedirects > 0 {
+		maxRedirects = cfg.HTTP.MaxRedirects
 	}
+
+	h := &HttpClient{
+		client:              client,
+		headers:             headers,
+		maxBodySize:         maxBodySize,
+		allowedContentTypes: allowed,
+		headCheck:           cfg.HTTP.HeadCheck,
+		maxRedirects:        maxRedirects,
+		conns:               newConnStats(),
+		domainHeaders:       newDomainHeaders(cfg.HTTP.DomainHeaders),
+		timeout:             timeout,
+		timeouts:            newDomainTimeouts(cfg.HTTP.Timeouts),
+	}
+	client.CheckRedirect = h.checkRedirect
+	return h, nil
+}
+
+// ConnStats returns per-host connection reuse counters gathered so far.
+func (h *HttpClient) ConnStats() map[string]ConnStat {
+	return h.conns.snapshot()
 }
 
-func (h *HttpClient) Visit(url string) (io.ReadCloser, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// newRequest builds a request carrying the crawler's headers and bounded by
+// the host's timeout. The returned cancel releases that timeout and must be
+// called once the response body is done with.
+func (h *HttpClient) newRequest(ctx context.Context, method, url string) (*http.Request, context.CancelFunc, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	ctx, cancel := h.requestContext(ctx, req.URL.Hostname())
+	req = req.WithContext(ctx)
 	for key, vals := range h.headers {
 		for _, val := range vals {
 			req.Header.Add(key, val)
 		}
 	}
+	h.domainHeaders.apply(req)
+	return req, cancel, nil
+}
+
+func (h *HttpClient) Visit(ctx context.Context, url string) (*Response, error) {
+	if h.headCheck {
+		if err := h.precheck(ctx, url); err != nil {
+			return nil, err
+		}
+	}
+
+	req, cancel, err := h.newRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, err
+	}
+	// On success the body's closer takes over cancelling.
+	bodyHandedOff := false
+	defer func() {
+		if !bodyHandedOff {
+			cancel()
+		}
+	}()
+	trace := &redirectTrace{}
+	ctx = context.WithValue(req.Context(), redirectTraceKey{}, trace)
+	req = req.WithContext(httptrace.WithClientTrace(ctx, h.conns.clientTrace()))
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		if resp == nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		// A rejected redirect still hands back the last 3xx response, which
+		// is worth keeping for the chain it reveals.
+		resp.Body.Close()
+		return newResponseMeta(resp, trace), fmt.Errorf("request failed: %w", err)
 	}
+	meta := newResponseMeta(resp, trace)
+	h.conns.recordProto(resp)
 	if resp.StatusCode != http.StatusOK {
 		defer resp.Body.Close()
-		return nil, fmt.Errorf("bad response status: %s", resp.Status)
+		return meta, fmt.Errorf("bad response status: %s", resp.Status)
+	}
+	if resp.ContentLength > h.maxBodySize {
+		resp.Body.Close()
+		return meta, fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, resp.ContentLength)
+	}
+
+	decoded, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		resp.Body.Close()
+		return meta, err
+	}
+
+	body := bufio.NewReaderSize(decoded, sniffLen)
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		head, _ := body.Peek(sniffLen)
+		contentType = http.DetectContentType(head)
+	}
+	if !h.isAllowedContentType(contentType) {
+		resp.Body.Close()
+		return meta, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+	meta.ContentType = contentType
+
+	meta.Body = &limitedBody{
+		reader: io.LimitReader(body, h.maxBodySize+1),
+		closer: closerFunc(func() error {
+			if c, ok := decoded.(io.Closer); ok {
+				c.Close()
+			}
+			defer cancel()
+			return resp.Body.Close()
+		}),
+		limit: h.maxBodySize,
+	}
+	bodyHandedOff = true
+	return meta, nil
+}
+
+func newResponseMeta(resp *http.Response, trace *redirectTrace) *Response {
+	finalURL := resp.Request.URL.String()
+	if normalized, err := normalizeUrl(finalURL); err == nil {
+		finalURL = normalized
+	}
+	return &Response{
+		Header:        resp.Header,
+		StatusCode:    resp.StatusCode,
+		FinalURL:      finalURL,
+		RedirectChain: trace.chain,
+		ContentLength: resp.ContentLength,
+	}
+}
+
+// decodeBody wraps body with a decompressor matching the Content-Encoding
+// header. Multiple encodings are applied in reverse order of listing.
+func decodeBody(body io.Reader, contentEncoding string) (io.Reader, error) {
+	if contentEncoding == "" {
+		return body, nil
+	}
+	encodings := strings.Split(contentEncoding, ",")
+	reader := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(reader)
+		case "deflate":
+			reader, err = newDeflateReader(reader)
+		case "br":
+			reader = brotli.NewReader(reader)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding: %s", encodings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s response: %w", encodings[i], err)
+		}
+	}
+	return reader, nil
+}
+
+// newDeflateReader handles both zlib-wrapped deflate (what the spec says) and
+// raw deflate streams (what a lot of servers actually send).
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
 	}
-	return resp.Body, nil
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// precheck issues a HEAD request so oversized or non-HTML resources can be
+// skipped without downloading them. Servers that don't support HEAD are let
+// through and rely on the checks done during the GET.
+func (h *HttpClient) precheck(ctx context.Context, url string) error {
+	req, cancel, err := h.newRequest(ctx, "HEAD", url)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	if resp.ContentLength > h.maxBodySize {
+		return fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, resp.ContentLength)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !h.isAllowedContentType(contentType) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+	return nil
+}
+
+func (h *HttpClient) isAllowedContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return h.allowedContentTypes[strings.ToLower(mediaType)]
+}
+
+type limitedBody struct {
+	reader io.Reader
+	closer io.Closer
+	limit  int64
+	read   int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, fmt.Errorf("%w: exceeded %d bytes", ErrBodyTooLarge, b.limit)
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.closer.Close()
 }
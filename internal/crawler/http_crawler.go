@@ -1,14 +1,27 @@
 package crawler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/amankumarsingh77/search_engine/internal/common/database"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
 	"github.com/amankumarsingh77/search_engine/models"
+	"github.com/amankumarsingh77/search_engine/pkg"
+	"log"
+	"net/http"
 	httpUrl "net/url"
 	"strings"
+	"time"
 )
 
+// recordedHeaders are the response headers kept on the page for crawl analysis.
+var recordedHeaders = []string{
+	"Content-Type", "Content-Language", "Content-Encoding", "Last-Modified",
+	"ETag", "Cache-Control", "Server", "X-Robots-Tag",
+}
+
 type httpCrawler struct {
 	collector *HttpClient
 	frontier  URLFrontier
@@ -16,7 +29,7 @@ type httpCrawler struct {
 }
 
 type WebCrawler interface {
-	CrawlPage(url string) (*models.WebPage, error)
+	CrawlPage(ctx context.Context, url string) (*models.WebPage, error)
 }
 
 func NewHttpCrawler(collector *HttpClient, frontier URLFrontier, db *database.MongoClient) WebCrawler {
@@ -27,19 +40,87 @@ func NewHttpCrawler(collector *HttpClient, frontier URLFrontier, db *database.Mo
 	}
 }
 
-func (c *httpCrawler) CrawlPage(url string) (*models.WebPage, error) {
+func (c *httpCrawler) CrawlPage(ctx context.Context, url string) (*models.WebPage, error) {
+	start := time.Now()
+	resp, err := c.collector.Visit(ctx, url)
+	return c.processResponse(ctx, url, resp, err, start)
+}
+
+// processResponse turns a fetched response into a WebPage. It is shared by
+// every fetch backend so that rendered and plain pages are extracted the same
+// way.
+func (c *httpCrawler) processResponse(ctx context.Context, url string, resp *Response, err error, start time.Time) (*models.WebPage, error) {
 	var pageData *models.WebPage
 
-	respBody, err := c.collector.Visit(url)
+	if resp != nil {
+		c.markRedirects(ctx, resp)
+	}
+	if err != nil {
+		if resp == nil {
+			return nil, err
+		}
+		return &models.WebPage{
+			URL:   url,
+			Fetch: fetchMetadata(resp, resp.ContentLength, time.Since(start)),
+		}, err
+	}
+	defer resp.Body.Close()
+
+	handler, ok := contentHandlers[resp.MediaType()]
+	if !ok {
+		handler = (*httpCrawler).parseHTML
+	}
+	pageData, err = handler(c, url, resp)
+	if err != nil {
+		return &models.WebPage{
+			URL:   url,
+			Fetch: fetchMetadata(resp, resp.BytesRead(), time.Since(start)),
+		}, err
+	}
+	pageData.URL = url
+	pageData.Fetch = fetchMetadata(resp, resp.BytesRead(), time.Since(start))
+
+	// The header applies to every content type, so it is merged here rather
+	// than in the HTML handler that reads the meta tag.
+	var robots robotsDirectives
+	robots.addHeader(resp.Header.Values("X-Robots-Tag"))
+	robots.apply(pageData)
+
+	quality, err := c.frontier.SourceQuality(ctx, url)
 	if err != nil {
-		return nil, err
+		log.Printf("failed to look up source quality for %s: %v", url, err)
+		quality = pkg.DefaultSourceQuality
 	}
-	doc, err := goquery.NewDocumentFromReader(respBody)
+	pageData.SourceQuality = quality
+
+	//docID, err := c.db.AddWebPage(pageData)
+	//if err != nil {
+	//	log.Printf("Error saving webpage %s: %v", url, err)
+	//	return nil, fmt.Errorf("failed to save webpage: %w", err)
+	//}
+	//pageData.ID = docID
+	return pageData, nil
+}
+
+func (c *httpCrawler) parseHTML(url string, resp *Response) (*models.WebPage, error) {
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response : %v", err)
 	}
 	title := strings.TrimSpace(doc.Find("title").Text())
+	htmlLang := strings.TrimSpace(doc.Find("html").AttrOr("lang", ""))
+	contentLanguage := strings.TrimSpace(resp.Header.Get("Content-Language"))
 	description := strings.TrimSpace(doc.Find("meta[name='description']").AttrOr("content", ""))
+	structured := extractStructuredData(doc)
+	if structured != nil {
+		if title == "" {
+			title = structured.OGTitle
+		}
+		if description == "" {
+			description = structured.OGDescription
+		}
+	}
+	canonical := canonicalURL(resp.FinalURL, doc.Find("link[rel='canonical']").AttrOr("href", ""))
 	keywordsRaw := strings.TrimSpace(doc.Find("meta[name='keywords']").AttrOr("content", ""))
 	var keywords []string
 	if keywordsRaw != "" {
@@ -49,6 +130,8 @@ func (c *httpCrawler) CrawlPage(url string) (*models.WebPage, error) {
 		}
 	}
 
+	headings := extractHeadings(doc)
+
 	var bodyTextBuilder strings.Builder
 	allowedTags := map[string]bool{
 		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
@@ -68,20 +151,33 @@ func (c *httpCrawler) CrawlPage(url string) (*models.WebPage, error) {
 	})
 
 	var internalLinks, externalLinks []string
+	var anchors []models.Anchor
+	baseUrl, err := httpUrl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page url : %v", err)
+	}
+	seen := make(map[string]bool)
 	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
-		seen := make(map[string]bool)
 		parsedUrl, err := httpUrl.Parse(strings.TrimSpace(s.AttrOr("href", "")))
 		if err != nil {
 			return
 		}
-		baseUrl, _ := httpUrl.Parse(url)
-		absUrl := baseUrl.ResolveReference(parsedUrl).String()
+		resolved := baseUrl.ResolveReference(parsedUrl)
+		if resolved.Scheme != "http" && resolved.Scheme != "https" {
+			return
+		}
+		resolved.Fragment = ""
+		absUrl := resolved.String()
 
 		if seen[absUrl] {
 			return
 		}
-		if strings.Contains(absUrl, baseUrl.Host) {
+		seen[absUrl] = true
+		if strings.EqualFold(resolved.Hostname(), baseUrl.Hostname()) {
 			internalLinks = append(internalLinks, absUrl)
+			if anchor, ok := anchorFor(url, absUrl, s); ok {
+				anchors = append(anchors, anchor)
+			}
 		} else {
 			externalLinks = append(externalLinks, absUrl)
 		}
@@ -93,28 +189,173 @@ func (c *httpCrawler) CrawlPage(url string) (*models.WebPage, error) {
 		if text == "" {
 			return
 		}
-		normalizedText := normalize(text)
+		normalizedText := textproc.Normalize(text)
 		if normalizedText != "" {
 			paras = append(paras, normalizedText)
 		}
 	})
 
-	pageData = &models.WebPage{
-		URL:           url,
+	page := &models.WebPage{
 		Title:         title,
 		Description:   description,
 		Paragraphs:    paras,
 		Keywords:      keywords,
+		Headings:      headings,
 		BodyText:      bodyTextBuilder.String(),
 		InternalLinks: internalLinks,
 		ExternalLinks: externalLinks,
+		Anchors:       anchors,
+
+		HTMLLang:        htmlLang,
+		ContentLanguage: contentLanguage,
+		LanguageHint:    languageHint(htmlLang, contentLanguage),
+
+		CanonicalURL: canonical,
+		Structured:   structured,
 	}
+	var robots robotsDirectives
+	robots.addMeta(doc)
+	robots.apply(page)
+	return page, nil
+}
 
-	//docID, err := c.db.AddWebPage(pageData)
-	//if err != nil {
-	//	log.Printf("Error saving webpage %s: %v", url, err)
-	//	return nil, fmt.Errorf("failed to save webpage: %w", err)
-	//}
-	//pageData.ID = docID
-	return pageData, nil
+// maxHeadingText caps the text kept per heading, like maxAnchorText.
+const maxHeadingText = 300
+
+// extractHeadings returns the text of the page's h1–h6 elements keyed by tag,
+// in document order. Headings inside nav, header and footer are site chrome
+// repeated on every page, so they are left out.
+func extractHeadings(doc *goquery.Document) map[string][]string {
+	var headings map[string][]string
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(_ int, s *goquery.Selection) {
+		if s.Closest("nav, header, footer").Length() > 0 {
+			return
+		}
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" {
+			return
+		}
+		if len(text) > maxHeadingText {
+			text = strings.ToValidUTF8(text[:maxHeadingText], "")
+		}
+		if headings == nil {
+			headings = make(map[string][]string)
+		}
+		tag := goquery.NodeName(s)
+		headings[tag] = append(headings[tag], text)
+	})
+	return headings
+}
+
+// maxAnchorText caps the anchor text kept per link; longer anchors are
+// usually whole blocks wrapped in a link rather than a label.
+const maxAnchorText = 200
+
+// anchorFor returns the anchor text of a link to target, keyed by the
+// normalized target so the indexer can match it to the target's document.
+// Self-links and links without text are skipped.
+func anchorFor(pageURL, target string, s *goquery.Selection) (models.Anchor, bool) {
+	text := strings.Join(strings.Fields(s.Text()), " ")
+	if text == "" {
+		text = strings.TrimSpace(s.Find("img").AttrOr("alt", ""))
+	}
+	if text == "" {
+		return models.Anchor{}, false
+	}
+	normalized, err := normalizeUrl(target)
+	if err != nil {
+		return models.Anchor{}, false
+	}
+	if self, err := normalizeUrl(pageURL); err == nil && self == normalized {
+		return models.Anchor{}, false
+	}
+	if len(text) > maxAnchorText {
+		text = strings.ToValidUTF8(text[:maxAnchorText], "")
+	}
+	return models.Anchor{URL: normalized, Text: text}, true
+}
+
+// canonicalURL resolves a rel=canonical href against the page URL and
+// normalizes it. Missing or unparsable hints yield "".
+func canonicalURL(pageURL, href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	base, err := httpUrl.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	ref, err := httpUrl.Parse(href)
+	if err != nil {
+		return ""
+	}
+	normalized, err := normalizeUrl(base.ResolveReference(ref).String())
+	if err != nil {
+		return ""
+	}
+	return normalized
+}
+
+// urlEquivalences lists every URL form seen for a crawled page: the URL that
+// was queued and each hop of its redirect chain.
+func urlEquivalences(page *models.WebPage) []models.URLEquivalence {
+	raws := []string{page.URL}
+	var finalURL string
+	if page.Fetch != nil {
+		raws = append(raws, page.Fetch.RedirectChain...)
+		finalURL = page.Fetch.FinalURL
+	}
+	entries := make([]models.URLEquivalence, 0, len(raws))
+	seen := make(map[string]bool, len(raws))
+	for _, raw := range raws {
+		if seen[raw] {
+			continue
+		}
+		seen[raw] = true
+		normalized, err := normalizeUrl(raw)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, models.URLEquivalence{
+			RawURL:        raw,
+			NormalizedURL: normalized,
+			CanonicalURL:  page.CanonicalURL,
+			FinalURL:      finalURL,
+		})
+	}
+	return entries
+}
+
+// markRedirects adds every URL the fetch passed through to the bloom filter so
+// that links pointing at either end of a redirect aren't fetched again.
+func (c *httpCrawler) markRedirects(ctx context.Context, resp *Response) {
+	if len(resp.RedirectChain) == 0 {
+		return
+	}
+	for _, u := range resp.RedirectChain {
+		if err := c.frontier.Visit(ctx, u); err != nil && !errors.Is(err, ErrURLFiltered) {
+			log.Printf("failed to mark redirect %s as visited: %v", u, err)
+		}
+	}
+	if err := c.frontier.Visit(ctx, resp.FinalURL); err != nil && !errors.Is(err, ErrURLFiltered) {
+		log.Printf("failed to mark redirect target %s as visited: %v", resp.FinalURL, err)
+	}
+}
+
+func fetchMetadata(resp *Response, contentLength int64, duration time.Duration) *models.FetchMetadata {
+	headers := make(map[string]string)
+	for _, name := range recordedHeaders {
+		if v := resp.Header.Get(name); v != "" {
+			headers[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	return &models.FetchMetadata{
+		StatusCode:    resp.StatusCode,
+		FinalURL:      resp.FinalURL,
+		RedirectChain: resp.RedirectChain,
+		ContentLength: contentLength,
+		DurationMs:    duration.Milliseconds(),
+		Headers:       headers,
+	}
 }
@@ -0,0 +1,17 @@
+package crawler
+
+// keyspace scopes the Redis keys of one crawl job so several jobs can share a
+// Redis without seeing each other's frontier, bloom filter or bookkeeping.
+// The default crawl uses the empty keyspace and keeps the unprefixed keys it
+// always had.
+type keyspace string
+
+const defaultKeyspace keyspace = ""
+
+func jobKeyspace(name string) keyspace {
+	return keyspace("job:" + name + ":")
+}
+
+func (ks keyspace) key(name string) string {
+	return string(ks) + name
+}
@@ -0,0 +1,35 @@
+package crawler
+
+import "strings"
+
+// languageHint derives a primary language subtag ("en", "hi") from the
+// declared page language. The <html lang> attribute is authored per page and
+// wins over Content-Language, which servers often set site-wide.
+func languageHint(htmlLang, contentLanguage string) string {
+	if tag := primarySubtag(htmlLang); tag != "" {
+		return tag
+	}
+	for _, lang := range strings.Split(contentLanguage, ",") {
+		if tag := primarySubtag(lang); tag != "" {
+			return tag
+		}
+	}
+	return ""
+}
+
+func primarySubtag(lang string) string {
+	lang = strings.TrimSpace(lang)
+	if i := strings.IndexAny(lang, "-_;"); i >= 0 {
+		lang = lang[:i]
+	}
+	lang = strings.ToLower(lang)
+	if len(lang) < 2 || len(lang) > 3 {
+		return ""
+	}
+	for _, r := range lang {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return lang
+}
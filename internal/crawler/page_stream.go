@@ -0,0 +1,66 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultPageStreamKey matches the indexer's default.
+	defaultPageStreamKey    = "crawled_pages"
+	defaultPageStreamMaxLen = 100000
+)
+
+// pageStream appends crawled pages to the Redis Stream the indexer reads in
+// streaming mode. Each entry holds one page as JSON under "page".
+type pageStream struct {
+	client *redis.Client
+	key    string
+	maxLen int64
+}
+
+// newPageStream returns nil unless streaming is enabled.
+func newPageStream(client *redis.Client, cfg *config.StreamConfig) *pageStream {
+	if !cfg.Enabled {
+		return nil
+	}
+	key := defaultPageStreamKey
+	if cfg.Key != "" {
+		key = cfg.Key
+	}
+	maxLen := int64(defaultPageStreamMaxLen)
+	if cfg.MaxLen > 0 {
+		maxLen = cfg.MaxLen
+	}
+	return &pageStream{client: client, key: key, maxLen: maxLen}
+}
+
+// publish appends pages in one round trip. The stream is trimmed
+// approximately, which Redis does in whole nodes and far more cheaply.
+func (s *pageStream) publish(ctx context.Context, pages []*models.WebPage) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	pipe := s.client.Pipeline()
+	for _, page := range pages {
+		data, err := json.Marshal(page)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", page.URL, err)
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.key,
+			MaxLen: s.maxLen,
+			Approx: true,
+			Values: map[string]any{"page": data},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to publish pages: %w", err)
+	}
+	return nil
+}
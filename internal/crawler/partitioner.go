@@ -0,0 +1,180 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	clusterNodesKey          = "crawler:nodes"
+	defaultPartitionSlots    = 256
+	defaultVirtualNodes      = 64
+	defaultHeartbeatInterval = 10 * time.Second
+	defaultNodeTTL           = 30 * time.Second
+)
+
+// Partitioner splits hosts across crawler instances. Every host hashes to one
+// of a fixed number of slots, and slots are assigned to the live nodes with a
+// consistent-hash ring so membership changes only move a fraction of them.
+type Partitioner struct {
+	redisClient *redis.Client
+	nodeID      string
+	slots       int
+	vnodes      int
+	interval    time.Duration
+	ttl         time.Duration
+	keys        keyspace
+
+	mu    sync.RWMutex
+	owned []int
+	nodes []string
+}
+
+type ringPoint struct {
+	hash uint32
+	node string
+}
+
+func NewPartitioner(redisClient *redis.Client, cfg *config.ClusterConfig, keys keyspace) *Partitioner {
+	p := &Partitioner{
+		redisClient: redisClient,
+		nodeID:      cfg.NodeID,
+		slots:       defaultPartitionSlots,
+		vnodes:      defaultVirtualNodes,
+		interval:    defaultHeartbeatInterval,
+		ttl:         defaultNodeTTL,
+		keys:        keys,
+	}
+	if p.nodeID == "" {
+		hostname, _ := os.Hostname()
+		p.nodeID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if cfg.Slots > 0 {
+		p.slots = cfg.Slots
+	}
+	if cfg.VirtualNodes > 0 {
+		p.vnodes = cfg.VirtualNodes
+	}
+	if cfg.HeartbeatInterval > 0 {
+		p.interval = cfg.HeartbeatInterval
+	}
+	if cfg.NodeTTL > 0 {
+		p.ttl = cfg.NodeTTL
+	}
+	return p
+}
+
+func (p *Partitioner) NodeID() string {
+	return p.nodeID
+}
+
+// Start registers the node and keeps its heartbeat and slot ownership fresh
+// until ctx is cancelled, at which point the node deregisters itself.
+func (p *Partitioner) Start(ctx context.Context) error {
+	if err := p.heartbeat(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := p.redisClient.ZRem(leaveCtx, p.keys.key(clusterNodesKey), p.nodeID).Err(); err != nil {
+					log.Printf("partitioner: failed to deregister node %s: %v", p.nodeID, err)
+				}
+				cancel()
+				return
+			case <-ticker.C:
+				if err := p.heartbeat(ctx); err != nil {
+					log.Printf("partitioner: heartbeat failed: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *Partitioner) heartbeat(ctx context.Context) error {
+	now := time.Now()
+	pipe := p.redisClient.TxPipeline()
+	pipe.ZAdd(ctx, p.keys.key(clusterNodesKey), redis.Z{Score: float64(now.Unix()), Member: p.nodeID})
+	pipe.ZRemRangeByScore(ctx, p.keys.key(clusterNodesKey), "-inf", strconv.FormatInt(now.Add(-p.ttl).Unix(), 10))
+	members := pipe.ZRange(ctx, p.keys.key(clusterNodesKey), 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to refresh cluster membership: %w", err)
+	}
+	p.rebalance(members.Val())
+	return nil
+}
+
+func (p *Partitioner) rebalance(nodes []string) {
+	sort.Strings(nodes)
+	ring := make([]ringPoint, 0, len(nodes)*p.vnodes)
+	for _, node := range nodes {
+		for v := 0; v < p.vnodes; v++ {
+			ring = append(ring, ringPoint{hash: hash32(fmt.Sprintf("%s#%d", node, v)), node: node})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	var owned []int
+	for slot := 0; slot < p.slots; slot++ {
+		if ownerOf(ring, hash32("slot:"+strconv.Itoa(slot))) == p.nodeID {
+			owned = append(owned, slot)
+		}
+	}
+
+	p.mu.Lock()
+	changed := len(owned) != len(p.owned) || len(nodes) != len(p.nodes)
+	p.owned = owned
+	p.nodes = nodes
+	p.mu.Unlock()
+	if changed {
+		log.Printf("partitioner: node %s owns %d/%d slots across %d nodes", p.nodeID, len(owned), p.slots, len(nodes))
+	}
+}
+
+func ownerOf(ring []ringPoint, h uint32) string {
+	if len(ring) == 0 {
+		return ""
+	}
+	idx := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	if idx == len(ring) {
+		idx = 0
+	}
+	return ring[idx].node
+}
+
+func (p *Partitioner) SlotFor(host string) int {
+	return int(hash32(host) % uint32(p.slots))
+}
+
+func (p *Partitioner) OwnedSlots() []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]int(nil), p.owned...)
+}
+
+func (p *Partitioner) Nodes() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]string(nil), p.nodes...)
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,347 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// maxPDFInflateSize bounds the bytes inflated from all of a PDF's streams
+// together, so a small file of many compressed streams can't expand into
+// gigabytes.
+const maxPDFInflateSize = 16 << 20
+
+var (
+	errNoPDFText = errors.New("no extractable text")
+
+	pdfStreamRe = regexp.MustCompile(`>>\s*stream\r?\n`)
+	pdfTitleRe  = regexp.MustCompile(`/Title\s*\(`)
+)
+
+// extractPDFText pulls the document title and visible text out of a PDF. It
+// understands uncompressed and FlateDecode content streams and the text
+// showing operators (Tj, TJ, ' and "), which covers most text-based PDFs.
+// Hex strings are decoded too. Shown strings that are mostly control bytes,
+// which is what two-byte CID fonts and most custom encodings turn into
+// without their ToUnicode maps, are dropped, so scanned documents and such
+// fonts yield errNoPDFText rather than being indexed as noise.
+func extractPDFText(data []byte) (string, string, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data[:min(len(data), 1024)]), []byte("%PDF-")) {
+		return "", "", errors.New("not a pdf document")
+	}
+
+	var text strings.Builder
+	inflateBudget := int64(maxPDFInflateSize)
+	for pos := 0; pos < len(data) && inflateBudget > 0; {
+		loc := pdfStreamRe.FindIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		dictEnd := pos + loc[0] + len(">>")
+		start := pos + loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		// Whatever the outcome, the search resumes past this stream so its
+		// binary data is never mistaken for another stream's header.
+		pos = start + end + len("endstream")
+		dictStart := pdfDictStart(data, dictEnd)
+		if dictStart < 0 {
+			continue
+		}
+		dict := data[dictStart:dictEnd]
+		raw := data[start : start+end]
+		if bytes.Contains(dict, []byte("/Subtype/Image")) || bytes.Contains(dict, []byte("/Subtype /Image")) {
+			continue
+		}
+		content := raw
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			r, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			content, err = io.ReadAll(io.LimitReader(r, inflateBudget))
+			r.Close()
+			inflateBudget -= int64(len(content))
+			if err != nil && len(content) == 0 {
+				continue
+			}
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			// Other filters (DCT, LZW, ...) almost never carry text.
+			continue
+		}
+		pdfContentText(content, &text)
+	}
+
+	body := strings.TrimSpace(text.String())
+	if body == "" {
+		return "", "", errNoPDFText
+	}
+	return pdfTitle(data), body, nil
+}
+
+// pdfDictStart returns the offset of the << opening the dictionary that ends
+// just before dictEnd, balancing nested dictionaries on the way back. The
+// search stops at the nearest obj keyword, the header of the object owning
+// the stream, so it never wanders into an earlier object; -1 means no
+// balanced dictionary was found there.
+func pdfDictStart(data []byte, dictEnd int) int {
+	limit := bytes.LastIndex(data[:dictEnd], []byte("obj"))
+	if limit < 0 {
+		limit = 0
+	}
+	depth := 0
+	for i := dictEnd - 1; i > limit; i-- {
+		switch {
+		case data[i] == '>' && data[i-1] == '>':
+			depth++
+			i--
+		case data[i] == '<' && data[i-1] == '<':
+			depth--
+			i--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// pdfContentText appends the strings shown by text operators in a content
+// stream. Each BT/ET text object ends a line and a TJ kerning gap wide enough
+// to be a word space becomes one.
+func pdfContentText(content []byte, out *strings.Builder) {
+	var operands []string
+	inText := false
+	for i := 0; i < len(content); {
+		ch := content[i]
+		switch {
+		case ch == '(':
+			s, n := pdfLiteralString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case ch == '<' && i+1 < len(content) && content[i+1] != '<':
+			s, n := pdfHexString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case ch == '[':
+			end := bytes.IndexByte(content[i:], ']')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfArrayText(content[i+1:i+end]))
+			i += end + 1
+		case ch == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFRegular(ch):
+			j := i
+			for j < len(content) && isPDFRegular(content[j]) {
+				j++
+			}
+			op := string(content[i:j])
+			i = j
+			switch op {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				out.WriteString("\n")
+			case "Tj", "TJ", "'", "\"":
+				if inText && len(operands) > 0 && pdfReadable(operands[len(operands)-1]) {
+					if op != "Tj" && op != "TJ" {
+						out.WriteString("\n")
+					}
+					out.WriteString(operands[len(operands)-1])
+				}
+			case "T*", "Td", "TD":
+				if inText {
+					out.WriteString(" ")
+				}
+			}
+			if !isPDFNumber(op) && !strings.HasPrefix(op, "/") {
+				operands = operands[:0]
+			}
+		default:
+			i++
+		}
+	}
+}
+
+func pdfArrayText(arr []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(arr); {
+		switch {
+		case arr[i] == '(':
+			s, n := pdfLiteralString(arr[i:])
+			sb.WriteString(s)
+			i += n
+		case arr[i] == '<':
+			s, n := pdfHexString(arr[i:])
+			sb.WriteString(s)
+			i += n
+		case arr[i] == '-' || (arr[i] >= '0' && arr[i] <= '9'):
+			j := i + 1
+			for j < len(arr) && (arr[j] == '.' || (arr[j] >= '0' && arr[j] <= '9')) {
+				j++
+			}
+			// Kerning adjustments are in thousandths of an em; large
+			// negative values are how many PDFs encode a space.
+			if arr[i] == '-' && j-i > 3 {
+				sb.WriteByte(' ')
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// pdfLiteralString decodes a (...) string starting at b[0] and returns it
+// along with the number of bytes consumed.
+func pdfLiteralString(b []byte) (string, int) {
+	var sb strings.Builder
+	depth := 0
+	i := 0
+	for i < len(b) {
+		ch := b[i]
+		switch {
+		case ch == '(':
+			if depth > 0 {
+				sb.WriteByte(ch)
+			}
+			depth++
+		case ch == ')':
+			depth--
+			if depth == 0 {
+				return sb.String(), i + 1
+			}
+			sb.WriteByte(ch)
+		case ch == '\\' && i+1 < len(b):
+			i++
+			switch esc := b[i]; esc {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+			default:
+				if esc >= '0' && esc <= '7' {
+					v := 0
+					j := 0
+					for j < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7' {
+						v = v*8 + int(b[i]-'0')
+						i++
+						j++
+					}
+					i--
+					sb.WriteByte(byte(v))
+				} else {
+					sb.WriteByte(esc)
+				}
+			}
+		default:
+			sb.WriteByte(ch)
+		}
+		i++
+	}
+	return sb.String(), i
+}
+
+// pdfHexString decodes a <...> string starting at b[0] and returns it along
+// with the number of bytes consumed. Whitespace is ignored and an odd final
+// digit is padded with 0, as the PDF spec says.
+func pdfHexString(b []byte) (string, int) {
+	end := bytes.IndexByte(b, '>')
+	if end < 0 {
+		end = len(b)
+	}
+	var digits []byte
+	for _, ch := range b[1:end] {
+		if _, ok := hexValue(ch); ok {
+			digits = append(digits, ch)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	decoded := make([]byte, len(digits)/2)
+	for i := range decoded {
+		hi, _ := hexValue(digits[2*i])
+		lo, _ := hexValue(digits[2*i+1])
+		decoded[i] = hi<<4 | lo
+	}
+	return string(decoded), min(end+1, len(b))
+}
+
+func hexValue(ch byte) (byte, bool) {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return ch - '0', true
+	case ch >= 'a' && ch <= 'f':
+		return ch - 'a' + 10, true
+	case ch >= 'A' && ch <= 'F':
+		return ch - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// pdfReadable reports whether a shown string looks like text in a single-byte
+// encoding. Two-byte CID codes and glyph ids are full of NUL and other
+// control bytes, so a string where those make up more than a tenth of the
+// bytes can't be decoded without the font's ToUnicode map and is skipped.
+func pdfReadable(s string) bool {
+	if s == "" {
+		return false
+	}
+	control := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch < 0x20 && ch != '\t' && ch != '\n' && ch != '\r' || ch == 0x7f {
+			control++
+		}
+	}
+	return control*10 <= len(s)
+}
+
+func pdfTitle(data []byte) string {
+	loc := pdfTitleRe.FindIndex(data)
+	if loc == nil {
+		return ""
+	}
+	title, _ := pdfLiteralString(data[loc[1]-1:])
+	// UTF-16 titles (BOM FE FF) are left out rather than mis-decoded.
+	if strings.HasPrefix(title, "\xfe\xff") {
+		return ""
+	}
+	return strings.TrimSpace(title)
+}
+
+func isPDFRegular(ch byte) bool {
+	switch ch {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '%':
+		return false
+	}
+	return true
+}
+
+func isPDFNumber(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	for _, r := range tok {
+		if (r < '0' || r > '9') && r != '.' && r != '-' && r != '+' {
+			return false
+		}
+	}
+	return true
+}
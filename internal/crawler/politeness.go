@@ -0,0 +1,153 @@
+package crawler
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+)
+
+const (
+	defaultMinDelay      = 250 * time.Millisecond
+	defaultMaxDelay      = 30 * time.Second
+	defaultInitialDelay  = 750 * time.Millisecond
+	defaultTargetLatency = time.Second
+	defaultMaxErrorRate  = 0.2
+	defaultLatencyWindow = 50
+	minSamplesToAdapt    = 5
+)
+
+// PolitenessController picks the delay between requests to a host from how
+// that host has been responding. A slow p95 or a high error rate doubles the
+// delay; a fast, healthy host has it shrunk gradually back towards MinDelay.
+type PolitenessController struct {
+	minDelay      time.Duration
+	maxDelay      time.Duration
+	initialDelay  time.Duration
+	targetLatency time.Duration
+	maxErrorRate  float64
+	window        int
+
+	mu    sync.Mutex
+	hosts map[string]*hostStats
+
+	audit *politenessAudit
+}
+
+type hostStats struct {
+	latencies []time.Duration
+	failures  []bool
+	next      int
+	delay     time.Duration
+}
+
+func NewPolitenessController(cfg *config.PolitenessConfig) *PolitenessController {
+	p := &PolitenessController{
+		minDelay:      defaultMinDelay,
+		maxDelay:      defaultMaxDelay,
+		initialDelay:  defaultInitialDelay,
+		targetLatency: defaultTargetLatency,
+		maxErrorRate:  defaultMaxErrorRate,
+		window:        defaultLatencyWindow,
+		hosts:         make(map[string]*hostStats),
+	}
+	if cfg.MinDelay > 0 {
+		p.minDelay = cfg.MinDelay
+	}
+	if cfg.MaxDelay > 0 {
+		p.maxDelay = cfg.MaxDelay
+	}
+	if cfg.InitialDelay > 0 {
+		p.initialDelay = cfg.InitialDelay
+	}
+	if cfg.TargetLatency > 0 {
+		p.targetLatency = cfg.TargetLatency
+	}
+	if cfg.MaxErrorRate > 0 {
+		p.maxErrorRate = cfg.MaxErrorRate
+	}
+	if cfg.Window > 0 {
+		p.window = cfg.Window
+	}
+	return p
+}
+
+func (p *PolitenessController) statsFor(host string) *hostStats {
+	stats, ok := p.hosts[host]
+	if !ok {
+		stats = &hostStats{delay: p.initialDelay}
+		p.hosts[host] = stats
+	}
+	return stats
+}
+
+// Observe records the outcome of a request to host and adjusts its delay.
+// failed should only be set for responses that indicate the server is
+// struggling (timeouts, 429s, 5xx), not for ordinary 404s.
+func (p *PolitenessController) Observe(host string, latency time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.statsFor(host)
+	if len(stats.latencies) < p.window {
+		stats.latencies = append(stats.latencies, latency)
+		stats.failures = append(stats.failures, failed)
+	} else {
+		stats.latencies[stats.next] = latency
+		stats.failures[stats.next] = failed
+		stats.next = (stats.next + 1) % p.window
+	}
+
+	if failed {
+		stats.delay = min(stats.delay*2, p.maxDelay)
+		return
+	}
+	if len(stats.latencies) < minSamplesToAdapt {
+		return
+	}
+
+	p95 := percentile(stats.latencies, 0.95)
+	switch {
+	case p95 > p.targetLatency || stats.errorRate() > p.maxErrorRate:
+		stats.delay = min(stats.delay*2, p.maxDelay)
+	case p95 < p.targetLatency/2:
+		stats.delay = max(stats.delay-stats.delay/10, p.minDelay)
+	}
+}
+
+// Delay returns how long to wait before the next request to host, with up to
+// 25% jitter so workers sharing a host don't fire in lockstep.
+func (p *PolitenessController) Delay(host string) time.Duration {
+	p.mu.Lock()
+	delay := p.statsFor(host).delay
+	p.mu.Unlock()
+	jitter := time.Duration(rand.Int63n(int64(delay)/4 + 1))
+	return delay + jitter
+}
+
+// Audit records a request for the politeness audit report, if enabled.
+func (p *PolitenessController) Audit(url string, status int, at time.Time) {
+	p.audit.Record(url, status, at)
+}
+
+func (s *hostStats) errorRate() float64 {
+	if len(s.failures) == 0 {
+		return 0
+	}
+	var failed int
+	for _, f := range s.failures {
+		if f {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(s.failures))
+}
+
+func percentile(samples []time.Duration, q float64) time.Duration {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(len(sorted)-1) * q)
+	return sorted[idx]
+}
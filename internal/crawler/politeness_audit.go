@@ -0,0 +1,252 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	politenessAuditKey    = "politeness:audit"
+	auditFlushInterval    = time.Minute
+	minIntervalUnmeasured = -1
+	auditQueueSize        = 4096
+	auditCheckers         = 4
+)
+
+// HostAudit is the politeness record of one host: how fast it was actually
+// crawled against what its robots.txt asks for.
+type HostAudit struct {
+	Host                  string  `json:"host"`
+	Requests              int64   `json:"requests"`
+	Status429             int64   `json:"status_429"`
+	RobotsViolations      int64   `json:"robots_violations"`
+	DeclaredCrawlDelayMs  int64   `json:"declared_crawl_delay_ms"`
+	MinIntervalMs         int64   `json:"min_interval_ms"`
+	AvgIntervalMs         int64   `json:"avg_interval_ms"`
+	IntervalsBelowDelay   int64   `json:"intervals_below_crawl_delay"`
+	ObservedRatePerMinute float64 `json:"observed_rate_per_minute"`
+	FirstRequest          int64   `json:"first_request"`
+	LastRequest           int64   `json:"last_request"`
+	Compliant             bool    `json:"compliant"`
+	Intervals             int64   `json:"intervals"`
+	IntervalSumMs         int64   `json:"interval_sum_ms"`
+	Unchecked             int64   `json:"unchecked_requests"`
+}
+
+// politenessAudit tracks, per host, every request the crawler makes so that
+// operators can show it kept to robots.txt rules and crawl-delay. Totals are
+// persisted in Redis and accumulate across runs and nodes.
+//
+// Record only does the bookkeeping that needs no network; checking a request
+// against robots.txt is queued for background checkers, so a slow or missing
+// robots.txt never holds up a worker.
+type politenessAudit struct {
+	redisClient *redis.Client
+	robots      *robotsCache
+	logger      *log.Logger
+	checks      chan auditCheck
+
+	mu    sync.Mutex
+	hosts map[string]*HostAudit
+	last  map[string]time.Time
+	dirty map[string]bool
+}
+
+func newPolitenessAudit(ctx context.Context, redisClient *redis.Client, robots *robotsCache, logger *log.Logger) *politenessAudit {
+	a := &politenessAudit{
+		redisClient: redisClient,
+		robots:      robots,
+		logger:      logger,
+		hosts:       make(map[string]*HostAudit),
+		last:        make(map[string]time.Time),
+		dirty:       make(map[string]bool),
+		checks:      make(chan auditCheck, auditQueueSize),
+	}
+	existing, err := LoadPolitenessAudit(ctx, redisClient)
+	if err != nil {
+		logger.Printf("failed to load politeness audit, starting empty: %v", err)
+	}
+	for i := range existing {
+		a.hosts[existing[i].Host] = &existing[i]
+	}
+	return a
+}
+
+// auditCheck is a recorded request waiting to be checked against its host's
+// robots.txt. interval is the gap to the previous request to the host in
+// milliseconds, or -1 for the first one.
+type auditCheck struct {
+	scheme   string
+	host     string
+	hostname string
+	path     string
+	interval int64
+}
+
+// Record notes a request to rawURL that came back with status (0 when no
+// response was received).
+func (a *politenessAudit) Record(rawURL string, status int, at time.Time) {
+	if a == nil {
+		return
+	}
+	u, err := neturl.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	host := u.Hostname()
+	check := auditCheck{scheme: u.Scheme, host: u.Host, hostname: host, path: u.RequestURI(), interval: -1}
+
+	a.mu.Lock()
+	h, ok := a.hosts[host]
+	if !ok {
+		h = &HostAudit{Host: host, MinIntervalMs: minIntervalUnmeasured, FirstRequest: at.Unix()}
+		a.hosts[host] = h
+	}
+	h.Requests++
+	h.LastRequest = at.Unix()
+	if status == http.StatusTooManyRequests {
+		h.Status429++
+	}
+	if prev, ok := a.last[host]; ok {
+		check.interval = at.Sub(prev).Milliseconds()
+		h.Intervals++
+		h.IntervalSumMs += check.interval
+		if h.MinIntervalMs == minIntervalUnmeasured || check.interval < h.MinIntervalMs {
+			h.MinIntervalMs = check.interval
+		}
+	}
+	a.last[host] = at
+	a.dirty[host] = true
+
+	select {
+	case a.checks <- check:
+	default:
+		// The checkers are behind; count the request as unchecked rather
+		// than slow the crawl down.
+		h.Unchecked++
+	}
+	a.mu.Unlock()
+}
+
+// check applies the robots.txt of a recorded request's host to it.
+func (a *politenessAudit) check(c auditCheck) {
+	rules, err := a.robots.rules(c.scheme, c.host)
+	if err != nil {
+		// Without a readable robots.txt nothing can be checked, but the
+		// request still counted towards the rate.
+		rules = nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	h := a.hosts[c.hostname]
+	h.DeclaredCrawlDelayMs = rules.CrawlDelay().Milliseconds()
+	if !rules.Allowed(c.path) {
+		h.RobotsViolations++
+	}
+	if c.interval >= 0 && h.DeclaredCrawlDelayMs > 0 && c.interval < h.DeclaredCrawlDelayMs {
+		h.IntervalsBelowDelay++
+	}
+	a.dirty[c.hostname] = true
+}
+
+// Run starts the robots.txt checkers and flushes changed hosts to Redis
+// periodically and once more when ctx is done.
+func (a *politenessAudit) Run(ctx context.Context) {
+	for i := 0; i < auditCheckers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case c := <-a.checks:
+					a.check(c)
+				}
+			}
+		}()
+	}
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			a.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			a.flush(ctx)
+		}
+	}
+}
+
+func (a *politenessAudit) flush(ctx context.Context) {
+	a.mu.Lock()
+	fields := make(map[string]interface{}, len(a.dirty))
+	for host := range a.dirty {
+		h := *a.hosts[host]
+		h.finalize()
+		data, err := json.Marshal(h)
+		if err != nil {
+			continue
+		}
+		fields[host] = data
+	}
+	a.dirty = make(map[string]bool)
+	a.mu.Unlock()
+	if len(fields) == 0 {
+		return
+	}
+	if err := a.redisClient.HSet(ctx, politenessAuditKey, fields).Err(); err != nil {
+		a.logger.Printf("failed to save politeness audit: %v", err)
+	}
+}
+
+func (h *HostAudit) finalize() {
+	if h.Intervals > 0 {
+		h.AvgIntervalMs = h.IntervalSumMs / h.Intervals
+	}
+	if span := time.Duration(h.LastRequest-h.FirstRequest) * time.Second; span > 0 {
+		h.ObservedRatePerMinute = float64(h.Requests) / span.Minutes()
+	}
+	h.Compliant = h.RobotsViolations == 0 && h.IntervalsBelowDelay == 0
+}
+
+// LoadPolitenessAudit returns the persisted audit of every crawled host,
+// least compliant first.
+func LoadPolitenessAudit(ctx context.Context, redisClient *redis.Client) ([]HostAudit, error) {
+	raw, err := redisClient.HGetAll(ctx, politenessAuditKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read politeness audit: %w", err)
+	}
+	audits := make([]HostAudit, 0, len(raw))
+	for _, data := range raw {
+		var h HostAudit
+		if err := json.Unmarshal([]byte(data), &h); err != nil {
+			continue
+		}
+		audits = append(audits, h)
+	}
+	sort.Slice(audits, func(i, j int) bool {
+		if audits[i].Compliant != audits[j].Compliant {
+			return !audits[i].Compliant
+		}
+		if audits[i].RobotsViolations != audits[j].RobotsViolations {
+			return audits[i].RobotsViolations > audits[j].RobotsViolations
+		}
+		if audits[i].Status429 != audits[j].Status429 {
+			return audits[i].Status429 > audits[j].Status429
+		}
+		return audits[i].Host < audits[j].Host
+	})
+	return audits, nil
+}
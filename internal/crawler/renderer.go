@@ -0,0 +1,302 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/common/database"
+	"github.com/amankumarsingh77/search_engine/models"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultChromePath    = "chromium"
+	defaultRenderTimeout = 30 * time.Second
+	defaultRenderBudget  = 5 * time.Second
+)
+
+// ChromeRenderer fetches pages through a headless Chrome driven over the
+// DevTools protocol, so that content built client-side by JavaScript ends up
+// in the DOM we parse. One browser is started on first use and every page
+// gets a tab of its own. The status, headers and URL of the document a tab
+// ends up showing come from its network events, so error pages and
+// redirects are treated as they are for the plain client.
+type ChromeRenderer struct {
+	chromePath  string
+	timeout     time.Duration
+	budget      time.Duration
+	userAgent   string
+	maxBodySize int64
+
+	mu           sync.Mutex
+	browser      context.Context
+	closeBrowser context.CancelFunc
+}
+
+func NewChromeRenderer(cfg *config.RenderConfig, maxBodySize int64) *ChromeRenderer {
+	r := &ChromeRenderer{
+		chromePath:  defaultChromePath,
+		timeout:     defaultRenderTimeout,
+		budget:      defaultRenderBudget,
+		maxBodySize: maxBodySize,
+	}
+	if cfg.ChromePath != "" {
+		r.chromePath = cfg.ChromePath
+	}
+	if cfg.Timeout > 0 {
+		r.timeout = cfg.Timeout
+	}
+	if cfg.JSBudget > 0 {
+		r.budget = cfg.JSBudget
+	}
+	r.userAgent = cfg.UserAgent
+	return r
+}
+
+// browserContext returns the context of the running browser, starting one
+// if there is none or the last one has gone away.
+func (r *ChromeRenderer) browserContext() (context.Context, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.browser != nil && r.browser.Err() == nil {
+		return r.browser, nil
+	}
+	if r.closeBrowser != nil {
+		r.closeBrowser()
+	}
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.ExecPath(r.chromePath),
+		chromedp.DisableGPU,
+	)
+	if r.userAgent != "" {
+		opts = append(opts, chromedp.UserAgent(r.userAgent))
+	}
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	// Running nothing on the first context is what starts the browser.
+	if err := chromedp.Run(browserCtx); err != nil {
+		cancelBrowser()
+		cancelAlloc()
+		return nil, fmt.Errorf("failed to start headless browser: %w", err)
+	}
+	r.browser = browserCtx
+	r.closeBrowser = func() {
+		cancelBrowser()
+		cancelAlloc()
+	}
+	return r.browser, nil
+}
+
+// Close shuts the browser down, if one was started.
+func (r *ChromeRenderer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closeBrowser != nil {
+		r.closeBrowser()
+		r.browser, r.closeBrowser = nil, nil
+	}
+	return nil
+}
+
+func (r *ChromeRenderer) Render(ctx context.Context, url string) (*Response, error) {
+	browser, err := r.browserContext()
+	if err != nil {
+		return nil, err
+	}
+	tab, closeTab := chromedp.NewContext(browser)
+	defer closeTab()
+	// Tabs hang off the browser's context rather than the caller's, so the
+	// caller giving up has to be passed on.
+	tab, cancel := context.WithTimeout(tab, r.timeout)
+	defer cancel()
+	defer context.AfterFunc(ctx, cancel)()
+
+	if err := chromedp.Run(tab); err != nil {
+		return nil, fmt.Errorf("failed to open browser tab: %w", err)
+	}
+	nav := &renderNavigation{mainFrame: cdp.FrameID(chromedp.FromContext(tab).Target.TargetID)}
+	chromedp.ListenTarget(tab, nav.observe)
+
+	if _, err := chromedp.RunResponse(tab, chromedp.Navigate(url)); err != nil {
+		return nil, fmt.Errorf("headless render failed: %w", err)
+	}
+	if meta, err := nav.response(); err != nil {
+		return meta, err
+	}
+	// Give scripts their budget, then take the document the page has
+	// settled on, which a script redirect may have replaced.
+	if err := chromedp.Run(tab, chromedp.Sleep(r.budget)); err != nil {
+		return nil, fmt.Errorf("headless render failed: %w", err)
+	}
+	meta, err := nav.response()
+	if err != nil {
+		return meta, err
+	}
+
+	// The length is in UTF-16 code units, never more than the UTF-8 bytes,
+	// so an oversized DOM is caught before it is copied out of the browser.
+	var length int64
+	if err := chromedp.Run(tab, chromedp.Evaluate(`document.documentElement.outerHTML.length`, &length)); err != nil {
+		return meta, fmt.Errorf("headless render failed: %w", err)
+	}
+	if length > r.maxBodySize {
+		return meta, fmt.Errorf("%w: rendered DOM is %d characters", ErrBodyTooLarge, length)
+	}
+	var html string
+	if err := chromedp.Run(tab, chromedp.OuterHTML("html", &html, chromedp.ByQuery)); err != nil {
+		return meta, fmt.Errorf("headless render failed: %w", err)
+	}
+
+	meta.Body = &limitedBody{
+		reader: strings.NewReader(html),
+		closer: io.NopCloser(nil),
+		limit:  r.maxBodySize,
+	}
+	// The body is the serialized DOM, whatever charset the page came in.
+	meta.ContentType = "text/html; charset=utf-8"
+	meta.ContentLength = int64(len(html))
+	return meta, nil
+}
+
+// renderNavigation follows the documents loaded into a tab's main frame.
+// HTTP and script redirects alike add the URL they left to the chain, and
+// the last document's response is the one the page is taken from.
+type renderNavigation struct {
+	mainFrame cdp.FrameID
+
+	mu       sync.Mutex
+	chain    []string
+	document *network.Response
+}
+
+func (n *renderNavigation) observe(ev any) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		if ev.Type != network.ResourceTypeDocument || ev.FrameID != n.mainFrame {
+			return
+		}
+		switch {
+		case ev.RedirectResponse != nil:
+			n.chain = append(n.chain, ev.RedirectResponse.URL)
+		case n.document != nil:
+			n.chain = append(n.chain, n.document.URL)
+		}
+	case *network.EventResponseReceived:
+		if ev.Type == network.ResourceTypeDocument && ev.FrameID == n.mainFrame {
+			n.document = ev.Response
+		}
+	}
+}
+
+// response describes the current document like HttpClient.Visit describes
+// a response, failing the same way for statuses other than 200 and for
+// content that isn't HTML.
+func (n *renderNavigation) response() (*Response, error) {
+	n.mu.Lock()
+	doc := n.document
+	chain := slices.Clone(n.chain)
+	n.mu.Unlock()
+	if doc == nil {
+		return nil, errors.New("headless render failed: no document response")
+	}
+
+	header := make(http.Header, len(doc.Headers))
+	for name, value := range doc.Headers {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		// DevTools joins repeated headers with newlines.
+		for _, v := range strings.Split(s, "\n") {
+			header.Add(name, v)
+		}
+	}
+	finalURL := doc.URL
+	if normalized, err := normalizeUrl(finalURL); err == nil {
+		finalURL = normalized
+	}
+	meta := &Response{
+		Header:        header,
+		StatusCode:    int(doc.Status),
+		ContentType:   header.Get("Content-Type"),
+		FinalURL:      finalURL,
+		RedirectChain: chain,
+	}
+	if meta.StatusCode != http.StatusOK {
+		return meta, fmt.Errorf("bad response status: %d %s", doc.Status, http.StatusText(meta.StatusCode))
+	}
+	if doc.MimeType != "text/html" && doc.MimeType != "application/xhtml+xml" {
+		return meta, fmt.Errorf("%w: %s", ErrUnsupportedContentType, doc.MimeType)
+	}
+	return meta, nil
+}
+
+// renderingCrawler sends hosts flagged as JavaScript-rendered through the
+// headless browser and everything else through the plain HTTP client.
+type renderingCrawler struct {
+	plain    *httpCrawler
+	renderer *ChromeRenderer
+	domains  []string
+}
+
+func NewRenderingCrawler(collector *HttpClient, frontier URLFrontier, db *database.MongoClient, cfg *config.RenderConfig) WebCrawler {
+	domains := make([]string, 0, len(cfg.Domains))
+	for _, d := range cfg.Domains {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "www.")
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return &renderingCrawler{
+		plain: &httpCrawler{
+			collector: collector,
+			frontier:  frontier,
+			db:        *db,
+		},
+		renderer: NewChromeRenderer(cfg, collector.maxBodySize),
+		domains:  domains,
+	}
+}
+
+// Close shuts down the browser pages were rendered with.
+func (c *renderingCrawler) Close() error {
+	return c.renderer.Close()
+}
+
+func (c *renderingCrawler) needsRendering(url string) bool {
+	host, err := hostKey(url)
+	if err != nil {
+		return false
+	}
+	for _, d := range c.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *renderingCrawler) CrawlPage(ctx context.Context, url string) (*models.WebPage, error) {
+	if !c.needsRendering(url) {
+		return c.plain.CrawlPage(ctx, url)
+	}
+	ctx, span := tracer.Start(ctx, "crawler.render", trace.WithAttributes(attribute.String("url.full", url)))
+	defer span.End()
+	start := time.Now()
+	resp, err := c.renderer.Render(ctx, url)
+	return c.plain.processResponse(ctx, url, resp, err, start)
+}
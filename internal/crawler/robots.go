@@ -0,0 +1,231 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	robotsCacheTTL  = 24 * time.Hour
+	robotsErrorTTL  = 10 * time.Minute
+	maxRobotsSize   = 512 << 10
+	robotsUserAgent = "searchyfy"
+)
+
+// robotsRules is the robots.txt group that applies to the crawler.
+// A nil *robotsRules allows everything.
+type robotsRules struct {
+	allow      []string
+	disallow   []string
+	crawlDelay time.Duration
+}
+
+type robotsGroup struct {
+	agents []string
+	rules  robotsRules
+}
+
+// parseRobots reads a robots.txt and returns the group for agent, falling
+// back to the * group. Groups naming the agent win over the wildcard.
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxRobotsSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow", "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			switch key {
+			case "allow":
+				if value != "" {
+					current.rules.allow = append(current.rules.allow, value)
+				}
+			case "disallow":
+				if value != "" {
+					current.rules.disallow = append(current.rules.disallow, value)
+				}
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+					current.rules.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	agent = strings.ToLower(agent)
+	var wildcard *robotsRules
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if wildcard == nil {
+					wildcard = &g.rules
+				}
+			} else if strings.Contains(agent, a) {
+				return &g.rules
+			}
+		}
+	}
+	return wildcard
+}
+
+// Allowed reports whether path (including any query) may be fetched. The
+// longest matching rule wins and Allow wins ties, as in RFC 9309.
+func (r *robotsRules) Allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	best, allowed := -1, true
+	for _, rule := range r.disallow {
+		if robotsMatch(rule, path) && len(rule) > best {
+			best, allowed = len(rule), false
+		}
+	}
+	for _, rule := range r.allow {
+		if robotsMatch(rule, path) && len(rule) >= best {
+			best, allowed = len(rule), true
+		}
+	}
+	return allowed
+}
+
+func (r *robotsRules) CrawlDelay() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.crawlDelay
+}
+
+// robotsMatch matches a robots path pattern supporting * and a trailing $.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	return wildcardMatch(strings.TrimSuffix(pattern, "$"), path, anchored)
+}
+
+func wildcardMatch(pattern, s string, anchored bool) bool {
+	for pattern != "" {
+		if pattern[0] == '*' {
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" && !anchored {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatch(pattern, s[i:], anchored) {
+					return true
+				}
+			}
+			return false
+		}
+		if s == "" || s[0] != pattern[0] {
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return !anchored || s == ""
+}
+
+type robotsEntry struct {
+	rules     *robotsRules
+	err       error
+	fetchedAt time.Time
+}
+
+func (e robotsEntry) fresh() bool {
+	ttl := robotsCacheTTL
+	if e.err != nil {
+		ttl = robotsErrorTTL
+	}
+	return time.Since(e.fetchedAt) < ttl
+}
+
+// robotsCache fetches and caches robots.txt per scheme and host. Concurrent
+// lookups of one host share a single fetch, and a failed fetch is remembered
+// for robotsErrorTTL so an unreachable robots.txt isn't requested again for
+// every page of its host.
+type robotsCache struct {
+	client *HttpClient
+	flight singleflight.Group
+	mu     sync.Mutex
+	hosts  map[string]robotsEntry
+}
+
+func newRobotsCache(client *HttpClient) *robotsCache {
+	return &robotsCache{client: client, hosts: make(map[string]robotsEntry)}
+}
+
+func (c *robotsCache) rules(scheme, host string) (*robotsRules, error) {
+	key := scheme + "://" + host
+	c.mu.Lock()
+	entry, ok := c.hosts[key]
+	c.mu.Unlock()
+	if ok && entry.fresh() {
+		return entry.rules, entry.err
+	}
+
+	v, _, _ := c.flight.Do(key, func() (any, error) {
+		rules, err := c.client.fetchRobots(key + "/robots.txt")
+		entry := robotsEntry{rules: rules, err: err, fetchedAt: time.Now()}
+		c.mu.Lock()
+		c.hosts[key] = entry
+		c.mu.Unlock()
+		return entry, nil
+	})
+	entry = v.(robotsEntry)
+	return entry.rules, entry.err
+}
+
+// fetchRobots downloads a robots.txt. A missing file (4xx) allows
+// everything; a server error is returned so the caller can retry later.
+func (h *HttpClient) fetchRobots(url string) (*robotsRules, error) {
+	req, cancel, err := h.newRequest(context.Background(), "GET", url)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch robots.txt: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("failed to fetch robots.txt: %s", resp.Status)
+	case resp.StatusCode >= http.StatusBadRequest:
+		return nil, nil
+	}
+	body, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	return parseRobots(body, robotsUserAgent), nil
+}
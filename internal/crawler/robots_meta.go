@@ -0,0 +1,82 @@
+package crawler
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+// robotsDirectives are the page-level indexing rules a site can set through
+// <meta name="robots"> or the X-Robots-Tag header.
+type robotsDirectives struct {
+	noIndex  bool
+	noFollow bool
+}
+
+// add parses one comma-separated directive list such as "noindex, nofollow".
+// Unknown directives (noarchive, max-snippet:...) are ignored.
+func (d *robotsDirectives) add(value string) {
+	for _, token := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(token)) {
+		case "noindex":
+			d.noIndex = true
+		case "nofollow":
+			d.noFollow = true
+		case "none":
+			d.noIndex = true
+			d.noFollow = true
+		}
+	}
+}
+
+// addHeader parses X-Robots-Tag values. A value may be scoped to a crawler
+// with a "name:" prefix, in which case it only counts for us or for "*".
+func (d *robotsDirectives) addHeader(values []string) {
+	for _, value := range values {
+		if name, rest, ok := strings.Cut(value, ":"); ok && !strings.Contains(name, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !isRobotsDirective(name) {
+				if name != robotsUserAgent && name != "*" {
+					continue
+				}
+				value = rest
+			}
+		}
+		d.add(value)
+	}
+}
+
+// addMeta parses the <meta name="robots"> tags and any addressed to our user
+// agent by name.
+func (d *robotsDirectives) addMeta(doc *goquery.Document) {
+	doc.Find("meta[name]").Each(func(_ int, s *goquery.Selection) {
+		name := strings.ToLower(strings.TrimSpace(s.AttrOr("name", "")))
+		if name == "robots" || name == robotsUserAgent {
+			d.add(s.AttrOr("content", ""))
+		}
+	})
+}
+
+// isRobotsDirective reports whether name is a directive that takes a value,
+// so "unavailable_after: ..." isn't mistaken for a user agent prefix.
+func isRobotsDirective(name string) bool {
+	switch name {
+	case "unavailable_after", "max-snippet", "max-image-preview", "max-video-preview":
+		return true
+	}
+	return false
+}
+
+// apply flags noindex pages for the indexer and drops the outlinks of
+// nofollow pages so they never reach the frontier or seed discovery.
+func (d robotsDirectives) apply(page *models.WebPage) {
+	if d.noIndex {
+		page.NoIndex = true
+	}
+	if d.noFollow {
+		page.NoFollow = true
+		page.InternalLinks = nil
+		page.ExternalLinks = nil
+	}
+}
@@ -0,0 +1,163 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/common/database"
+)
+
+const (
+	defaultMaxRunTime = 2 * time.Hour
+	idleCheckInterval = 30 * time.Second
+	// A crawl must look idle this many checks in a row before it is stopped,
+	// so a momentary gap between batches doesn't end it.
+	idleChecksToStop = 2
+)
+
+// Scheduler starts crawl jobs on their cron schedules. Runs are sequential:
+// a job due while another is running starts on the first matching minute
+// after that run ends, and fire times missed in between are skipped.
+type Scheduler struct {
+	cfg        *config.CrawlerConfig
+	db         *database.MongoClient
+	maxRunTime time.Duration
+	log        *log.Logger
+}
+
+type scheduledJob struct {
+	name     string
+	schedule *cronSchedule
+}
+
+func NewScheduler(ctx context.Context, cfg *config.CrawlerConfig) (*Scheduler, error) {
+	for _, job := range cfg.Scheduler.Jobs {
+		if _, err := parseCron(job.Schedule); err != nil {
+			return nil, fmt.Errorf("invalid schedule for crawl job %s: %w", job.Job, err)
+		}
+	}
+	mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mongo client : %v", err)
+	}
+	s := &Scheduler{
+		cfg:        cfg,
+		db:         mongoClient,
+		maxRunTime: defaultMaxRunTime,
+		log:        log.New(os.Stdout, "[Scheduler]: ", log.LstdFlags|log.Lshortfile),
+	}
+	if cfg.Scheduler.MaxRunTime > 0 {
+		s.maxRunTime = cfg.Scheduler.MaxRunTime
+	}
+	return s, nil
+}
+
+// Run checks the schedules at the start of every minute until ctx is done.
+// Schedules are reloaded each time, so jobs saved while the scheduler is
+// running are picked up without a restart.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.log.Println("Scheduler started")
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			s.log.Println("Scheduler stopped")
+			return
+		case <-time.After(time.Until(next)):
+		}
+		jobs, err := s.jobs(ctx)
+		if err != nil {
+			s.log.Printf("failed to load crawl job schedules: %v", err)
+			continue
+		}
+		for _, job := range jobs {
+			if ctx.Err() != nil {
+				break
+			}
+			if job.schedule.Matches(next) {
+				s.runJob(ctx, job.name)
+			}
+		}
+	}
+}
+
+// jobs returns the configured schedules followed by those stored with jobs
+// that the config doesn't mention.
+func (s *Scheduler) jobs(ctx context.Context) ([]scheduledJob, error) {
+	var jobs []scheduledJob
+	configured := make(map[string]bool, len(s.cfg.Scheduler.Jobs))
+	for _, job := range s.cfg.Scheduler.Jobs {
+		schedule, err := parseCron(job.Schedule)
+		if err != nil {
+			continue
+		}
+		configured[job.Job] = true
+		jobs = append(jobs, scheduledJob{name: job.Job, schedule: schedule})
+	}
+	stored, err := s.db.ListCrawlJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range stored {
+		if job.Schedule == "" || configured[job.Name] {
+			continue
+		}
+		schedule, err := parseCron(job.Schedule)
+		if err != nil {
+			s.log.Printf("skipping crawl job %s: invalid schedule: %v", job.Name, err)
+			continue
+		}
+		jobs = append(jobs, scheduledJob{name: job.Name, schedule: schedule})
+	}
+	return jobs, nil
+}
+
+// runJob re-queues the job's seeds and crawls until the frontier drains or
+// the run time limit is hit.
+func (s *Scheduler) runJob(ctx context.Context, name string) {
+	s.log.Printf("Starting scheduled crawl of job %s", name)
+	start := time.Now()
+	spider, err := NewJobCrawler(ctx, s.cfg, name)
+	if err != nil {
+		s.log.Printf("failed to start crawl job %s: %v", name, err)
+		return
+	}
+	spider.RefreshJob()
+
+	runCtx, cancel := context.WithTimeout(ctx, s.maxRunTime)
+	defer cancel()
+	go s.stopWhenIdle(runCtx, spider, cancel)
+	spider.RunCrawler(runCtx)
+	s.log.Printf("Scheduled crawl of job %s finished after %v", name, time.Since(start).Round(time.Second))
+}
+
+func (s *Scheduler) stopWhenIdle(ctx context.Context, spider *Spider, stop context.CancelFunc) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	idle := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := spider.Idle(ctx)
+			if err != nil {
+				s.log.Printf("failed to check crawl progress: %v", err)
+				continue
+			}
+			if !ok {
+				idle = 0
+				continue
+			}
+			if idle++; idle >= idleChecksToStop {
+				s.log.Println("Frontier drained, stopping crawl")
+				stop()
+				return
+			}
+		}
+	}
+}
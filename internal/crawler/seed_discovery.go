@@ -0,0 +1,233 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/common/database"
+	"github.com/amankumarsingh77/search_engine/pkg"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	seedCandidatesKey = "seeds:candidates"
+	seedRejectedKey   = "seeds:rejected"
+
+	defaultMinLinkingPages = 20
+	defaultMinLinkingHosts = 3
+)
+
+var ErrUnknownCandidate = errors.New("no such seed candidate")
+
+// SeedCandidate is an external host that crawled pages link to often enough
+// to be worth crawling itself, pending an operator's decision.
+type SeedCandidate struct {
+	Host         string    `json:"host"`
+	SeedURL      string    `json:"seed_url"`
+	LinkingPages int       `json:"linking_pages"`
+	LinkingHosts int       `json:"linking_hosts"`
+	DiscoveredAt time.Time `json:"discovered_at"`
+}
+
+// seedDiscovery mines the outbound links of crawled pages for hosts outside
+// the seed set and queues the frequently linked ones for review. Nothing is
+// crawled until a candidate is approved.
+type seedDiscovery struct {
+	redisClient     *redis.Client
+	db              *database.MongoClient
+	frontier        URLFrontier
+	minLinkingPages int
+	minLinkingHosts int
+	logger          *log.Logger
+	keys            keyspace
+}
+
+func newSeedDiscovery(redisClient *redis.Client, db *database.MongoClient, frontier URLFrontier, cfg *config.SeedDiscoveryConfig, logger *log.Logger, keys keyspace) *seedDiscovery {
+	d := &seedDiscovery{
+		redisClient:     redisClient,
+		db:              db,
+		frontier:        frontier,
+		minLinkingPages: defaultMinLinkingPages,
+		minLinkingHosts: defaultMinLinkingHosts,
+		logger:          logger,
+		keys:            keys,
+	}
+	if cfg.MinLinkingPages > 0 {
+		d.minLinkingPages = cfg.MinLinkingPages
+	}
+	if cfg.MinLinkingHosts > 0 {
+		d.minLinkingHosts = cfg.MinLinkingHosts
+	}
+	return d
+}
+
+type linkTally struct {
+	pages   int
+	linkers map[string]bool
+	sample  *neturl.URL
+}
+
+// Discover scans every crawled page and records candidates above both
+// thresholds. Seeded and previously rejected hosts are never proposed.
+// Counting distinct linking hosts keeps one site's sitewide footer link from
+// promoting a host on its own.
+func (d *seedDiscovery) Discover(ctx context.Context) (int, error) {
+	known, err := d.redisClient.HKeys(ctx, d.keys.key(sourceQuality)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read seed hosts: %w", err)
+	}
+	rejected, err := d.redisClient.SMembers(ctx, d.keys.key(seedRejectedKey)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rejected candidates: %w", err)
+	}
+	skip := make(map[string]bool, len(known)+len(rejected))
+	for _, host := range append(known, rejected...) {
+		skip[strings.TrimPrefix(host, "www.")] = true
+	}
+
+	tallies := make(map[string]*linkTally)
+	err = d.db.EachPageLinks(ctx, func(pageURL string, links []string) error {
+		from, err := neturl.Parse(pageURL)
+		if err != nil {
+			return nil
+		}
+		fromHost := strings.TrimPrefix(from.Hostname(), "www.")
+		seen := make(map[string]bool)
+		for _, link := range links {
+			u, err := neturl.Parse(link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			host := strings.TrimPrefix(u.Hostname(), "www.")
+			if host == "" || host == fromHost || skip[host] || seen[host] {
+				continue
+			}
+			seen[host] = true
+			t, ok := tallies[host]
+			if !ok {
+				t = &linkTally{linkers: make(map[string]bool), sample: u}
+				tallies[host] = t
+			}
+			t.pages++
+			t.linkers[fromHost] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	fields := make(map[string]interface{})
+	for host, t := range tallies {
+		if t.pages < d.minLinkingPages || len(t.linkers) < d.minLinkingHosts {
+			continue
+		}
+		data, err := json.Marshal(SeedCandidate{
+			Host:         host,
+			SeedURL:      t.sample.Scheme + "://" + t.sample.Host + "/",
+			LinkingPages: t.pages,
+			LinkingHosts: len(t.linkers),
+			DiscoveredAt: now,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal seed candidate: %w", err)
+		}
+		fields[host] = data
+	}
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	if err = d.redisClient.HSet(ctx, d.keys.key(seedCandidatesKey), fields).Err(); err != nil {
+		return 0, fmt.Errorf("failed to save seed candidates: %w", err)
+	}
+	return len(fields), nil
+}
+
+// Candidates returns the review queue, most widely linked first.
+func (d *seedDiscovery) Candidates(ctx context.Context) ([]SeedCandidate, error) {
+	raw, err := d.redisClient.HGetAll(ctx, d.keys.key(seedCandidatesKey)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seed candidates: %w", err)
+	}
+	candidates := make([]SeedCandidate, 0, len(raw))
+	for _, data := range raw {
+		var c SeedCandidate
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].LinkingHosts != candidates[j].LinkingHosts {
+			return candidates[i].LinkingHosts > candidates[j].LinkingHosts
+		}
+		return candidates[i].Host < candidates[j].Host
+	})
+	return candidates, nil
+}
+
+// Approve seeds the candidate's root URL at the default source quality and
+// removes it from the queue.
+func (d *seedDiscovery) Approve(ctx context.Context, host string) (*SeedCandidate, error) {
+	data, err := d.redisClient.HGet(ctx, d.keys.key(seedCandidatesKey), host).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUnknownCandidate
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seed candidate: %w", err)
+	}
+	var c SeedCandidate
+	if err = json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("failed to decode seed candidate: %w", err)
+	}
+	if err = d.frontier.SetSourceQuality(ctx, c.SeedURL, pkg.DefaultSourceQuality); err != nil {
+		return nil, err
+	}
+	if err = d.frontier.Seed(ctx, c.SeedURL, 0); err != nil {
+		return nil, err
+	}
+	if err = d.redisClient.HDel(ctx, d.keys.key(seedCandidatesKey), host).Err(); err != nil {
+		return nil, fmt.Errorf("failed to remove seed candidate: %w", err)
+	}
+	return &c, nil
+}
+
+// Reject removes the candidate and keeps it from being proposed again.
+func (d *seedDiscovery) Reject(ctx context.Context, host string) error {
+	removed, err := d.redisClient.HDel(ctx, d.keys.key(seedCandidatesKey), host).Result()
+	if err != nil {
+		return fmt.Errorf("failed to remove seed candidate: %w", err)
+	}
+	if removed == 0 {
+		return ErrUnknownCandidate
+	}
+	return d.redisClient.SAdd(ctx, d.keys.key(seedRejectedKey), host).Err()
+}
+
+// Run repeats discovery every interval until ctx is done.
+func (d *seedDiscovery) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := d.Discover(ctx)
+			if err != nil {
+				d.logger.Printf("seed discovery failed: %v", err)
+				continue
+			}
+			d.logger.Printf("seed discovery: %d candidates awaiting review", n)
+		}
+	}
+}
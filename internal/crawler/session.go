@@ -0,0 +1,51 @@
+package crawler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/config"
+)
+
+// domainHeaders holds extra request headers per domain, e.g. a consent
+// cookie a site requires before it serves content. A domain's headers also
+// apply to its subdomains.
+type domainHeaders map[string]http.Header
+
+func newDomainHeaders(cfg []config.DomainHeaderConfig) domainHeaders {
+	if len(cfg) == 0 {
+		return nil
+	}
+	headers := make(domainHeaders, len(cfg))
+	for _, entry := range cfg {
+		domain := strings.ToLower(strings.TrimPrefix(entry.Domain, "."))
+		h, ok := headers[domain]
+		if !ok {
+			h = make(http.Header, len(entry.Headers))
+			headers[domain] = h
+		}
+		for name, value := range entry.Headers {
+			h.Set(name, value)
+		}
+	}
+	return headers
+}
+
+// apply sets the headers configured for req's host, most specific domain
+// last so it wins.
+func (d domainHeaders) apply(req *http.Request) {
+	if len(d) == 0 {
+		return
+	}
+	host := strings.ToLower(req.URL.Hostname())
+	labels := strings.Split(host, ".")
+	for i := len(labels) - 2; i >= 0; i-- {
+		h, ok := d[strings.Join(labels[i:], ".")]
+		if !ok {
+			continue
+		}
+		for name, values := range h {
+			req.Header[name] = values
+		}
+	}
+}
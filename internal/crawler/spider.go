@@ -2,15 +2,19 @@ package crawler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/amankumarsingh77/search_engine/config"
 	"github.com/amankumarsingh77/search_engine/internal/common/database"
 	"github.com/amankumarsingh77/search_engine/models"
 	"github.com/amankumarsingh77/search_engine/pkg"
 	"github.com/redis/go-redis/v9"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"sync"
+	"time"
 )
 
 type Spider struct {
@@ -20,9 +24,13 @@ type Spider struct {
 	db          *database.MongoClient
 	redisClient *redis.Client
 	bfClient    *BloomFilter
+	traps       *TrapDetector
+	partitioner *Partitioner
+	discovery   *seedDiscovery
+	job         *models.CrawlJob
+	keys        keyspace
 	cleanUp     func()
 	log         *log.Logger
-	wg          sync.WaitGroup
 }
 
 func NewWebCrawler(ctx context.Context, cfg *config.CrawlerConfig) (*Spider, error) {
@@ -30,21 +38,65 @@ func NewWebCrawler(ctx context.Context, cfg *config.CrawlerConfig) (*Spider, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to load mongo client : %v", err)
 	}
+	return newSpider(ctx, cfg, mongoClient, nil)
+}
+
+// NewJobCrawler builds a spider for the named crawl job. The job's filters
+// and depth replace the configured ones, and its frontier, bloom filter and
+// pages are kept apart from every other job's.
+func NewJobCrawler(ctx context.Context, cfg *config.CrawlerConfig, name string) (*Spider, error) {
+	mongoClient, err := database.NewMongoClient(ctx, &cfg.Mongo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mongo client : %v", err)
+	}
+	job, err := mongoClient.GetCrawlJob(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	jobCfg := *cfg
+	jobCfg.Filters = jobFilters(job)
+	if job.MaxDepth > 0 {
+		jobCfg.MaxDepth = job.MaxDepth
+	}
+	jobCfg.Mongo.CrawlerColl = job.PagesCollection()
+	return newSpider(ctx, &jobCfg, mongoClient.WithCrawlerColl(jobCfg.Mongo.CrawlerColl), job)
+}
+
+func newSpider(ctx context.Context, cfg *config.CrawlerConfig, mongoClient *database.MongoClient, job *models.CrawlJob) (*Spider, error) {
+	keys := defaultKeyspace
+	if job != nil {
+		keys = jobKeyspace(job.Name)
+	}
 	redisClient, err := NewRedisClient(ctx, &cfg.Redis)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load redis client : %v", err)
 	}
-	bfClient, err := NewRedisBloomFilter(&cfg.Redis)
+	bfClient, err := NewRedisBloomFilter(&cfg.Redis, keys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load bloom filter client : %v", err)
 	}
-	frontier := NewURLFrontier(redisClient, bfClient)
+	logger := log.New(os.Stdout, "[Spider]: ", log.LstdFlags|log.Lshortfile)
+	traps := NewTrapDetector(redisClient, &cfg.Traps, logger, keys)
+	var partitioner *Partitioner
+	if cfg.Cluster.Enabled {
+		partitioner = NewPartitioner(redisClient, &cfg.Cluster, keys)
+	}
+	filter, err := NewURLFilter(&cfg.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load url filters : %v", err)
+	}
+	frontier := NewURLFrontier(redisClient, bfClient, traps, partitioner, filter, &cfg.Frontier, keys)
 	cleanup := func() {
 		fmt.Println("Cleaning up frontier and redis resources")
 		redisClient.Close()
 		frontier.Close()
+		mongoClient.Disconnect()
+	}
+	httpClient, err := NewHttpClient(cfg)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to create http client: %w", err)
 	}
-	httpClient := NewHttpClient(cfg)
 	return &Spider{
 		cfg:         cfg,
 		httpClient:  httpClient,
@@ -52,7 +104,12 @@ func NewWebCrawler(ctx context.Context, cfg *config.CrawlerConfig) (*Spider, err
 		db:          mongoClient,
 		redisClient: redisClient,
 		bfClient:    bfClient,
-		log:         log.New(os.Stdout, "[Spider]: ", log.LstdFlags|log.Lshortfile),
+		traps:       traps,
+		partitioner: partitioner,
+		discovery:   newSeedDiscovery(redisClient, mongoClient, frontier, &cfg.Discovery, logger, keys),
+		job:         job,
+		keys:        keys,
+		log:         logger,
 		cleanUp:     cleanup,
 	}, nil
 }
@@ -62,21 +119,75 @@ func (c *Spider) RunCrawler(ctx context.Context) {
 	defer c.cleanUp()
 	defer cancel()
 	log.Println("Starting crawler...")
+	if c.partitioner != nil {
+		if err := c.partitioner.Start(crawlCtx); err != nil {
+			c.log.Printf("failed to join crawler cluster: %v", err)
+			return
+		}
+		c.log.Printf("joined crawler cluster as %s", c.partitioner.NodeID())
+	}
 	webProcessor := NewHttpCrawler(c.httpClient, c.frontier, c.db)
+	if c.cfg.Render.Enabled {
+		webProcessor = NewRenderingCrawler(c.httpClient, c.frontier, c.db, &c.cfg.Render)
+		if closer, ok := webProcessor.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+	polite := NewPolitenessController(&c.cfg.Politeness)
+	// The audit is about how we treat hosts, not what a job crawls, so it
+	// stays shared across jobs.
+	if c.cfg.Politeness.Audit {
+		polite.audit = newPolitenessAudit(crawlCtx, c.redisClient, newRobotsCache(c.httpClient), c.log)
+		go polite.audit.Run(crawlCtx)
+	}
+	metrics := newCrawlMetrics()
+	settings := newWorkerSettings(&c.cfg.Worker)
+	stream := newPageStream(c.redisClient, &c.cfg.Stream)
 	pageChan := make(chan models.WebPage, 10000)
-	workers := make([]*Worker, c.cfg.Workers)
-
-	for i := 0; i < c.cfg.Workers; i++ {
-		workerID := fmt.Sprintf("worker-%d", i)
-		logger := log.New(os.Stdout, fmt.Sprintf("[%s]", workerID), log.LstdFlags|log.Lshortfile)
-		workers[i] = NewWorker(workerID, c.frontier, pageChan, logger, webProcessor, c.db, c.cfg.MaxDepth)
-		c.wg.Add(1)
-		go workers[i].Start(crawlCtx)
+	c.requeueClaimed(crawlCtx, nil)
+	progress, err := (&crawlCheckpoint{redisClient: c.redisClient, keys: c.keys}).Load(crawlCtx)
+	if err != nil {
+		c.log.Printf("failed to load crawl checkpoint, starting fresh: %v", err)
+	}
+	idPrefix := ""
+	if c.partitioner != nil {
+		idPrefix = c.partitioner.NodeID() + "/"
+	}
+	pool := newWorkerPool(crawlCtx, idPrefix, func(id string, wg *sync.WaitGroup, gate *pauseGate) *Worker {
+		logger := log.New(os.Stdout, fmt.Sprintf("[%s]", id), log.LstdFlags|log.Lshortfile)
+		w := NewWorker(id, c.frontier, pageChan, wg, gate, settings, logger, webProcessor, c.db, polite, metrics, c.cfg.MaxDepth)
+		w.stream = stream
+		if prev, ok := progress[id]; ok {
+			w.stats.restore(prev)
+		}
+		return w
+	})
+	if err := pool.Resize(c.cfg.Workers); err != nil {
+		c.log.Printf("failed to start workers: %v", err)
+		return
 	}
 	log.Printf("Started %d workers. Crawling in progress", c.cfg.Workers)
+	var registry *workerRegistry
+	if c.partitioner != nil {
+		registry = &workerRegistry{redisClient: c.redisClient, frontier: c.frontier, partitioner: c.partitioner, workers: pool.IDs, logger: c.log, keys: c.keys}
+		registry.Start(crawlCtx)
+	}
+	go metrics.refreshFrontierStats(crawlCtx, c.frontier)
+	if c.cfg.Discovery.Interval > 0 {
+		go c.discovery.Run(crawlCtx, c.cfg.Discovery.Interval)
+	}
+	if c.cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.handler())
+		c.serveHTTP(crawlCtx, "metrics", c.cfg.MetricsAddr, mux)
+	}
+	if c.cfg.AdminAddr != "" {
+		admin := &adminAPI{pool: pool, settings: settings, frontier: c.frontier, discovery: c.discovery, apiKey: c.cfg.AdminAPIKey}
+		c.serveHTTP(crawlCtx, "admin", c.cfg.AdminAddr, admin.handler())
+	}
 	done := make(chan struct{})
 	go func() {
-		c.wg.Wait()
+		pool.Wait()
 		close(pageChan)
 		close(done)
 	}()
@@ -87,21 +198,151 @@ func (c *Spider) RunCrawler(ctx context.Context) {
 	case <-done:
 		log.Println("All workers finished")
 	}
-	for _, worker := range workers {
-		worker.Stop()
+	pool.StopAll()
+	c.checkpoint(pool, done)
+	if registry != nil {
+		deregCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		registry.Deregister(deregCtx)
+		cancel()
+	}
+	c.logTrapStats()
+	c.logConnStats()
+	c.logExpiredStats()
+}
+
+func (c *Spider) logExpiredStats() {
+	counts, err := c.frontier.ExpiredCounts(context.Background())
+	if err != nil {
+		c.log.Printf("failed to read frontier expiry counts: %v", err)
+		return
+	}
+	for policy, count := range counts {
+		c.log.Printf("stale pending items: %s=%d", policy, count)
+	}
+}
+
+// serveHTTP runs an auxiliary HTTP server until ctx is done.
+func (c *Spider) serveHTTP(ctx context.Context, name, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		c.log.Printf("serving crawler %s on %s", name, addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.log.Printf("%s server failed: %v", name, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+}
+
+func (c *Spider) logConnStats() {
+	for host, stat := range c.httpClient.ConnStats() {
+		c.log.Printf("connections to %s: new=%d reused=%d http2=%d", host, stat.New, stat.Reused, stat.HTTP2)
+	}
+}
+
+func (c *Spider) logTrapStats() {
+	stats := c.traps.Stats()
+	if len(stats) == 0 {
+		return
+	}
+	for reason, count := range stats {
+		c.log.Printf("spider traps blocked: %s=%d", reason, count)
 	}
 }
 
+// PolitenessReport returns the per-host politeness audit gathered by crawls
+// run with Politeness.Audit enabled.
+func (c *Spider) PolitenessReport(ctx context.Context) ([]HostAudit, error) {
+	return LoadPolitenessAudit(ctx, c.redisClient)
+}
+
+// DiscoverSeeds refreshes the seed candidate review queue from the outbound
+// links of crawled pages and returns how many candidates this run found.
+func (c *Spider) DiscoverSeeds(ctx context.Context) (int, error) {
+	return c.discovery.Discover(ctx)
+}
+
+// FailedItems lists failed URLs matching filter, most recent first.
+func (c *Spider) FailedItems(ctx context.Context, filter FailedFilter, limit int) ([]FailedItem, error) {
+	return c.frontier.FailedItems(ctx, filter, limit)
+}
+
+// RequeueFailed moves failed URLs matching filter back to pending.
+func (c *Spider) RequeueFailed(ctx context.Context, filter FailedFilter) (int64, error) {
+	return c.frontier.RequeueFailed(ctx, filter)
+}
+
+// SeedJob queues the crawl job's seed list at the default source quality.
+func (c *Spider) SeedJob() {
+	c.queueJobSeeds(func(ctx context.Context, url string) error {
+		return c.frontier.Seed(ctx, url, 0)
+	})
+}
+
+// RefreshJob queues the crawl job's seeds again whether or not they have
+// been crawled, for scheduled re-crawls.
+func (c *Spider) RefreshJob() {
+	c.queueJobSeeds(c.frontier.Refresh)
+}
+
+// Recrawl queues urls again whether or not they have been crawled, for
+// pages the index let expire, and returns how many it queued.
+func (c *Spider) Recrawl(ctx context.Context, urls []string) (int, error) {
+	queued := 0
+	for _, url := range urls {
+		if err := c.frontier.Refresh(ctx, url); err != nil {
+			return queued, fmt.Errorf("failed to queue %s: %w", url, err)
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+func (c *Spider) queueJobSeeds(queue func(ctx context.Context, url string) error) {
+	if c.job == nil {
+		c.log.Fatal("no crawl job to seed")
+	}
+	ctx := context.Background()
+	c.log.Printf("Seeding %d urls for crawl job %s", len(c.job.Seeds), c.job.Name)
+	for _, seed := range c.job.Seeds {
+		if err := c.frontier.SetSourceQuality(ctx, seed, pkg.DefaultSourceQuality); err != nil {
+			c.log.Printf("failed to record source quality for %s : %v", seed, err)
+		}
+		if err := queue(ctx, seed); err != nil {
+			c.log.Printf("skipping url %s : error : %v", seed, err)
+		}
+	}
+	c.logTrapStats()
+}
+
+// Idle reports whether the frontier has nothing pending and no worker holds
+// a claimed URL, i.e. the crawl has run out of work.
+func (c *Spider) Idle(ctx context.Context) (bool, error) {
+	stats, err := c.frontier.FrontierStats(ctx)
+	if err != nil {
+		return false, err
+	}
+	return stats.Pending == 0 && len(stats.PerWorker) == 0, nil
+}
+
 func (c *Spider) SeedUrls(filename string) {
-	seedUrls, err := pkg.LoadSeedURLs(filename)
+	seeds, err := pkg.LoadSeeds(filename)
 	if err != nil {
 		c.log.Fatal(err)
 	}
 	ctx := context.Background()
 	c.log.Println("Seeding urls to the queue")
-	for _, url := range seedUrls {
-		if err = c.frontier.Seed(ctx, url, 0); err != nil {
-			c.log.Printf("skipping url %s : error : %v", url, err)
+	for _, seed := range seeds {
+		if err = c.frontier.SetSourceQuality(ctx, seed.URL, seed.Quality); err != nil {
+			c.log.Printf("failed to record source quality for %s : %v", seed.URL, err)
+		}
+		if err = c.frontier.Seed(ctx, seed.URL, 0); err != nil {
+			c.log.Printf("skipping url %s : error : %v", seed.URL, err)
 		}
 	}
+	c.logTrapStats()
 }
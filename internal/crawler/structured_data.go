@@ -0,0 +1,98 @@
+package crawler
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+// publishedTimeLayouts are the formats seen in article:published_time in the
+// wild; ISO 8601 is the spec but date-only values are common.
+var publishedTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// extractStructuredData pulls OpenGraph tags and JSON-LD blocks out of the
+// page. It returns nil when the page carries neither.
+func extractStructuredData(doc *goquery.Document) *models.StructuredData {
+	meta := func(property string) string {
+		return strings.TrimSpace(doc.Find("meta[property='"+property+"']").AttrOr("content", ""))
+	}
+	data := &models.StructuredData{
+		OGTitle:       meta("og:title"),
+		OGDescription: meta("og:description"),
+		OGImage:       meta("og:image"),
+		OGType:        meta("og:type"),
+	}
+	data.PublishedTime = parsePublishedTime(meta("article:published_time"))
+
+	doc.Find("script[type='application/ld+json']").Each(func(_ int, s *goquery.Selection) {
+		raw := strings.TrimSpace(s.Text())
+		if raw == "" {
+			return
+		}
+		// A block may hold a single object or an array of them.
+		var objects []map[string]any
+		if err := json.Unmarshal([]byte(raw), &objects); err != nil {
+			var object map[string]any
+			if err := json.Unmarshal([]byte(raw), &object); err != nil {
+				return
+			}
+			objects = []map[string]any{object}
+		}
+		data.JSONLD = append(data.JSONLD, objects...)
+	})
+
+	if data.PublishedTime == nil {
+		data.PublishedTime = jsonLDPublishedTime(data.JSONLD)
+	}
+
+	if data.OGTitle == "" && data.OGDescription == "" && data.OGImage == "" &&
+		data.OGType == "" && data.PublishedTime == nil && len(data.JSONLD) == 0 {
+		return nil
+	}
+	return data
+}
+
+func parsePublishedTime(raw string) *time.Time {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	for _, layout := range publishedTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// jsonLDPublishedTime returns the first parsable datePublished among the
+// JSON-LD objects, looking inside @graph containers too.
+func jsonLDPublishedTime(objects []map[string]any) *time.Time {
+	for _, object := range objects {
+		if raw, ok := object["datePublished"].(string); ok {
+			if t := parsePublishedTime(raw); t != nil {
+				return t
+			}
+		}
+		if graph, ok := object["@graph"].([]any); ok {
+			nested := make([]map[string]any, 0, len(graph))
+			for _, node := range graph {
+				if m, ok := node.(map[string]any); ok {
+					nested = append(nested, m)
+				}
+			}
+			if t := jsonLDPublishedTime(nested); t != nil {
+				return t
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultMaxURLLength       = 2048
+	defaultMaxSegmentRepeats  = 3
+	defaultMaxURLsPerHost     = 50000
+	defaultMaxCalendarDepth   = 2
+	defaultHostCountWindow    = 24 * time.Hour
+	hostURLCountsKey          = "trap:host_url_counts"
+	trapReasonURLLength       = "url_length"
+	trapReasonRepeatedSegment = "repeated_segment"
+	trapReasonSessionID       = "session_id"
+	trapReasonCalendar        = "calendar"
+	trapReasonHostExplosion   = "host_explosion"
+)
+
+var (
+	sessionParams = map[string]bool{
+		"sid": true, "sessionid": true, "session_id": true, "jsessionid": true,
+		"phpsessid": true, "aspsessionid": true, "sessid": true,
+	}
+	calendarSegment = regexp.MustCompile(`^(19|20)\d{2}([-_/]?(0[1-9]|1[0-2]))?([-_/]?(0[1-9]|[12]\d|3[01]))?$`)
+)
+
+type TrapDetector struct {
+	redisClient       *redis.Client
+	maxURLLength      int
+	maxSegmentRepeats int
+	maxURLsPerHost    int64
+	maxCalendarDepth  int
+	hostCountWindow   time.Duration
+	mu                sync.Mutex
+	blocked           map[string]int64
+	log               *log.Logger
+	keys              keyspace
+}
+
+func NewTrapDetector(redisClient *redis.Client, cfg *config.TrapConfig, logger *log.Logger, keys keyspace) *TrapDetector {
+	d := &TrapDetector{
+		redisClient:       redisClient,
+		maxURLLength:      defaultMaxURLLength,
+		maxSegmentRepeats: defaultMaxSegmentRepeats,
+		maxURLsPerHost:    defaultMaxURLsPerHost,
+		maxCalendarDepth:  defaultMaxCalendarDepth,
+		hostCountWindow:   defaultHostCountWindow,
+		blocked:           make(map[string]int64),
+		log:               logger,
+		keys:              keys,
+	}
+	if cfg.MaxURLLength > 0 {
+		d.maxURLLength = cfg.MaxURLLength
+	}
+	if cfg.MaxSegmentRepeats > 0 {
+		d.maxSegmentRepeats = cfg.MaxSegmentRepeats
+	}
+	if cfg.MaxURLsPerHost > 0 {
+		d.maxURLsPerHost = cfg.MaxURLsPerHost
+	}
+	if cfg.MaxCalendarDepth > 0 {
+		d.maxCalendarDepth = cfg.MaxCalendarDepth
+	}
+	if cfg.HostCountWindow > 0 {
+		d.hostCountWindow = cfg.HostCountWindow
+	}
+	return d
+}
+
+// hostCountsKey returns the hash counting hosts' URLs in the current window.
+// Each window has its own hash, kept a window longer than it is used, so
+// counts start over rather than cutting a host off for good.
+func (d *TrapDetector) hostCountsKey(now time.Time) string {
+	window := now.UnixNano() / int64(d.hostCountWindow)
+	return d.keys.key(fmt.Sprintf("%s:%d", hostURLCountsKey, window))
+}
+
+// Check reports whether rawUrl looks like a spider trap and, if so, the reason.
+// URLs that pass are counted against their host so that runaway hosts get cut off
+// until the host count window ends.
+func (d *TrapDetector) Check(ctx context.Context, rawUrl string) (string, bool, error) {
+	if reason, trapped := d.checkPattern(rawUrl); trapped {
+		d.record(reason, rawUrl)
+		return reason, true, nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse url: %w", err)
+	}
+	key := d.hostCountsKey(time.Now())
+	pipe := d.redisClient.Pipeline()
+	count := pipe.HIncrBy(ctx, key, u.Host, 1)
+	pipe.Expire(ctx, key, 2*d.hostCountWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", false, fmt.Errorf("failed to update host url count: %w", err)
+	}
+	if count.Val() > d.maxURLsPerHost {
+		d.record(trapReasonHostExplosion, rawUrl)
+		return trapReasonHostExplosion, true, nil
+	}
+	return "", false, nil
+}
+
+// CheckBatch runs Check over many URLs with one round trip for the host
+// counters and returns the URLs that are not traps, in order.
+func (d *TrapDetector) CheckBatch(ctx context.Context, rawUrls []string) ([]string, error) {
+	var candidates []string
+	var hosts []string
+	for _, rawUrl := range rawUrls {
+		if reason, trapped := d.checkPattern(rawUrl); trapped {
+			d.record(reason, rawUrl)
+			continue
+		}
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, rawUrl)
+		hosts = append(hosts, u.Host)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	key := d.hostCountsKey(time.Now())
+	pipe := d.redisClient.Pipeline()
+	counts := make([]*redis.IntCmd, len(hosts))
+	for i, host := range hosts {
+		counts[i] = pipe.HIncrBy(ctx, key, host, 1)
+	}
+	pipe.Expire(ctx, key, 2*d.hostCountWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to update host url counts: %w", err)
+	}
+	kept := candidates[:0]
+	for i, rawUrl := range candidates {
+		if counts[i].Val() > d.maxURLsPerHost {
+			d.record(trapReasonHostExplosion, rawUrl)
+			continue
+		}
+		kept = append(kept, rawUrl)
+	}
+	return kept, nil
+}
+
+func (d *TrapDetector) checkPattern(rawUrl string) (string, bool) {
+	if len(rawUrl) > d.maxURLLength {
+		return trapReasonURLLength, true
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", false
+	}
+	for key := range u.Query() {
+		if sessionParams[strings.ToLower(key)] {
+			return trapReasonSessionID, true
+		}
+	}
+	if strings.Contains(strings.ToLower(u.Path), ";jsessionid=") {
+		return trapReasonSessionID, true
+	}
+
+	segmentCounts := make(map[string]int)
+	calendarSegments := 0
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "" {
+			continue
+		}
+		segmentCounts[segment]++
+		if segmentCounts[segment] > d.maxSegmentRepeats {
+			return trapReasonRepeatedSegment, true
+		}
+		if calendarSegment.MatchString(segment) {
+			calendarSegments++
+		}
+	}
+	// A single /2024/ or /2024-05-01/ is normal for news sites, but calendar
+	// widgets tend to nest date segments or paginate them through the query.
+	if calendarSegments > d.maxCalendarDepth {
+		return trapReasonCalendar, true
+	}
+	for key, vals := range u.Query() {
+		k := strings.ToLower(key)
+		if k != "date" && k != "month" && k != "year" && k != "day" && k != "cal" {
+			continue
+		}
+		for _, v := range vals {
+			if calendarSegment.MatchString(v) && calendarSegments > 0 {
+				return trapReasonCalendar, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (d *TrapDetector) record(reason, rawUrl string) {
+	d.mu.Lock()
+	d.blocked[reason]++
+	d.mu.Unlock()
+	d.log.Printf("spider trap detected (%s): %s", reason, rawUrl)
+}
+
+// Stats returns the number of URLs blocked per trap reason since startup.
+func (d *TrapDetector) Stats() map[string]int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := make(map[string]int64, len(d.blocked))
+	for reason, count := range d.blocked {
+		stats[reason] = count
+	}
+	return stats
+}
@@ -0,0 +1,115 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/config"
+)
+
+var ErrURLFiltered = errors.New("url excluded by crawl scope")
+
+// URLFilter scopes a crawl. Domain patterns are globs matched against the
+// host without "www." ("example.com" matches only that host, "*.example.com"
+// its subdomains). Path patterns are regular expressions matched against the
+// path plus query string. Blocks always win over allows, and an empty allow
+// list admits everything.
+type URLFilter struct {
+	allowDomains []string
+	blockDomains []string
+	allowPaths   []*regexp.Regexp
+	blockPaths   []*regexp.Regexp
+}
+
+func NewURLFilter(cfg *config.URLFilterConfig) (*URLFilter, error) {
+	f := &URLFilter{
+		allowDomains: lowerAll(cfg.AllowDomains),
+		blockDomains: lowerAll(cfg.BlockDomains),
+	}
+	for _, globs := range [][]string{f.allowDomains, f.blockDomains} {
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid domain pattern %q: %w", glob, err)
+			}
+		}
+	}
+	var err error
+	if f.allowPaths, err = compileAll(cfg.AllowPaths); err != nil {
+		return nil, err
+	}
+	if f.blockPaths, err = compileAll(cfg.BlockPaths); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Allowed reports whether rawUrl is inside the crawl scope.
+func (f *URLFilter) Allowed(rawUrl string) bool {
+	if f == nil {
+		return true
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	if matchesGlob(f.blockDomains, host) || matchesRegex(f.blockPaths, target) {
+		return false
+	}
+	if len(f.allowDomains) > 0 && !matchesGlob(f.allowDomains, host) {
+		return false
+	}
+	if len(f.allowPaths) > 0 && !matchesRegex(f.allowPaths, target) {
+		return false
+	}
+	return true
+}
+
+func matchesGlob(globs []string, host string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRegex(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func lowerAll(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -2,14 +2,19 @@ package crawler
 
 import (
 	"context"
+	"errors"
 	"github.com/amankumarsingh77/search_engine/internal/common/database"
 	"log"
-	"math/rand"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/amankumarsingh77/search_engine/internal/telemetry"
 	"github.com/amankumarsingh77/search_engine/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Worker struct {
@@ -17,17 +22,54 @@ type Worker struct {
 	frontier URLFrontier
 	crawler  WebCrawler
 	wg       *sync.WaitGroup
+	gate     *pauseGate
+	settings *workerSettings
+	stats    *workerStats
 	stopChan chan struct{}
 	outChan  chan models.WebPage
 	maxDepth int64
 	db       *database.MongoClient
+	stream   *pageStream
+	polite   *PolitenessController
+	metrics  *crawlMetrics
 	logger   *log.Logger
 }
 
-const batchSize = 50
+var tracer = telemetry.Tracer("crawler")
 
-func NewWorker(id string, frontier URLFrontier, outChan chan models.WebPage, logger *log.Logger, webCrawler WebCrawler, db *database.MongoClient, maxDepth int64) *Worker {
-	var wg *sync.WaitGroup
+const (
+	failReasonRedirectLoop     = "redirect_loop"
+	failReasonTooManyRedirects = "too_many_redirects"
+)
+
+// failureReason maps a crawl error to the reason stored in the failed queue.
+// Redirect failures get fixed reasons so they can be counted and retried as a
+// class; everything else keeps its error text.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrRedirectLoop):
+		return failReasonRedirectLoop
+	case errors.Is(err, ErrTooManyRedirects):
+		return failReasonTooManyRedirects
+	default:
+		return err.Error()
+	}
+}
+
+// isServerStrain reports whether a crawl outcome suggests the host is
+// overloaded: a transport failure, 429 or 5xx response.
+func isServerStrain(page *models.WebPage, err error) bool {
+	if err == nil {
+		return false
+	}
+	if page == nil || page.Fetch == nil {
+		return true
+	}
+	status := page.Fetch.StatusCode
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+func NewWorker(id string, frontier URLFrontier, outChan chan models.WebPage, wg *sync.WaitGroup, gate *pauseGate, settings *workerSettings, logger *log.Logger, webCrawler WebCrawler, db *database.MongoClient, polite *PolitenessController, metrics *crawlMetrics, maxDepth int64) *Worker {
 	return &Worker{
 		ID:       id,
 		frontier: frontier,
@@ -36,18 +78,26 @@ func NewWorker(id string, frontier URLFrontier, outChan chan models.WebPage, log
 		stopChan: make(chan struct{}),
 		logger:   logger,
 		wg:       wg,
+		gate:     gate,
+		settings: settings,
+		stats:    &workerStats{state: WorkerState{ID: id, Status: workerStatusIdle, StartedAt: time.Now()}},
 		db:       db,
+		polite:   polite,
+		metrics:  metrics,
 		maxDepth: maxDepth,
 	}
 }
 
+// State reports what the worker is doing and how much it has crawled.
+func (w *Worker) State() WorkerState {
+	return w.stats.snapshot()
+}
+
 func (w *Worker) Start(ctx context.Context) {
 	defer w.wg.Done()
+	defer w.stats.setStatus(workerStatusStopped)
 	w.logger.Printf("Worker %s: Starting", w.ID)
 
-	const maxConcurrentCrawls = 5
-	sem := make(chan struct{}, maxConcurrentCrawls)
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -57,10 +107,21 @@ func (w *Worker) Start(ctx context.Context) {
 			w.logger.Printf("Worker %s: Stop signal received, shutting down", w.ID)
 			return
 		default:
-			batchItems, err := w.frontier.NextBatch(ctx, w.ID, batchSize)
+			if w.gate.Paused() {
+				w.stats.setStatus(workerStatusPaused)
+				w.logger.Printf("Worker %s: Paused", w.ID)
+				if !w.gate.wait(ctx, w.stopChan) {
+					return
+				}
+				w.logger.Printf("Worker %s: Resumed", w.ID)
+			}
+			w.stats.setStatus(workerStatusIdle)
+			settings := w.settings.Get()
+			batchItems, err := w.frontier.NextBatch(ctx, w.ID, settings.BatchSize)
 			if err != nil {
 				if strings.Contains(err.Error(), "frontier is empty") || strings.Contains(err.Error(), "timeout reached") {
 					w.logger.Printf("Worker %s: Frontier empty or timeout, sleeping", w.ID)
+					w.stats.setStatus(workerStatusWaiting)
 					select {
 					case <-ctx.Done():
 						return
@@ -74,9 +135,22 @@ func (w *Worker) Start(ctx context.Context) {
 				return
 			}
 
+			w.stats.setStatus(workerStatusFetching)
+			// Results are reported with a context that outlives shutdown, so
+			// fetches already in flight when the crawl is interrupted are
+			// still recorded instead of being crawled again on restart.
+			flushCtx := context.WithoutCancel(ctx)
 			var batchWg sync.WaitGroup
+			var pagesMu sync.Mutex
 			var pagesData []*models.WebPage
+			sem := make(chan struct{}, settings.Concurrency)
+			limiter := newDomainLimiter(settings)
 			for _, item := range batchItems {
+				if w.stopping(ctx) {
+					// Items not started stay claimed and are handed back
+					// to pending by the spider's checkpoint.
+					break
+				}
 				urlToCrawl := item.Url
 				if urlToCrawl == "" {
 					w.logger.Printf("Worker %s: Frontier returned empty URL, skipping", w.ID)
@@ -89,10 +163,40 @@ func (w *Worker) Start(ctx context.Context) {
 				go func(url string) {
 					defer batchWg.Done()
 					defer func() { <-sem }()
+					if host, err := hostKey(url); err == nil {
+						defer limiter.acquire(host)()
+					}
 
 					w.logger.Printf("Worker %s: Processing URL: %s", w.ID, url)
-					pageData, err := w.crawler.CrawlPage(url)
+					// The item's span is carried into flushCtx too, so the
+					// frontier updates after a fetch land in the same trace.
+					itemCtx, span := tracer.Start(ctx, "crawler.crawl_item", trace.WithAttributes(
+						attribute.String("url.full", url),
+						attribute.Int64("crawler.depth", item.Depth),
+						attribute.String("crawler.worker", w.ID),
+					))
+					itemFlushCtx := trace.ContextWithSpan(flushCtx, span)
+					w.stats.begin(url)
+					fetchStart := time.Now()
+					pageData, err := w.crawler.CrawlPage(itemCtx, url)
+					elapsed := time.Since(fetchStart)
+					w.stats.finish(err)
+					host, hostErr := hostKey(url)
+					if hostErr == nil {
+						w.polite.Observe(host, elapsed, isServerStrain(pageData, err))
+					}
+					w.metrics.observeFetch(host, pageData, err, elapsed)
+					status := 0
+					if pageData != nil && pageData.Fetch != nil {
+						status = pageData.Fetch.StatusCode
+					}
+					w.polite.Audit(url, status, fetchStart)
+					if status != 0 {
+						span.SetAttributes(attribute.Int("http.response.status_code", status))
+					}
 					if err != nil {
+						span.RecordError(err)
+						span.SetStatus(codes.Error, failureReason(err))
 						w.logger.Printf("Worker %s: Failed to process %s: %v", w.ID, url, err)
 						if pageData == nil {
 							pageData = &models.WebPage{
@@ -102,34 +206,24 @@ func (w *Worker) Start(ctx context.Context) {
 						} else {
 							pageData.ErrorString = err.Error()
 						}
-						if err = w.frontier.Fail(ctx, item, w.ID, err.Error()); err != nil {
+						if err = w.frontier.Fail(itemFlushCtx, item, w.ID, failureReason(err)); err != nil {
 							w.logger.Printf("Worker %s: CRITICAL - Failed to report crawl failure for %s: %v", w.ID, url, err)
 						}
+						if pageData.Fetch != nil {
+							pagesMu.Lock()
+							pagesData = append(pagesData, pageData)
+							pagesMu.Unlock()
+						}
 					} else {
-						if err = w.frontier.Done(ctx, item, w.ID); err != nil {
+						if err = w.frontier.Done(itemFlushCtx, item, w.ID); err != nil {
 							w.logger.Printf("Worker %s: CRITICAL - Failed to report crawl success for %s: %v", w.ID, url, err)
 						}
-						//for _, link := range pageData.InternalLinks {
-						//	select {
-						//	case <-ctx.Done():
-						//		w.logger.Printf("Worker %s: Context cancelled while adding links", w.ID)
-						//		return
-						//	case <-w.stopChan:
-						//		w.logger.Printf("Worker %s: Stop signal received while adding links", w.ID)
-						//		return
-						//	default:
-						//		if item.Depth == w.maxDepth {
-						//			w.logger.Println("Max depth reached : skipping")
-						//			return
-						//		}
-						//		if err = w.frontier.Seed(ctx, link, item.Depth+1); err != nil {
-						//			w.logger.Printf("Worker %s: Could not add link %s to frontier: %v", w.ID, link, err)
-						//		}
-						//	}
-						//}
+						w.seedLinks(itemFlushCtx, item, pageData, settings.FollowExternalLinks)
+						pagesMu.Lock()
 						pagesData = append(pagesData, pageData)
-						w.logger.Printf("Worker %s: Added %d links from %s to frontier", w.ID, len(pageData.InternalLinks), url)
+						pagesMu.Unlock()
 					}
+					span.End()
 
 					//if w.outChan != nil {
 					//	select {
@@ -145,7 +239,7 @@ func (w *Worker) Start(ctx context.Context) {
 					//	}
 					//}
 
-					delay := time.Duration(500+rand.Intn(500)) * time.Millisecond
+					delay := w.polite.Delay(host)
 					w.logger.Printf("Worker %s: Sleeping for %v before next request", w.ID, delay)
 					select {
 					case <-time.After(delay):
@@ -156,11 +250,67 @@ func (w *Worker) Start(ctx context.Context) {
 				}(urlToCrawl)
 			}
 			batchWg.Wait()
-			if err = w.db.AddBatchWebPage(pagesData); err != nil {
-				w.logger.Printf("failed to add batch pages to db : %v", err)
-			}
+			w.storePages(flushCtx, pagesData)
+		}
+	}
+}
+
+// storePages writes a batch's pages and their URL equivalences to Mongo and,
+// when streaming, hands the pages to the indexer.
+func (w *Worker) storePages(ctx context.Context, pages []*models.WebPage) {
+	ctx, span := tracer.Start(ctx, "crawler.store_pages", trace.WithAttributes(attribute.Int("crawler.pages", len(pages))))
+	defer span.End()
+	if err := w.db.AddBatchWebPage(ctx, pages); err != nil {
+		span.RecordError(err)
+		w.logger.Printf("failed to add batch pages to db : %v", err)
+	}
+	if w.stream != nil {
+		if err := w.stream.publish(ctx, pages); err != nil {
+			span.RecordError(err)
+			w.logger.Printf("failed to publish pages to the indexer : %v", err)
 		}
 	}
+	var equivalences []models.URLEquivalence
+	for _, page := range pages {
+		equivalences = append(equivalences, urlEquivalences(page)...)
+	}
+	if err := w.db.UpsertURLEquivalences(ctx, equivalences); err != nil {
+		span.RecordError(err)
+		w.logger.Printf("failed to record url equivalences : %v", err)
+	}
+}
+
+// seedLinks queues a crawled page's links one level deeper, unless the page
+// is already at the depth limit. External links are only followed when
+// enabled.
+func (w *Worker) seedLinks(ctx context.Context, item *crawlItem, page *models.WebPage, followExternal bool) {
+	if item.Depth >= w.maxDepth {
+		return
+	}
+	links := page.InternalLinks
+	if followExternal {
+		links = append(links[:len(links):len(links)], page.ExternalLinks...)
+	}
+	if len(links) == 0 {
+		return
+	}
+	added, err := w.frontier.SeedBatch(ctx, links, item.Depth+1)
+	if err != nil {
+		w.logger.Printf("Worker %s: Could not add links from %s to frontier: %v", w.ID, item.Url, err)
+		return
+	}
+	w.logger.Printf("Worker %s: Added %d of %d links from %s to frontier", w.ID, added, len(links), item.Url)
+}
+
+func (w *Worker) stopping(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-w.stopChan:
+		return true
+	default:
+		return false
+	}
 }
 
 func (w *Worker) Stop() {
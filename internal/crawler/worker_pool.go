@@ -0,0 +1,216 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	workerStatusIdle     = "idle"
+	workerStatusFetching = "fetching"
+	workerStatusWaiting  = "waiting"
+	workerStatusPaused   = "paused"
+	workerStatusStopped  = "stopped"
+)
+
+// WorkerState is a point-in-time view of one worker for the admin API.
+type WorkerState struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	InFlight   int       `json:"in_flight"`
+	Crawled    int64     `json:"crawled"`
+	Failed     int64     `json:"failed"`
+	LastURL    string    `json:"last_url,omitempty"`
+	LastActive time.Time `json:"last_active,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// workerStats is the mutable state behind WorkerState.
+type workerStats struct {
+	mu    sync.Mutex
+	state WorkerState
+}
+
+func (s *workerStats) setStatus(status string) {
+	s.mu.Lock()
+	s.state.Status = status
+	s.mu.Unlock()
+}
+
+func (s *workerStats) begin(url string) {
+	s.mu.Lock()
+	s.state.InFlight++
+	s.state.LastURL = url
+	s.state.LastActive = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *workerStats) finish(err error) {
+	s.mu.Lock()
+	s.state.InFlight--
+	if err != nil {
+		s.state.Failed++
+	} else {
+		s.state.Crawled++
+	}
+	s.state.LastActive = time.Now()
+	s.mu.Unlock()
+}
+
+// restore carries counters over from a checkpoint of an earlier run.
+func (s *workerStats) restore(prev WorkerState) {
+	s.mu.Lock()
+	s.state.Crawled = prev.Crawled
+	s.state.Failed = prev.Failed
+	s.state.LastURL = prev.LastURL
+	s.state.LastActive = prev.LastActive
+	s.mu.Unlock()
+}
+
+func (s *workerStats) snapshot() WorkerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// pauseGate blocks workers between batches while the crawl is paused.
+// In-flight fetches are allowed to finish.
+type pauseGate struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{}
+}
+
+func (g *pauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resume = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resume)
+	}
+}
+
+func (g *pauseGate) Paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// wait blocks while the gate is paused. It returns false if ctx or stop fired
+// first, meaning the worker should exit.
+func (g *pauseGate) wait(ctx context.Context, stop <-chan struct{}) bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	if !g.paused {
+		g.mu.Unlock()
+		return true
+	}
+	resume := g.resume
+	g.mu.Unlock()
+	select {
+	case <-resume:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// workerPool owns the running workers so they can be paused, inspected and
+// resized while the crawl is running.
+type workerPool struct {
+	ctx context.Context
+	// idPrefix namespaces worker IDs, and with them their processing
+	// queues, when several crawler nodes share one frontier.
+	idPrefix string
+	spawn    func(id string, wg *sync.WaitGroup, gate *pauseGate) *Worker
+	gate     *pauseGate
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	workers  []*Worker
+	nextID   int
+}
+
+func newWorkerPool(ctx context.Context, idPrefix string, spawn func(id string, wg *sync.WaitGroup, gate *pauseGate) *Worker) *workerPool {
+	return &workerPool{ctx: ctx, idPrefix: idPrefix, spawn: spawn, gate: &pauseGate{}}
+}
+
+// Resize starts or stops workers until n are running. Stopped workers finish
+// their current batch before exiting, so the pool shrinks gradually.
+func (p *workerPool) Resize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("worker count must be at least 1, got %d", n)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.workers) < n {
+		id := fmt.Sprintf("%sworker-%d", p.idPrefix, p.nextID)
+		p.nextID++
+		w := p.spawn(id, &p.wg, p.gate)
+		p.wg.Add(1)
+		go w.Start(p.ctx)
+		p.workers = append(p.workers, w)
+	}
+	for len(p.workers) > n {
+		last := p.workers[len(p.workers)-1]
+		last.Stop()
+		p.workers = p.workers[:len(p.workers)-1]
+	}
+	return nil
+}
+
+func (p *workerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.workers)
+}
+
+func (p *workerPool) IDs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, len(p.workers))
+	for i, w := range p.workers {
+		ids[i] = w.ID
+	}
+	return ids
+}
+
+func (p *workerPool) States() []WorkerState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	states := make([]WorkerState, len(p.workers))
+	for i, w := range p.workers {
+		states[i] = w.State()
+	}
+	return states
+}
+
+// Wait blocks until every worker ever started has exited.
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *workerPool) StopAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, w := range p.workers {
+		w.Stop()
+	}
+}
@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/amankumarsingh77/search_engine/config"
+)
+
+const (
+	defaultWorkerBatchSize   = 50
+	defaultWorkerConcurrency = 5
+)
+
+// WorkerSettings are the per-worker limits that can be changed while a crawl
+// runs. DomainConcurrency caps parallel fetches to a domain and its
+// subdomains below Concurrency.
+type WorkerSettings struct {
+	BatchSize           int            `json:"batch_size"`
+	Concurrency         int            `json:"concurrency"`
+	DomainConcurrency   map[string]int `json:"domain_concurrency,omitempty"`
+	FollowExternalLinks bool           `json:"follow_external_links"`
+}
+
+// workerSettings is shared by every worker in a pool. Workers read it at the
+// start of each batch, so a change takes effect on their next batch.
+type workerSettings struct {
+	mu      sync.RWMutex
+	current WorkerSettings
+}
+
+func newWorkerSettings(cfg *config.WorkerConfig) *workerSettings {
+	s := WorkerSettings{
+		BatchSize:           defaultWorkerBatchSize,
+		Concurrency:         defaultWorkerConcurrency,
+		FollowExternalLinks: cfg.FollowExternalLinks,
+	}
+	if cfg.BatchSize > 0 {
+		s.BatchSize = cfg.BatchSize
+	}
+	if cfg.Concurrency > 0 {
+		s.Concurrency = cfg.Concurrency
+	}
+	for _, d := range cfg.Domains {
+		if d.Concurrency <= 0 {
+			continue
+		}
+		if s.DomainConcurrency == nil {
+			s.DomainConcurrency = make(map[string]int)
+		}
+		s.DomainConcurrency[strings.ToLower(strings.TrimPrefix(d.Domain, "."))] = d.Concurrency
+	}
+	return &workerSettings{current: s}
+}
+
+func (s *workerSettings) Get() WorkerSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Set replaces the settings. The domain map is copied so the caller can't
+// change it behind the workers' backs.
+func (s *workerSettings) Set(next WorkerSettings) error {
+	if next.BatchSize < 1 || next.Concurrency < 1 {
+		return errors.New("batch_size and concurrency must be at least 1")
+	}
+	domains := make(map[string]int, len(next.DomainConcurrency))
+	for domain, n := range next.DomainConcurrency {
+		if n < 1 {
+			return errors.New("domain concurrency must be at least 1")
+		}
+		domains[strings.ToLower(strings.TrimPrefix(domain, "."))] = n
+	}
+	next.DomainConcurrency = domains
+	s.mu.Lock()
+	s.current = next
+	s.mu.Unlock()
+	return nil
+}
+
+// domainFor returns the most specific configured domain covering host, or
+// "" if there is none.
+func (ws WorkerSettings) domainFor(host string) string {
+	if len(ws.DomainConcurrency) == 0 {
+		return ""
+	}
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		domain := strings.Join(labels[i:], ".")
+		if _, ok := ws.DomainConcurrency[domain]; ok {
+			return domain
+		}
+	}
+	return ""
+}
+
+// domainLimiter hands out fetch slots per configured domain for one batch.
+type domainLimiter struct {
+	settings WorkerSettings
+	mu       sync.Mutex
+	slots    map[string]chan struct{}
+}
+
+func newDomainLimiter(settings WorkerSettings) *domainLimiter {
+	return &domainLimiter{settings: settings, slots: make(map[string]chan struct{})}
+}
+
+// acquire blocks until host may be fetched and returns the release func.
+// Hosts outside every configured domain are not limited.
+func (l *domainLimiter) acquire(host string) func() {
+	domain := l.settings.domainFor(host)
+	if domain == "" {
+		return func() {}
+	}
+	l.mu.Lock()
+	slot, ok := l.slots[domain]
+	if !ok {
+		slot = make(chan struct{}, l.settings.DomainConcurrency[domain])
+		l.slots[domain] = slot
+	}
+	l.mu.Unlock()
+	slot <- struct{}{}
+	return func() { <-slot }
+}
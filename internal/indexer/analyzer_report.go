@@ -0,0 +1,80 @@
+package indexer
+
+import (
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/internal/lemmatizer"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+type AnalyzerReport struct {
+	Documents       int     `json:"documents"`
+	QueryWords      int     `json:"query_words"`
+	StemVocabulary  int     `json:"stem_vocabulary"`
+	LemmaVocabulary int     `json:"lemma_vocabulary"`
+	StemPostings    int     `json:"stem_postings"`
+	LemmaPostings   int     `json:"lemma_postings"`
+	StemAvgMatches  float64 `json:"stem_avg_matches"`
+	LemmaAvgMatches float64 `json:"lemma_avg_matches"`
+	RelativeRecall  float64 `json:"relative_recall"`
+}
+
+// CompareAnalyzers indexes docs in memory with both the Porter stemmer and the
+// lemmatizer and reports vocabulary/postings sizes along with how many documents
+// each surface word in the corpus would match under either analyzer.
+func CompareAnalyzers(docs []models.WebPage, lemmas *lemmatizer.Lemmatizer) AnalyzerReport {
+	stemIndex := make(map[string]map[int]struct{})
+	lemmaIndex := make(map[string]map[int]struct{})
+	surfaceWords := make(map[string]struct{})
+
+	addPosting := func(index map[string]map[int]struct{}, term string, docIdx int) {
+		if index[term] == nil {
+			index[term] = make(map[int]struct{})
+		}
+		index[term][docIdx] = struct{}{}
+	}
+
+	for docIdx, doc := range docs {
+		text := doc.Title + " " + doc.Description + " " + doc.BodyText + " " + strings.Join(doc.Paragraphs, " ")
+		words := textproc.Tokenize(textproc.Normalize(text))
+		for _, word := range words {
+			surfaceWords[word] = struct{}{}
+		}
+		for _, term := range textproc.Stem(words) {
+			addPosting(stemIndex, term, docIdx)
+		}
+		for _, term := range lemmas.LemmatizeTokens(words) {
+			addPosting(lemmaIndex, term, docIdx)
+		}
+	}
+
+	report := AnalyzerReport{
+		Documents:       len(docs),
+		QueryWords:      len(surfaceWords),
+		StemVocabulary:  len(stemIndex),
+		LemmaVocabulary: len(lemmaIndex),
+	}
+	for _, postings := range stemIndex {
+		report.StemPostings += len(postings)
+	}
+	for _, postings := range lemmaIndex {
+		report.LemmaPostings += len(postings)
+	}
+
+	var stemMatches, lemmaMatches int
+	for word := range surfaceWords {
+		if stemmed := textproc.Stem([]string{word}); len(stemmed) > 0 {
+			stemMatches += len(stemIndex[stemmed[0]])
+		}
+		lemmaMatches += len(lemmaIndex[lemmas.Lemma(word)])
+	}
+	if len(surfaceWords) > 0 {
+		report.StemAvgMatches = float64(stemMatches) / float64(len(surfaceWords))
+		report.LemmaAvgMatches = float64(lemmaMatches) / float64(len(surfaceWords))
+	}
+	if stemMatches > 0 {
+		report.RelativeRecall = float64(lemmaMatches) / float64(stemMatches)
+	}
+	return report
+}
@@ -0,0 +1,63 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxInboundAnchors caps the distinct anchor texts indexed per document,
+// most common first, so a page linked from every template isn't drowned in
+// them.
+const maxInboundAnchors = 20
+
+// ReplaceAnchors records the anchor text of each page's internal links,
+// replacing what an earlier crawl of the page recorded. Pages that failed,
+// or whose robots directives say nofollow, vouch for nothing and only have
+// their old anchors removed.
+func (s *Storage) ReplaceAnchors(ctx context.Context, docs []*models.WebPage) error {
+	batch := &pgx.Batch{}
+	for _, doc := range docs {
+		source := canonicalURL(doc.URL)
+		batch.Queue(deleteDocumentAnchors, source)
+		if doc.NoFollow || doc.IsErrorStatus() {
+			continue
+		}
+		for _, a := range doc.Anchors {
+			batch.Queue(insertDocumentAnchor, source, canonicalURL(a.URL), textproc.RemoveInvalidUTF8(a.Text))
+		}
+	}
+
+	results := s.pool.SendBatch(ctx, batch)
+	defer results.Close()
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			return fmt.Errorf("error storing anchors: %w", err)
+		}
+	}
+	return nil
+}
+
+// InboundAnchors returns the anchor texts of links pointing at each of urls.
+func (s *Storage) InboundAnchors(ctx context.Context, urls []string) (map[string][]string, error) {
+	rows, err := s.pool.Query(ctx, getInboundAnchors, urls, maxInboundAnchors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch anchors: %w", err)
+	}
+	defer rows.Close()
+	anchors := make(map[string][]string)
+	for rows.Next() {
+		var target, text string
+		if err := rows.Scan(&target, &text); err != nil {
+			return nil, fmt.Errorf("failed to read anchor: %w", err)
+		}
+		anchors[target] = append(anchors[target], text)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch anchors: %w", err)
+	}
+	return anchors, nil
+}
@@ -0,0 +1,839 @@
+package indexer
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/cache"
+	"github.com/amankumarsingh77/search_engine/internal/query"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Keys of the Badger index, by prefix. Ids are big-endian, so the keys of a
+// prefix are in id order.
+const (
+	badgerDocs      = "doc/"     // document id: its dumpDocument as JSON
+	badgerURLs      = "url/"     // canonical URL: document id
+	badgerTerms     = "term/"    // term: term id
+	badgerTermNames = "tname/"   // term id: term
+	badgerPostings  = "post/"    // term id: serialized posting list
+	badgerFreqs     = "freq/"    // term id: documents and occurrences
+	badgerDocTerms  = "dterm/"   // document id: ids of its terms
+	badgerDeleted   = "deleted/" // id of a tombstoned document: nothing
+	badgerLinks     = "link/"    // document id: linked hosts as JSON
+	badgerPassages  = "passage/" // document id: passages as JSON
+	badgerFields    = "field/"   // document id, field name: stored text
+	badgerAnchors   = "anchor/"  // source URL, 0, target URL: anchor text
+	badgerInbound   = "inbound/" // target URL, 0, source URL: anchor text
+	badgerNextDoc   = "meta/next_doc"
+	badgerNextTerm  = "meta/next_term"
+	badgerStats     = "meta/stats"
+)
+
+// BadgerStore keeps the index in an embedded Badger database, so a single
+// binary can index and search without Postgres. Each term's postings are
+// one serialized posting list, rewritten whole when a batch touches the
+// term, and the query filters on document metadata scan every document,
+// which suits small and medium corpora. Badger lets one process open a
+// directory at a time, so the indexer and the search API take turns on it.
+type BadgerStore struct {
+	db *badger.DB
+	// mu serializes writes: posting lists, counters and stats are read,
+	// changed and written back.
+	mu        sync.Mutex
+	termCache *cache.LRUCache
+}
+
+var (
+	_ Store       = (*BadgerStore)(nil)
+	_ query.Index = (*BadgerStore)(nil)
+)
+
+// OpenBadgerStore opens, creating it if needed, the Badger index in the
+// directory cfg.Path. Read-only, as for searching, it sees the index as it
+// was when opened.
+func OpenBadgerStore(cfg *config.IndexerConfig, readOnly bool) (*BadgerStore, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("Path is empty in config")
+	}
+	opts := badger.DefaultOptions(cfg.Path).
+		WithReadOnly(readOnly).
+		WithLoggingLevel(badger.WARNING)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger index at %s: %w", cfg.Path, err)
+	}
+	return &BadgerStore{db: db, termCache: newTermCache(cfg.TermCacheSize)}, nil
+}
+
+func (s *BadgerStore) TermCacheStats() cache.Stats {
+	return s.termCache.Stats()
+}
+
+func (s *BadgerStore) Close() {
+	if err := s.db.Close(); err != nil {
+		log.Printf("Failed to close badger index: %v", err)
+	}
+}
+
+func badgerKey(prefix string, id int64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(prefix), uint64(id))
+}
+
+func badgerFieldKey(docID int64, field string) []byte {
+	return append(badgerKey(badgerFields, docID), field...)
+}
+
+func badgerAnchorKey(prefix, from, to string) []byte {
+	return []byte(prefix + from + "\x00" + to)
+}
+
+func encodeID(id int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+func decodeID(data []byte) int64 {
+	if len(data) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+// encodeIDs serializes ids, sorting them, as varint differences.
+func encodeIDs(ids []int64) []byte {
+	slices.Sort(ids)
+	var buf []byte
+	var prev int64
+	for _, id := range ids {
+		buf = binary.AppendUvarint(buf, uint64(id-prev))
+		prev = id
+	}
+	return buf
+}
+
+func decodeIDs(data []byte) []int64 {
+	var ids []int64
+	var id int64
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			break
+		}
+		id += int64(delta)
+		ids = append(ids, id)
+		data = data[n:]
+	}
+	return ids
+}
+
+// badgerBatch writes a batch in one transaction, so it is stored whole or
+// not at all. A batch too big for a Badger transaction fails; each term it
+// touches has its whole posting list rewritten, so that takes a smaller
+// Index.BatchSize once lists grow long. Writers hold the store's lock.
+type badgerBatch struct {
+	txn *badger.Txn
+}
+
+func (s *BadgerStore) batch() *badgerBatch {
+	return &badgerBatch{txn: s.db.NewTransaction(true)}
+}
+
+// get returns the value of key, nil if there is none.
+func (b *badgerBatch) get(key []byte) ([]byte, error) {
+	return badgerGet(b.txn, key)
+}
+
+func badgerGet(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (b *badgerBatch) set(key, value []byte) error {
+	return checkTxnSize(b.txn.Set(key, value))
+}
+
+func (b *badgerBatch) delete(key []byte) error {
+	return checkTxnSize(b.txn.Delete(key))
+}
+
+func checkTxnSize(err error) error {
+	if errors.Is(err, badger.ErrTxnTooBig) {
+		return fmt.Errorf("batch is too big for one badger transaction, lower Index.BatchSize or PurgeBatchSize: %w", err)
+	}
+	return err
+}
+
+// keys returns the keys starting with prefix.
+func (b *badgerBatch) keys(prefix []byte) [][]byte {
+	it := b.txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	return keys
+}
+
+func (b *badgerBatch) commit() error {
+	return b.txn.Commit()
+}
+
+func (b *badgerBatch) discard() {
+	b.txn.Discard()
+}
+
+// next returns the next id of the sequence kept at key, starting at 1.
+func (b *badgerBatch) next(key string) (int64, error) {
+	value, err := b.get([]byte(key))
+	if err != nil {
+		return 0, err
+	}
+	id := decodeID(value) + 1
+	return id, b.set([]byte(key), encodeID(id))
+}
+
+func (b *badgerBatch) document(id int64) (*dumpDocument, error) {
+	return badgerDocument(b.txn, id)
+}
+
+func badgerDocument(txn *badger.Txn, id int64) (*dumpDocument, error) {
+	data, err := badgerGet(txn, badgerKey(badgerDocs, id))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var doc dumpDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to read document %d: %w", id, err)
+	}
+	return &doc, nil
+}
+
+func (b *badgerBatch) setDocument(doc *dumpDocument) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return b.set(badgerKey(badgerDocs, doc.ID), data)
+}
+
+func (b *badgerBatch) postingList(termID int64) ([]posting, error) {
+	return badgerPostingList(b.txn, termID)
+}
+
+func badgerPostingList(txn *badger.Txn, termID int64) ([]posting, error) {
+	data, err := badgerGet(txn, badgerKey(badgerPostings, termID))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	list, err := decodePostingList(termID, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read postings of term %d: %w", termID, err)
+	}
+	return list, nil
+}
+
+// setPostingList stores the postings of termID and their frequencies, or
+// drops the term's list once it has none.
+func (b *badgerBatch) setPostingList(termID int64, list []posting) error {
+	if len(list) == 0 {
+		if err := b.delete(badgerKey(badgerPostings, termID)); err != nil {
+			return err
+		}
+		return b.delete(badgerKey(badgerFreqs, termID))
+	}
+	if err := b.set(badgerKey(badgerPostings, termID), encodePostingList(list)); err != nil {
+		return err
+	}
+	docs, occurrences := postingListFrequency(list)
+	freq := binary.AppendUvarint(nil, uint64(docs))
+	freq = binary.AppendUvarint(freq, uint64(occurrences))
+	return b.set(badgerKey(badgerFreqs, termID), freq)
+}
+
+func decodeFrequency(data []byte) (docs, occurrences int64) {
+	d, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, 0
+	}
+	o, _ := binary.Uvarint(data[n:])
+	return int64(d), int64(o)
+}
+
+// badgerIndexStats is what index_stats holds in Postgres.
+type badgerIndexStats struct {
+	Docs   int64 `json:"docs"`
+	Tokens int64 `json:"tokens"`
+}
+
+func badgerStatsOf(txn *badger.Txn) (badgerIndexStats, error) {
+	var stats badgerIndexStats
+	data, err := badgerGet(txn, []byte(badgerStats))
+	if err != nil || data == nil {
+		return stats, err
+	}
+	err = json.Unmarshal(data, &stats)
+	return stats, err
+}
+
+func (b *badgerBatch) addStats(delta StatsDelta) error {
+	stats, err := badgerStatsOf(b.txn)
+	if err != nil {
+		return err
+	}
+	stats.Docs += delta.Docs
+	stats.Tokens += delta.Tokens
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return b.set([]byte(badgerStats), data)
+}
+
+func (s *BadgerStore) InsertDocuments(ctx context.Context, docs []*models.WebPage) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+
+	var delta StatsDelta
+	ids := make([]int64, len(docs))
+	now := time.Now().UTC()
+	for i, doc := range docs {
+		record := newDocumentRecord(doc, now)
+		urlKey := []byte(badgerURLs + record.URL)
+		value, err := b.get(urlKey)
+		if err != nil {
+			return nil, err
+		}
+		record.ID = decodeID(value)
+		previous := (*dumpDocument)(nil)
+		if record.ID == 0 {
+			if record.ID, err = b.next(badgerNextDoc); err != nil {
+				return nil, err
+			}
+			if err := b.set(urlKey, encodeID(record.ID)); err != nil {
+				return nil, err
+			}
+		} else if previous, err = b.document(record.ID); err != nil {
+			return nil, err
+		}
+		if previous != nil && previous.DeletedAt != nil {
+			if err := b.delete(badgerKey(badgerDeleted, record.ID)); err != nil {
+				return nil, err
+			}
+		}
+		if err := b.setDocument(&record); err != nil {
+			return nil, err
+		}
+		ids[i] = record.ID
+
+		delta.Tokens += int64(doc.TokenCount)
+		if previous != nil && previous.DeletedAt == nil {
+			delta.Tokens -= int64(previous.TokenCount)
+		} else {
+			delta.Docs++
+		}
+	}
+	if err := b.addStats(delta); err != nil {
+		return nil, fmt.Errorf("failed to update index stats: %w", err)
+	}
+	if err := b.commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *BadgerStore) UpsertTerms(ctx context.Context, terms []string) (map[string]int64, error) {
+	termMap := make(map[string]int64)
+	var missingTerms []string
+	for _, term := range terms {
+		if id, ok := s.termCache.Get(term); ok {
+			termMap[term] = id.(int64)
+		} else {
+			missingTerms = append(missingTerms, term)
+		}
+	}
+	if len(missingTerms) == 0 {
+		return termMap, nil
+	}
+	sort.Strings(missingTerms)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+	loaded := make(map[string]int64, len(missingTerms))
+	for _, term := range missingTerms {
+		key := []byte(badgerTerms + term)
+		value, err := b.get(key)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching term IDs: %w", err)
+		}
+		id := decodeID(value)
+		if id == 0 {
+			if id, err = b.next(badgerNextTerm); err != nil {
+				return nil, fmt.Errorf("error inserting terms: %w", err)
+			}
+			if err := b.set(key, encodeID(id)); err != nil {
+				return nil, fmt.Errorf("error inserting terms: %w", err)
+			}
+			if err := b.set(badgerKey(badgerTermNames, id), []byte(term)); err != nil {
+				return nil, fmt.Errorf("error inserting terms: %w", err)
+			}
+		}
+		loaded[term] = id
+	}
+	if err := b.commit(); err != nil {
+		return nil, fmt.Errorf("error inserting terms: %w", err)
+	}
+	for term, id := range loaded {
+		termMap[term] = id
+		s.termCache.Put(term, id)
+	}
+	return termMap, nil
+}
+
+// InsertPosting replaces the postings of the batch's documents: every term
+// they are posted to now, or were before, has its list rewritten.
+func (s *BadgerStore) InsertPosting(
+	ctx context.Context,
+	termMap map[string]int64,
+	docIDs []int64,
+	docs []*models.WebPage,
+	occurrences map[string]map[int][]Occurrence,
+) error {
+	byTerm := make(map[int64][]posting)
+	docTerms := make(map[int64][]int64, len(docIDs))
+	for _, p := range buildPostings(termMap, docIDs, docs, occurrences) {
+		byTerm[p.termID] = append(byTerm[p.termID], p)
+		docTerms[p.docID] = append(docTerms[p.docID], p.termID)
+	}
+	replaced := make(map[int64]struct{}, len(docIDs))
+	for _, docID := range docIDs {
+		replaced[docID] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+	for _, docID := range docIDs {
+		previous, err := b.get(badgerKey(badgerDocTerms, docID))
+		if err != nil {
+			return fmt.Errorf("error reading document terms: %w", err)
+		}
+		for _, termID := range decodeIDs(previous) {
+			if _, ok := byTerm[termID]; !ok {
+				byTerm[termID] = nil
+			}
+		}
+	}
+
+	termIDs := make([]int64, 0, len(byTerm))
+	for termID := range byTerm {
+		termIDs = append(termIDs, termID)
+	}
+	slices.Sort(termIDs)
+	for _, termID := range termIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		list, err := b.postingList(termID)
+		if err != nil {
+			return err
+		}
+		if err := b.setPostingList(termID, mergePostingList(list, replaced, byTerm[termID])); err != nil {
+			return fmt.Errorf("error inserting postings of term %d: %w", termID, err)
+		}
+	}
+	for _, docID := range docIDs {
+		key := badgerKey(badgerDocTerms, docID)
+		var err error
+		if terms := docTerms[docID]; len(terms) > 0 {
+			err = b.set(key, encodeIDs(terms))
+		} else {
+			err = b.delete(key)
+		}
+		if err != nil {
+			return fmt.Errorf("error storing document terms: %w", err)
+		}
+	}
+	return b.commit()
+}
+
+// setJSON stores value at key as JSON, or deletes key when empty is set.
+func (b *badgerBatch) setJSON(key []byte, value any, empty bool) error {
+	if empty {
+		return b.delete(key)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return b.set(key, data)
+}
+
+func (s *BadgerStore) ReplaceDocumentLinks(ctx context.Context, docIDs []int64, docs []*models.WebPage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+	for i, doc := range docs {
+		hosts := make(map[string]int)
+		for host, count := range linkHostCounts(doc.ExternalLinks) {
+			hosts[textproc.RemoveInvalidUTF8(host)] += count
+		}
+		if err := b.setJSON(badgerKey(badgerLinks, docIDs[i]), hosts, len(hosts) == 0); err != nil {
+			return fmt.Errorf("error storing document links: %w", err)
+		}
+	}
+	return b.commit()
+}
+
+func (s *BadgerStore) ReplaceDocumentPassages(ctx context.Context, docIDs []int64, passages map[int][]Passage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+	for docIdx, docID := range docIDs {
+		stored := make([]query.Passage, len(passages[docIdx]))
+		for i, p := range passages[docIdx] {
+			stored[i] = query.Passage{No: p.No, Start: int32(p.Start), End: int32(p.End), Body: textproc.RemoveInvalidUTF8(p.Text)}
+		}
+		if err := b.setJSON(badgerKey(badgerPassages, docID), stored, len(stored) == 0); err != nil {
+			return fmt.Errorf("error storing document passages: %w", err)
+		}
+	}
+	return b.commit()
+}
+
+func (s *BadgerStore) ReplaceStoredBodies(ctx context.Context, docIDs []int64, bodies map[int]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+	for docIdx, body := range bodies {
+		if err := b.set(badgerFieldKey(docIDs[docIdx], textproc.FieldBody), []byte(truncateUTF8(body, maxStoredBody))); err != nil {
+			return fmt.Errorf("error storing document bodies: %w", err)
+		}
+	}
+	return b.commit()
+}
+
+// ReplaceAnchors records each page's anchors under its URL and, for
+// InboundAnchors, under each target's.
+func (s *BadgerStore) ReplaceAnchors(ctx context.Context, docs []*models.WebPage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+	for _, doc := range docs {
+		source := canonicalURL(doc.URL)
+		if err := b.deleteAnchors(source); err != nil {
+			return fmt.Errorf("error storing anchors: %w", err)
+		}
+		if doc.NoFollow || doc.IsErrorStatus() {
+			continue
+		}
+		for _, a := range doc.Anchors {
+			target := canonicalURL(a.URL)
+			text := []byte(textproc.RemoveInvalidUTF8(a.Text))
+			if err := b.set(badgerAnchorKey(badgerAnchors, source, target), text); err != nil {
+				return fmt.Errorf("error storing anchors: %w", err)
+			}
+			if err := b.set(badgerAnchorKey(badgerInbound, target, source), text); err != nil {
+				return fmt.Errorf("error storing anchors: %w", err)
+			}
+		}
+	}
+	return b.commit()
+}
+
+// deleteAnchors removes the anchors of the links on the page at source.
+func (b *badgerBatch) deleteAnchors(source string) error {
+	prefix := badgerAnchorKey(badgerAnchors, source, "")
+	for _, key := range b.keys(prefix) {
+		target := string(key[len(prefix):])
+		if err := b.delete(key); err != nil {
+			return err
+		}
+		if err := b.delete(badgerAnchorKey(badgerInbound, target, source)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BadgerStore) InboundAnchors(ctx context.Context, urls []string) (map[string][]string, error) {
+	anchors := make(map[string][]string)
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, target := range urls {
+			prefix := badgerAnchorKey(badgerInbound, target, "")
+			counts := make(map[string]int)
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+			for it.Rewind(); it.Valid(); it.Next() {
+				if string(it.Item().Key()[len(prefix):]) == target {
+					continue
+				}
+				text, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					it.Close()
+					return err
+				}
+				counts[string(text)]++
+			}
+			it.Close()
+			texts := make([]string, 0, len(counts))
+			for text := range counts {
+				texts = append(texts, text)
+			}
+			slices.SortFunc(texts, func(a, b string) int {
+				if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+					return c
+				}
+				return cmp.Compare(a, b)
+			})
+			if len(texts) > 0 {
+				anchors[target] = texts[:min(len(texts), maxInboundAnchors)]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch anchors: %w", err)
+	}
+	return anchors, nil
+}
+
+// DeleteDocuments tombstones documents like Storage.DeleteDocuments.
+func (s *BadgerStore) DeleteDocuments(ctx context.Context, urls []string, ids []int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+	for _, u := range urls {
+		value, err := b.get([]byte(badgerURLs + canonicalURL(u)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete documents: %w", err)
+		}
+		if id := decodeID(value); id != 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	var deleted []int64
+	var delta StatsDelta
+	now := time.Now().UTC()
+	for _, id := range ids {
+		doc, err := b.document(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete documents: %w", err)
+		}
+		if doc == nil || doc.DeletedAt != nil {
+			continue
+		}
+		doc.DeletedAt = &now
+		if err := b.setDocument(doc); err != nil {
+			return nil, fmt.Errorf("failed to delete documents: %w", err)
+		}
+		if err := b.set(badgerKey(badgerDeleted, id), nil); err != nil {
+			return nil, fmt.Errorf("failed to delete documents: %w", err)
+		}
+		delta.Docs--
+		delta.Tokens -= int64(doc.TokenCount)
+		deleted = append(deleted, id)
+	}
+	if err := b.addStats(delta); err != nil {
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	if err := b.commit(); err != nil {
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	return deleted, nil
+}
+
+// scanDocuments calls fn with every document, in id order, until it
+// returns false.
+func scanDocuments(txn *badger.Txn, fn func(doc *dumpDocument) bool) error {
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(badgerDocs), PrefetchValues: true, PrefetchSize: 100})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		var doc dumpDocument
+		err := it.Item().Value(func(data []byte) error {
+			return json.Unmarshal(data, &doc)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read document: %w", err)
+		}
+		if !fn(&doc) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *BadgerStore) PurgeDeletedDocuments(ctx context.Context, limit int) (int64, error) {
+	if limit <= 0 {
+		limit = defaultPurgeBatchSize
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batch()
+	defer b.discard()
+
+	var doomed []*dumpDocument
+	err := scanDocuments(b.txn, func(doc *dumpDocument) bool {
+		if doc.DeletedAt != nil {
+			doomed = append(doomed, doc)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted documents: %w", err)
+	}
+	slices.SortFunc(doomed, func(a, b *dumpDocument) int { return a.DeletedAt.Compare(*b.DeletedAt) })
+	doomed = doomed[:min(len(doomed), limit)]
+	for _, doc := range doomed {
+		if err := b.removeDocument(doc); err != nil {
+			return 0, fmt.Errorf("failed to purge deleted documents: %w", err)
+		}
+	}
+	if err := b.commit(); err != nil {
+		return 0, fmt.Errorf("failed to purge deleted documents: %w", err)
+	}
+	return int64(len(doomed)), nil
+}
+
+// removeDocument deletes doc and everything stored about it.
+func (b *badgerBatch) removeDocument(doc *dumpDocument) error {
+	terms, err := b.get(badgerKey(badgerDocTerms, doc.ID))
+	if err != nil {
+		return err
+	}
+	removed := map[int64]struct{}{doc.ID: {}}
+	for _, termID := range decodeIDs(terms) {
+		list, err := b.postingList(termID)
+		if err != nil {
+			return err
+		}
+		if err := b.setPostingList(termID, mergePostingList(list, removed, nil)); err != nil {
+			return err
+		}
+	}
+	keys := b.keys(badgerKey(badgerFields, doc.ID))
+	keys = append(keys,
+		badgerKey(badgerDocTerms, doc.ID),
+		badgerKey(badgerLinks, doc.ID),
+		badgerKey(badgerPassages, doc.ID),
+		badgerKey(badgerDeleted, doc.ID),
+		badgerKey(badgerDocs, doc.ID),
+	)
+	for _, key := range keys {
+		if err := b.delete(key); err != nil {
+			return err
+		}
+	}
+	if err := b.deleteAnchors(doc.URL); err != nil {
+		return err
+	}
+	urlKey := []byte(badgerURLs + doc.URL)
+	value, err := b.get(urlKey)
+	if err != nil {
+		return err
+	}
+	if decodeID(value) == doc.ID {
+		return b.delete(urlKey)
+	}
+	return nil
+}
+
+func (s *BadgerStore) ExpireDocuments(ctx context.Context, rules ExpiryRules, limit int) ([]ExpiredDocument, error) {
+	if limit <= 0 {
+		limit = defaultExpireBatchSize
+	}
+	now := time.Now()
+	var expired []*dumpDocument
+	err := s.db.View(func(txn *badger.Txn) error {
+		return scanDocuments(txn, func(doc *dumpDocument) bool {
+			if doc.DeletedAt != nil {
+				return true
+			}
+			var domain string
+			if doc.Domain != nil {
+				domain = *doc.Domain
+			}
+			if ttl := rules.ttlFor(domain); ttl > 0 && doc.IndexedAt.Before(now.Add(-ttl)) {
+				expired = append(expired, doc)
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired documents: %w", err)
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(expired, func(a, b *dumpDocument) int { return a.IndexedAt.Compare(b.IndexedAt) })
+	expired = expired[:min(len(expired), limit)]
+
+	urls := make(map[int64]string, len(expired))
+	ids := make([]int64, len(expired))
+	for i, doc := range expired {
+		urls[doc.ID] = doc.URL
+		ids[i] = doc.ID
+	}
+	deleted, err := s.DeleteDocuments(ctx, nil, ids)
+	docs := make([]ExpiredDocument, len(deleted))
+	for i, id := range deleted {
+		docs[i] = ExpiredDocument{ID: id, URL: urls[id]}
+	}
+	return docs, err
+}
+
+// RefreshTermFrequencies has nothing to do: each term's frequencies are
+// written with its posting list.
+func (s *BadgerStore) RefreshTermFrequencies(ctx context.Context) error {
+	return nil
+}
+
+// Analyze has nothing to do: Badger keeps no planner statistics.
+func (s *BadgerStore) Analyze(ctx context.Context) error {
+	return nil
+}
+
+// RebuildBloatedIndexes reclaims the space of rewritten posting lists from
+// Badger's value log. There are no indexes to report.
+func (s *BadgerStore) RebuildBloatedIndexes(ctx context.Context, opts ReindexOptions) ([]IndexBloat, error) {
+	for ctx.Err() == nil {
+		err := s.db.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect badger value log: %w", err)
+		}
+	}
+	return nil, ctx.Err()
+}
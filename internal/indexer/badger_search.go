@@ -0,0 +1,484 @@
+package indexer
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/internal/query"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// The query.Index methods of BadgerStore, which serve the query engine
+// from the embedded index.
+
+func (s *BadgerStore) Stats(ctx context.Context) (int64, float64, error) {
+	var stats badgerIndexStats
+	err := s.db.View(func(txn *badger.Txn) (err error) {
+		stats, err = badgerStatsOf(txn)
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read index stats: %w", err)
+	}
+	if stats.Docs <= 0 {
+		return stats.Docs, 0, nil
+	}
+	return stats.Docs, float64(stats.Tokens) / float64(stats.Docs), nil
+}
+
+// termFrequency is a term's entry under badgerFreqs.
+type termFrequency struct {
+	termID      int64
+	docs        int64
+	occurrences int64
+}
+
+// frequencies returns the frequencies of every term with postings.
+func (s *BadgerStore) frequencies() ([]termFrequency, error) {
+	var freqs []termFrequency
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(badgerFreqs), PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			f := termFrequency{termID: decodeID(it.Item().Key()[len(badgerFreqs):])}
+			err := it.Item().Value(func(data []byte) error {
+				f.docs, f.occurrences = decodeFrequency(data)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			freqs = append(freqs, f)
+		}
+		return nil
+	})
+	return freqs, err
+}
+
+func (s *BadgerStore) TopTerms(ctx context.Context, n int) ([]int64, error) {
+	freqs, err := s.frequencies()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(freqs, func(a, b termFrequency) int { return cmp.Compare(b.occurrences, a.occurrences) })
+	ids := make([]int64, 0, min(n, len(freqs)))
+	for _, f := range freqs[:min(n, len(freqs))] {
+		ids = append(ids, f.termID)
+	}
+	return ids, nil
+}
+
+func (s *BadgerStore) TermIDs(ctx context.Context, terms []string) (map[string]int64, error) {
+	ids := make(map[string]int64, len(terms))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, term := range terms {
+			value, err := badgerGet(txn, []byte(badgerTerms+term))
+			if err != nil {
+				return err
+			}
+			if id := decodeID(value); id != 0 {
+				ids[term] = id
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// postingLists returns the decoded posting list of each of termIDs.
+func (s *BadgerStore) postingLists(ctx context.Context, termIDs []int64) (map[int64][]posting, error) {
+	lists := make(map[int64][]posting, len(termIDs))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, termID := range termIDs {
+			if _, ok := lists[termID]; ok {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			list, err := badgerPostingList(txn, termID)
+			if err != nil {
+				return err
+			}
+			lists[termID] = list
+		}
+		return nil
+	})
+	return lists, err
+}
+
+func (s *BadgerStore) Postings(ctx context.Context, termIDs []int64) (map[int64][]query.Posting, error) {
+	lists, err := s.postingLists(ctx, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	postingsByTerm := make(map[int64][]query.Posting, len(lists))
+	for termID, list := range lists {
+		for _, p := range list {
+			postingsByTerm[termID] = append(postingsByTerm[termID], query.Posting{
+				DocID:     p.docID,
+				Positions: p.positions,
+				Fields:    p.fields,
+			})
+		}
+	}
+	return postingsByTerm, nil
+}
+
+// DocFrequencies counts the documents in each term's list that aren't
+// tombstoned; only while some are does it read the lists themselves.
+func (s *BadgerStore) DocFrequencies(ctx context.Context, termIDs []int64) (map[int64]int64, error) {
+	docFreqs := make(map[int64]int64, len(termIDs))
+	err := s.db.View(func(txn *badger.Txn) error {
+		deleted := make(map[int64]struct{})
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(badgerDeleted)})
+		for it.Rewind(); it.Valid(); it.Next() {
+			deleted[decodeID(it.Item().Key()[len(badgerDeleted):])] = struct{}{}
+		}
+		it.Close()
+
+		for _, termID := range termIDs {
+			value, err := badgerGet(txn, badgerKey(badgerFreqs, termID))
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				continue
+			}
+			docs, _ := decodeFrequency(value)
+			if len(deleted) > 0 {
+				list, err := badgerPostingList(txn, termID)
+				if err != nil {
+					return err
+				}
+				for _, p := range list {
+					if _, ok := deleted[p.docID]; ok {
+						docs--
+					}
+				}
+			}
+			docFreqs[termID] = docs
+		}
+		return nil
+	})
+	return docFreqs, err
+}
+
+func (s *BadgerStore) TermDocFrequencies(ctx context.Context, terms []string) (map[string]int64, error) {
+	ids, err := s.TermIDs(ctx, terms)
+	if err != nil {
+		return nil, err
+	}
+	termIDs := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		termIDs = append(termIDs, id)
+	}
+	docFreqs, err := s.DocFrequencies(ctx, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(ids))
+	for term, id := range ids {
+		if docFreq, ok := docFreqs[id]; ok {
+			counts[term] = docFreq
+		}
+	}
+	return counts, nil
+}
+
+func (s *BadgerStore) Vocabulary(ctx context.Context, n int) (map[string]int64, error) {
+	freqs, err := s.frequencies()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(freqs, func(a, b termFrequency) int { return cmp.Compare(b.docs, a.docs) })
+	freqs = freqs[:min(n, len(freqs))]
+	counts := make(map[string]int64, len(freqs))
+	err = s.db.View(func(txn *badger.Txn) error {
+		for _, f := range freqs {
+			term, err := badgerGet(txn, badgerKey(badgerTermNames, f.termID))
+			if err != nil {
+				return err
+			}
+			if term != nil {
+				counts[string(term)] = f.docs
+			}
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// groupCounts returns, for each document posted to termIDs, the number of
+// distinct groups of the terms it holds, counting only postings keep
+// accepts.
+func (s *BadgerStore) groupCounts(ctx context.Context, termIDs []int64, groups []int, keep func(p posting) bool) (map[int64]int, error) {
+	lists, err := s.postingLists(ctx, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]map[int]struct{})
+	for i, termID := range termIDs {
+		for _, p := range lists[termID] {
+			if !keep(p) {
+				continue
+			}
+			if seen[p.docID] == nil {
+				seen[p.docID] = make(map[int]struct{})
+			}
+			seen[p.docID][groups[i]] = struct{}{}
+		}
+	}
+	counts := make(map[int64]int, len(seen))
+	for docID, docGroups := range seen {
+		counts[docID] = len(docGroups)
+	}
+	return counts, nil
+}
+
+func (s *BadgerStore) MatchGroups(ctx context.Context, q query.GroupMatch) ([]int64, error) {
+	var within map[int64]struct{}
+	if q.Within != nil {
+		within = make(map[int64]struct{}, len(q.Within))
+		for _, id := range q.Within {
+			within[id] = struct{}{}
+		}
+	}
+	counts, err := s.groupCounts(ctx, q.TermIDs, q.Groups, func(p posting) bool {
+		if within == nil {
+			return true
+		}
+		_, ok := within[p.docID]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for docID, count := range counts {
+		if count >= q.Need {
+			ids = append(ids, docID)
+		}
+	}
+	slices.Sort(ids)
+	if q.Site == "" {
+		return ids, nil
+	}
+	return s.OnSite(ctx, ids, q.Site)
+}
+
+func (s *BadgerStore) InFields(ctx context.Context, termIDs []int64, groups []int, need int, fields int16) ([]int64, error) {
+	counts, err := s.groupCounts(ctx, termIDs, groups, func(p posting) bool { return p.fields&fields != 0 })
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for docID, count := range counts {
+		if count == need {
+			ids = append(ids, docID)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// documents calls fn with each of docIDs that is stored, live or not.
+func (s *BadgerStore) documents(docIDs []int64, fn func(doc *dumpDocument)) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		for _, id := range docIDs {
+			doc, err := badgerDocument(txn, id)
+			if err != nil {
+				return err
+			}
+			if doc != nil {
+				fn(doc)
+			}
+		}
+		return nil
+	})
+}
+
+// matchDocuments returns the documents, in id order, match accepts.
+func (s *BadgerStore) matchDocuments(match func(doc *dumpDocument) bool) ([]int64, error) {
+	var ids []int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		return scanDocuments(txn, func(doc *dumpDocument) bool {
+			if match(doc) {
+				ids = append(ids, doc.ID)
+			}
+			return true
+		})
+	})
+	return ids, err
+}
+
+func (s *BadgerStore) OnSite(ctx context.Context, docIDs []int64, domain string) ([]int64, error) {
+	var ids []int64
+	err := s.documents(docIDs, func(doc *dumpDocument) {
+		if doc.Domain != nil && query.OnDomain(*doc.Domain, domain) {
+			ids = append(ids, doc.ID)
+		}
+	})
+	return ids, err
+}
+
+func (s *BadgerStore) LinkingTo(ctx context.Context, host string) ([]int64, error) {
+	var ids []int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(badgerLinks), PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var hosts map[string]int
+			if err := it.Item().Value(func(data []byte) error { return json.Unmarshal(data, &hosts) }); err != nil {
+				continue
+			}
+			for linked := range hosts {
+				if query.OnDomain(linked, host) {
+					ids = append(ids, decodeID(it.Item().Key()[len(badgerLinks):]))
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func (s *BadgerStore) PublishedBetween(ctx context.Context, before, after *time.Time) ([]int64, error) {
+	return s.matchDocuments(func(doc *dumpDocument) bool {
+		return doc.PublishedAt != nil &&
+			(before == nil || doc.PublishedAt.Before(*before)) &&
+			(after == nil || !doc.PublishedAt.Before(*after))
+	})
+}
+
+func (s *BadgerStore) InLanguage(ctx context.Context, lang string) ([]int64, error) {
+	return s.matchDocuments(func(doc *dumpDocument) bool {
+		return doc.Language != nil && *doc.Language == lang
+	})
+}
+
+func (s *BadgerStore) URLContains(ctx context.Context, part string) ([]int64, error) {
+	return s.matchDocuments(func(doc *dumpDocument) bool {
+		return strings.Contains(strings.ToLower(doc.URL), part)
+	})
+}
+
+func (s *BadgerStore) DocumentLengths(ctx context.Context, docIDs []int64) ([]query.DocumentLength, error) {
+	lengths := make([]query.DocumentLength, 0, len(docIDs))
+	err := s.documents(docIDs, func(doc *dumpDocument) {
+		if doc.DeletedAt != nil {
+			return
+		}
+		lengths = append(lengths, query.DocumentLength{
+			DocID:           doc.ID,
+			TokenCount:      int(doc.TokenCount),
+			SourceQuality:   float64(doc.SourceQuality),
+			TitleTokenCount: int(doc.TitleTokenCount),
+			FieldLengths:    doc.FieldLengths,
+			PositionGap:     doc.PositionGap,
+			PublishedAt:     doc.PublishedAt,
+		})
+	})
+	return lengths, err
+}
+
+// documentPostings returns the postings of termIDs in each of docIDs that
+// keep accepts.
+func (s *BadgerStore) documentPostings(ctx context.Context, docIDs, termIDs []int64, keep func(p posting) bool) ([]query.DocumentPosting, error) {
+	lists, err := s.postingLists(ctx, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	var postings []query.DocumentPosting
+	for termID, list := range lists {
+		for _, docID := range docIDs {
+			i, found := slices.BinarySearchFunc(list, docID, func(p posting, id int64) int { return cmp.Compare(p.docID, id) })
+			if !found || !keep(list[i]) {
+				continue
+			}
+			postings = append(postings, query.DocumentPosting{
+				DocID:     docID,
+				TermID:    termID,
+				Positions: list[i].positions,
+				Offsets:   list[i].offsets,
+			})
+		}
+	}
+	return postings, nil
+}
+
+func (s *BadgerStore) Positions(ctx context.Context, docIDs, termIDs []int64) ([]query.DocumentPosting, error) {
+	return s.documentPostings(ctx, docIDs, termIDs, func(posting) bool { return true })
+}
+
+// Offsets leaves out postings without a recorded offset, as Postgres leaves
+// out those whose offsets are NULL.
+func (s *BadgerStore) Offsets(ctx context.Context, docIDs, termIDs []int64) ([]query.DocumentPosting, error) {
+	return s.documentPostings(ctx, docIDs, termIDs, func(p posting) bool {
+		return slices.ContainsFunc(p.offsets, func(offset int32) bool { return offset >= 0 })
+	})
+}
+
+func (s *BadgerStore) StoredFields(ctx context.Context, docIDs []int64, field string) (map[int64]string, error) {
+	bodies := make(map[int64]string, len(docIDs))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, docID := range docIDs {
+			text, err := badgerGet(txn, badgerFieldKey(docID, field))
+			if err != nil {
+				return err
+			}
+			if text != nil {
+				bodies[docID] = string(text)
+			}
+		}
+		return nil
+	})
+	return bodies, err
+}
+
+func (s *BadgerStore) Passages(ctx context.Context, docIDs []int64) (map[int64][]query.Passage, error) {
+	passagesByDoc := make(map[int64][]query.Passage, len(docIDs))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for _, docID := range docIDs {
+			data, err := badgerGet(txn, badgerKey(badgerPassages, docID))
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				continue
+			}
+			var passages []query.Passage
+			if err := json.Unmarshal(data, &passages); err != nil {
+				continue
+			}
+			passagesByDoc[docID] = passages
+		}
+		return nil
+	})
+	return passagesByDoc, err
+}
+
+// Documents returns the live documents among docIDs in the order given.
+func (s *BadgerStore) Documents(ctx context.Context, docIDs []int64) ([]query.DocumentDetail, error) {
+	docs := make([]query.DocumentDetail, 0, len(docIDs))
+	err := s.documents(docIDs, func(doc *dumpDocument) {
+		if doc.DeletedAt != nil {
+			return
+		}
+		docs = append(docs, query.DocumentDetail{
+			ID:            doc.ID,
+			URL:           doc.URL,
+			Title:         doc.Title,
+			Description:   doc.Description,
+			TokenCount:    int(doc.TokenCount),
+			SourceQuality: float64(doc.SourceQuality),
+		})
+	})
+	return docs, err
+}
@@ -3,62 +3,266 @@ package indexer
 import (
 	"context"
 	"fmt"
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
 	"github.com/amankumarsingh77/search_engine/models"
+	"sort"
 	"strings"
+	"time"
 )
 
 type BatchProcessor struct {
-	adapter *Storage
+	adapter  Store
+	analyzer *textproc.Analyzer
+	passages bool
+	gate     qualityGate
+	metrics  *indexerMetrics
 }
 
 type Batch struct {
-	docs    []*models.WebPage
-	termMap map[string]map[int][]int
+	docs     []*models.WebPage
+	termMap  map[string]map[int][]Occurrence
+	passages map[int][]Passage
+	bodies   map[int]string
+	// skipped counts the pages the quality gate kept out, by reason.
+	skipped map[string]int
 }
 
-func NewBatchProcessor(adapter *Storage) *BatchProcessor {
+// Occurrence is one position of a term in a document and the span of its
+// field's text the term was analyzed from.
+type Occurrence struct {
+	Pos  int
+	Span textproc.Span
+}
+
+// Passage is one paragraph of a document and the half-open range of token
+// positions it occupies in the document's postings.
+type Passage struct {
+	No    int
+	Start int
+	End   int
+	Text  string
+}
+
+// NewBatchProcessor indexes with analyzer, which must be the one the query
+// engine uses.
+func NewBatchProcessor(adapter Store, cfg *config.IndexerConfig, analyzer *textproc.Analyzer) *BatchProcessor {
 	return &BatchProcessor{
-		adapter: adapter,
+		adapter:  adapter,
+		analyzer: analyzer,
+		passages: cfg.IndexPassages,
+		gate:     newQualityGate(cfg),
+		metrics:  newIndexerMetrics(),
 	}
 }
 
+// ProcessBatch writes an analyzed batch, timing each stage.
 func (p *BatchProcessor) ProcessBatch(ctx context.Context, batch *Batch) error {
+	batchStart := time.Now()
+	start := batchStart
 	docIDs, err := p.adapter.InsertDocuments(ctx, batch.docs)
 	if err != nil {
 		return fmt.Errorf("failed to insert documents: %w", err)
 	}
+	p.metrics.observe(stageInsertDocuments, start)
+
+	start = time.Now()
+	if err = p.adapter.ReplaceDocumentLinks(ctx, docIDs, batch.docs); err != nil {
+		return fmt.Errorf("failed to store document links: %w", err)
+	}
+	p.metrics.observe(stageLinks, start)
+
+	start = time.Now()
+	if err = p.adapter.ReplaceAnchors(ctx, batch.docs); err != nil {
+		return fmt.Errorf("failed to store anchors: %w", err)
+	}
+	p.metrics.observe(stageAnchors, start)
 
 	terms := make([]string, 0, len(batch.termMap))
-	for term := range batch.termMap {
+	postings := 0
+	for term, docs := range batch.termMap {
 		terms = append(terms, term)
+		postings += len(docs)
 	}
 
+	start = time.Now()
 	termMap, err := p.adapter.UpsertTerms(ctx, terms)
 	if err != nil {
 		return fmt.Errorf("failed to upsert terms: %w", err)
 	}
+	p.metrics.observe(stageUpsertTerms, start)
 
-	if err = p.adapter.InsertPosting(ctx, termMap, docIDs, batch.termMap); err != nil {
+	start = time.Now()
+	if err = p.adapter.InsertPosting(ctx, termMap, docIDs, batch.docs, batch.termMap); err != nil {
 		return fmt.Errorf("failed to insert postings: %w", err)
 	}
+	p.metrics.observe(stageInsertPostings, start)
+
+	start = time.Now()
+	if err = p.adapter.ReplaceStoredBodies(ctx, docIDs, batch.bodies); err != nil {
+		return fmt.Errorf("failed to store bodies: %w", err)
+	}
+	p.metrics.observe(stageStoredBodies, start)
 
+	if p.passages {
+		start = time.Now()
+		if err = p.adapter.ReplaceDocumentPassages(ctx, docIDs, batch.passages); err != nil {
+			return fmt.Errorf("failed to store passages: %w", err)
+		}
+		p.metrics.observe(stagePassages, start)
+	}
+
+	p.metrics.observe(stageBatch, batchStart)
+	p.metrics.documents.WithLabelValues().Add(float64(len(batch.docs)))
+	p.metrics.terms.WithLabelValues().Add(float64(len(terms)))
+	p.metrics.postings.WithLabelValues().Add(float64(postings))
+	return nil
+}
+
+// LoadAnchors sets each document's AnchorText from the links other pages
+// make to it, for CreateBatch to index as the anchor field.
+func (p *BatchProcessor) LoadAnchors(ctx context.Context, docs []*models.WebPage) error {
+	urls := make([]string, len(docs))
+	for i, doc := range docs {
+		urls[i] = canonicalURL(doc.URL)
+	}
+	anchors, err := p.adapter.InboundAnchors(ctx, urls)
+	if err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		doc.AnchorText = anchors[urls[i]]
+	}
 	return nil
 }
 
+// CreateBatch analyzes docs into a batch, leaving out those with too few
+// tokens to pass the quality gate.
 func (p *BatchProcessor) CreateBatch(docs []*models.WebPage) *Batch {
 	docBatch := &Batch{
-		docs:    docs,
-		termMap: make(map[string]map[int][]int),
-	}
-	for docIdx, doc := range docs {
-		tokens := normalizePageContent(doc.Title + " " + doc.Description + " " + doc.BodyText + " " + strings.Join(doc.Paragraphs, " "))
-		docs[docIdx].TokenCount = len(tokens)
-		for pos, token := range tokens {
-			if docBatch.termMap[token] == nil {
-				docBatch.termMap[token] = make(map[int][]int)
+		docs:     make([]*models.WebPage, 0, len(docs)),
+		termMap:  make(map[string]map[int][]Occurrence),
+		passages: make(map[int][]Passage),
+		bodies:   make(map[int]string),
+		skipped:  make(map[string]int),
+	}
+	for _, doc := range docs {
+		docIdx := len(docBatch.docs)
+		// Each field is analyzed on its own and laid out in textproc.Fields
+		// order, title first, with textproc.FieldPositionGap positions left
+		// empty after each so phrases can't match across fields; with the
+		// field lengths stored, a position tells the query engine which
+		// field it is in. Terms expanded from a token (synonyms, edge
+		// n-grams) share its position, so counts and passage ranges are in
+		// positions.
+		// Spans are byte offsets into the field's text, which for the body
+		// is stored so snippets can highlight exactly what matched.
+		var tokens [][]string
+		var spans []textproc.Span
+		var positions []int
+		var body string
+		var passages []Passage
+		lengths := make([]int32, len(textproc.Fields))
+		next := 0
+		for i, field := range textproc.Fields {
+			start := next
+			text := fieldText(doc, field)
+			fieldTokens, fieldSpans := p.analyzer.AnalyzeFieldSpans(field, text)
+			tokens = append(tokens, fieldTokens...)
+			spans = append(spans, fieldSpans...)
+			for range fieldTokens {
+				positions = append(positions, next)
+				next++
+			}
+			lengths[i] = int32(len(fieldTokens))
+			next += textproc.FieldPositionGap
+			if field != textproc.FieldBody {
+				continue
+			}
+			body = text
+			if p.passages {
+				passages = paragraphPassages(doc, start, fieldSpans)
+			}
+		}
+		if reason := p.gate.rejectTokens(len(tokens)); reason != "" {
+			docBatch.skipped[reason]++
+			continue
+		}
+		docBatch.docs = append(docBatch.docs, doc)
+		docBatch.bodies[docIdx] = body
+		if p.passages {
+			docBatch.passages[docIdx] = passages
+		}
+		doc.FieldLengths = lengths
+		doc.PositionGap = textproc.FieldPositionGap
+		doc.TitleTokenCount = int(lengths[0])
+		doc.TokenCount = len(tokens)
+		for i, terms := range tokens {
+			for _, term := range terms {
+				if docBatch.termMap[term] == nil {
+					docBatch.termMap[term] = make(map[int][]Occurrence)
+				}
+				docBatch.termMap[term][docIdx] = append(docBatch.termMap[term][docIdx], Occurrence{Pos: positions[i], Span: spans[i]})
 			}
-			docBatch.termMap[token][docIdx] = append(docBatch.termMap[token][docIdx], pos)
 		}
 	}
 	return docBatch
 }
+
+// fieldText returns the text of one of textproc.Fields, cleaned of invalid
+// UTF-8 so spans into it stay valid once it is stored.
+func fieldText(doc *models.WebPage, field string) string {
+	return textproc.RemoveInvalidUTF8(rawFieldText(doc, field))
+}
+
+func rawFieldText(doc *models.WebPage, field string) string {
+	switch field {
+	case textproc.FieldTitle:
+		return doc.Title
+	case textproc.FieldDescription:
+		return doc.Description
+	case textproc.FieldKeywords:
+		// Keywords are separate phrases; the comma keeps a stray
+		// tokenizer from gluing the last word of one to the next.
+		return strings.Join(doc.Keywords, ", ")
+	case textproc.FieldHeadings:
+		levels := make([]string, 0, len(doc.Headings))
+		for level := range doc.Headings {
+			levels = append(levels, level)
+		}
+		sort.Strings(levels)
+		var headings []string
+		for _, level := range levels {
+			headings = append(headings, doc.Headings[level]...)
+		}
+		return strings.Join(headings, ". ")
+	case textproc.FieldAnchor:
+		return strings.Join(doc.AnchorText, ". ")
+	default:
+		return doc.BodyText + " " + strings.Join(doc.Paragraphs, " ")
+	}
+}
+
+// paragraphPassages finds the position range of each paragraph in the body,
+// whose text is the body text followed by the paragraphs, space separated.
+// bodyStart is the body's first position and spans its positions' spans.
+func paragraphPassages(doc *models.WebPage, bodyStart int, spans []textproc.Span) []Passage {
+	var passages []Passage
+	offset := int32(len(textproc.RemoveInvalidUTF8(doc.BodyText)))
+	next := 0
+	for no, para := range doc.Paragraphs {
+		begin := offset + 1
+		offset = begin + int32(len(textproc.RemoveInvalidUTF8(para)))
+		for next < len(spans) && spans[next].Start < begin {
+			next++
+		}
+		first := next
+		for next < len(spans) && spans[next].Start < offset {
+			next++
+		}
+		if next > first {
+			passages = append(passages, Passage{No: no, Start: bodyStart + first, End: bodyStart + next, Text: para})
+		}
+	}
+	return passages
+}
@@ -0,0 +1,85 @@
+package indexer
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CheckpointFunc persists the id of the last crawled page such that it and
+// every page before it are committed to the index.
+type CheckpointFunc func(ctx context.Context, id primitive.ObjectID) error
+
+// checkpointTracker turns out-of-order batch commits from parallel workers
+// into a safe resume point. Pages are registered in the order they are read,
+// which is id order, and the checkpoint only moves past a page once it and
+// every earlier page are committed; a restart may re-index some pages but
+// never skips one.
+type checkpointTracker struct {
+	mu      sync.Mutex
+	save    CheckpointFunc
+	pending []primitive.ObjectID
+	done    map[primitive.ObjectID]bool
+	last    primitive.ObjectID
+	saved   primitive.ObjectID
+}
+
+func newCheckpointTracker(save CheckpointFunc) *checkpointTracker {
+	return &checkpointTracker{save: save, done: make(map[primitive.ObjectID]bool)}
+}
+
+// add registers a page about to be queued.
+func (t *checkpointTracker) add(id primitive.ObjectID) {
+	if id.IsZero() {
+		return
+	}
+	t.mu.Lock()
+	t.pending = append(t.pending, id)
+	t.mu.Unlock()
+}
+
+// remove forgets a page that could not be queued after all.
+func (t *checkpointTracker) remove(id primitive.ObjectID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.pending) - 1; i >= 0; i-- {
+		if t.pending[i] == id {
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// complete marks pages as committed and saves the new checkpoint if it
+// moved. Saving under the lock keeps an older checkpoint from overwriting a
+// newer one; a failed save is retried with the next commit.
+func (t *checkpointTracker) complete(ids []primitive.ObjectID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range ids {
+		if !id.IsZero() {
+			t.done[id] = true
+		}
+	}
+	n := 0
+	for n < len(t.pending) && t.done[t.pending[n]] {
+		delete(t.done, t.pending[n])
+		t.last = t.pending[n]
+		n++
+	}
+	t.pending = t.pending[n:]
+	if t.last == t.saved {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := t.save(ctx, t.last); err != nil {
+		log.Printf("failed to save indexer checkpoint %s: %v", t.last.Hex(), err)
+		return
+	}
+	t.saved = t.last
+}
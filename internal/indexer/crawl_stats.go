@@ -0,0 +1,191 @@
+package indexer
+
+import (
+	"encoding/csv"
+	"hash/fnv"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+// tokenBuckets are the upper bounds of the token count histogram; the last
+// bucket takes everything above them.
+var tokenBuckets = []int{0, 100, 500, 1000, 5000}
+
+// CrawlStats summarizes a crawl's pages so seed lists can be judged before
+// anything is indexed. A page is an error when the crawler recorded an error
+// or the final status was 4xx/5xx; it is a duplicate when its analyzed body
+// is identical to a page seen earlier in the scan.
+type CrawlStats struct {
+	Pages          int            `json:"pages"`
+	Errors         int            `json:"errors"`
+	ErrorRate      float64        `json:"error_rate"`
+	AvgFetchMs     float64        `json:"avg_fetch_ms"`
+	Duplicates     int            `json:"duplicates"`
+	DuplicateRatio float64        `json:"duplicate_ratio"`
+	AvgTokens      float64        `json:"avg_tokens"`
+	TokenBuckets   []TokenBucket  `json:"token_buckets"`
+	Domains        []*DomainStats `json:"domains"`
+
+	domains    map[string]*DomainStats
+	bodies     map[uint64]struct{}
+	fetched    int
+	fetchMs    int64
+	tokens     int64
+	bucketHits []int
+}
+
+type DomainStats struct {
+	Domain         string  `json:"domain"`
+	Pages          int     `json:"pages"`
+	Errors         int     `json:"errors"`
+	ErrorRate      float64 `json:"error_rate"`
+	AvgFetchMs     float64 `json:"avg_fetch_ms"`
+	Duplicates     int     `json:"duplicates"`
+	DuplicateRatio float64 `json:"duplicate_ratio"`
+	AvgTokens      float64 `json:"avg_tokens"`
+
+	fetched int
+	fetchMs int64
+	tokens  int64
+}
+
+// TokenBucket counts pages whose token count falls in [Min, Max]. Max is -1
+// for the open-ended last bucket.
+type TokenBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Pages int `json:"pages"`
+}
+
+func NewCrawlStats() *CrawlStats {
+	return &CrawlStats{
+		domains:    make(map[string]*DomainStats),
+		bodies:     make(map[uint64]struct{}),
+		bucketHits: make([]int, len(tokenBuckets)+1),
+	}
+}
+
+// Add counts one crawled page.
+func (s *CrawlStats) Add(page *models.WebPage) {
+	domain := "(unknown)"
+	if u, err := url.Parse(page.URL); err == nil && u.Hostname() != "" {
+		domain = strings.ToLower(strings.TrimPrefix(u.Hostname(), "www."))
+	}
+	d := s.domains[domain]
+	if d == nil {
+		d = &DomainStats{Domain: domain}
+		s.domains[domain] = d
+	}
+	s.Pages++
+	d.Pages++
+
+	if page.ErrorString != "" || page.IsErrorStatus() {
+		s.Errors++
+		d.Errors++
+	}
+	if page.Fetch != nil && page.Fetch.DurationMs > 0 {
+		s.fetched++
+		s.fetchMs += page.Fetch.DurationMs
+		d.fetched++
+		d.fetchMs += page.Fetch.DurationMs
+	}
+
+	words := textproc.Tokenize(textproc.Normalize(page.Title + " " + page.Description + " " + page.BodyText + " " + strings.Join(page.Paragraphs, " ")))
+	s.tokens += int64(len(words))
+	d.tokens += int64(len(words))
+	bucket := sort.SearchInts(tokenBuckets, len(words))
+	s.bucketHits[bucket]++
+
+	// Pages without any body text are errors or redirects, not duplicates.
+	if len(words) > 0 {
+		h := fnv.New64a()
+		for _, w := range words {
+			h.Write([]byte(w))
+			h.Write([]byte{' '})
+		}
+		sum := h.Sum64()
+		if _, seen := s.bodies[sum]; seen {
+			s.Duplicates++
+			d.Duplicates++
+		} else {
+			s.bodies[sum] = struct{}{}
+		}
+	}
+}
+
+// Finish computes the averages and ratios and orders domains by page count.
+func (s *CrawlStats) Finish() {
+	s.ErrorRate, s.DuplicateRatio = ratio(s.Errors, s.Pages), ratio(s.Duplicates, s.Pages)
+	s.AvgFetchMs = ratio64(s.fetchMs, s.fetched)
+	s.AvgTokens = ratio64(s.tokens, s.Pages)
+
+	s.TokenBuckets = s.TokenBuckets[:0]
+	min := 0
+	for i, hits := range s.bucketHits {
+		max := -1
+		if i < len(tokenBuckets) {
+			max = tokenBuckets[i]
+		}
+		s.TokenBuckets = append(s.TokenBuckets, TokenBucket{Min: min, Max: max, Pages: hits})
+		min = max + 1
+	}
+
+	s.Domains = s.Domains[:0]
+	for _, d := range s.domains {
+		d.ErrorRate, d.DuplicateRatio = ratio(d.Errors, d.Pages), ratio(d.Duplicates, d.Pages)
+		d.AvgFetchMs = ratio64(d.fetchMs, d.fetched)
+		d.AvgTokens = ratio64(d.tokens, d.Pages)
+		s.Domains = append(s.Domains, d)
+	}
+	sort.Slice(s.Domains, func(i, j int) bool {
+		if s.Domains[i].Pages != s.Domains[j].Pages {
+			return s.Domains[i].Pages > s.Domains[j].Pages
+		}
+		return s.Domains[i].Domain < s.Domains[j].Domain
+	})
+}
+
+// WriteCSV writes one row per domain followed by a total row. The token
+// histogram only appears in the JSON report.
+func (s *CrawlStats) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"domain", "pages", "errors", "error_rate", "avg_fetch_ms", "duplicates", "duplicate_ratio", "avg_tokens"})
+	row := func(domain string, pages, errors int, errorRate, fetchMs float64, dups int, dupRatio, tokens float64) {
+		cw.Write([]string{
+			domain,
+			strconv.Itoa(pages),
+			strconv.Itoa(errors),
+			strconv.FormatFloat(errorRate, 'f', 4, 64),
+			strconv.FormatFloat(fetchMs, 'f', 1, 64),
+			strconv.Itoa(dups),
+			strconv.FormatFloat(dupRatio, 'f', 4, 64),
+			strconv.FormatFloat(tokens, 'f', 1, 64),
+		})
+	}
+	for _, d := range s.Domains {
+		row(d.Domain, d.Pages, d.Errors, d.ErrorRate, d.AvgFetchMs, d.Duplicates, d.DuplicateRatio, d.AvgTokens)
+	}
+	row("(total)", s.Pages, s.Errors, s.ErrorRate, s.AvgFetchMs, s.Duplicates, s.DuplicateRatio, s.AvgTokens)
+	cw.Flush()
+	return cw.Error()
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+func ratio64(n int64, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
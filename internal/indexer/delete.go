@@ -0,0 +1,136 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDeleteBatchSize = 1000
+	defaultPurgeBatchSize  = 500
+	defaultPurgeInterval   = time.Minute
+)
+
+// DeleteDocuments tombstones the documents with the given URLs or ids:
+// search skips them from then on and their share leaves the index stats.
+// Their rows in documents, postings, links, passages and stored fields stay
+// until PurgeDeletedDocuments removes them; re-indexing a URL first brings
+// its document back. URLs are matched in their canonical form, so any
+// http/https or www variant of an indexed page deletes it. Unknown and
+// already deleted URLs and ids are ignored. It returns the ids of the
+// documents deleted so callers can invalidate cached results that contain
+// them.
+func (s *Storage) DeleteDocuments(ctx context.Context, urls []string, ids []int64) ([]int64, error) {
+	canonical := make([]string, len(urls))
+	for i, u := range urls {
+		canonical[i] = canonicalURL(u)
+	}
+	urls = canonical
+	var deleted []int64
+	for len(urls) > 0 || len(ids) > 0 {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		urlBatch := urls[:min(len(urls), defaultDeleteBatchSize)]
+		idBatch := ids[:min(len(ids), defaultDeleteBatchSize-len(urlBatch))]
+		urls, ids = urls[len(urlBatch):], ids[len(idBatch):]
+
+		removed, err := s.deleteDocumentBatch(ctx, urlBatch, idBatch)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, removed...)
+	}
+	return deleted, nil
+}
+
+func (s *Storage) deleteDocumentBatch(ctx context.Context, urls []string, ids []int64) ([]int64, error) {
+	if urls == nil {
+		urls = []string{}
+	}
+	if ids == nil {
+		ids = []int64{}
+	}
+	rows, err := s.pool.Query(ctx, tombstoneDocuments, urls, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	defer rows.Close()
+	var deleted []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read deleted document: %w", err)
+		}
+		deleted = append(deleted, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	return deleted, nil
+}
+
+// PurgeDeletedDocuments removes up to limit tombstoned documents and their
+// dependent rows, oldest deletions first, and returns how many it removed.
+func (s *Storage) PurgeDeletedDocuments(ctx context.Context, limit int) (int64, error) {
+	if limit <= 0 {
+		limit = defaultPurgeBatchSize
+	}
+	var purged int64
+	if err := s.pool.QueryRow(ctx, purgeDocuments, limit).Scan(&purged); err != nil {
+		return 0, fmt.Errorf("failed to purge deleted documents: %w", err)
+	}
+	return purged, nil
+}
+
+// ReadURLList reads a deletion list: one URL per line, with blank lines and
+// lines starting with # skipped.
+func ReadURLList(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read url list: %w", err)
+	}
+	return urls, nil
+}
+
+// InvalidateSearchCaches tells the search API at searchURL that docIDs were
+// removed, so it stops serving them from its caches. The search API answers
+// from its caches for a few minutes otherwise.
+func InvalidateSearchCaches(ctx context.Context, searchURL, apiKey string, docIDs []int64) error {
+	body, err := json.Marshal(map[string][]int64{"doc_ids": docIDs})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(searchURL, "/")+"/admin/invalidate", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build invalidation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate search caches: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to invalidate search caches: %s", resp.Status)
+	}
+	return nil
+}
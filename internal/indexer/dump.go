@@ -0,0 +1,338 @@
+package indexer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// dumpFormatVersion is written to every dump; Import refuses other versions.
+const dumpFormatVersion = 1
+
+// importBatchSize is how many rows Import buffers per table before copying
+// them in.
+const importBatchSize = 10000
+
+// A dump is gzip-compressed JSON lines: a header, then one record per row of
+// documents, terms, postings, stored fields, links, passages and anchors, in that
+// order. Ids are kept, so postings still point at the right documents and
+// terms after an import.
+type dumpHeader struct {
+	Type          string    `json:"type"`
+	FormatVersion int       `json:"format_version"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type dumpDocument struct {
+	Type              string     `json:"type"`
+	ID                int64      `json:"id"`
+	URL               string     `json:"url"`
+	Title             string     `json:"title"`
+	Description       string     `json:"description"`
+	TokenCount        int32      `json:"token_count"`
+	ExternalLinkCount int32      `json:"external_link_count"`
+	SourceQuality     float32    `json:"source_quality"`
+	TitleTokenCount   int32      `json:"title_token_count"`
+	FieldLengths      []int32    `json:"field_lengths,omitempty"`
+	Language          *string    `json:"language,omitempty"`
+	PublishedAt       *time.Time `json:"published_at,omitempty"`
+	Domain            *string    `json:"domain,omitempty"`
+	DeletedAt         *time.Time `json:"deleted_at,omitempty"`
+	IndexedAt         time.Time  `json:"indexed_at"`
+	PositionGap       int32      `json:"position_gap,omitempty"`
+}
+
+type dumpTerm struct {
+	Type      string    `json:"type"`
+	ID        int64     `json:"id"`
+	Term      string    `json:"term"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type dumpPosting struct {
+	Type      string  `json:"type"`
+	TermID    int64   `json:"term_id"`
+	DocID     int64   `json:"doc_id"`
+	Positions []int32 `json:"positions"`
+	Offsets   []int32 `json:"offsets,omitempty"`
+	Fields    int16   `json:"fields"`
+	Frequency int32   `json:"frequency"`
+}
+
+type dumpStoredField struct {
+	Type  string `json:"type"`
+	DocID int64  `json:"doc_id"`
+	Field string `json:"field"`
+	Body  string `json:"body"`
+}
+
+type dumpLink struct {
+	Type      string `json:"type"`
+	DocID     int64  `json:"doc_id"`
+	Host      string `json:"host"`
+	LinkCount int32  `json:"link_count"`
+}
+
+type dumpPassage struct {
+	Type        string `json:"type"`
+	DocID       int64  `json:"doc_id"`
+	ParagraphNo int32  `json:"paragraph_no"`
+	StartPos    int32  `json:"start_pos"`
+	EndPos      int32  `json:"end_pos"`
+	Body        string `json:"body"`
+}
+
+type dumpAnchor struct {
+	Type      string `json:"type"`
+	SourceURL string `json:"source_url"`
+	TargetURL string `json:"target_url"`
+	Text      string `json:"text"`
+}
+
+// dumpTable maps a record type to its table, export query and the columns
+// its rows are copied into.
+type dumpTable struct {
+	record  string
+	table   string
+	export  string
+	columns []string
+}
+
+var dumpTables = []dumpTable{
+	{"document", "documents", exportDocuments, []string{"id", "url", "title", "description", "token_count", "external_link_count", "source_quality", "title_token_count", "field_lengths", "language", "published_at", "domain", "deleted_at", "indexed_at", "position_gap"}},
+	{"term", "terms", exportTerms, []string{"id", "term", "created_at"}},
+	{"posting", "postings", exportPostings, []string{"term_id", "doc_id", "positions", "offsets", "fields", "frequency"}},
+	{"stored_field", "stored_fields", exportStoredFields, []string{"doc_id", "field", "body"}},
+	{"link", "document_links", exportDocumentLinks, []string{"doc_id", "host", "link_count"}},
+	{"passage", "document_passages", exportDocumentPassages, []string{"doc_id", "paragraph_no", "start_pos", "end_pos", "body"}},
+	{"anchor", "document_anchors", exportDocumentAnchors, []string{"source_url", "target_url", "text"}},
+}
+
+// DumpCounts is the number of records of each type written or read.
+type DumpCounts map[string]int64
+
+// Export writes the whole index to w as a compressed dump. All tables are
+// read in one repeatable-read transaction, so the dump is consistent even
+// while the indexer runs.
+func (s *Storage) Export(ctx context.Context, w io.Writer) (DumpCounts, error) {
+	migrations, err := s.Migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := dumpHeader{Type: "header", FormatVersion: dumpFormatVersion, CreatedAt: time.Now().UTC()}
+	for _, m := range migrations {
+		if !m.AppliedAt.IsZero() {
+			header.SchemaVersion = m.Version
+		}
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("failed to write dump: %w", err)
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start export: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	counts := make(DumpCounts, len(dumpTables))
+	for _, t := range dumpTables {
+		n, err := exportTable(ctx, tx, t, enc)
+		counts[t.record] = n
+		if err != nil {
+			return counts, fmt.Errorf("failed to export %s: %w", t.table, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return counts, fmt.Errorf("failed to write dump: %w", err)
+	}
+	return counts, nil
+}
+
+func exportTable(ctx context.Context, tx pgx.Tx, t dumpTable, enc *json.Encoder) (int64, error) {
+	rows, err := tx.Query(ctx, t.export)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		var record any
+		switch t.record {
+		case "document":
+			d := dumpDocument{Type: t.record}
+			err = rows.Scan(&d.ID, &d.URL, &d.Title, &d.Description, &d.TokenCount, &d.ExternalLinkCount, &d.SourceQuality, &d.TitleTokenCount, &d.FieldLengths, &d.Language, &d.PublishedAt, &d.Domain, &d.DeletedAt, &d.IndexedAt, &d.PositionGap)
+			record = d
+		case "term":
+			d := dumpTerm{Type: t.record}
+			err = rows.Scan(&d.ID, &d.Term, &d.CreatedAt)
+			record = d
+		case "posting":
+			d := dumpPosting{Type: t.record}
+			err = rows.Scan(&d.TermID, &d.DocID, &d.Positions, &d.Offsets, &d.Fields, &d.Frequency)
+			record = d
+		case "stored_field":
+			d := dumpStoredField{Type: t.record}
+			err = rows.Scan(&d.DocID, &d.Field, &d.Body)
+			record = d
+		case "link":
+			d := dumpLink{Type: t.record}
+			err = rows.Scan(&d.DocID, &d.Host, &d.LinkCount)
+			record = d
+		case "passage":
+			d := dumpPassage{Type: t.record}
+			err = rows.Scan(&d.DocID, &d.ParagraphNo, &d.StartPos, &d.EndPos, &d.Body)
+			record = d
+		case "anchor":
+			d := dumpAnchor{Type: t.record}
+			err = rows.Scan(&d.SourceURL, &d.TargetURL, &d.Text)
+			record = d
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// Import loads a dump written by Export into an empty index, keeping its
+// ids, then moves the id sequences past them and recomputes the index stats
+// and term frequencies.
+func (s *Storage) Import(ctx context.Context, r io.Reader) (DumpCounts, error) {
+	var docs int64
+	if err := s.pool.QueryRow(ctx, countDocuments).Scan(&docs); err != nil {
+		return nil, fmt.Errorf("failed to check index is empty: %w", err)
+	}
+	if docs > 0 {
+		return nil, fmt.Errorf("import needs an empty index, this one has %d documents", docs)
+	}
+
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dump: %w", err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+
+	var header dumpHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to read dump header: %w", err)
+	}
+	if header.Type != "header" || header.FormatVersion != dumpFormatVersion {
+		return nil, fmt.Errorf("unsupported dump format %d, expected %d", header.FormatVersion, dumpFormatVersion)
+	}
+
+	tables := make(map[string]dumpTable, len(dumpTables))
+	for _, t := range dumpTables {
+		tables[t.record] = t
+	}
+	pending := make(map[string][][]any, len(dumpTables))
+	counts := make(DumpCounts, len(dumpTables))
+	flush := func(record string) error {
+		t := tables[record]
+		if len(pending[record]) == 0 {
+			return nil
+		}
+		if _, err := s.pool.CopyFrom(ctx, pgx.Identifier{t.table}, t.columns, pgx.CopyFromRows(pending[record])); err != nil {
+			return fmt.Errorf("failed to import %s: %w", t.table, err)
+		}
+		counts[record] += int64(len(pending[record]))
+		pending[record] = pending[record][:0]
+		return nil
+	}
+
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return counts, fmt.Errorf("failed to read dump: %w", err)
+		}
+		row, record, err := decodeDumpRecord(raw)
+		if err != nil {
+			return counts, err
+		}
+		if _, ok := tables[record]; !ok {
+			return counts, fmt.Errorf("unknown dump record type %q", record)
+		}
+		pending[record] = append(pending[record], row)
+		if len(pending[record]) >= importBatchSize {
+			if err := flush(record); err != nil {
+				return counts, err
+			}
+		}
+	}
+	for _, t := range dumpTables {
+		if err := flush(t.record); err != nil {
+			return counts, err
+		}
+	}
+
+	for _, stmt := range []string{resetDocumentIDs, resetTermIDs, recomputeIndexStats, refreshTermFrequencyView} {
+		if _, err := s.pool.Exec(ctx, stmt); err != nil {
+			return counts, fmt.Errorf("failed to finish import: %w", err)
+		}
+	}
+	return counts, nil
+}
+
+// decodeDumpRecord turns one dump line into the row to copy and its type.
+func decodeDumpRecord(raw json.RawMessage) ([]any, string, error) {
+	var kind struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &kind); err != nil {
+		return nil, "", fmt.Errorf("failed to read dump record: %w", err)
+	}
+	var row []any
+	var err error
+	switch kind.Type {
+	case "document":
+		var d dumpDocument
+		err = json.Unmarshal(raw, &d)
+		row = []any{d.ID, d.URL, d.Title, d.Description, d.TokenCount, d.ExternalLinkCount, d.SourceQuality, d.TitleTokenCount, d.FieldLengths, d.Language, d.PublishedAt, d.Domain, d.DeletedAt, d.IndexedAt, d.PositionGap}
+	case "term":
+		var d dumpTerm
+		err = json.Unmarshal(raw, &d)
+		row = []any{d.ID, d.Term, d.CreatedAt}
+	case "posting":
+		var d dumpPosting
+		err = json.Unmarshal(raw, &d)
+		row = []any{d.TermID, d.DocID, d.Positions, d.Offsets, d.Fields, d.Frequency}
+	case "stored_field":
+		var d dumpStoredField
+		err = json.Unmarshal(raw, &d)
+		row = []any{d.DocID, d.Field, d.Body}
+	case "link":
+		var d dumpLink
+		err = json.Unmarshal(raw, &d)
+		row = []any{d.DocID, d.Host, d.LinkCount}
+	case "passage":
+		var d dumpPassage
+		err = json.Unmarshal(raw, &d)
+		row = []any{d.DocID, d.ParagraphNo, d.StartPos, d.EndPos, d.Body}
+	case "anchor":
+		var d dumpAnchor
+		err = json.Unmarshal(raw, &d)
+		row = []any{d.SourceURL, d.TargetURL, d.Text}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s record: %w", kind.Type, err)
+	}
+	return row, kind.Type, nil
+}
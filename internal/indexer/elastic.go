@@ -0,0 +1,287 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+const (
+	defaultElasticIndex     = "searchyfy"
+	defaultElasticBatchSize = 500
+	elasticRequestTimeout   = 2 * time.Minute
+)
+
+// ElasticExporter streams indexed pages into an Elasticsearch or OpenSearch
+// index through the bulk API. Pages are taken from the crawl, so every field
+// the indexer analyzes is exported as text, and matched by URL to their live
+// document in the index for its id and metadata. Pages that aren't indexed
+// are skipped.
+type ElasticExporter struct {
+	storage   *Storage
+	client    *http.Client
+	baseURL   string
+	index     string
+	username  string
+	password  string
+	apiKey    string
+	batchSize int
+	pending   []*models.WebPage
+
+	// Exported and Skipped count the pages sent and the pages left out
+	// because they aren't in the index.
+	Exported int64
+	Skipped  int64
+}
+
+func NewElasticExporter(storage *Storage, cfg *config.ElasticConfig) (*ElasticExporter, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("Elastic.URL is not set")
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid Elastic.URL: %w", err)
+	}
+	index := defaultElasticIndex
+	if cfg.Index != "" {
+		index = cfg.Index
+	}
+	batchSize := defaultElasticBatchSize
+	if cfg.BatchSize > 0 {
+		batchSize = cfg.BatchSize
+	}
+	return &ElasticExporter{
+		storage:   storage,
+		client:    &http.Client{Timeout: elasticRequestTimeout},
+		baseURL:   strings.TrimRight(cfg.URL, "/"),
+		index:     index,
+		username:  cfg.Username,
+		password:  cfg.Password,
+		apiKey:    cfg.APIKey,
+		batchSize: batchSize,
+	}, nil
+}
+
+// ElasticMapping generates the index mapping: one text field per indexed
+// field, keywords for the URL, domain and language, and the dates and
+// source quality for filtering and ranking experiments. Elasticsearch
+// dropped index-time boosts, so the search engine's field boosts are kept
+// in _meta for queries to apply.
+func ElasticMapping(boosts map[string]float64) map[string]any {
+	properties := map[string]any{
+		"doc_id":         map[string]any{"type": "long"},
+		"url":            map[string]any{"type": "keyword"},
+		"domain":         map[string]any{"type": "keyword"},
+		"language":       map[string]any{"type": "keyword"},
+		"published_at":   map[string]any{"type": "date"},
+		"indexed_at":     map[string]any{"type": "date"},
+		"source_quality": map[string]any{"type": "float"},
+	}
+	for _, field := range textproc.Fields {
+		properties[field] = map[string]any{"type": "text"}
+	}
+	return map[string]any{
+		"mappings": map[string]any{
+			"dynamic":    "strict",
+			"_meta":      map[string]any{"field_boosts": boosts},
+			"properties": properties,
+		},
+	}
+}
+
+// CreateIndex creates the index with ElasticMapping. An index that already
+// exists is left as it is.
+func (e *ElasticExporter) CreateIndex(ctx context.Context, boosts map[string]float64) error {
+	body, err := json.Marshal(ElasticMapping(boosts))
+	if err != nil {
+		return err
+	}
+	resp, err := e.do(ctx, http.MethodPut, "/"+url.PathEscape(e.index), "application/json", body)
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %w", e.index, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusBadRequest && bytes.Contains(msg, []byte("resource_already_exists_exception")) {
+		return nil
+	}
+	return fmt.Errorf("failed to create index %s: %s: %s", e.index, resp.Status, msg)
+}
+
+// Add queues a page, sending the queue once it holds a full batch.
+func (e *ElasticExporter) Add(ctx context.Context, page *models.WebPage) error {
+	if page.NoIndex || page.IsErrorStatus() {
+		e.Skipped++
+		return nil
+	}
+	e.pending = append(e.pending, page)
+	if len(e.pending) < e.batchSize {
+		return nil
+	}
+	return e.Flush(ctx)
+}
+
+// Flush sends the queued pages.
+func (e *ElasticExporter) Flush(ctx context.Context) error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	pages := e.pending
+	e.pending = nil
+
+	urls := make([]string, len(pages))
+	for i, page := range pages {
+		urls[i] = canonicalURL(page.URL)
+	}
+	docs, err := e.storage.liveDocuments(ctx, urls)
+	if err != nil {
+		return err
+	}
+	anchors, err := e.storage.InboundAnchors(ctx, urls)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	var sent int64
+	for i, page := range pages {
+		doc, ok := docs[urls[i]]
+		if !ok {
+			e.Skipped++
+			continue
+		}
+		page.AnchorText = anchors[urls[i]]
+		source := map[string]any{
+			"doc_id":         doc.id,
+			"url":            urls[i],
+			"source_quality": doc.sourceQuality,
+			"indexed_at":     doc.indexedAt,
+		}
+		if doc.domain != nil {
+			source["domain"] = *doc.domain
+		}
+		if doc.language != nil {
+			source["language"] = *doc.language
+		}
+		if doc.publishedAt != nil {
+			source["published_at"] = *doc.publishedAt
+		}
+		for _, field := range textproc.Fields {
+			if text := fieldText(page, field); text != "" {
+				source[field] = text
+			}
+		}
+		action := map[string]any{"index": map[string]any{"_index": e.index, "_id": strconv.FormatInt(doc.id, 10)}}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		if err := enc.Encode(source); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", urls[i], err)
+		}
+		sent++
+	}
+	if sent == 0 {
+		return nil
+	}
+
+	resp, err := e.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return fmt.Errorf("bulk request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("bulk request failed: %s: %s", resp.Status, msg)
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read bulk response: %w", err)
+	}
+	if result.Errors {
+		failed := 0
+		var first string
+		for _, item := range result.Items {
+			for _, r := range item {
+				if r.Status >= 300 {
+					if failed == 0 {
+						first = fmt.Sprintf("document %s: %s", r.ID, r.Error)
+					}
+					failed++
+				}
+			}
+		}
+		e.Exported += sent - int64(failed)
+		return fmt.Errorf("%d of %d documents were rejected, first %s", failed, sent, first)
+	}
+	e.Exported += sent
+	return nil
+}
+
+func (e *ElasticExporter) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case e.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	case e.username != "":
+		req.SetBasicAuth(e.username, e.password)
+	}
+	return e.client.Do(req)
+}
+
+// liveDocument is the part of a document row exported alongside its text.
+type liveDocument struct {
+	id            int64
+	sourceQuality float64
+	language      *string
+	publishedAt   *time.Time
+	domain        *string
+	indexedAt     time.Time
+}
+
+// liveDocuments returns the documents that aren't deleted among urls, by URL.
+func (s *Storage) liveDocuments(ctx context.Context, urls []string) (map[string]liveDocument, error) {
+	rows, err := s.pool.Query(ctx, getLiveDocumentsByURL, urls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch documents: %w", err)
+	}
+	defer rows.Close()
+	docs := make(map[string]liveDocument, len(urls))
+	for rows.Next() {
+		var url string
+		var d liveDocument
+		if err := rows.Scan(&d.id, &url, &d.sourceQuality, &d.language, &d.publishedAt, &d.domain, &d.indexedAt); err != nil {
+			return nil, fmt.Errorf("failed to read document: %w", err)
+		}
+		docs[url] = d
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch documents: %w", err)
+	}
+	return docs, nil
+}
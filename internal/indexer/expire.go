@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+)
+
+const (
+	defaultExpiryInterval  = 10 * time.Minute
+	defaultExpireBatchSize = 500
+)
+
+// ExpiryRules sets how long documents stay searchable after they were last
+// indexed: TTL, or for a domain in Domains and its subdomains that domain's
+// TTL. A zero TTL never expires.
+type ExpiryRules struct {
+	TTL     time.Duration
+	Domains map[string]time.Duration
+}
+
+func newExpiryRules(cfg *config.IndexerConfig) ExpiryRules {
+	rules := ExpiryRules{TTL: cfg.TTL}
+	for _, d := range cfg.DomainTTLs {
+		if rules.Domains == nil {
+			rules.Domains = make(map[string]time.Duration)
+		}
+		rules.Domains[strings.TrimPrefix(strings.ToLower(d.Domain), "www.")] = d.TTL
+	}
+	return rules
+}
+
+// Enabled reports whether any document can expire.
+func (r ExpiryRules) Enabled() bool {
+	if r.TTL > 0 {
+		return true
+	}
+	for _, ttl := range r.Domains {
+		if ttl > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ttlFor returns the TTL of a document on domain: that of the longest rule
+// domain equal to or a parent of it, else the global one.
+func (r ExpiryRules) ttlFor(domain string) time.Duration {
+	ttl, longest := r.TTL, -1
+	for d, t := range r.Domains {
+		if len(d) > longest && (domain == d || strings.HasSuffix(domain, "."+d)) {
+			ttl, longest = t, len(d)
+		}
+	}
+	return ttl
+}
+
+// ExpiredDocument is a document ExpireDocuments deleted.
+type ExpiredDocument struct {
+	ID  int64
+	URL string
+}
+
+// ExpireDocuments deletes up to limit documents, least recently indexed
+// first, that have outlived their TTL under rules, and returns them so
+// their URLs can be recrawled. Like DeleteDocuments it only tombstones
+// them; indexing a URL again brings its document back.
+func (s *Storage) ExpireDocuments(ctx context.Context, rules ExpiryRules, limit int) ([]ExpiredDocument, error) {
+	if limit <= 0 {
+		limit = defaultExpireBatchSize
+	}
+	domains := make([]string, 0, len(rules.Domains))
+	ttls := make([]int64, 0, len(rules.Domains))
+	for domain, ttl := range rules.Domains {
+		domains = append(domains, domain)
+		ttls = append(ttls, int64(ttl.Seconds()))
+	}
+	rows, err := s.pool.Query(ctx, getExpiredDocuments, domains, ttls, int64(rules.TTL.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired documents: %w", err)
+	}
+	urls := make(map[int64]string)
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read expired document: %w", err)
+		}
+		urls[id] = url
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find expired documents: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	deleted, err := s.DeleteDocuments(ctx, nil, ids)
+	expired := make([]ExpiredDocument, len(deleted))
+	for i, id := range deleted {
+		expired[i] = ExpiredDocument{ID: id, URL: urls[id]}
+	}
+	return expired, err
+}
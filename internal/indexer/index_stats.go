@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const defaultIndexStatsTopN = 20
+
+// indexTables are the tables whose size IndexStats reports; a partitioned
+// table counts all of its partitions.
+var indexTables = []string{"documents", "terms", "postings", "stored_fields", "document_passages", "document_links"}
+
+// IndexStats describes the size and freshness of the index, for operators
+// watching it grow.
+type IndexStats struct {
+	Documents int64 `json:"documents"`
+	// DeletedDocuments are tombstoned and waiting to be purged.
+	DeletedDocuments   int64            `json:"deleted_documents"`
+	Terms              int64            `json:"terms"`
+	Postings           int64            `json:"postings"`
+	AvgPostingsPerTerm float64          `json:"avg_postings_per_term"`
+	DatabaseBytes      int64            `json:"database_bytes"`
+	TableBytes         map[string]int64 `json:"table_bytes"`
+	LargestTerms       []TermPostings   `json:"largest_terms"`
+	LastIndexedAt      *time.Time       `json:"last_indexed_at,omitempty"`
+}
+
+// TermPostings is a term and the number of documents it is posted to.
+type TermPostings struct {
+	Term     string `json:"term"`
+	Postings int64  `json:"postings"`
+}
+
+// NewStorage wraps an existing pool, such as the search API's, for read-only
+// use like IndexStats. Unlike NewPostgresClient it leaves the schema alone.
+func NewStorage(pool *pgxpool.Pool) *Storage {
+	return &Storage{pool: pool, termCache: newTermCache(0)}
+}
+
+// IndexStats reports the index's size and the topN terms with the most
+// postings. Counting postings scans the whole table, so it is meant for
+// monitoring rather than the request path.
+func (s *Storage) IndexStats(ctx context.Context, topN int) (*IndexStats, error) {
+	if topN <= 0 {
+		topN = defaultIndexStatsTopN
+	}
+	stats := &IndexStats{TableBytes: make(map[string]int64, len(indexTables))}
+
+	var docs *int64
+	err := s.pool.QueryRow(ctx, getIndexCounts).Scan(&docs, &stats.Terms, &stats.Postings, &stats.DeletedDocuments, &stats.LastIndexedAt, &stats.DatabaseBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count index rows: %w", err)
+	}
+	if docs != nil {
+		stats.Documents = *docs
+	}
+	if stats.Terms > 0 {
+		stats.AvgPostingsPerTerm = float64(stats.Postings) / float64(stats.Terms)
+	}
+
+	rows, err := s.pool.Query(ctx, getTableSizes, indexTables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure index tables: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		var size int64
+		if err := rows.Scan(&name, &size); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read table size: %w", err)
+		}
+		stats.TableBytes[name] = size
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to measure index tables: %w", err)
+	}
+
+	rows, err = s.pool.Query(ctx, getLargestTerms, topN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find largest terms: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TermPostings
+		if err := rows.Scan(&t.Term, &t.Postings); err != nil {
+			return nil, fmt.Errorf("failed to read largest term: %w", err)
+		}
+		stats.LargestTerms = append(stats.LargestTerms, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find largest terms: %w", err)
+	}
+	return stats, nil
+}
@@ -2,31 +2,142 @@ package indexer
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/amankumarsingh77/search_engine/config"
 	"github.com/amankumarsingh77/search_engine/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultEnqueueTimeout   = 30 * time.Second
+	defaultMaxBatchBytes    = 64 << 20
+	defaultRefreshInterval  = 15 * time.Minute
+	defaultReindexInterval  = 24 * time.Hour
+	defaultAnalyzeAfterDocs = 50000
+)
+
+// ErrQueueFull is returned by AddDocument when the workers didn't take the
+// document within the enqueue timeout. The caller should retry it later.
+var ErrQueueFull = errors.New("indexer queue is full")
+
 type Indexer struct {
-	adapter      *Storage
-	processor    *BatchProcessor
-	batchSize    int
-	workers      int
-	documentChan chan models.WebPage
+	adapter        Store
+	processor      *BatchProcessor
+	batchSize      int
+	maxBatchBytes  int64
+	workers        int
+	enqueueTimeout time.Duration
+	documentChan   chan models.WebPage
+	// inFlight holds a slot for each batch being analyzed or written; a
+	// worker with a full batch waits for one.
+	inFlight   chan struct{}
+	checkpoint *checkpointTracker
+
+	purgeInterval  time.Duration
+	purgeBatchSize int
+
+	expiry         ExpiryRules
+	expiryInterval time.Duration
+	onExpired      ExpiredFunc
+
+	refreshInterval  time.Duration
+	reindexInterval  time.Duration
+	reindex          ReindexOptions
+	analyzeAfterDocs int64
+	sinceAnalyze     atomic.Int64
+	analyzeDue       chan struct{}
+
+	metrics *indexerMetrics
 }
 
-func NewIndexer(cfg *config.IndexerConfig, adapter *Storage, batchProcessor *BatchProcessor) *Indexer {
+func NewIndexer(cfg *config.IndexerConfig, adapter Store, batchProcessor *BatchProcessor) *Indexer {
+	queueSize := cfg.BatchSize * 2
+	if cfg.QueueSize > 0 {
+		queueSize = cfg.QueueSize
+	}
+	enqueueTimeout := defaultEnqueueTimeout
+	if cfg.EnqueueTimeout > 0 {
+		enqueueTimeout = cfg.EnqueueTimeout
+	}
+	maxBatchBytes := int64(defaultMaxBatchBytes)
+	if cfg.MaxBatchBytes > 0 {
+		maxBatchBytes = cfg.MaxBatchBytes
+	}
+	maxInFlight := cfg.Workers
+	if cfg.MaxInFlightBatches > 0 {
+		maxInFlight = cfg.MaxInFlightBatches
+	}
+	purgeInterval := defaultPurgeInterval
+	if cfg.PurgeInterval > 0 {
+		purgeInterval = cfg.PurgeInterval
+	}
+	purgeBatchSize := defaultPurgeBatchSize
+	if cfg.PurgeBatchSize > 0 {
+		purgeBatchSize = cfg.PurgeBatchSize
+	}
+	expiryInterval := defaultExpiryInterval
+	if cfg.ExpiryInterval > 0 {
+		expiryInterval = cfg.ExpiryInterval
+	}
+	refreshInterval := defaultRefreshInterval
+	if cfg.RefreshInterval > 0 {
+		refreshInterval = cfg.RefreshInterval
+	}
+	reindexInterval := defaultReindexInterval
+	if cfg.ReindexInterval > 0 {
+		reindexInterval = cfg.ReindexInterval
+	}
+	analyzeAfterDocs := int64(defaultAnalyzeAfterDocs)
+	if cfg.AnalyzeAfterDocs > 0 {
+		analyzeAfterDocs = int64(cfg.AnalyzeAfterDocs)
+	}
 	indexer := &Indexer{
-		adapter:      adapter,
-		processor:    batchProcessor,
-		batchSize:    cfg.BatchSize,
-		workers:      cfg.Workers,
-		documentChan: make(chan models.WebPage, cfg.BatchSize*2),
+		adapter:        adapter,
+		processor:      batchProcessor,
+		batchSize:      cfg.BatchSize,
+		maxBatchBytes:  maxBatchBytes,
+		workers:        cfg.Workers,
+		enqueueTimeout: enqueueTimeout,
+		documentChan:   make(chan models.WebPage, queueSize),
+		inFlight:       make(chan struct{}, maxInFlight),
+		purgeInterval:  purgeInterval,
+		purgeBatchSize: purgeBatchSize,
+		expiry:         newExpiryRules(cfg),
+		expiryInterval: expiryInterval,
+
+		refreshInterval:  refreshInterval,
+		reindexInterval:  reindexInterval,
+		reindex:          ReindexOptions{BloatRatio: cfg.ReindexBloatRatio, MinBytes: cfg.ReindexMinBytes},
+		analyzeAfterDocs: analyzeAfterDocs,
+		analyzeDue:       make(chan struct{}, 1),
+
+		metrics: batchProcessor.metrics,
 	}
 	return indexer
 }
+
+// SetCheckpoint has the indexer persist its progress with save after each
+// committed batch. It must be called before any document is added.
+func (i *Indexer) SetCheckpoint(save CheckpointFunc) {
+	i.checkpoint = newCheckpointTracker(save)
+}
+
+// ExpiredFunc is told about each batch of documents the indexer expired,
+// typically to queue their URLs for a recrawl.
+type ExpiredFunc func(ctx context.Context, docs []ExpiredDocument) error
+
+// OnExpired has the indexer call fn after expiring documents. It must be
+// called before Start.
+func (i *Indexer) OnExpired(fn ExpiredFunc) {
+	i.onExpired = fn
+}
+
 func (i *Indexer) Start() {
 	var wg sync.WaitGroup
 	wg.Add(i.workers)
@@ -35,36 +146,195 @@ func (i *Indexer) Start() {
 		go i.worker(&wg)
 	}
 
+	stopMaintenance := make(chan struct{})
+	maintained := make(chan struct{})
+	go func() {
+		defer close(maintained)
+		i.maintain(stopMaintenance)
+	}()
+	go i.metrics.refreshRates(stopMaintenance, func() {
+		i.metrics.queueDepth.WithLabelValues().Set(float64(len(i.documentChan)))
+		i.metrics.inFlight.WithLabelValues().Set(float64(len(i.inFlight)))
+		i.metrics.observeTermCache(i.adapter.TermCacheStats())
+	})
+
 	wg.Wait()
+	close(stopMaintenance)
+	<-maintained
+}
+
+// Skipped returns how many pages the quality gate kept out of the index, by
+// reason.
+func (i *Indexer) Skipped() map[string]int64 {
+	skipped := make(map[string]int64)
+	for _, reason := range []string{SkipFetchError, SkipErrorStatus, SkipNoIndex, SkipEmptyTitle, SkipTooShort} {
+		if n := int64(i.metrics.skipped.WithLabelValues(reason).Get()); n > 0 {
+			skipped[reason] = n
+		}
+	}
+	return skipped
+}
+
+// MetricsHandler serves the indexer's throughput and stage timings in the
+// Prometheus text format.
+func (i *Indexer) MetricsHandler() http.Handler {
+	return i.metrics.handler()
+}
+
+// maintain runs the index's housekeeping until stop is closed: purging
+// deleted documents, refreshing term_frequencies, analyzing the tables once
+// enough documents were indexed, and rebuilding bloated indexes.
+func (i *Indexer) maintain(stop <-chan struct{}) {
+	purge := time.NewTicker(i.purgeInterval)
+	defer purge.Stop()
+	refresh := time.NewTicker(i.refreshInterval)
+	defer refresh.Stop()
+	reindex := time.NewTicker(i.reindexInterval)
+	defer reindex.Stop()
+	// Without a TTL the expiry ticker's channel stays nil and never fires.
+	var expire <-chan time.Time
+	if i.expiry.Enabled() {
+		ticker := time.NewTicker(i.expiryInterval)
+		defer ticker.Stop()
+		expire = ticker.C
+	}
+	ctx := context.Background()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-purge.C:
+			i.purgeDeleted(stop)
+		case <-expire:
+			i.expireDocuments(stop)
+		case <-refresh.C:
+			if err := i.adapter.RefreshTermFrequencies(ctx); err != nil {
+				log.Printf("Maintenance: %v", err)
+			}
+		case <-i.analyzeDue:
+			if err := i.adapter.Analyze(ctx); err != nil {
+				log.Printf("Maintenance: %v", err)
+			}
+		case <-reindex.C:
+			rebuilt, err := i.adapter.RebuildBloatedIndexes(ctx, i.reindex)
+			for _, b := range rebuilt {
+				log.Printf("Rebuilt index %s, %d bytes at %.1fx its estimated size", b.Name, b.Bytes, b.Ratio)
+			}
+			if err != nil {
+				log.Printf("Maintenance: %v", err)
+			}
+		}
+	}
+}
+
+// purgeDeleted removes the rows of tombstoned documents until none are left
+// or stop is closed. Batches are small so a large deletion doesn't hold
+// locks on postings for long.
+func (i *Indexer) purgeDeleted(stop <-chan struct{}) {
+	var total int64
+	defer func() {
+		if total > 0 {
+			log.Printf("Purged %d deleted documents", total)
+		}
+	}()
+	for {
+		n, err := i.adapter.PurgeDeletedDocuments(context.Background(), i.purgeBatchSize)
+		if err != nil {
+			log.Printf("Purging deleted documents stopped: %v", err)
+			return
+		}
+		total += n
+		if n < int64(i.purgeBatchSize) {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// expireDocuments deletes the documents past their TTL, a batch at a time
+// until none are left or stop is closed, and hands each batch to the
+// OnExpired function.
+func (i *Indexer) expireDocuments(stop <-chan struct{}) {
+	ctx := context.Background()
+	var total int
+	defer func() {
+		if total > 0 {
+			log.Printf("Expired %d documents", total)
+		}
+	}()
+	for {
+		expired, err := i.adapter.ExpireDocuments(ctx, i.expiry, defaultExpireBatchSize)
+		total += len(expired)
+		if len(expired) > 0 && i.onExpired != nil {
+			if err := i.onExpired(ctx, expired); err != nil {
+				log.Printf("Expired documents: %v", err)
+			}
+		}
+		if err != nil {
+			log.Printf("Expiring documents stopped: %v", err)
+			return
+		}
+		if len(expired) < defaultExpireBatchSize {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// countIndexed counts documents towards the next ANALYZE and asks for it
+// once analyzeAfterDocs have been indexed since the last one.
+func (i *Indexer) countIndexed(n int) {
+	if i.sinceAnalyze.Add(int64(n)) < i.analyzeAfterDocs {
+		return
+	}
+	i.sinceAnalyze.Store(0)
+	select {
+	case i.analyzeDue <- struct{}{}:
+	default:
+	}
 }
 
 func (i *Indexer) worker(wg *sync.WaitGroup) {
 	defer wg.Done()
 	var docs []*models.WebPage
+	var batchBytes int64
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 
+	flush := func() {
+		if len(docs) > 0 {
+			i.processDocuments(docs)
+			docs = nil
+			batchBytes = 0
+		}
+	}
 	for {
 		select {
 		case doc, ok := <-i.documentChan:
 			if !ok {
-				if len(docs) > 0 {
-					i.processDocuments(docs)
-				}
+				flush()
 				return
 			}
 
 			docs = append(docs, &doc)
-			if len(docs) >= i.batchSize {
-				i.processDocuments(docs)
-				docs = nil
+			batchBytes += pageSize(&doc)
+			// A batch is cut short when its text grows past maxBatchBytes,
+			// so a run of huge pages can't multiply into gigabytes of
+			// analyzed postings.
+			if len(docs) >= i.batchSize || batchBytes >= i.maxBatchBytes {
+				flush()
 			}
 
 		case <-ticker.C:
-			if len(docs) > 0 {
-				i.processDocuments(docs)
-				docs = nil
-			}
+			flush()
 		}
 	}
 }
@@ -73,16 +343,107 @@ func (i *Indexer) processDocuments(docs []*models.WebPage) {
 	//ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	//defer cancel()
 
-	batch := i.processor.CreateBatch(docs)
-	if err := i.processor.ProcessBatch(context.Background(), batch); err != nil {
-		log.Fatalf("failed to process the batch %v", err)
+	i.inFlight <- struct{}{}
+	defer func() { <-i.inFlight }()
+
+	pages := latestByURL(docs)
+	start := time.Now()
+	if err := i.processor.LoadAnchors(context.Background(), pages); err != nil {
+		log.Fatalf("failed to load anchors for the batch %v", err)
+	}
+	i.metrics.observe(stageLoadAnchors, start)
+	start = time.Now()
+	batch := i.processor.CreateBatch(pages)
+	i.metrics.observe(stageAnalyze, start)
+	for reason, n := range batch.skipped {
+		i.metrics.skipped.WithLabelValues(reason).Add(float64(n))
+	}
+	if len(batch.docs) > 0 {
+		if err := i.processor.ProcessBatch(context.Background(), batch); err != nil {
+			log.Fatalf("failed to process the batch %v", err)
+		}
+		i.countIndexed(len(batch.docs))
+	}
+	if i.checkpoint != nil {
+		ids := make([]primitive.ObjectID, len(docs))
+		for n, doc := range docs {
+			ids[n] = doc.ID
+		}
+		i.checkpoint.complete(ids)
 	}
 }
 
-func (i *Indexer) AddDocument(doc models.WebPage) {
-	i.documentChan <- doc
+// AddDocument queues doc for indexing, skipping pages the quality gate
+// rejects without analyzing them. It
+// blocks while the queue is full, for at most the enqueue timeout, and then
+// returns ErrQueueFull so the producer slows down instead of piling up
+// documents in memory.
+func (i *Indexer) AddDocument(ctx context.Context, doc models.WebPage) error {
+	if i.checkpoint != nil {
+		i.checkpoint.add(doc.ID)
+	}
+	if reason := i.processor.gate.rejectPage(&doc); reason != "" {
+		i.metrics.skipped.WithLabelValues(reason).Inc()
+		if i.checkpoint != nil {
+			i.checkpoint.complete([]primitive.ObjectID{doc.ID})
+		}
+		return nil
+	}
+	doc.URL = canonicalURL(doc.URL)
+	err := i.enqueue(ctx, doc)
+	if err != nil && i.checkpoint != nil {
+		i.checkpoint.remove(doc.ID)
+	}
+	return err
 }
 
+func (i *Indexer) enqueue(ctx context.Context, doc models.WebPage) error {
+	select {
+	case i.documentChan <- doc:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(i.enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case i.documentChan <- doc:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%w: waited %s for %s", ErrQueueFull, i.enqueueTimeout, doc.URL)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting documents; Start returns once the workers have
+// committed what was queued.
 func (i *Indexer) Close() {
 	close(i.documentChan)
 }
+
+// pageSize estimates the memory a page takes up while it waits in a batch,
+// from the text it carries.
+func pageSize(doc *models.WebPage) int64 {
+	size := len(doc.URL) + len(doc.Title) + len(doc.Description) + len(doc.BodyText)
+	for _, s := range doc.Keywords {
+		size += len(s)
+	}
+	for _, s := range doc.Paragraphs {
+		size += len(s)
+	}
+	for _, headings := range doc.Headings {
+		for _, s := range headings {
+			size += len(s)
+		}
+	}
+	for _, s := range doc.InternalLinks {
+		size += len(s)
+	}
+	for _, s := range doc.ExternalLinks {
+		size += len(s)
+	}
+	for _, a := range doc.Anchors {
+		size += len(a.URL) + len(a.Text)
+	}
+	return int64(size)
+}
@@ -0,0 +1,115 @@
+package indexer
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/internal/cache"
+	"github.com/amankumarsingh77/search_engine/internal/metrics"
+)
+
+const rateMetricsInterval = 15 * time.Second
+
+// batchBuckets are latency buckets in seconds for batch stages, which run
+// from milliseconds for small upserts to minutes for a large posting load.
+var batchBuckets = []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Stages of a batch, as the stage label of indexer_stage_duration_seconds.
+const (
+	stageLoadAnchors     = "load_anchors"
+	stageAnalyze         = "analyze"
+	stageInsertDocuments = "insert_documents"
+	stageLinks           = "links"
+	stageAnchors         = "anchors"
+	stageUpsertTerms     = "upsert_terms"
+	stageInsertPostings  = "insert_postings"
+	stageStoredBodies    = "stored_bodies"
+	stagePassages        = "passages"
+	stageBatch           = "batch"
+)
+
+type indexerMetrics struct {
+	registry      *metrics.Registry
+	documents     *metrics.CounterVec
+	skipped       *metrics.CounterVec
+	terms         *metrics.CounterVec
+	postings      *metrics.CounterVec
+	documentRate  *metrics.GaugeVec
+	termRate      *metrics.GaugeVec
+	postingRate   *metrics.GaugeVec
+	queueDepth    *metrics.GaugeVec
+	inFlight      *metrics.GaugeVec
+	stageDuration *metrics.HistogramVec
+
+	termCacheHits      *metrics.CounterVec
+	termCacheMisses    *metrics.CounterVec
+	termCacheEvictions *metrics.CounterVec
+	termCacheSize      *metrics.GaugeVec
+	termCacheHitRate   *metrics.GaugeVec
+}
+
+func newIndexerMetrics() *indexerMetrics {
+	r := metrics.NewRegistry()
+	return &indexerMetrics{
+		registry:      r,
+		documents:     r.NewCounterVec("indexer_documents_total", "Documents indexed."),
+		skipped:       r.NewCounterVec("indexer_documents_skipped_total", "Pages kept out of the index by the quality gate.", "reason"),
+		terms:         r.NewCounterVec("indexer_terms_total", "Distinct terms per batch, summed over batches."),
+		postings:      r.NewCounterVec("indexer_postings_total", "Postings written."),
+		documentRate:  r.NewGaugeVec("indexer_documents_per_second", "Documents indexed per second over the last 15 seconds."),
+		termRate:      r.NewGaugeVec("indexer_terms_per_second", "Terms upserted per second over the last 15 seconds."),
+		postingRate:   r.NewGaugeVec("indexer_postings_per_second", "Postings written per second over the last 15 seconds."),
+		queueDepth:    r.NewGaugeVec("indexer_queue_depth", "Documents waiting for a worker."),
+		inFlight:      r.NewGaugeVec("indexer_batches_in_flight", "Batches being analyzed or written."),
+		stageDuration: r.NewHistogramVec("indexer_stage_duration_seconds", "Time spent per batch in each stage; stage=batch is the whole write.", batchBuckets, "stage"),
+
+		termCacheHits:      r.NewCounterVec("indexer_term_cache_hits_total", "Term id lookups served from memory."),
+		termCacheMisses:    r.NewCounterVec("indexer_term_cache_misses_total", "Term id lookups that went to the terms table."),
+		termCacheEvictions: r.NewCounterVec("indexer_term_cache_evictions_total", "Term ids evicted to keep the cache within Index.TermCacheSize."),
+		termCacheSize:      r.NewGaugeVec("indexer_term_cache_size", "Term ids held in memory."),
+		termCacheHitRate:   r.NewGaugeVec("indexer_term_cache_hit_rate", "Share of term id lookups served from memory since start."),
+	}
+}
+
+// observe records how long stage took.
+func (m *indexerMetrics) observe(stage string, start time.Time) {
+	m.stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// observeTermCache copies the term cache's counts into the metrics.
+func (m *indexerMetrics) observeTermCache(stats cache.Stats) {
+	m.termCacheHits.WithLabelValues().Set(float64(stats.Hits))
+	m.termCacheMisses.WithLabelValues().Set(float64(stats.Misses))
+	m.termCacheEvictions.WithLabelValues().Set(float64(stats.Evictions))
+	m.termCacheSize.WithLabelValues().Set(float64(stats.Size))
+	m.termCacheHitRate.WithLabelValues().Set(stats.HitRate())
+}
+
+// refreshRates turns the counters into per-second rates and calls sample to
+// record the indexer's current state every rateMetricsInterval until stop
+// is closed.
+func (m *indexerMetrics) refreshRates(stop <-chan struct{}, sample func()) {
+	ticker := time.NewTicker(rateMetricsInterval)
+	defer ticker.Stop()
+	docs, terms, postings := m.documents.WithLabelValues(), m.terms.WithLabelValues(), m.postings.WithLabelValues()
+	lastDocs, lastTerms, lastPostings := docs.Get(), terms.Get(), postings.Get()
+	last := time.Now()
+	for {
+		sample()
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(last).Seconds()
+			d, t, p := docs.Get(), terms.Get(), postings.Get()
+			m.documentRate.WithLabelValues().Set((d - lastDocs) / elapsed)
+			m.termRate.WithLabelValues().Set((t - lastTerms) / elapsed)
+			m.postingRate.WithLabelValues().Set((p - lastPostings) / elapsed)
+			lastDocs, lastTerms, lastPostings, last = d, t, p, now
+		}
+	}
+}
+
+func (m *indexerMetrics) handler() http.Handler {
+	return m.registry.Handler()
+}
@@ -0,0 +1,65 @@
+package indexer
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/internal/urlnorm"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+// canonicalURL is the key a page is stored under: the frontier's
+// normalization with http taken to be https, so http/https and www/non-www
+// variants of a page collapse into one document. A URL that doesn't parse
+// is kept as it is.
+func canonicalURL(link string) string {
+	link = textproc.RemoveInvalidUTF8(link)
+	if canonical, err := urlnorm.Canonical(link); err == nil {
+		return canonical
+	}
+	return link
+}
+
+// latestByURL drops all but the last of the pages in docs that share a URL,
+// which must already be canonical, keeping the order of the rest. The
+// documents upsert can't touch one row twice in a batch.
+func latestByURL(docs []*models.WebPage) []*models.WebPage {
+	last := make(map[string]int, len(docs))
+	for i, doc := range docs {
+		last[doc.URL] = i
+	}
+	if len(last) == len(docs) {
+		return docs
+	}
+	kept := make([]*models.WebPage, 0, len(last))
+	for i, doc := range docs {
+		if last[doc.URL] == i {
+			kept = append(kept, doc)
+		}
+	}
+	return kept
+}
+
+func linkHostCounts(links []string) map[string]int {
+	counts := make(map[string]int)
+	for _, link := range links {
+		if host := linkHost(link); host != "" {
+			counts[host]++
+		}
+	}
+	return counts
+}
+
+// linkHost returns the lowercased host of an http(s) URL without "www.",
+// or "" for anything else. Documents store it as their domain.
+func linkHost(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
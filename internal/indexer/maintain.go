@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	defaultReindexBloatRatio = 2.0
+	defaultReindexMinBytes   = 64 << 20
+)
+
+// maintainedTables are analyzed after large batches; their indexes, and
+// those of every postings partition, are checked for bloat.
+var maintainedTables = []string{"documents", "terms", "postings", "stored_fields", "document_passages", "document_links", "document_anchors"}
+
+// ReindexOptions sets which indexes RebuildBloatedIndexes rebuilds: those at
+// least MinBytes large and BloatRatio times their estimated size.
+type ReindexOptions struct {
+	BloatRatio float64
+	MinBytes   int64
+}
+
+// IndexBloat is an index's size against what its rows should take.
+type IndexBloat struct {
+	Name           string  `json:"name"`
+	Bytes          int64   `json:"bytes"`
+	EstimatedBytes int64   `json:"estimated_bytes"`
+	Ratio          float64 `json:"ratio"`
+}
+
+// RefreshTermFrequencies recomputes the term_frequencies view without
+// blocking the queries that read it.
+func (s *Storage) RefreshTermFrequencies(ctx context.Context) error {
+	if _, err := s.pool.Exec(ctx, refreshTermFrequenciesConcurrently); err != nil {
+		return fmt.Errorf("failed to refresh term frequencies: %w", err)
+	}
+	return nil
+}
+
+// Analyze updates the planner statistics of the index tables. Postings
+// grows fastest, and stale statistics there turn term lookups into scans.
+func (s *Storage) Analyze(ctx context.Context) error {
+	for _, table := range maintainedTables {
+		if _, err := s.pool.Exec(ctx, "ANALYZE "+table); err != nil {
+			return fmt.Errorf("failed to analyze %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// RebuildBloatedIndexes rebuilds, one at a time and without blocking writes,
+// the indexes that deletes and updates have bloated past opts, and returns
+// them. Sizes are estimated from the planner statistics, so Analyze should
+// have run recently.
+func (s *Storage) RebuildBloatedIndexes(ctx context.Context, opts ReindexOptions) ([]IndexBloat, error) {
+	if opts.BloatRatio <= 0 {
+		opts.BloatRatio = defaultReindexBloatRatio
+	}
+	if opts.MinBytes <= 0 {
+		opts.MinBytes = defaultReindexMinBytes
+	}
+	rows, err := s.pool.Query(ctx, getIndexBloat, maintainedTables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate index bloat: %w", err)
+	}
+	var bloated []IndexBloat
+	for rows.Next() {
+		var b IndexBloat
+		var estimated float64
+		if err := rows.Scan(&b.Name, &b.Bytes, &estimated); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read index size: %w", err)
+		}
+		if estimated < 1 || b.Bytes < opts.MinBytes {
+			continue
+		}
+		b.EstimatedBytes = int64(estimated)
+		b.Ratio = float64(b.Bytes) / estimated
+		if b.Ratio >= opts.BloatRatio {
+			bloated = append(bloated, b)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to estimate index bloat: %w", err)
+	}
+
+	var rebuilt []IndexBloat
+	for _, b := range bloated {
+		// The name comes from regclass, so it is already quoted.
+		if _, err := s.pool.Exec(ctx, "REINDEX INDEX CONCURRENTLY "+b.Name); err != nil {
+			return rebuilt, fmt.Errorf("failed to rebuild %s: %w", b.Name, err)
+		}
+		rebuilt = append(rebuilt, b)
+	}
+	return rebuilt, nil
+}
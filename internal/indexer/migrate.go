@@ -0,0 +1,159 @@
+package indexer
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Schema changes are numbered SQL files in migrations/, applied in order and
+// recorded in schema_migrations. Add a new file for every change; never edit
+// one that has shipped.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key that keeps indexers started
+// together from applying the same migration twice.
+const migrationLock = 7270518
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+	sql       string
+}
+
+// loadMigrations reads the embedded migrations, named <version>_<name>.sql,
+// in version order.
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		file := entry.Name()
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", file)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, file, version)
+		}
+		seen[version] = file
+		sql, err := migrationFiles.ReadFile(path.Join("migrations", file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, sql: string(sql)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies every migration newer than the database's schema version,
+// each in its own transaction, and returns the ones it applied.
+func (s *Storage) Migrate(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection for migrations: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return nil, fmt.Errorf("failed to lock schema for migrations: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	if _, err := conn.Exec(ctx, createSchemaMigrations); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	applied, err := s.appliedMigrations(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+
+	var ran []Migration
+	for _, m := range migrations {
+		if done[m.Version] {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, insertSchemaMigration, m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		m.AppliedAt = time.Now()
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// Migrations lists every known migration, with AppliedAt zero for those
+// not yet applied.
+func (s *Storage) Migrations(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, createSchemaMigrations); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	applied, err := s.appliedMigrations(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+	at := make(map[int]time.Time, len(applied))
+	for _, m := range applied {
+		at[m.Version] = m.AppliedAt
+	}
+	for i := range migrations {
+		migrations[i].AppliedAt = at[migrations[i].Version]
+	}
+	return migrations, nil
+}
+
+func (s *Storage) appliedMigrations(ctx context.Context, conn *pgx.Conn) ([]Migration, error) {
+	rows, err := conn.Query(ctx, getSchemaMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	applied, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Migration, error) {
+		var m Migration
+		err := row.Scan(&m.Version, &m.Name, &m.AppliedAt)
+		return m, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return applied, nil
+}
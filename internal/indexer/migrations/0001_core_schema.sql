@@ -0,0 +1,23 @@
+-- Core inverted index: documents, the term dictionary and one posting per
+-- term and document. IF NOT EXISTS lets indexes created before migrations
+-- adopt this as their first version.
+CREATE TABLE IF NOT EXISTS documents (
+    id          BIGSERIAL PRIMARY KEY,
+    url         TEXT NOT NULL UNIQUE,
+    title       TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    token_count INT NOT NULL DEFAULT 0,
+    indexed_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS terms (
+    id   BIGSERIAL PRIMARY KEY,
+    term TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+    term_id   BIGINT NOT NULL,
+    doc_id    BIGINT NOT NULL,
+    positions INT[] NOT NULL,
+    PRIMARY KEY (term_id, doc_id)
+);
@@ -0,0 +1,72 @@
+-- Everything the indexer used to add on startup on top of the core schema.
+-- Every statement is idempotent, so indexes that already have it are fine.
+CREATE TABLE IF NOT EXISTS index_stats (
+    id           SMALLINT PRIMARY KEY,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    doc_count    BIGINT NOT NULL DEFAULT 0,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+INSERT INTO index_stats (id, total_tokens, doc_count)
+    SELECT 1, COALESCE(SUM(token_count), 0), COUNT(*) FROM documents
+    ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS external_link_count INT NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS source_quality REAL NOT NULL DEFAULT 1;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS title_token_count INT NOT NULL DEFAULT 0;
+
+-- field_lengths holds the token count of each field in textproc.Fields
+-- order; postings.fields has bit i set when a term occurs in field i.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS field_lengths INT[];
+ALTER TABLE postings ADD COLUMN IF NOT EXISTS fields SMALLINT NOT NULL DEFAULT 0;
+
+-- offsets holds a start and end byte offset into the field's text for
+-- each position; -1 when the term couldn't be traced back to it.
+ALTER TABLE postings ADD COLUMN IF NOT EXISTS offsets INT[];
+
+-- frequency is the number of positions, read by the top-terms query and
+-- the term_frequencies view. Every posting has at least one position, so a
+-- zero frequency marks a row written before the column existed.
+ALTER TABLE postings ADD COLUMN IF NOT EXISTS frequency INT NOT NULL DEFAULT 0;
+UPDATE postings SET frequency = COALESCE(array_length(positions, 1), 0)
+    WHERE frequency = 0 AND array_length(positions, 1) > 0;
+
+CREATE TABLE IF NOT EXISTS stored_fields (
+    doc_id BIGINT NOT NULL,
+    field  TEXT NOT NULL,
+    body   TEXT NOT NULL,
+    PRIMARY KEY (doc_id, field)
+);
+
+CREATE TABLE IF NOT EXISTS document_links (
+    doc_id     BIGINT NOT NULL,
+    host       TEXT NOT NULL,
+    link_count INT NOT NULL,
+    PRIMARY KEY (doc_id, host)
+);
+CREATE INDEX IF NOT EXISTS idx_document_links_host ON document_links(host);
+
+ALTER TABLE terms ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE TABLE IF NOT EXISTS document_passages (
+    doc_id       BIGINT NOT NULL,
+    paragraph_no INT NOT NULL,
+    start_pos    INT NOT NULL,
+    end_pos      INT NOT NULL,
+    body         TEXT NOT NULL,
+    PRIMARY KEY (doc_id, paragraph_no)
+);
+
+-- postings_staging receives COPY loads before they are merged into
+-- postings. Unlogged, so staging skips the WAL; a crash only loses batches
+-- that were never merged.
+CREATE UNLOGGED TABLE IF NOT EXISTS postings_staging (
+    batch_id  BIGINT NOT NULL,
+    term_id   BIGINT NOT NULL,
+    doc_id    BIGINT NOT NULL,
+    positions INT[] NOT NULL,
+    offsets   INT[],
+    fields    SMALLINT NOT NULL,
+    frequency INT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_postings_staging_batch ON postings_staging(batch_id);
+CREATE SEQUENCE IF NOT EXISTS postings_staging_batch_seq;
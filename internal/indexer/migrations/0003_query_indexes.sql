@@ -0,0 +1,16 @@
+-- Secondary indexes the query engine relies on, and the per-term
+-- frequency view. The (term_id, doc_id) and url/term lookups are already
+-- covered by the core schema's keys.
+CREATE INDEX IF NOT EXISTS idx_postings_doc_term ON postings(doc_id, term_id);
+CREATE INDEX IF NOT EXISTS idx_documents_token_count ON documents(token_count);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS term_frequencies AS
+    SELECT
+        term_id,
+        COUNT(DISTINCT doc_id) AS doc_frequency,
+        SUM(frequency) AS total_frequency
+    FROM postings
+    GROUP BY term_id
+    WITH DATA;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_term_frequencies_term_id ON term_frequencies(term_id);
+CREATE INDEX IF NOT EXISTS idx_term_frequencies_frequency ON term_frequencies(total_frequency DESC);
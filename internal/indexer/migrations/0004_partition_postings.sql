@@ -0,0 +1,52 @@
+-- Hash-partitions postings on term_id. Every postings query filters on
+-- term_id, so it only reads the partitions holding its terms, and each
+-- partition's indexes stay small enough to keep cached. An existing
+-- postings table is copied into the partitioned one inside this
+-- migration's transaction, which on a large index takes a while and needs
+-- room for a second copy of postings.
+DROP MATERIALIZED VIEW IF EXISTS term_frequencies;
+
+DO $$
+DECLARE
+    i INT;
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'postings'::regclass) THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE postings RENAME TO postings_unpartitioned;
+    ALTER INDEX IF EXISTS postings_pkey RENAME TO postings_unpartitioned_pkey;
+    DROP INDEX IF EXISTS idx_postings_doc_term;
+
+    CREATE TABLE postings (
+        term_id   BIGINT NOT NULL,
+        doc_id    BIGINT NOT NULL,
+        positions INT[] NOT NULL,
+        fields    SMALLINT NOT NULL DEFAULT 0,
+        offsets   INT[],
+        frequency INT NOT NULL DEFAULT 0,
+        PRIMARY KEY (term_id, doc_id)
+    ) PARTITION BY HASH (term_id);
+
+    FOR i IN 0..15 LOOP
+        EXECUTE format('CREATE TABLE postings_p%s PARTITION OF postings FOR VALUES WITH (MODULUS 16, REMAINDER %s)', i, i);
+    END LOOP;
+
+    INSERT INTO postings (term_id, doc_id, positions, fields, offsets, frequency)
+        SELECT term_id, doc_id, positions, fields, offsets, frequency FROM postings_unpartitioned;
+    DROP TABLE postings_unpartitioned;
+END $$;
+
+-- Created on the parent, so every partition gets its own.
+CREATE INDEX IF NOT EXISTS idx_postings_doc_term ON postings(doc_id, term_id);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS term_frequencies AS
+    SELECT
+        term_id,
+        COUNT(DISTINCT doc_id) AS doc_frequency,
+        SUM(frequency) AS total_frequency
+    FROM postings
+    GROUP BY term_id
+    WITH DATA;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_term_frequencies_term_id ON term_frequencies(term_id);
+CREATE INDEX IF NOT EXISTS idx_term_frequencies_frequency ON term_frequencies(total_frequency DESC);
@@ -0,0 +1,10 @@
+-- Anchor text of internal links, keyed by the linking page's URL so it can
+-- be recorded before either page is indexed. The target's document indexes
+-- it as its anchor field.
+CREATE TABLE IF NOT EXISTS document_anchors (
+    source_url TEXT NOT NULL,
+    target_url TEXT NOT NULL,
+    text       TEXT NOT NULL,
+    PRIMARY KEY (source_url, target_url)
+);
+CREATE INDEX IF NOT EXISTS idx_document_anchors_target ON document_anchors(target_url);
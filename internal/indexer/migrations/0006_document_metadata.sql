@@ -0,0 +1,18 @@
+-- Per-document metadata for query-time filters: the page's declared
+-- language, its publication date, and its host without "www.", so lang:,
+-- before:/after: and site: can use indexes instead of scanning URLs.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS language TEXT;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS domain TEXT;
+
+-- Documents indexed before this only have a URL to go on; language and
+-- published_at fill in as they are re-indexed.
+UPDATE documents
+SET domain = regexp_replace(lower(substring(url FROM '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/]*@)?([^/:?#]+)')), '^www\.', '')
+WHERE domain IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_documents_domain ON documents(domain);
+-- Serves subdomain matches (domain LIKE '%.example.com') as a prefix search.
+CREATE INDEX IF NOT EXISTS idx_documents_domain_reversed ON documents(reverse(domain) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_documents_language ON documents(language) WHERE language IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_published_at ON documents(published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Deleted documents are tombstoned first: search skips them at once, and the
+-- indexer purges their rows and postings in the background.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_documents_deleted_at ON documents(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Lets inurl: match any part of a URL through an index. pg_trgm is a
+-- trusted extension, so the database owner can create it.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_documents_url_trgm ON documents USING gin (lower(url) gin_trgm_ops);
@@ -0,0 +1,4 @@
+-- position_gap is how many empty positions the indexer left between fields.
+-- Documents indexed before fields were spaced apart keep 0, which is how
+-- their positions were laid out, until they are re-indexed.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS position_gap INT NOT NULL DEFAULT 0;
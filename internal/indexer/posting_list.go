@@ -0,0 +1,126 @@
+package indexer
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"slices"
+)
+
+var errCorruptPostingList = errors.New("corrupt posting list")
+
+// A serialized posting list holds the postings of one term ordered by
+// document id: the number of postings, then for each its document id as
+// the difference from the previous one, its fields bitmask and its number
+// of positions, followed by the positions, each as the difference from the
+// one before, and a start and end offset per position. All are varints.
+
+// encodePostingList serializes postings, which must be ordered by document.
+func encodePostingList(postings []posting) []byte {
+	buf := make([]byte, 0, 16*len(postings))
+	buf = binary.AppendUvarint(buf, uint64(len(postings)))
+	var prevDoc int64
+	for _, p := range postings {
+		buf = binary.AppendVarint(buf, p.docID-prevDoc)
+		prevDoc = p.docID
+		buf = binary.AppendUvarint(buf, uint64(uint16(p.fields)))
+		buf = binary.AppendUvarint(buf, uint64(len(p.positions)))
+		var prevPos int32
+		for _, pos := range p.positions {
+			buf = binary.AppendVarint(buf, int64(pos-prevPos))
+			prevPos = pos
+		}
+		for i := range p.positions {
+			start, end := int32(-1), int32(-1)
+			if 2*i+1 < len(p.offsets) {
+				start, end = p.offsets[2*i], p.offsets[2*i+1]
+			}
+			buf = binary.AppendVarint(buf, int64(start))
+			buf = binary.AppendVarint(buf, int64(end))
+		}
+	}
+	return buf
+}
+
+// decodePostingList reads the postings of termID back from data.
+func decodePostingList(termID int64, data []byte) ([]posting, error) {
+	r := postingReader{data: data}
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(data)) {
+		return nil, errCorruptPostingList
+	}
+	postings := make([]posting, n)
+	var docID int64
+	for i := range postings {
+		docID += r.varint()
+		p := posting{termID: termID, docID: docID, fields: int16(r.uvarint())}
+		count := r.uvarint()
+		if r.err != nil || count > uint64(len(data)) {
+			return nil, errCorruptPostingList
+		}
+		p.positions = make([]int32, count)
+		var pos int32
+		for j := range p.positions {
+			pos += int32(r.varint())
+			p.positions[j] = pos
+		}
+		p.offsets = make([]int32, 2*count)
+		for j := range p.offsets {
+			p.offsets[j] = int32(r.varint())
+		}
+		postings[i] = p
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return postings, nil
+}
+
+type postingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *postingReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errCorruptPostingList
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *postingReader) varint() int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errCorruptPostingList
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// mergePostingList returns list without the postings of the documents in
+// replaced, plus added, ordered by document. added must not repeat a
+// document.
+func mergePostingList(list []posting, replaced map[int64]struct{}, added []posting) []posting {
+	merged := make([]posting, 0, len(list)+len(added))
+	for _, p := range list {
+		if _, ok := replaced[p.docID]; !ok {
+			merged = append(merged, p)
+		}
+	}
+	merged = append(merged, added...)
+	slices.SortFunc(merged, func(a, b posting) int { return cmp.Compare(a.docID, b.docID) })
+	return merged
+}
+
+// postingListFrequency returns the number of documents in list and the
+// occurrences of the term across them.
+func postingListFrequency(list []posting) (docs, occurrences int64) {
+	for _, p := range list {
+		occurrences += int64(len(p.positions))
+	}
+	return int64(len(list)), occurrences
+}
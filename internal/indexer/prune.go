@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultPruneMinDocFreq = 2
+	defaultPruneMinAge     = 30 * 24 * time.Hour
+	defaultPruneBatchSize  = 5000
+)
+
+// PruneOptions selects which terms PruneTerms removes: those appearing in
+// fewer than MinDocFreq documents and first seen more than MinAge ago.
+type PruneOptions struct {
+	MinDocFreq int
+	MinAge     time.Duration
+	BatchSize  int
+}
+
+// PruneTerms deletes rare, old terms and their postings in batches. Such terms
+// are almost always tokenizer noise (typos, mangled markup, ids) and otherwise
+// accumulate in the terms table forever. It returns the number of terms removed.
+func (s *Storage) PruneTerms(ctx context.Context, opts PruneOptions) (int64, error) {
+	if opts.MinDocFreq <= 0 {
+		opts.MinDocFreq = defaultPruneMinDocFreq
+	}
+	if opts.MinAge <= 0 {
+		opts.MinAge = defaultPruneMinAge
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultPruneBatchSize
+	}
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		rows, err := s.pool.Query(ctx, pruneTerms, opts.MinDocFreq, opts.MinAge.Seconds(), opts.BatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to prune terms: %w", err)
+		}
+		var pruned int64
+		for rows.Next() {
+			var term string
+			if err := rows.Scan(&term); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("failed to read pruned term: %w", err)
+			}
+			// The cached id is gone; a later batch must re-insert the term.
+			s.termCache.Delete(term)
+			pruned++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, fmt.Errorf("failed to prune terms: %w", err)
+		}
+		total += pruned
+		if pruned < int64(opts.BatchSize) {
+			return total, nil
+		}
+	}
+}
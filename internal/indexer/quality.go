@@ -0,0 +1,54 @@
+package indexer
+
+import (
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+// Reasons a page is kept out of the index, as the reason label of
+// indexer_documents_skipped_total.
+const (
+	SkipErrorStatus = "error_status"
+	SkipFetchError  = "fetch_error"
+	SkipNoIndex     = "noindex"
+	SkipEmptyTitle  = "empty_title"
+	SkipTooShort    = "too_short"
+)
+
+// qualityGate decides which crawled pages are worth indexing. Failed
+// fetches, error statuses and noindex pages are always skipped; an empty
+// title and a token count under minTokens only when configured.
+type qualityGate struct {
+	minTokens    int
+	requireTitle bool
+}
+
+func newQualityGate(cfg *config.IndexerConfig) qualityGate {
+	return qualityGate{minTokens: cfg.MinTokens, requireTitle: cfg.RequireTitle}
+}
+
+// rejectPage returns why doc must be skipped before analysis, or "".
+func (g qualityGate) rejectPage(doc *models.WebPage) string {
+	switch {
+	case doc.ErrorString != "":
+		return SkipFetchError
+	case doc.IsErrorStatus():
+		return SkipErrorStatus
+	case doc.NoIndex:
+		return SkipNoIndex
+	case g.requireTitle && strings.TrimSpace(doc.Title) == "":
+		return SkipEmptyTitle
+	}
+	return ""
+}
+
+// rejectTokens returns why a page analyzed into tokens positions must be
+// skipped, or "".
+func (g qualityGate) rejectTokens(tokens int) string {
+	if tokens < g.minTokens {
+		return SkipTooShort
+	}
+	return ""
+}
@@ -1,22 +1,251 @@
 package indexer
 
 const (
-	insertDocuments = `INSERT INTO documents (url, title, description, token_count)
-						VALUES ($1, $2, $3, $4)
-						ON CONFLICT(url) DO UPDATE SET 
-								title = EXCLUDED.title,
-								description = EXCLUDED.description,
-						    	token_count= EXCLUDED.token_count,
-								indexed_at=NOW()
-						RETURNING id
+	insertDocuments = `WITH previous AS (
+							SELECT token_count FROM documents WHERE url = $1 AND deleted_at IS NULL
+						), upserted AS (
+							INSERT INTO documents (url, title, description, token_count, external_link_count, source_quality, title_token_count, field_lengths,
+								language, published_at, domain, position_gap)
+							VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+							ON CONFLICT(url) DO UPDATE SET 
+									title = EXCLUDED.title,
+									description = EXCLUDED.description,
+							    	token_count= EXCLUDED.token_count,
+									external_link_count = EXCLUDED.external_link_count,
+									source_quality = EXCLUDED.source_quality,
+									title_token_count = EXCLUDED.title_token_count,
+									field_lengths = EXCLUDED.field_lengths,
+									language = EXCLUDED.language,
+									published_at = EXCLUDED.published_at,
+									domain = EXCLUDED.domain,
+									position_gap = EXCLUDED.position_gap,
+									deleted_at = NULL,
+									indexed_at=NOW()
+							RETURNING id
+						)
+						SELECT upserted.id, (SELECT token_count FROM previous) FROM upserted
 						`
 	insertMissingTerms = `INSERT INTO terms (term)
 							SELECT unnest($1::text[])
 							ON CONFLICT (term) DO NOTHING
 							`
-	getIDsByTerms  = `SELECT id, term FROM terms WHERE term = ANY($1::text[])`
-	insertPostings = `INSERT INTO postings (term_id, doc_id, positions)
-				VALUES ($1, $2, $3)
+	getIDsByTerms       = `SELECT id, term FROM terms WHERE term = ANY($1::text[])`
+	deleteDocumentLinks = `DELETE FROM document_links WHERE doc_id = ANY($1)`
+	insertDocumentLink  = `INSERT INTO document_links (doc_id, host, link_count) VALUES ($1, $2, $3)`
+	updateIndexStats    = `UPDATE index_stats SET
+							total_tokens = total_tokens + $1,
+							doc_count = doc_count + $2,
+							updated_at = NOW()
+						WHERE id = 1`
+	pruneTerms = `WITH doomed AS (
+							SELECT t.id FROM terms t
+							WHERE t.created_at < NOW() - make_interval(secs => $2)
+							  AND (SELECT COUNT(*) FROM postings p WHERE p.term_id = t.id) < $1
+							LIMIT $3
+						), removed_postings AS (
+							DELETE FROM postings WHERE term_id IN (SELECT id FROM doomed)
+						)
+						DELETE FROM terms WHERE id IN (SELECT id FROM doomed)
+						RETURNING term`
+	deleteDocumentPassages = `DELETE FROM document_passages WHERE doc_id = ANY($1)`
+	insertDocumentPassage  = `INSERT INTO document_passages (doc_id, paragraph_no, start_pos, end_pos, body) VALUES ($1, $2, $3, $4, $5)`
+	deleteDocumentPostings = `DELETE FROM postings WHERE doc_id = ANY($1)`
+	insertPostings         = `INSERT INTO postings (term_id, doc_id, positions, offsets, fields, frequency)
+				VALUES ($1, $2, $3, $4, $5, $6)
 				ON CONFLICT (term_id, doc_id) DO UPDATE SET
-					positions = EXCLUDED.positions`
+					positions = EXCLUDED.positions,
+					offsets = EXCLUDED.offsets,
+					fields = EXCLUDED.fields,
+					frequency = EXCLUDED.frequency`
+	upsertStoredField = `INSERT INTO stored_fields (doc_id, field, body) VALUES ($1, $2, $3)
+							ON CONFLICT (doc_id, field) DO UPDATE SET body = EXCLUDED.body`
+	nextStagingBatch    = `SELECT nextval('postings_staging_batch_seq')`
+	mergeStagedPostings = `INSERT INTO postings (term_id, doc_id, positions, offsets, fields, frequency)
+							SELECT term_id, doc_id, positions, offsets, fields, frequency
+							FROM postings_staging WHERE batch_id = $1
+							ORDER BY term_id, doc_id
+							ON CONFLICT (term_id, doc_id) DO UPDATE SET
+								positions = EXCLUDED.positions,
+								offsets = EXCLUDED.offsets,
+								fields = EXCLUDED.fields,
+								frequency = EXCLUDED.frequency`
+	clearStagedPostings = `DELETE FROM postings_staging WHERE batch_id = $1`
+	// Tombstoning takes a document out of search and its share out of the
+	// stats in one statement; purgeDocuments removes its rows later.
+	tombstoneDocuments = `WITH doomed AS (
+							UPDATE documents SET deleted_at = NOW()
+							WHERE (url = ANY($1::text[]) OR id = ANY($2::bigint[])) AND deleted_at IS NULL
+							RETURNING id, token_count
+						), stats AS (
+							UPDATE index_stats SET
+								total_tokens = total_tokens - (SELECT COALESCE(SUM(token_count), 0) FROM doomed),
+								doc_count = doc_count - (SELECT COUNT(*) FROM doomed),
+								updated_at = NOW()
+							WHERE id = 1
+						)
+						SELECT id FROM doomed`
+	// Every statement in the CTE sees the same snapshot, so the documents
+	// and their dependent rows go in one atomic step. Deleting postings by
+	// doc_id is the one postings statement that touches every partition,
+	// through each one's (doc_id, term_id) index. A document re-indexed
+	// since it was picked has lost its tombstone and is kept.
+	purgeDocuments = `WITH doomed AS (
+							DELETE FROM documents
+							WHERE id IN (
+								SELECT id FROM documents WHERE deleted_at IS NOT NULL
+								ORDER BY deleted_at LIMIT $1
+							) AND deleted_at IS NOT NULL
+							RETURNING id, url
+						), removed_postings AS (
+							DELETE FROM postings WHERE doc_id IN (SELECT id FROM doomed)
+						), removed_links AS (
+							DELETE FROM document_links WHERE doc_id IN (SELECT id FROM doomed)
+						), removed_passages AS (
+							DELETE FROM document_passages WHERE doc_id IN (SELECT id FROM doomed)
+						), removed_fields AS (
+							DELETE FROM stored_fields WHERE doc_id IN (SELECT id FROM doomed)
+						), removed_anchors AS (
+							DELETE FROM document_anchors WHERE source_url IN (SELECT url FROM doomed)
+						)
+						SELECT COUNT(*) FROM doomed`
+	createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+							version    INT PRIMARY KEY,
+							name       TEXT NOT NULL,
+							applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+						)`
+	getSchemaMigrations   = `SELECT version, name, applied_at FROM schema_migrations ORDER BY version`
+	insertSchemaMigration = `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+	getIndexCounts        = `SELECT
+							(SELECT doc_count FROM index_stats WHERE id = 1),
+							(SELECT COUNT(*) FROM terms),
+							(SELECT COUNT(*) FROM postings),
+							(SELECT COUNT(*) FROM documents WHERE deleted_at IS NOT NULL),
+							(SELECT MAX(indexed_at) FROM documents),
+							pg_database_size(current_database())`
+	getTableSizes = `SELECT t.name, COALESCE((
+							SELECT SUM(pg_total_relation_size(relid)) FROM pg_partition_tree(to_regclass(t.name))
+						), 0)::bigint
+						FROM unnest($1::text[]) AS t(name)`
+	getLargestTerms = `SELECT t.term, p.postings
+						FROM (
+							SELECT term_id, COUNT(*) AS postings FROM postings
+							GROUP BY term_id ORDER BY postings DESC LIMIT $1
+						) p
+						JOIN terms t ON t.id = p.term_id
+						ORDER BY p.postings DESC`
+	// Duplicates are URLs equal but for case, a trailing slash, the scheme
+	// and "www.", as documents indexed before URLs were stored canonical can
+	// be; each group lists the most recently indexed document first.
+	verifyDuplicateURLs = `SELECT regexp_replace(lower(rtrim(url, '/')), '^https?://(www\.)?', ''), array_agg(id ORDER BY indexed_at DESC, id DESC)
+						FROM documents
+						WHERE deleted_at IS NULL
+						GROUP BY 1
+						HAVING COUNT(*) > 1
+						ORDER BY 1`
+	verifyPostingsMissingDocument = `SELECT DISTINCT p.doc_id FROM postings p
+						WHERE NOT EXISTS (SELECT 1 FROM documents d WHERE d.id = p.doc_id)
+						ORDER BY 1`
+	verifyPostingsMissingTerm = `SELECT DISTINCT p.term_id FROM postings p
+						WHERE NOT EXISTS (SELECT 1 FROM terms t WHERE t.id = p.term_id)
+						ORDER BY 1`
+	verifyDocsWithoutPostings = `SELECT d.id FROM documents d
+						WHERE d.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.doc_id = d.id)
+						ORDER BY 1`
+	// Every position holds at least one term, so a document's token count
+	// is the number of distinct positions in its postings. Its last position
+	// doesn't say, as fields are indexed with gaps between them.
+	verifyTokenCounts = `SELECT d.id, d.token_count, m.positions
+						FROM documents d
+						JOIN (
+							SELECT p.doc_id, COUNT(DISTINCT pos)::int AS positions
+							FROM postings p, unnest(p.positions) AS pos GROUP BY p.doc_id
+						) m ON m.doc_id = d.id
+						WHERE d.token_count <> m.positions AND d.deleted_at IS NULL
+						ORDER BY d.id`
+	repairPostingsMissingDocument = `WITH removed_postings AS (
+							DELETE FROM postings WHERE doc_id = ANY($1)
+						), removed_links AS (
+							DELETE FROM document_links WHERE doc_id = ANY($1)
+						), removed_passages AS (
+							DELETE FROM document_passages WHERE doc_id = ANY($1)
+						)
+						DELETE FROM stored_fields WHERE doc_id = ANY($1)`
+	repairPostingsMissingTerm = `DELETE FROM postings WHERE term_id = ANY($1)`
+	repairTokenCounts         = `UPDATE documents d SET token_count = v.n
+						FROM unnest($1::bigint[], $2::int[]) AS v(id, n)
+						WHERE d.id = v.id`
+	recomputeIndexStats = `UPDATE index_stats SET
+							total_tokens = (SELECT COALESCE(SUM(token_count), 0) FROM documents WHERE deleted_at IS NULL),
+							doc_count = (SELECT COUNT(*) FROM documents WHERE deleted_at IS NULL),
+							updated_at = NOW()
+						WHERE id = 1`
+	exportDocuments = `SELECT id, url, title, description, token_count, external_link_count, source_quality,
+							title_token_count, field_lengths, language, published_at, domain, deleted_at, indexed_at, position_gap
+						FROM documents ORDER BY id`
+	exportTerms              = `SELECT id, term, created_at FROM terms ORDER BY id`
+	exportPostings           = `SELECT term_id, doc_id, positions, offsets, fields, frequency FROM postings ORDER BY term_id, doc_id`
+	exportStoredFields       = `SELECT doc_id, field, body FROM stored_fields ORDER BY doc_id, field`
+	exportDocumentLinks      = `SELECT doc_id, host, link_count FROM document_links ORDER BY doc_id, host`
+	exportDocumentPassages   = `SELECT doc_id, paragraph_no, start_pos, end_pos, body FROM document_passages ORDER BY doc_id, paragraph_no`
+	exportDocumentAnchors    = `SELECT source_url, target_url, text FROM document_anchors ORDER BY source_url, target_url`
+	countDocuments           = `SELECT COUNT(*) FROM documents`
+	resetDocumentIDs         = `SELECT setval(pg_get_serial_sequence('documents', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM documents`
+	resetTermIDs             = `SELECT setval(pg_get_serial_sequence('terms', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM terms`
+	refreshTermFrequencyView = `REFRESH MATERIALIZED VIEW term_frequencies`
+	deleteDocumentAnchors    = `DELETE FROM document_anchors WHERE source_url = $1`
+	insertDocumentAnchor     = `INSERT INTO document_anchors (source_url, target_url, text) VALUES ($1, $2, $3)
+							ON CONFLICT (source_url, target_url) DO UPDATE SET text = EXCLUDED.text`
+	getInboundAnchors = `SELECT target_url, text FROM (
+							SELECT target_url, text,
+								row_number() OVER (PARTITION BY target_url ORDER BY COUNT(*) DESC, text) AS rank
+							FROM document_anchors
+							WHERE target_url = ANY($1::text[]) AND source_url <> target_url
+							GROUP BY target_url, text
+						) a
+						WHERE rank <= $2
+						ORDER BY target_url, rank`
+	getLiveDocumentsByURL = `SELECT id, url, source_quality, language, published_at, domain, indexed_at
+						FROM documents
+						WHERE url = ANY($1::text[]) AND deleted_at IS NULL`
+	refreshTermFrequenciesConcurrently = `REFRESH MATERIALIZED VIEW CONCURRENTLY term_frequencies`
+	// A btree entry takes its key's average width from pg_stats plus about
+	// 12 bytes of tuple header and item pointer, at the default 90% fill.
+	// Expression keys have no column stats and count as 8 bytes.
+	getIndexBloat = `WITH tables AS (
+							SELECT relid FROM pg_partition_tree('postings') WHERE isleaf
+							UNION
+							SELECT to_regclass(t) FROM unnest($1::text[]) AS t WHERE to_regclass(t) IS NOT NULL
+						)
+						SELECT i.indexrelid::regclass::text,
+							pg_relation_size(i.indexrelid),
+							GREATEST(ic.reltuples, 0)::float8 * (12 + COALESCE((
+								SELECT SUM(s.avg_width)
+								FROM pg_attribute a
+								JOIN pg_stats s ON s.schemaname = n.nspname AND s.tablename = t.relname AND s.attname = a.attname
+								WHERE a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+							), 8)) / 0.9
+						FROM pg_index i
+						JOIN tables ON tables.relid = i.indrelid
+						JOIN pg_class ic ON ic.oid = i.indexrelid
+						JOIN pg_class t ON t.oid = i.indrelid
+						JOIN pg_namespace n ON n.oid = t.relnamespace
+						WHERE ic.relkind = 'i' AND i.indisvalid
+						ORDER BY 2 DESC`
+	// A document expires ttl seconds after it was last indexed, ttl being
+	// that of the longest rule domain equal to or a parent of its own, else
+	// the global $3. A ttl of 0 never expires.
+	getExpiredDocuments = `SELECT d.id, d.url FROM documents d
+						CROSS JOIN LATERAL (
+							SELECT COALESCE((
+								SELECT r.ttl FROM unnest($1::text[], $2::bigint[]) AS r(domain, ttl)
+								WHERE d.domain = r.domain OR d.domain LIKE '%.' || r.domain
+								ORDER BY length(r.domain) DESC LIMIT 1
+							), $3::bigint) AS ttl
+						) t
+						WHERE d.deleted_at IS NULL AND t.ttl > 0
+							AND d.indexed_at < NOW() - make_interval(secs => t.ttl)
+						ORDER BY d.indexed_at
+						LIMIT $4`
 )
+
+var postingsStagingColumns = []string{"batch_id", "term_id", "doc_id", "positions", "offsets", "fields", "frequency"}
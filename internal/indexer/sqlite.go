@@ -0,0 +1,544 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/cache"
+	"github.com/amankumarsingh77/search_engine/internal/query"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"github.com/amankumarsingh77/search_engine/models"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed sqlite_schema.sql
+var sqliteSchema string
+
+// SQLiteStore keeps the index in a single SQLite file, in the tables of the
+// Postgres schema, so the whole pipeline runs on a laptop without a
+// database server. The file is in WAL mode: the indexer writes through one
+// connection while the search API reads it from another process. The
+// driver is cgo; without it the store fails to open.
+type SQLiteStore struct {
+	db        *sql.DB
+	termCache *cache.LRUCache
+}
+
+var (
+	_ Store       = (*SQLiteStore)(nil)
+	_ query.Index = (*SQLiteStore)(nil)
+)
+
+// OpenSQLiteStore opens the SQLite index in the file cfg.Path, creating it
+// and its tables unless readOnly is set, as for searching.
+func OpenSQLiteStore(cfg *config.IndexerConfig, readOnly bool) (*SQLiteStore, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("Path is empty in config")
+	}
+	dsn := "file:" + cfg.Path + "?_busy_timeout=5000"
+	if readOnly {
+		dsn += "&mode=ro"
+	} else {
+		// Transactions take the write lock up front, so a batch waits for
+		// another writer instead of failing when it upgrades.
+		dsn += "&_journal_mode=WAL&_synchronous=NORMAL&_txlock=immediate"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite index at %s: %w", cfg.Path, err)
+	}
+	if !readOnly {
+		// SQLite has one writer at a time; workers queue for the connection
+		// rather than for the file lock.
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec(sqliteSchema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create sqlite index schema: %w", err)
+		}
+	} else if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open sqlite index at %s: %w", cfg.Path, err)
+	}
+	return &SQLiteStore{db: db, termCache: newTermCache(cfg.TermCacheSize)}, nil
+}
+
+func (s *SQLiteStore) TermCacheStats() cache.Stats {
+	return s.termCache.Stats()
+}
+
+func (s *SQLiteStore) Close() {
+	if err := s.db.Close(); err != nil {
+		log.Printf("Failed to close sqlite index: %v", err)
+	}
+}
+
+// encodeInt32s serializes values as varints, for the array columns.
+func encodeInt32s(values []int32) []byte {
+	if values == nil {
+		return nil
+	}
+	buf := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		buf = binary.AppendVarint(buf, int64(v))
+	}
+	return buf
+}
+
+func decodeInt32s(data []byte) []int32 {
+	if data == nil {
+		return nil
+	}
+	values := make([]int32, 0, len(data))
+	for len(data) > 0 {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			break
+		}
+		values = append(values, int32(v))
+		data = data[n:]
+	}
+	return values
+}
+
+// unixSeconds is t as stored in a time column, NULL for nil.
+func unixSeconds(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+func timeFromUnix(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.Unix(v.Int64, 0).UTC()
+	return &t
+}
+
+// jsonArray encodes values for json_each.
+func jsonArray[T any](values []T) string {
+	if values == nil {
+		return "[]"
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds.
+func (s *SQLiteStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) InsertDocuments(ctx context.Context, docs []*models.WebPage) ([]int64, error) {
+	ids := make([]int64, len(docs))
+	now := time.Now()
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var delta StatsDelta
+		for i, doc := range docs {
+			r := newDocumentRecord(doc, now)
+			var previousTokens sql.NullInt64
+			if err := tx.QueryRowContext(ctx, sqliteGetDocumentTokens, r.URL).Scan(&previousTokens); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			err := tx.QueryRowContext(ctx, sqliteInsertDocument, r.URL, r.Title, r.Description, r.TokenCount, r.ExternalLinkCount,
+				r.SourceQuality, r.TitleTokenCount, encodeInt32s(r.FieldLengths), r.Language, unixSeconds(r.PublishedAt), r.Domain,
+				r.PositionGap, r.IndexedAt.Unix()).Scan(&ids[i])
+			if err != nil {
+				return err
+			}
+			delta.Tokens += int64(doc.TokenCount)
+			if previousTokens.Valid {
+				delta.Tokens -= previousTokens.Int64
+			} else {
+				delta.Docs++
+			}
+		}
+		if _, err := tx.ExecContext(ctx, sqliteUpdateIndexStats, delta.Tokens, delta.Docs); err != nil {
+			return fmt.Errorf("failed to update index stats: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *SQLiteStore) UpsertTerms(ctx context.Context, terms []string) (map[string]int64, error) {
+	termMap := make(map[string]int64)
+	var missingTerms []string
+	for _, term := range terms {
+		if id, ok := s.termCache.Get(term); ok {
+			termMap[term] = id.(int64)
+		} else {
+			missingTerms = append(missingTerms, term)
+		}
+	}
+	if len(missingTerms) == 0 {
+		return termMap, nil
+	}
+	sort.Strings(missingTerms)
+
+	loaded := make(map[string]int64, len(missingTerms))
+	now := time.Now().Unix()
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, term := range missingTerms {
+			if _, err := tx.ExecContext(ctx, sqliteInsertTerm, term, now); err != nil {
+				return fmt.Errorf("error inserting terms: %w", err)
+			}
+			var id int64
+			if err := tx.QueryRowContext(ctx, sqliteGetTermID, term).Scan(&id); err != nil {
+				return fmt.Errorf("error fetching term IDs: %w", err)
+			}
+			loaded[term] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for term, id := range loaded {
+		termMap[term] = id
+		s.termCache.Put(term, id)
+	}
+	return termMap, nil
+}
+
+// InsertPosting replaces the postings of the batch's documents, dropping
+// those of terms a document no longer holds.
+func (s *SQLiteStore) InsertPosting(
+	ctx context.Context,
+	termMap map[string]int64,
+	docIDs []int64,
+	docs []*models.WebPage,
+	occurrences map[string]map[int][]Occurrence,
+) error {
+	allPostings := buildPostings(termMap, docIDs, docs, occurrences)
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, docID := range docIDs {
+			if _, err := tx.ExecContext(ctx, sqliteDeletePostings, docID); err != nil {
+				return fmt.Errorf("error replacing postings: %w", err)
+			}
+		}
+		stmt, err := tx.PrepareContext(ctx, sqliteInsertPosting)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, p := range allPostings {
+			if _, err := stmt.ExecContext(ctx, p.termID, p.docID, encodeInt32s(p.positions), encodeInt32s(p.offsets), p.fields, len(p.positions)); err != nil {
+				return fmt.Errorf("error inserting posting: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) ReplaceDocumentLinks(ctx context.Context, docIDs []int64, docs []*models.WebPage) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for i, doc := range docs {
+			if _, err := tx.ExecContext(ctx, sqliteDeleteDocumentLinks, docIDs[i]); err != nil {
+				return err
+			}
+			for host, count := range linkHostCounts(doc.ExternalLinks) {
+				if _, err := tx.ExecContext(ctx, sqliteInsertDocumentLink, docIDs[i], textproc.RemoveInvalidUTF8(host), count); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error storing document links: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ReplaceDocumentPassages(ctx context.Context, docIDs []int64, passages map[int][]Passage) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, docID := range docIDs {
+			if _, err := tx.ExecContext(ctx, sqliteDeleteDocumentPassages, docID); err != nil {
+				return err
+			}
+		}
+		for docIdx, docPassages := range passages {
+			for _, p := range docPassages {
+				if _, err := tx.ExecContext(ctx, sqliteInsertDocumentPassage, docIDs[docIdx], p.No, p.Start, p.End, textproc.RemoveInvalidUTF8(p.Text)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error storing document passages: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ReplaceStoredBodies(ctx context.Context, docIDs []int64, bodies map[int]string) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for docIdx, body := range bodies {
+			if _, err := tx.ExecContext(ctx, sqliteUpsertStoredField, docIDs[docIdx], textproc.FieldBody, truncateUTF8(body, maxStoredBody)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error storing document bodies: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ReplaceAnchors(ctx context.Context, docs []*models.WebPage) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, doc := range docs {
+			source := canonicalURL(doc.URL)
+			if _, err := tx.ExecContext(ctx, sqliteDeleteDocumentAnchors, source); err != nil {
+				return err
+			}
+			if doc.NoFollow || doc.IsErrorStatus() {
+				continue
+			}
+			for _, a := range doc.Anchors {
+				if _, err := tx.ExecContext(ctx, sqliteInsertDocumentAnchor, source, canonicalURL(a.URL), textproc.RemoveInvalidUTF8(a.Text)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error storing anchors: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) InboundAnchors(ctx context.Context, urls []string) (map[string][]string, error) {
+	anchors := make(map[string][]string)
+	for _, target := range urls {
+		rows, err := s.db.QueryContext(ctx, sqliteGetInboundAnchors, target, maxInboundAnchors)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch anchors: %w", err)
+		}
+		for rows.Next() {
+			var text string
+			if err := rows.Scan(&text); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to read anchor: %w", err)
+			}
+			anchors[target] = append(anchors[target], text)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch anchors: %w", err)
+		}
+	}
+	return anchors, nil
+}
+
+// DeleteDocuments tombstones documents like Storage.DeleteDocuments.
+func (s *SQLiteStore) DeleteDocuments(ctx context.Context, urls []string, ids []int64) ([]int64, error) {
+	canonical := make([]string, len(urls))
+	for i, u := range urls {
+		canonical[i] = canonicalURL(u)
+	}
+	var deleted []int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, sqliteTombstoneDocuments, jsonArray(canonical), jsonArray(ids))
+		if err != nil {
+			return err
+		}
+		var delta StatsDelta
+		for rows.Next() {
+			var id, tokens int64
+			if err := rows.Scan(&id, &tokens); err != nil {
+				rows.Close()
+				return err
+			}
+			deleted = append(deleted, id)
+			delta.Docs--
+			delta.Tokens -= tokens
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, sqliteUpdateIndexStats, delta.Tokens, delta.Docs)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	return deleted, nil
+}
+
+// PurgeDeletedDocuments removes up to limit tombstoned documents, oldest
+// deletion first, with their postings, links, passages, stored fields and
+// the anchors of their links.
+func (s *SQLiteStore) PurgeDeletedDocuments(ctx context.Context, limit int) (int64, error) {
+	if limit <= 0 {
+		limit = defaultPurgeBatchSize
+	}
+	var purged int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, sqliteGetPurgeableDocuments, limit)
+		if err != nil {
+			return err
+		}
+		var doomed []ExpiredDocument
+		for rows.Next() {
+			var d ExpiredDocument
+			if err := rows.Scan(&d.ID, &d.URL); err != nil {
+				rows.Close()
+				return err
+			}
+			doomed = append(doomed, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, d := range doomed {
+			for _, stmt := range []string{sqlitePurgeDocument, sqliteDeletePostings, sqliteDeleteDocumentLinks, sqliteDeleteDocumentPassages, sqlitePurgeFields} {
+				if _, err := tx.ExecContext(ctx, stmt, d.ID); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.ExecContext(ctx, sqliteDeleteDocumentAnchors, d.URL); err != nil {
+				return err
+			}
+		}
+		purged = int64(len(doomed))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted documents: %w", err)
+	}
+	return purged, nil
+}
+
+func (s *SQLiteStore) ExpireDocuments(ctx context.Context, rules ExpiryRules, limit int) ([]ExpiredDocument, error) {
+	if limit <= 0 {
+		limit = defaultExpireBatchSize
+	}
+	shortest := rules.TTL
+	for _, ttl := range rules.Domains {
+		if ttl > 0 && (shortest <= 0 || ttl < shortest) {
+			shortest = ttl
+		}
+	}
+	if shortest <= 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	rows, err := s.db.QueryContext(ctx, sqliteGetExpiryCandidates, now.Add(-shortest).Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired documents: %w", err)
+	}
+	var expired []ExpiredDocument
+	for rows.Next() && len(expired) < limit {
+		var d ExpiredDocument
+		var domain sql.NullString
+		var indexedAt int64
+		if err := rows.Scan(&d.ID, &d.URL, &domain, &indexedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read expired document: %w", err)
+		}
+		if ttl := rules.ttlFor(domain.String); ttl > 0 && time.Unix(indexedAt, 0).Before(now.Add(-ttl)) {
+			expired = append(expired, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find expired documents: %w", err)
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	urls := make(map[int64]string, len(expired))
+	ids := make([]int64, len(expired))
+	for i, d := range expired {
+		urls[d.ID] = d.URL
+		ids[i] = d.ID
+	}
+	deleted, err := s.DeleteDocuments(ctx, nil, ids)
+	docs := make([]ExpiredDocument, len(deleted))
+	for i, id := range deleted {
+		docs[i] = ExpiredDocument{ID: id, URL: urls[id]}
+	}
+	return docs, err
+}
+
+// RefreshTermFrequencies rebuilds the term_frequencies table.
+func (s *SQLiteStore) RefreshTermFrequencies(ctx context.Context) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, sqliteClearTermFrequencies); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, sqliteRefreshTermFrequencies)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh term frequencies: %w", err)
+	}
+	return nil
+}
+
+// Analyze updates the query planner's statistics of the index tables.
+func (s *SQLiteStore) Analyze(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "ANALYZE"); err != nil {
+		return fmt.Errorf("failed to analyze sqlite index: %w", err)
+	}
+	return nil
+}
+
+// RebuildBloatedIndexes vacuums the file once the pages freed by deletes
+// and rewrites pass opts, and returns it as the one bloated index. VACUUM
+// rewrites the whole file, blocking writers while it runs.
+func (s *SQLiteStore) RebuildBloatedIndexes(ctx context.Context, opts ReindexOptions) ([]IndexBloat, error) {
+	if opts.BloatRatio <= 0 {
+		opts.BloatRatio = defaultReindexBloatRatio
+	}
+	if opts.MinBytes <= 0 {
+		opts.MinBytes = defaultReindexMinBytes
+	}
+	var pages, free, pageSize int64
+	for pragma, dest := range map[string]*int64{"page_count": &pages, "freelist_count": &free, "page_size": &pageSize} {
+		if err := s.db.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(dest); err != nil {
+			return nil, fmt.Errorf("failed to estimate sqlite bloat: %w", err)
+		}
+	}
+	bloat := IndexBloat{Name: "sqlite", Bytes: pages * pageSize, EstimatedBytes: (pages - free) * pageSize}
+	if bloat.EstimatedBytes > 0 {
+		bloat.Ratio = float64(bloat.Bytes) / float64(bloat.EstimatedBytes)
+	}
+	if bloat.Bytes < opts.MinBytes || bloat.Ratio < opts.BloatRatio {
+		return nil, nil
+	}
+	if _, err := s.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return nil, fmt.Errorf("failed to vacuum sqlite index: %w", err)
+	}
+	return []IndexBloat{bloat}, nil
+}
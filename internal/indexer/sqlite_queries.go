@@ -0,0 +1,149 @@
+package indexer
+
+// Statements of SQLiteStore. Lists of ids and terms are passed as JSON
+// arrays and read with json_each, as Postgres reads arrays with ANY.
+const (
+	sqliteGetDocumentTokens = `SELECT token_count FROM documents WHERE url = ? AND deleted_at IS NULL`
+	sqliteInsertDocument    = `INSERT INTO documents (url, title, description, token_count, external_link_count, source_quality, title_token_count, field_lengths,
+							language, published_at, domain, position_gap, indexed_at)
+						VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+						ON CONFLICT(url) DO UPDATE SET
+							title = excluded.title,
+							description = excluded.description,
+							token_count = excluded.token_count,
+							external_link_count = excluded.external_link_count,
+							source_quality = excluded.source_quality,
+							title_token_count = excluded.title_token_count,
+							field_lengths = excluded.field_lengths,
+							language = excluded.language,
+							published_at = excluded.published_at,
+							domain = excluded.domain,
+							position_gap = excluded.position_gap,
+							deleted_at = NULL,
+							indexed_at = excluded.indexed_at
+						RETURNING id`
+	sqliteInsertTerm     = `INSERT INTO terms (term, created_at) VALUES (?, ?) ON CONFLICT(term) DO NOTHING`
+	sqliteGetTermID      = `SELECT id FROM terms WHERE term = ?`
+	sqliteDeletePostings = `DELETE FROM postings WHERE doc_id = ?`
+	sqliteInsertPosting  = `INSERT INTO postings (term_id, doc_id, positions, offsets, fields, frequency)
+						VALUES (?, ?, ?, ?, ?, ?)`
+	sqliteDeleteDocumentLinks = `DELETE FROM document_links WHERE doc_id = ?`
+	sqliteInsertDocumentLink  = `INSERT INTO document_links (doc_id, host, link_count) VALUES (?, ?, ?)
+						ON CONFLICT(doc_id, host) DO UPDATE SET link_count = link_count + excluded.link_count`
+	sqliteDeleteDocumentPassages = `DELETE FROM document_passages WHERE doc_id = ?`
+	sqliteInsertDocumentPassage  = `INSERT INTO document_passages (doc_id, paragraph_no, start_pos, end_pos, body) VALUES (?, ?, ?, ?, ?)`
+	sqliteUpsertStoredField      = `INSERT INTO stored_fields (doc_id, field, body) VALUES (?, ?, ?)
+						ON CONFLICT(doc_id, field) DO UPDATE SET body = excluded.body`
+	sqliteDeleteDocumentAnchors = `DELETE FROM document_anchors WHERE source_url = ?`
+	sqliteInsertDocumentAnchor  = `INSERT INTO document_anchors (source_url, target_url, text) VALUES (?, ?, ?)
+						ON CONFLICT(source_url, target_url) DO UPDATE SET text = excluded.text`
+	sqliteGetInboundAnchors = `SELECT text FROM document_anchors
+						WHERE target_url = ? AND source_url <> target_url
+						GROUP BY text
+						ORDER BY COUNT(*) DESC, text
+						LIMIT ?`
+	sqliteUpdateIndexStats = `UPDATE index_stats SET
+							total_tokens = total_tokens + ?,
+							doc_count = doc_count + ?,
+							updated_at = unixepoch()
+						WHERE id = 1`
+	sqliteTombstoneDocuments = `UPDATE documents SET deleted_at = unixepoch()
+						WHERE (url IN (SELECT value FROM json_each(?)) OR id IN (SELECT value FROM json_each(?)))
+							AND deleted_at IS NULL
+						RETURNING id, token_count`
+	sqliteGetPurgeableDocuments = `SELECT id, url FROM documents WHERE deleted_at IS NOT NULL
+						ORDER BY deleted_at, id LIMIT ?`
+	sqlitePurgeDocument = `DELETE FROM documents WHERE id = ?`
+	sqlitePurgeFields   = `DELETE FROM stored_fields WHERE doc_id = ?`
+	// sqliteGetExpiryCandidates returns the live documents indexed before
+	// the cutoff of the shortest TTL, oldest first; their own TTLs are
+	// checked against their domains in Go.
+	sqliteGetExpiryCandidates = `SELECT id, url, domain, indexed_at FROM documents
+						WHERE deleted_at IS NULL AND indexed_at < ?
+						ORDER BY indexed_at`
+	sqliteClearTermFrequencies   = `DELETE FROM term_frequencies`
+	sqliteRefreshTermFrequencies = `INSERT INTO term_frequencies (term_id, doc_frequency, total_frequency)
+						SELECT term_id, COUNT(*), SUM(frequency) FROM postings GROUP BY term_id`
+
+	sqliteGetIndexStats = `SELECT doc_count, CAST(total_tokens AS REAL) / NULLIF(doc_count, 0)
+						FROM index_stats WHERE id = 1`
+	sqliteGetTopTerms = `SELECT term_id FROM postings
+						GROUP BY term_id
+						ORDER BY SUM(frequency) DESC
+						LIMIT ?`
+	sqliteGetTermsBatch = `SELECT term, id FROM terms
+						WHERE term IN (SELECT value FROM json_each(?))`
+	sqliteGetPostingsBatch = `SELECT term_id, doc_id, positions, fields FROM postings
+						WHERE term_id IN (SELECT value FROM json_each(?))`
+	sqliteGetDocFrequencyBatch = `SELECT p.term_id, COUNT(*) FROM postings p
+						JOIN documents d ON d.id = p.doc_id
+						WHERE p.term_id IN (SELECT value FROM json_each(?)) AND d.deleted_at IS NULL
+						GROUP BY p.term_id`
+	sqliteGetSpellVocabulary = `SELECT t.term, tf.doc_frequency
+						FROM term_frequencies tf
+						JOIN terms t ON t.id = tf.term_id
+						ORDER BY tf.doc_frequency DESC
+						LIMIT ?`
+	sqliteGetTermDocFrequencies = `SELECT t.term, tf.doc_frequency
+						FROM terms t
+						JOIN term_frequencies tf ON tf.term_id = t.id
+						WHERE t.term IN (SELECT value FROM json_each(?))`
+	// ?1 and ?2 are the term ids and their groups, ?4 the documents to keep
+	// or NULL, and ?5 the site or NULL. The domain is the site or ends in
+	// "." and the site.
+	sqliteGetBooleanIntersection = `
+		WITH query_terms AS (
+			SELECT t.value AS term_id, g.value AS grp
+			FROM json_each(?1) t JOIN json_each(?2) g ON g.key = t.key
+		),
+		doc_group_counts AS (
+			SELECT p.doc_id, COUNT(DISTINCT q.grp) AS group_count
+			FROM postings p
+			JOIN query_terms q ON q.term_id = p.term_id
+			WHERE ?4 IS NULL OR p.doc_id IN (SELECT value FROM json_each(?4))
+			GROUP BY p.doc_id
+		)
+		SELECT g.doc_id
+		FROM doc_group_counts g
+		JOIN documents d ON d.id = g.doc_id
+		WHERE g.group_count >= ?3
+			AND (?5 IS NULL OR d.domain = ?5 OR substr(d.domain, -length(?5) - 1) = '.' || ?5)`
+	sqliteGetSiteDocs = `SELECT id FROM documents
+						WHERE id IN (SELECT value FROM json_each(?1))
+							AND (domain = ?2 OR substr(domain, -length(?2) - 1) = '.' || ?2)`
+	sqliteGetLinksToFilteredDocs = `SELECT DISTINCT doc_id FROM document_links
+						WHERE host = ?1 OR substr(host, -length(?1) - 1) = '.' || ?1`
+	sqliteGetPublishedFilteredDocs = `SELECT id FROM documents
+						WHERE published_at IS NOT NULL
+							AND (?1 IS NULL OR published_at < ?1)
+							AND (?2 IS NULL OR published_at >= ?2)`
+	sqliteGetLanguageFilteredDocs = `SELECT id FROM documents WHERE language = ?`
+	sqliteGetURLFilteredDocs      = `SELECT id FROM documents WHERE instr(lower(url), ?) > 0`
+	sqliteGetFieldFilteredDocs    = `
+		WITH query_terms AS (
+			SELECT t.value AS term_id, g.value AS grp
+			FROM json_each(?1) t JOIN json_each(?2) g ON g.key = t.key
+		)
+		SELECT p.doc_id
+		FROM postings p
+		JOIN query_terms q ON q.term_id = p.term_id
+		WHERE p.fields & ?4 <> 0
+		GROUP BY p.doc_id
+		HAVING COUNT(DISTINCT q.grp) = ?3`
+	sqliteGetDocumentLengthsBatch = `SELECT id, token_count, source_quality, title_token_count, field_lengths, position_gap, published_at
+						FROM documents
+						WHERE id IN (SELECT value FROM json_each(?)) AND deleted_at IS NULL`
+	sqliteGetPositionsBatch = `SELECT doc_id, term_id, positions, offsets FROM postings
+						WHERE doc_id IN (SELECT value FROM json_each(?)) AND term_id IN (SELECT value FROM json_each(?))`
+	sqliteGetStoredBodiesBatch = `SELECT doc_id, body FROM stored_fields
+						WHERE doc_id IN (SELECT value FROM json_each(?)) AND field = ?`
+	sqliteGetPassagesBatch = `SELECT doc_id, paragraph_no, start_pos, end_pos, body
+						FROM document_passages
+						WHERE doc_id IN (SELECT value FROM json_each(?))
+						ORDER BY doc_id, paragraph_no`
+	sqliteGetDocumentsBatch = `SELECT d.id, d.url, d.title, d.description, d.token_count, d.source_quality
+						FROM json_each(?) j
+						JOIN documents d ON d.id = j.value
+						WHERE d.deleted_at IS NULL
+						ORDER BY j.key`
+)
@@ -0,0 +1,92 @@
+-- The SQLite index: the tables of the Postgres schema as plain tables.
+-- Integer arrays (positions, offsets, field_lengths) are BLOBs of varints
+-- and times are unix seconds. Every statement is idempotent; the schema is
+-- applied whenever the indexer opens the file.
+CREATE TABLE IF NOT EXISTS documents (
+    id                  INTEGER PRIMARY KEY,
+    url                 TEXT NOT NULL UNIQUE,
+    title               TEXT NOT NULL DEFAULT '',
+    description         TEXT NOT NULL DEFAULT '',
+    token_count         INTEGER NOT NULL DEFAULT 0,
+    external_link_count INTEGER NOT NULL DEFAULT 0,
+    source_quality      REAL NOT NULL DEFAULT 1,
+    title_token_count   INTEGER NOT NULL DEFAULT 0,
+    field_lengths       BLOB,
+    language            TEXT,
+    published_at        INTEGER,
+    domain              TEXT,
+    position_gap        INTEGER NOT NULL DEFAULT 0,
+    deleted_at          INTEGER,
+    indexed_at          INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_documents_domain ON documents(domain);
+CREATE INDEX IF NOT EXISTS idx_documents_language ON documents(language) WHERE language IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_published_at ON documents(published_at) WHERE published_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_deleted_at ON documents(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_indexed_at ON documents(indexed_at);
+
+CREATE TABLE IF NOT EXISTS terms (
+    id         INTEGER PRIMARY KEY,
+    term       TEXT NOT NULL UNIQUE,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+    term_id   INTEGER NOT NULL,
+    doc_id    INTEGER NOT NULL,
+    positions BLOB NOT NULL,
+    offsets   BLOB,
+    fields    INTEGER NOT NULL DEFAULT 0,
+    frequency INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (term_id, doc_id)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS idx_postings_doc_term ON postings(doc_id, term_id);
+
+-- Stands in for the term_frequencies materialized view, rebuilt by
+-- RefreshTermFrequencies.
+CREATE TABLE IF NOT EXISTS term_frequencies (
+    term_id         INTEGER PRIMARY KEY,
+    doc_frequency   INTEGER NOT NULL,
+    total_frequency INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_term_frequencies_doc_frequency ON term_frequencies(doc_frequency DESC);
+
+CREATE TABLE IF NOT EXISTS index_stats (
+    id           INTEGER PRIMARY KEY,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    doc_count    INTEGER NOT NULL DEFAULT 0,
+    updated_at   INTEGER NOT NULL DEFAULT 0
+);
+INSERT OR IGNORE INTO index_stats (id) VALUES (1);
+
+CREATE TABLE IF NOT EXISTS stored_fields (
+    doc_id INTEGER NOT NULL,
+    field  TEXT NOT NULL,
+    body   TEXT NOT NULL,
+    PRIMARY KEY (doc_id, field)
+);
+
+CREATE TABLE IF NOT EXISTS document_links (
+    doc_id     INTEGER NOT NULL,
+    host       TEXT NOT NULL,
+    link_count INTEGER NOT NULL,
+    PRIMARY KEY (doc_id, host)
+);
+CREATE INDEX IF NOT EXISTS idx_document_links_host ON document_links(host);
+
+CREATE TABLE IF NOT EXISTS document_passages (
+    doc_id       INTEGER NOT NULL,
+    paragraph_no INTEGER NOT NULL,
+    start_pos    INTEGER NOT NULL,
+    end_pos      INTEGER NOT NULL,
+    body         TEXT NOT NULL,
+    PRIMARY KEY (doc_id, paragraph_no)
+);
+
+CREATE TABLE IF NOT EXISTS document_anchors (
+    source_url TEXT NOT NULL,
+    target_url TEXT NOT NULL,
+    text       TEXT NOT NULL,
+    PRIMARY KEY (source_url, target_url)
+);
+CREATE INDEX IF NOT EXISTS idx_document_anchors_target ON document_anchors(target_url);
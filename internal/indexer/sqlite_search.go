@@ -0,0 +1,277 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/internal/query"
+)
+
+// The query.Index methods of SQLiteStore, which serve the query engine
+// from the SQLite file.
+
+func (s *SQLiteStore) Stats(ctx context.Context) (int64, float64, error) {
+	var docs int64
+	var avgTokens sql.NullFloat64
+	if err := s.db.QueryRowContext(ctx, sqliteGetIndexStats).Scan(&docs, &avgTokens); err != nil {
+		return 0, 0, fmt.Errorf("failed to read index stats: %w", err)
+	}
+	return docs, avgTokens.Float64, nil
+}
+
+func (s *SQLiteStore) TopTerms(ctx context.Context, n int) ([]int64, error) {
+	return s.queryIDs(ctx, sqliteGetTopTerms, n)
+}
+
+func (s *SQLiteStore) TermIDs(ctx context.Context, terms []string) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetTermsBatch, jsonArray(terms))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int64, len(terms))
+	for rows.Next() {
+		var term string
+		var id int64
+		if err := rows.Scan(&term, &id); err != nil {
+			continue
+		}
+		ids[term] = id
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLiteStore) Postings(ctx context.Context, termIDs []int64) (map[int64][]query.Posting, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetPostingsBatch, jsonArray(termIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	postingsByTerm := make(map[int64][]query.Posting)
+	for rows.Next() {
+		var termID int64
+		var p query.Posting
+		var positions []byte
+		if err := rows.Scan(&termID, &p.DocID, &positions, &p.Fields); err != nil {
+			continue
+		}
+		p.Positions = decodeInt32s(positions)
+		postingsByTerm[termID] = append(postingsByTerm[termID], p)
+	}
+	return postingsByTerm, rows.Err()
+}
+
+func (s *SQLiteStore) DocFrequencies(ctx context.Context, termIDs []int64) (map[int64]int64, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetDocFrequencyBatch, jsonArray(termIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docFreqs := make(map[int64]int64, len(termIDs))
+	for rows.Next() {
+		var termID, docFreq int64
+		if err := rows.Scan(&termID, &docFreq); err != nil {
+			continue
+		}
+		docFreqs[termID] = docFreq
+	}
+	return docFreqs, rows.Err()
+}
+
+// TermDocFrequencies and Vocabulary read the term_frequencies table, which
+// the indexer refreshes.
+func (s *SQLiteStore) TermDocFrequencies(ctx context.Context, terms []string) (map[string]int64, error) {
+	return s.queryTermCounts(ctx, sqliteGetTermDocFrequencies, jsonArray(terms))
+}
+
+func (s *SQLiteStore) Vocabulary(ctx context.Context, n int) (map[string]int64, error) {
+	return s.queryTermCounts(ctx, sqliteGetSpellVocabulary, n)
+}
+
+func (s *SQLiteStore) queryTermCounts(ctx context.Context, stmt string, args ...any) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var term string
+		var count int64
+		if err := rows.Scan(&term, &count); err != nil {
+			continue
+		}
+		counts[term] = count
+	}
+	return counts, rows.Err()
+}
+
+func (s *SQLiteStore) MatchGroups(ctx context.Context, q query.GroupMatch) ([]int64, error) {
+	var within, site any
+	if q.Within != nil {
+		within = jsonArray(q.Within)
+	}
+	if q.Site != "" {
+		site = q.Site
+	}
+	return s.queryIDs(ctx, sqliteGetBooleanIntersection, jsonArray(q.TermIDs), jsonArray(q.Groups), q.Need, within, site)
+}
+
+func (s *SQLiteStore) OnSite(ctx context.Context, docIDs []int64, domain string) ([]int64, error) {
+	return s.queryIDs(ctx, sqliteGetSiteDocs, jsonArray(docIDs), domain)
+}
+
+func (s *SQLiteStore) LinkingTo(ctx context.Context, host string) ([]int64, error) {
+	return s.queryIDs(ctx, sqliteGetLinksToFilteredDocs, host)
+}
+
+func (s *SQLiteStore) PublishedBetween(ctx context.Context, before, after *time.Time) ([]int64, error) {
+	return s.queryIDs(ctx, sqliteGetPublishedFilteredDocs, unixSeconds(before), unixSeconds(after))
+}
+
+func (s *SQLiteStore) InLanguage(ctx context.Context, lang string) ([]int64, error) {
+	return s.queryIDs(ctx, sqliteGetLanguageFilteredDocs, lang)
+}
+
+func (s *SQLiteStore) URLContains(ctx context.Context, part string) ([]int64, error) {
+	return s.queryIDs(ctx, sqliteGetURLFilteredDocs, part)
+}
+
+func (s *SQLiteStore) InFields(ctx context.Context, termIDs []int64, groups []int, need int, fields int16) ([]int64, error) {
+	return s.queryIDs(ctx, sqliteGetFieldFilteredDocs, jsonArray(termIDs), jsonArray(groups), need, fields)
+}
+
+func (s *SQLiteStore) queryIDs(ctx context.Context, stmt string, args ...any) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLiteStore) DocumentLengths(ctx context.Context, docIDs []int64) ([]query.DocumentLength, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetDocumentLengthsBatch, jsonArray(docIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lengths := make([]query.DocumentLength, 0, len(docIDs))
+	for rows.Next() {
+		var d query.DocumentLength
+		var fieldLengths []byte
+		var published sql.NullInt64
+		if err := rows.Scan(&d.DocID, &d.TokenCount, &d.SourceQuality, &d.TitleTokenCount, &fieldLengths, &d.PositionGap, &published); err != nil {
+			continue
+		}
+		d.FieldLengths = decodeInt32s(fieldLengths)
+		d.PublishedAt = timeFromUnix(published)
+		lengths = append(lengths, d)
+	}
+	return lengths, rows.Err()
+}
+
+func (s *SQLiteStore) Positions(ctx context.Context, docIDs, termIDs []int64) ([]query.DocumentPosting, error) {
+	return s.documentPostings(ctx, docIDs, termIDs, false)
+}
+
+// Offsets leaves out postings stored without offsets, as Postgres does.
+func (s *SQLiteStore) Offsets(ctx context.Context, docIDs, termIDs []int64) ([]query.DocumentPosting, error) {
+	return s.documentPostings(ctx, docIDs, termIDs, true)
+}
+
+func (s *SQLiteStore) documentPostings(ctx context.Context, docIDs, termIDs []int64, withOffsets bool) ([]query.DocumentPosting, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetPositionsBatch, jsonArray(docIDs), jsonArray(termIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var postings []query.DocumentPosting
+	for rows.Next() {
+		var p query.DocumentPosting
+		var positions, offsets []byte
+		if err := rows.Scan(&p.DocID, &p.TermID, &positions, &offsets); err != nil {
+			continue
+		}
+		if withOffsets && offsets == nil {
+			continue
+		}
+		p.Positions = decodeInt32s(positions)
+		p.Offsets = decodeInt32s(offsets)
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+func (s *SQLiteStore) StoredFields(ctx context.Context, docIDs []int64, field string) (map[int64]string, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetStoredBodiesBatch, jsonArray(docIDs), field)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bodies := make(map[int64]string, len(docIDs))
+	for rows.Next() {
+		var docID int64
+		var text string
+		if err := rows.Scan(&docID, &text); err != nil {
+			continue
+		}
+		bodies[docID] = text
+	}
+	return bodies, rows.Err()
+}
+
+func (s *SQLiteStore) Passages(ctx context.Context, docIDs []int64) (map[int64][]query.Passage, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetPassagesBatch, jsonArray(docIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passagesByDoc := make(map[int64][]query.Passage, len(docIDs))
+	for rows.Next() {
+		var docID int64
+		var p query.Passage
+		if err := rows.Scan(&docID, &p.No, &p.Start, &p.End, &p.Body); err != nil {
+			continue
+		}
+		passagesByDoc[docID] = append(passagesByDoc[docID], p)
+	}
+	return passagesByDoc, rows.Err()
+}
+
+func (s *SQLiteStore) Documents(ctx context.Context, docIDs []int64) ([]query.DocumentDetail, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteGetDocumentsBatch, jsonArray(docIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := make([]query.DocumentDetail, 0, len(docIDs))
+	for rows.Next() {
+		var detail query.DocumentDetail
+		if err := rows.Scan(&detail.ID, &detail.URL, &detail.Title, &detail.Description, &detail.TokenCount, &detail.SourceQuality); err != nil {
+			continue
+		}
+		docs = append(docs, detail)
+	}
+	return docs, rows.Err()
+}
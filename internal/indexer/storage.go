@@ -5,18 +5,46 @@ import (
 	"fmt"
 	"log"
 	"sort"
-	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/cache"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
 	"github.com/amankumarsingh77/search_engine/models"
+	"github.com/exaring/otelpgx"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// maxStoredBody caps the body text kept for snippets; matches past it are
+// still indexed but can't be shown.
+const maxStoredBody = 64 << 10
+
+// migrationTimeout bounds schema upgrades on startup; backfills on a large
+// index can take a while.
+const migrationTimeout = 30 * time.Minute
+
+// defaultTermCacheSize bounds the term ids kept in memory; a vocabulary
+// larger than this falls back to the terms table for its rarer terms.
+const defaultTermCacheSize = 500000
+
 type Storage struct {
 	pool      *pgxpool.Pool
-	termCache sync.Map
+	termCache *cache.LRUCache
+	bulkLoad  bool
+}
+
+func newTermCache(size int) *cache.LRUCache {
+	if size <= 0 {
+		size = defaultTermCacheSize
+	}
+	return cache.NewLRUCache(size, 0)
+}
+
+// TermCacheStats reports how well the term id cache is doing.
+func (s *Storage) TermCacheStats() cache.Stats {
+	return s.termCache.Stats()
 }
 
 func NewPostgresClient(cfg *config.IndexerConfig) (*Storage, error) {
@@ -31,6 +59,7 @@ func NewPostgresClient(cfg *config.IndexerConfig) (*Storage, error) {
 
 	pgConfig.MaxConns = int32(cfg.Workers * 2)
 	pgConfig.MinConns = 1
+	pgConfig.ConnConfig.Tracer = otelpgx.NewTracer()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -40,38 +69,114 @@ func NewPostgresClient(cfg *config.IndexerConfig) (*Storage, error) {
 		return nil, fmt.Errorf("failed to create PostgreSQL connection pool: %w", err)
 	}
 
-	return &Storage{
-		pool: pool,
-	}, nil
+	storage := &Storage{
+		pool:      pool,
+		termCache: newTermCache(cfg.TermCacheSize),
+		bulkLoad:  cfg.BulkLoad,
+	}
+	// The schema is brought up to date on every start; the migrate mode
+	// only makes it explicit.
+	migrateCtx, migrateCancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer migrateCancel()
+	applied, err := storage.Migrate(migrateCtx)
+	for _, m := range applied {
+		log.Printf("Applied index migration %d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return storage, nil
+}
+
+// StatsDelta is the change in corpus size caused by a batch of document upserts.
+type StatsDelta struct {
+	Tokens int64
+	Docs   int64
 }
 
+// InsertDocuments upserts docs and applies their change to the index stats
+// in the same transaction, so the stats can't drift from the documents.
 func (s *Storage) InsertDocuments(ctx context.Context, docs []*models.WebPage) ([]int64, error) {
+	var delta StatsDelta
 	ids := make([]int64, len(docs))
 	batch := &pgx.Batch{}
 
 	for _, doc := range docs {
-		url := removeInvalidUTF8(doc.URL)
-		title := removeInvalidUTF8(doc.Title)
-		desc := removeInvalidUTF8(doc.Description)
-		batch.Queue(insertDocuments, url, title, desc, doc.TokenCount)
+		r := newDocumentRecord(doc, time.Time{})
+		batch.Queue(insertDocuments, r.URL, r.Title, r.Description, r.TokenCount, r.ExternalLinkCount, r.SourceQuality, r.TitleTokenCount, r.FieldLengths,
+			r.Language, r.PublishedAt, r.Domain, r.PositionGap)
 	}
 
-	res := s.pool.SendBatch(ctx, batch)
-	defer res.Close()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
 
-	for i := range docs {
-		if err := res.QueryRow().Scan(&ids[i]); err != nil {
+	res := tx.SendBatch(ctx, batch)
+	for i, doc := range docs {
+		var previousTokens *int64
+		if err := res.QueryRow().Scan(&ids[i], &previousTokens); err != nil {
+			res.Close()
 			return nil, err
 		}
+		delta.Tokens += int64(doc.TokenCount)
+		if previousTokens != nil {
+			delta.Tokens -= *previousTokens
+		} else {
+			delta.Docs++
+		}
+	}
+	if err := res.Close(); err != nil {
+		return nil, err
+	}
+	if delta.Tokens != 0 || delta.Docs != 0 {
+		if _, err := tx.Exec(ctx, updateIndexStats, delta.Tokens, delta.Docs); err != nil {
+			return nil, fmt.Errorf("failed to update index stats: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
 	}
 	return ids, nil
 }
 
+// newDocumentRecord returns the documents row of doc, as last indexed at
+// indexedAt and without its id.
+func newDocumentRecord(doc *models.WebPage, indexedAt time.Time) dumpDocument {
+	r := dumpDocument{
+		URL:               canonicalURL(doc.URL),
+		Title:             textproc.RemoveInvalidUTF8(doc.Title),
+		Description:       textproc.RemoveInvalidUTF8(doc.Description),
+		TokenCount:        int32(doc.TokenCount),
+		ExternalLinkCount: int32(len(doc.ExternalLinks)),
+		SourceQuality:     float32(doc.SourceQuality),
+		TitleTokenCount:   int32(doc.TitleTokenCount),
+		FieldLengths:      doc.FieldLengths,
+		PositionGap:       doc.PositionGap,
+		IndexedAt:         indexedAt,
+	}
+	if r.SourceQuality <= 0 {
+		r.SourceQuality = 1
+	}
+	if doc.LanguageHint != "" {
+		r.Language = &doc.LanguageHint
+	}
+	if doc.Structured != nil {
+		r.PublishedAt = doc.Structured.PublishedTime
+	}
+	if host := linkHost(r.URL); host != "" {
+		r.Domain = &host
+	}
+	return r
+}
+
 func (s *Storage) UpsertTerms(ctx context.Context, terms []string) (map[string]int64, error) {
 	termMap := make(map[string]int64)
 	var missingTerms []string
 	for _, term := range terms {
-		if id, ok := s.termCache.Load(term); ok {
+		if id, ok := s.termCache.Get(term); ok {
 			termMap[term] = id.(int64)
 		} else {
 			missingTerms = append(missingTerms, term)
@@ -96,26 +201,58 @@ func (s *Storage) UpsertTerms(ctx context.Context, terms []string) (map[string]i
 			return nil, err
 		}
 		termMap[term] = id
-		s.termCache.Store(term, id)
+		s.termCache.Put(term, id)
 	}
 	return termMap, nil
 }
 
+// posting is one row of the postings table.
+type posting struct {
+	termID    int64
+	docID     int64
+	positions []int32
+	offsets   []int32
+	fields    int16
+}
+
 func (s *Storage) InsertPosting(
 	ctx context.Context,
 	termMap map[string]int64,
 	docIDs []int64,
-	positions map[string]map[int][]int,
+	docs []*models.WebPage,
+	occurrences map[string]map[int][]Occurrence,
 ) error {
-	const maxBatchSize = 1000
-	type posting struct {
-		termID    int64
-		docID     int64
-		positions []int32
+	allPostings := buildPostings(termMap, docIDs, docs, occurrences)
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin posting load: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The batch's documents are posted to exactly their current terms, so
+	// a term a re-indexed page no longer holds stops matching it.
+	if _, err := tx.Exec(ctx, deleteDocumentPostings, docIDs); err != nil {
+		return fmt.Errorf("failed to delete stale postings: %w", err)
+	}
+	if s.bulkLoad {
+		err = s.copyPostings(ctx, tx, allPostings)
+	} else {
+		err = s.insertPostings(ctx, tx, allPostings)
 	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit posting load: %w", err)
+	}
+	return nil
+}
 
+// buildPostings turns a batch's occurrences into postings, ordered by term
+// and then document.
+func buildPostings(termMap map[string]int64, docIDs []int64, docs []*models.WebPage, occurrences map[string]map[int][]Occurrence) []posting {
 	var allPostings []posting
-	for term, docPositions := range positions {
+	for term, docPositions := range occurrences {
 		termID, ok := termMap[term]
 		if !ok {
 			continue
@@ -129,14 +266,20 @@ func (s *Storage) InsertPosting(
 
 			docID := docIDs[docIdx]
 			int32Positions := make([]int32, len(pos))
-			for i, p := range pos {
-				int32Positions[i] = int32(p)
+			offsets := make([]int32, 0, 2*len(pos))
+			var fields int16
+			for i, o := range pos {
+				int32Positions[i] = int32(o.Pos)
+				offsets = append(offsets, o.Span.Start, o.Span.End)
+				fields |= 1 << textproc.FieldAt(docs[docIdx].FieldLengths, docs[docIdx].PositionGap, int32(o.Pos))
 			}
 
 			allPostings = append(allPostings, posting{
 				termID:    termID,
 				docID:     docID,
 				positions: int32Positions,
+				offsets:   offsets,
+				fields:    fields,
 			})
 		}
 	}
@@ -147,7 +290,11 @@ func (s *Storage) InsertPosting(
 		}
 		return allPostings[i].termID < allPostings[j].termID
 	})
+	return allPostings
+}
 
+func (s *Storage) insertPostings(ctx context.Context, tx pgx.Tx, allPostings []posting) error {
+	const maxBatchSize = 1000
 	for i := 0; i < len(allPostings); i += maxBatchSize {
 		end := i + maxBatchSize
 		if end > len(allPostings) {
@@ -156,10 +303,10 @@ func (s *Storage) InsertPosting(
 
 		batch := &pgx.Batch{}
 		for _, p := range allPostings[i:end] {
-			batch.Queue(insertPostings, p.termID, p.docID, p.positions)
+			batch.Queue(insertPostings, p.termID, p.docID, p.positions, p.offsets, p.fields, len(p.positions))
 		}
 
-		results := s.pool.SendBatch(ctx, batch)
+		results := tx.SendBatch(ctx, batch)
 		for j := 0; j < batch.Len(); j++ {
 			if _, err := results.Exec(); err != nil {
 				results.Close()
@@ -174,6 +321,105 @@ func (s *Storage) InsertPosting(
 	return nil
 }
 
+// copyPostings streams postings into the unlogged staging table with COPY and
+// merges them into postings with a single statement, within tx. Each call
+// stages under its own batch id, so concurrent workers share the table
+// without seeing each other's rows.
+func (s *Storage) copyPostings(ctx context.Context, tx pgx.Tx, allPostings []posting) error {
+	if len(allPostings) == 0 {
+		return nil
+	}
+	var batchID int64
+	if err := tx.QueryRow(ctx, nextStagingBatch).Scan(&batchID); err != nil {
+		return fmt.Errorf("failed to allocate staging batch: %w", err)
+	}
+	rows := pgx.CopyFromSlice(len(allPostings), func(i int) ([]any, error) {
+		p := allPostings[i]
+		return []any{batchID, p.termID, p.docID, p.positions, p.offsets, p.fields, int32(len(p.positions))}, nil
+	})
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"postings_staging"}, postingsStagingColumns, rows); err != nil {
+		return fmt.Errorf("failed to copy postings: %w", err)
+	}
+	if _, err := tx.Exec(ctx, mergeStagedPostings, batchID); err != nil {
+		return fmt.Errorf("failed to merge staged postings: %w", err)
+	}
+	if _, err := tx.Exec(ctx, clearStagedPostings, batchID); err != nil {
+		return fmt.Errorf("failed to clear staged postings: %w", err)
+	}
+	return nil
+}
+
+// ReplaceDocumentLinks stores the deduplicated external hosts each document
+// links to, replacing whatever was recorded for it on a previous indexing run.
+func (s *Storage) ReplaceDocumentLinks(ctx context.Context, docIDs []int64, docs []*models.WebPage) error {
+	batch := &pgx.Batch{}
+	batch.Queue(deleteDocumentLinks, docIDs)
+	for i, doc := range docs {
+		for host, count := range linkHostCounts(doc.ExternalLinks) {
+			batch.Queue(insertDocumentLink, docIDs[i], textproc.RemoveInvalidUTF8(host), count)
+		}
+	}
+
+	results := s.pool.SendBatch(ctx, batch)
+	defer results.Close()
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			return fmt.Errorf("error storing document links: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReplaceDocumentPassages stores the paragraph ranges of each document,
+// replacing those from any previous indexing run.
+func (s *Storage) ReplaceDocumentPassages(ctx context.Context, docIDs []int64, passages map[int][]Passage) error {
+	batch := &pgx.Batch{}
+	batch.Queue(deleteDocumentPassages, docIDs)
+	for docIdx, docPassages := range passages {
+		for _, p := range docPassages {
+			batch.Queue(insertDocumentPassage, docIDs[docIdx], p.No, p.Start, p.End, textproc.RemoveInvalidUTF8(p.Text))
+		}
+	}
+
+	results := s.pool.SendBatch(ctx, batch)
+	defer results.Close()
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			return fmt.Errorf("error storing document passages: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReplaceStoredBodies stores the body text each document was indexed from,
+// cut to maxStoredBody bytes, for query-time snippets.
+func (s *Storage) ReplaceStoredBodies(ctx context.Context, docIDs []int64, bodies map[int]string) error {
+	batch := &pgx.Batch{}
+	for docIdx, body := range bodies {
+		batch.Queue(upsertStoredField, docIDs[docIdx], textproc.FieldBody, truncateUTF8(body, maxStoredBody))
+	}
+
+	results := s.pool.SendBatch(ctx, batch)
+	defer results.Close()
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			return fmt.Errorf("error storing document bodies: %w", err)
+		}
+	}
+	return nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
 func (s *Storage) Close() {
 	s.pool.Close()
 }
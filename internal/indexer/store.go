@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/cache"
+	"github.com/amankumarsingh77/search_engine/internal/query"
+	"github.com/amankumarsingh77/search_engine/models"
+)
+
+// Index backends. Postgres is the default; Badger and SQLite embed the
+// index in a directory or a file, for running without a database server.
+const (
+	BackendPostgres = "postgres"
+	BackendBadger   = "badger"
+	BackendSQLite   = "sqlite"
+)
+
+// Store is what the indexer writes a batch through. Storage, backed by
+// Postgres, BadgerStore and SQLiteStore implement it; another backend only has to keep
+// ids stable per URL and term and return them in the order given.
+type Store interface {
+	// InsertDocuments upserts docs by URL and returns their ids in order.
+	// The index stats take the change in the same write, so they can't
+	// drift from the documents if the batch fails later on.
+	InsertDocuments(ctx context.Context, docs []*models.WebPage) ([]int64, error)
+	// UpsertTerms returns the id of every term, creating missing ones.
+	UpsertTerms(ctx context.Context, terms []string) (map[string]int64, error)
+	// InsertPosting writes the postings of a batch; occurrences are keyed
+	// by term, then by the document's index in docs.
+	InsertPosting(ctx context.Context, termMap map[string]int64, docIDs []int64, docs []*models.WebPage, occurrences map[string]map[int][]Occurrence) error
+	ReplaceDocumentLinks(ctx context.Context, docIDs []int64, docs []*models.WebPage) error
+	ReplaceDocumentPassages(ctx context.Context, docIDs []int64, passages map[int][]Passage) error
+	ReplaceStoredBodies(ctx context.Context, docIDs []int64, bodies map[int]string) error
+	ReplaceAnchors(ctx context.Context, docs []*models.WebPage) error
+	InboundAnchors(ctx context.Context, urls []string) (map[string][]string, error)
+	PurgeDeletedDocuments(ctx context.Context, limit int) (int64, error)
+	ExpireDocuments(ctx context.Context, rules ExpiryRules, limit int) ([]ExpiredDocument, error)
+	RefreshTermFrequencies(ctx context.Context) error
+	Analyze(ctx context.Context) error
+	RebuildBloatedIndexes(ctx context.Context, opts ReindexOptions) ([]IndexBloat, error)
+	TermCacheStats() cache.Stats
+	Close()
+}
+
+var _ Store = (*Storage)(nil)
+
+// OpenStore opens the index backend named by cfg.Backend.
+func OpenStore(cfg *config.IndexerConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", BackendPostgres:
+		return NewPostgresClient(cfg)
+	case BackendBadger:
+		return OpenBadgerStore(cfg, false)
+	case BackendSQLite:
+		return OpenSQLiteStore(cfg, false)
+	default:
+		return nil, unsupportedBackend(cfg.Backend)
+	}
+}
+
+// SearchIndex is an embedded backend opened for the query engine.
+type SearchIndex interface {
+	query.Index
+	Close()
+}
+
+// OpenSearchIndex opens the embedded backend named by cfg.Backend for
+// searching. Postgres is searched through query.PostgresIndex on the
+// server's own pool instead.
+func OpenSearchIndex(cfg *config.IndexerConfig) (SearchIndex, error) {
+	switch cfg.Backend {
+	case BackendBadger:
+		return OpenBadgerStore(cfg, true)
+	case BackendSQLite:
+		return OpenSQLiteStore(cfg, true)
+	default:
+		return nil, unsupportedBackend(cfg.Backend)
+	}
+}
+
+func unsupportedBackend(name string) error {
+	return fmt.Errorf("unsupported index backend %q, expected %q, %q or %q", name, BackendPostgres, BackendBadger, BackendSQLite)
+}
@@ -0,0 +1,195 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/models"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultPageStreamKey matches the crawler's default.
+	defaultPageStreamKey   = "crawled_pages"
+	defaultStreamGroup     = "indexer"
+	defaultStreamBlock     = 5 * time.Second
+	defaultStreamReadCount = 100
+)
+
+// StreamConsumer feeds an Indexer from the Redis Stream the crawler
+// publishes pages to. Entries are acknowledged through the indexer's
+// checkpoint, once the page and every page read before it are committed, so
+// a consumer that restarts under the same name re-reads what it hadn't
+// committed before taking new pages.
+type StreamConsumer struct {
+	client   *redis.Client
+	key      string
+	group    string
+	consumer string
+	block    time.Duration
+	count    int64
+
+	mu sync.Mutex
+	// unacked lists the pages handed to the indexer and not yet
+	// acknowledged, in read order.
+	unacked []streamEntry
+}
+
+type streamEntry struct {
+	page    primitive.ObjectID
+	message string
+}
+
+func NewStreamConsumer(client *redis.Client, cfg *config.StreamConfig, batchSize int) *StreamConsumer {
+	key := defaultPageStreamKey
+	if cfg.Key != "" {
+		key = cfg.Key
+	}
+	group := defaultStreamGroup
+	if cfg.Group != "" {
+		group = cfg.Group
+	}
+	consumer := cfg.Consumer
+	if consumer == "" {
+		consumer, _ = os.Hostname()
+	}
+	if consumer == "" {
+		consumer = "indexer"
+	}
+	block := defaultStreamBlock
+	if cfg.Block > 0 {
+		block = cfg.Block
+	}
+	count := int64(defaultStreamReadCount)
+	if batchSize > 0 {
+		count = int64(batchSize)
+	}
+	return &StreamConsumer{
+		client:   client,
+		key:      key,
+		group:    group,
+		consumer: consumer,
+		block:    block,
+		count:    count,
+	}
+}
+
+// Run feeds idx until ctx is cancelled, then returns ctx's error; the caller
+// closes idx afterwards. idx's checkpoint must be set to Ack.
+func (c *StreamConsumer) Run(ctx context.Context, idx *Indexer) error {
+	err := c.client.XGroupCreateMkStream(ctx, c.key, c.group, "0").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s: %w", c.group, err)
+	}
+
+	// Until start is ">", reads return this consumer's entries that were
+	// delivered but never acknowledged, after the id in start. They stay
+	// pending until their batch is flushed, so start moves past each read;
+	// once a read returns none, ">" waits for new ones.
+	start := "0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		block := c.block
+		if start != ">" {
+			block = -1
+		}
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.key, start},
+			Count:    c.count,
+			Block:    block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Failed to read the page stream: %v", err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		var messages []redis.XMessage
+		for _, s := range streams {
+			messages = append(messages, s.Messages...)
+		}
+		if start != ">" {
+			if len(messages) == 0 {
+				start = ">"
+				continue
+			}
+			start = messages[len(messages)-1].ID
+		}
+		for _, msg := range messages {
+			if err := c.add(ctx, idx, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// add queues one entry's page. Entries that aren't a page are acknowledged
+// and dropped, so they aren't read back forever.
+func (c *StreamConsumer) add(ctx context.Context, idx *Indexer, msg redis.XMessage) error {
+	var page models.WebPage
+	data, _ := msg.Values["page"].(string)
+	if err := json.Unmarshal([]byte(data), &page); err != nil {
+		log.Printf("Dropping stream entry %s: %v", msg.ID, err)
+		return c.client.XAck(ctx, c.key, c.group, msg.ID).Err()
+	}
+	// The checkpoint tracks pages by id; a page the crawler couldn't store
+	// in Mongo has none, so it gets one for the ride.
+	if page.ID.IsZero() {
+		page.ID = primitive.NewObjectID()
+	}
+	c.mu.Lock()
+	c.unacked = append(c.unacked, streamEntry{page: page.ID, message: msg.ID})
+	c.mu.Unlock()
+	for {
+		err := idx.AddDocument(ctx, page)
+		if errors.Is(err, ErrQueueFull) {
+			log.Printf("Indexer is falling behind, retrying: %v", err)
+			continue
+		}
+		return err
+	}
+}
+
+// Ack acknowledges the entries up to and including the page with id, which
+// the indexer has committed along with every page read before it.
+func (c *StreamConsumer) Ack(ctx context.Context, id primitive.ObjectID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := -1
+	for i, e := range c.unacked {
+		if e.page == id {
+			n = i + 1
+			break
+		}
+	}
+	if n < 0 {
+		return nil
+	}
+	ids := make([]string, n)
+	for i, e := range c.unacked[:n] {
+		ids[i] = e.message
+	}
+	if err := c.client.XAck(ctx, c.key, c.group, ids...).Err(); err != nil {
+		return fmt.Errorf("failed to acknowledge %d stream entries: %w", n, err)
+	}
+	c.unacked = c.unacked[n:]
+	return nil
+}
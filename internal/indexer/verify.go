@@ -0,0 +1,218 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+)
+
+const defaultVerifySamples = 20
+
+// Names of the checks Verify runs.
+const (
+	CheckDocsWithoutPostings     = "documents_without_postings"
+	CheckPostingsMissingDocument = "postings_missing_document"
+	CheckPostingsMissingTerm     = "postings_missing_term"
+	CheckTokenCountMismatch      = "token_count_mismatch"
+	CheckDuplicateURLs           = "duplicate_urls"
+)
+
+// VerifyReport is the outcome of cross-checking the index. Each check says
+// what it found, a few examples, and what repairing it does.
+type VerifyReport struct {
+	Checks []*VerifyCheck `json:"checks"`
+	// Repaired is set when Verify was asked to apply the repairs.
+	Repaired bool `json:"repaired"`
+}
+
+// Problems is the number of problems found across all checks.
+func (r *VerifyReport) Problems() int {
+	total := 0
+	for _, c := range r.Checks {
+		total += c.Count
+	}
+	return total
+}
+
+type VerifyCheck struct {
+	Name     string   `json:"name"`
+	Count    int      `json:"count"`
+	Samples  []string `json:"samples,omitempty"`
+	Repair   string   `json:"repair"`
+	Repaired int      `json:"repaired,omitempty"`
+}
+
+// VerifyOptions sets how many examples each check lists and whether the
+// repairs are applied.
+type VerifyOptions struct {
+	Samples int
+	Repair  bool
+}
+
+// Verify cross-checks documents, terms and postings against each other. With
+// opts.Repair it also fixes what it found and recomputes the index stats;
+// the ids of deleted documents are returned so cached results can be
+// invalidated. Several checks scan all of postings, so run it off-peak.
+func (s *Storage) Verify(ctx context.Context, opts VerifyOptions) (*VerifyReport, []int64, error) {
+	if opts.Samples <= 0 {
+		opts.Samples = defaultVerifySamples
+	}
+	report := &VerifyReport{Repaired: opts.Repair}
+	check := func(name, repair string, count int, sample func(i int) string) *VerifyCheck {
+		c := &VerifyCheck{Name: name, Count: count, Repair: repair}
+		for i := 0; i < count && i < opts.Samples; i++ {
+			c.Samples = append(c.Samples, sample(i))
+		}
+		report.Checks = append(report.Checks, c)
+		return c
+	}
+
+	dupGroups, err := s.queryIDGroups(ctx, verifyDuplicateURLs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find duplicate urls: %w", err)
+	}
+	var dupIDs []int64
+	for _, g := range dupGroups {
+		dupIDs = append(dupIDs, g.ids[1:]...)
+	}
+	dupCheck := check(CheckDuplicateURLs, "keep the most recently indexed document of each URL and delete the others", len(dupGroups), func(i int) string {
+		return fmt.Sprintf("%s: documents %v", dupGroups[i].key, dupGroups[i].ids)
+	})
+
+	missingDocs, err := s.queryIDs(ctx, verifyPostingsMissingDocument)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find postings without documents: %w", err)
+	}
+	missingDocCheck := check(CheckPostingsMissingDocument, "delete the postings, links, passages and stored fields of the missing documents", len(missingDocs), func(i int) string {
+		return fmt.Sprintf("document %d", missingDocs[i])
+	})
+
+	missingTerms, err := s.queryIDs(ctx, verifyPostingsMissingTerm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find postings without terms: %w", err)
+	}
+	missingTermCheck := check(CheckPostingsMissingTerm, "delete the postings of the missing terms", len(missingTerms), func(i int) string {
+		return fmt.Sprintf("term %d", missingTerms[i])
+	})
+
+	empty, err := s.queryIDs(ctx, verifyDocsWithoutPostings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find documents without postings: %w", err)
+	}
+	emptyCheck := check(CheckDocsWithoutPostings, "delete the documents, which no query can match; re-crawl them to index them again", len(empty), func(i int) string {
+		return fmt.Sprintf("document %d", empty[i])
+	})
+
+	type mismatch struct {
+		id                   int64
+		tokenCount, expected int32
+	}
+	var mismatches []mismatch
+	rows, err := s.pool.Query(ctx, verifyTokenCounts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compare token counts: %w", err)
+	}
+	for rows.Next() {
+		var m mismatch
+		if err := rows.Scan(&m.id, &m.tokenCount, &m.expected); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to read token count: %w", err)
+		}
+		mismatches = append(mismatches, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to compare token counts: %w", err)
+	}
+	mismatchCheck := check(CheckTokenCountMismatch, "set token_count to the number of positions in the document's postings", len(mismatches), func(i int) string {
+		m := mismatches[i]
+		return fmt.Sprintf("document %d: token_count %d, postings hold %d positions", m.id, m.tokenCount, m.expected)
+	})
+
+	if !opts.Repair || report.Problems() == 0 {
+		return report, nil, nil
+	}
+
+	var deleted []int64
+	if len(dupIDs) > 0 {
+		ids, err := s.DeleteDocuments(ctx, nil, dupIDs)
+		deleted = append(deleted, ids...)
+		if err != nil {
+			return report, deleted, err
+		}
+		dupCheck.Repaired = len(dupGroups)
+	}
+	if len(missingDocs) > 0 {
+		if _, err := s.pool.Exec(ctx, repairPostingsMissingDocument, missingDocs); err != nil {
+			return report, deleted, fmt.Errorf("failed to delete postings without documents: %w", err)
+		}
+		missingDocCheck.Repaired = len(missingDocs)
+	}
+	if len(missingTerms) > 0 {
+		if _, err := s.pool.Exec(ctx, repairPostingsMissingTerm, missingTerms); err != nil {
+			return report, deleted, fmt.Errorf("failed to delete postings without terms: %w", err)
+		}
+		missingTermCheck.Repaired = len(missingTerms)
+	}
+	if len(empty) > 0 {
+		ids, err := s.DeleteDocuments(ctx, nil, empty)
+		deleted = append(deleted, ids...)
+		if err != nil {
+			return report, deleted, err
+		}
+		emptyCheck.Repaired = len(empty)
+	}
+	if len(mismatches) > 0 {
+		ids := make([]int64, len(mismatches))
+		counts := make([]int32, len(mismatches))
+		for i, m := range mismatches {
+			ids[i], counts[i] = m.id, m.expected
+		}
+		if _, err := s.pool.Exec(ctx, repairTokenCounts, ids, counts); err != nil {
+			return report, deleted, fmt.Errorf("failed to fix token counts: %w", err)
+		}
+		mismatchCheck.Repaired = len(mismatches)
+	}
+	if _, err := s.pool.Exec(ctx, recomputeIndexStats); err != nil {
+		return report, deleted, fmt.Errorf("failed to recompute index stats: %w", err)
+	}
+	return report, deleted, nil
+}
+
+func (s *Storage) queryIDs(ctx context.Context, sql string) ([]int64, error) {
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+type idGroup struct {
+	key string
+	ids []int64
+}
+
+func (s *Storage) queryIDGroups(ctx context.Context, sql string) ([]idGroup, error) {
+	rows, err := s.pool.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []idGroup
+	for rows.Next() {
+		var g idGroup
+		if err := rows.Scan(&g.key, &g.ids); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
@@ -0,0 +1,145 @@
+package lemmatizer
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"strings"
+)
+
+//go:embed data/*.txt
+var tables embed.FS
+
+// detachment rules in the spirit of WordNet's morphy: a suffix is only
+// stripped when the result is a known lemma, so unknown words are left alone.
+// Inflected suffixes may also have doubled the final consonant ("stopped") or
+// dropped a final e ("hated"), which Lemma undoes.
+var suffixRules = []struct {
+	suffix      string
+	replacement string
+	inflected   bool
+}{
+	{"ies", "y", false},
+	{"ches", "ch", false},
+	{"shes", "sh", false},
+	{"sses", "ss", false},
+	{"xes", "x", false},
+	{"zes", "z", false},
+	{"men", "man", false},
+	{"s", "", false},
+	{"es", "", false},
+	{"ied", "y", false},
+	{"ier", "y", false},
+	{"iest", "y", false},
+	{"ed", "", true},
+	{"ing", "", true},
+	{"er", "", true},
+	{"est", "", true},
+}
+
+type Lemmatizer struct {
+	lang   string
+	table  map[string]string
+	lemmas map[string]bool
+}
+
+func New(lang string) (*Lemmatizer, error) {
+	if lang == "" {
+		lang = "en"
+	}
+	f, err := tables.Open("data/" + lang + ".txt")
+	if err != nil {
+		return nil, fmt.Errorf("no lemma table for language %q, available: %s", lang, strings.Join(Languages(), ", "))
+	}
+	defer f.Close()
+
+	l := &Lemmatizer{
+		lang:   lang,
+		table:  make(map[string]string),
+		lemmas: make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			l.lemmas[fields[0]] = true
+		case 2:
+			l.table[fields[0]] = fields[1]
+			l.lemmas[fields[1]] = true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read lemma table: %w", err)
+	}
+	return l, nil
+}
+
+// Languages returns the languages that have a lemma table.
+func Languages() []string {
+	entries, _ := tables.ReadDir("data")
+	langs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		langs = append(langs, strings.TrimSuffix(e.Name(), ".txt"))
+	}
+	return langs
+}
+
+func (l *Lemmatizer) Lang() string {
+	return l.lang
+}
+
+func (l *Lemmatizer) Lemma(token string) string {
+	if lemma, ok := l.table[token]; ok {
+		return lemma
+	}
+	if l.lemmas[token] {
+		return token
+	}
+	for _, rule := range suffixRules {
+		if !strings.HasSuffix(token, rule.suffix) || len(token) <= len(rule.suffix)+1 {
+			continue
+		}
+		stem := strings.TrimSuffix(token, rule.suffix) + rule.replacement
+		candidates := []string{stem}
+		if rule.inflected {
+			candidates = inflectedStems(stem)
+		}
+		for _, candidate := range candidates {
+			if l.lemmas[candidate] {
+				return candidate
+			}
+		}
+	}
+	return token
+}
+
+// inflectedStems returns the lemmas stem may come from once an -ed, -ing, -er
+// or -est is stripped, likeliest first: "stopp" from stop, "hat" from hate
+// before hat, "walk" from walk before walke.
+func inflectedStems(stem string) []string {
+	n := len(stem)
+	if n >= 2 && stem[n-1] == stem[n-2] && !isVowel(stem[n-1]) && stem[n-1] != 's' && stem[n-1] != 'l' {
+		return []string{stem, stem[:n-1]}
+	}
+	if n >= 3 && !isVowel(stem[n-1]) && isVowel(stem[n-2]) && !isVowel(stem[n-3]) && !strings.ContainsRune("wxy", rune(stem[n-1])) {
+		return []string{stem + "e", stem}
+	}
+	return []string{stem, stem + "e"}
+}
+
+func isVowel(c byte) bool {
+	return strings.IndexByte("aeiou", c) >= 0
+}
+
+func (l *Lemmatizer) LemmatizeTokens(tokens []string) []string {
+	res := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		res = append(res, l.Lemma(token))
+	}
+	return res
+}
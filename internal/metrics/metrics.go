@@ -0,0 +1,288 @@
+// Package metrics is a small, dependency-free implementation of Prometheus
+// counters, gauges and histograms with label support, exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency buckets in seconds suited to HTTP fetches.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Write renders every registered metric to w.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func labelString(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		parts = append(parts, name+"="+strconv.Quote(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Value is a single float series used by counters and gauges.
+type Value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *Value) Add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *Value) Inc() {
+	v.Add(1)
+}
+
+func (v *Value) Set(value float64) {
+	v.mu.Lock()
+	v.v = value
+	v.mu.Unlock()
+}
+
+func (v *Value) Get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+type valueVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*Value
+	values map[string][]string
+}
+
+func (vv *valueVec) with(values []string) *Value {
+	key := vv.key(values)
+	vv.mu.Lock()
+	defer vv.mu.Unlock()
+	v, ok := vv.series[key]
+	if !ok {
+		v = &Value{}
+		vv.series[key] = v
+		vv.values[key] = append([]string(nil), values...)
+	}
+	return v
+}
+
+func (vv *valueVec) reset() {
+	vv.mu.Lock()
+	vv.series = make(map[string]*Value)
+	vv.values = make(map[string][]string)
+	vv.mu.Unlock()
+}
+
+func (vv *valueVec) write(w io.Writer) {
+	vv.header(w)
+	vv.mu.Lock()
+	defer vv.mu.Unlock()
+	keys := make([]string, 0, len(vv.series))
+	for k := range vv.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", vv.name, labelString(vv.labels, vv.values[k]), formatFloat(vv.series[k].Get()))
+	}
+}
+
+func newValueVec(kind, name, help string, labels []string) *valueVec {
+	return &valueVec{
+		desc:   desc{name: name, help: help, kind: kind, labels: labels},
+		series: make(map[string]*Value),
+		values: make(map[string][]string),
+	}
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels.
+type CounterVec struct {
+	*valueVec
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newValueVec("counter", name, help, labels)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) WithLabelValues(values ...string) *Value {
+	return c.with(values)
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct {
+	*valueVec
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newValueVec("gauge", name, help, labels)}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) WithLabelValues(values ...string) *Value {
+	return g.with(values)
+}
+
+// Reset drops every series, for gauges whose label set is rebuilt on each
+// refresh (e.g. per-worker queue sizes after workers are removed).
+func (g *GaugeVec) Reset() {
+	g.reset()
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*Histogram
+	values  map[string][]string
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: sorted,
+		series:  make(map[string]*Histogram),
+		values:  make(map[string][]string),
+	}
+	r.register(h)
+	return h
+}
+
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	key := hv.key(values)
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	h, ok := hv.series[key]
+	if !ok {
+		h = &Histogram{buckets: hv.buckets, counts: make([]uint64, len(hv.buckets))}
+		hv.series[key] = h
+		hv.values[key] = append([]string(nil), values...)
+	}
+	return h
+}
+
+func (hv *HistogramVec) write(w io.Writer) {
+	hv.header(w)
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	keys := make([]string, 0, len(hv.series))
+	for k := range hv.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := hv.series[k]
+		values := hv.values[k]
+		h.mu.Lock()
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, labelString(hv.labels, values, "le", formatFloat(upper)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, labelString(hv.labels, values, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, labelString(hv.labels, values), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, labelString(hv.labels, values), h.count)
+		h.mu.Unlock()
+	}
+}
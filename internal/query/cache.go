@@ -1,127 +1,98 @@
 package query
 
 import (
-	"container/list"
-	"sync"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
-type cacheItem struct {
-	key       interface{}
-	value     interface{}
-	expiresAt time.Time
-}
+const (
+	defaultResultCacheSize = 1000
+	defaultResultCacheTTL  = 2 * time.Minute
+)
 
-type LRUCache struct {
-	capacity int
-	ttl      time.Duration
-	cache    map[interface{}]*list.Element
-	list     *list.List
-	mu       sync.RWMutex
+type cachedResult struct {
+	results    []SearchResult
+	total      int
+	suggestion string
+	cachedAt   time.Time
 }
 
-func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
-	c := &LRUCache{
-		capacity: capacity,
-		ttl:      ttl,
-		cache:    make(map[interface{}]*list.Element),
-		list:     list.New(),
-	}
-
-	go c.cleanup()
-	return c
+// cachedRanking is a plan's ranked matches, in order up to sorted. It is
+// never modified once cached; extend returns a new one.
+type cachedRanking struct {
+	docs   []ScoredDoc
+	sorted int
 }
 
-func (c *LRUCache) Get(key interface{}) (interface{}, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if elem, ok := c.cache[key]; ok {
-		item := elem.Value.(*cacheItem)
-		if c.ttl > 0 && time.Now().After(item.expiresAt) {
-			c.removeElement(elem)
-			return nil, false
-		}
-
-		c.list.MoveToFront(elem)
-		return item.value, true
+// extend returns the ranking ordered up to end.
+func (r *cachedRanking) extend(end int, less func(a, b ScoredDoc) bool) *cachedRanking {
+	tail := topScored(slices.Clone(r.docs[r.sorted:]), end-r.sorted, less)
+	return &cachedRanking{
+		docs:   append(slices.Clone(r.docs[:r.sorted]), tail...),
+		sorted: min(end, len(r.docs)),
 	}
-	return nil, false
 }
 
-func (c *LRUCache) Put(key, value interface{}) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	now := time.Now()
-	expiresAt := now.Add(c.ttl)
-	if c.ttl == 0 {
-		expiresAt = time.Time{}
-	}
-
-	if elem, ok := c.cache[key]; ok {
-		c.list.MoveToFront(elem)
-		item := elem.Value.(*cacheItem)
-		item.value = value
-		item.expiresAt = expiresAt
-		return
+// cacheKey identifies what the plan matches and how it ranks them, so
+// queries that differ only in spacing, operator spelling or the page they
+// ask for share a ranking.
+func (p *QueryPlan) cacheKey() string {
+	var b strings.Builder
+	p.root.writeKey(&b)
+	names := make([]string, 0, len(p.filters))
+	for name := range p.filters {
+		names = append(names, name)
 	}
-
-	if c.list.Len() >= c.capacity {
-		elem := c.list.Back()
-		if elem != nil {
-			c.removeElement(elem)
-		}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "|%s:%s", name, p.filters[name])
 	}
-
-	item := &cacheItem{key, value, expiresAt}
-	elem := c.list.PushFront(item)
-	c.cache[key] = elem
-}
-
-func (c *LRUCache) removeElement(elem *list.Element) {
-	delete(c.cache, elem.Value.(*cacheItem).key)
-	c.list.Remove(elem)
+	fmt.Fprintf(&b, "|in=%s|sort=%s|mm=%s|ranker=%s", p.field, p.sort, p.minShouldMatch, p.ranker)
+	return b.String()
 }
 
-func (c *LRUCache) cleanup() {
-	if c.ttl == 0 {
+// writeKey writes n in a form that tells apart every distinct tree.
+func (n *queryNode) writeKey(b *strings.Builder) {
+	if n == nil {
 		return
 	}
-
-	ticker := time.NewTicker(c.ttl / 2)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		c.mu.Lock()
-		now := time.Now()
-		var toRemove []*list.Element
-
-		for elem := c.list.Back(); elem != nil; elem = elem.Prev() {
-			item := elem.Value.(*cacheItem)
-			if now.After(item.expiresAt) {
-				toRemove = append(toRemove, elem)
-			} else {
-				break
+	switch n.kind {
+	case nodeTerm, nodePhrase, nodeNear:
+		fmt.Fprintf(b, "%d~%d[", n.kind, n.slop)
+		for i, terms := range n.groups {
+			if i > 0 {
+				b.WriteByte(' ')
 			}
+			b.WriteString(strings.Join(terms, ","))
 		}
-
-		for _, elem := range toRemove {
-			c.removeElement(elem)
+		b.WriteByte(']')
+	default:
+		fmt.Fprintf(b, "%d(", n.kind)
+		for i, child := range n.children {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			child.writeKey(b)
 		}
-		c.mu.Unlock()
+		b.WriteByte(')')
 	}
 }
 
-func (c *LRUCache) Size() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.list.Len()
+func resultCacheKey(rawQuery string, page, pageSize int, opts SearchOptions) string {
+	return fmt.Sprintf("%s|%d|%d|%s|%t|%s|%s|%s", strings.Join(strings.Fields(rawQuery), " "), page, pageSize, opts.Field, opts.Passages, opts.Sort, opts.MinimumShouldMatch, opts.Ranker)
+}
+
+type cacheBypassKey struct{}
+
+func withCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
 }
 
-func (c *LRUCache) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.cache = make(map[interface{}]*list.Element)
-	c.list.Init()
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
 }
@@ -2,29 +2,94 @@ package query
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"runtime"
 	"sync/atomic"
 	"time"
 
 	"github.com/amankumarsingh77/search_engine/config"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/amankumarsingh77/search_engine/internal/cache"
+	"github.com/amankumarsingh77/search_engine/internal/telemetry"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
+var tracer = telemetry.Tracer("query")
+
+// Default time each stage of a search gets before it is abandoned.
+const (
+	defaultMatchTimeout = 2 * time.Second
+	defaultRankTimeout  = 2 * time.Second
+	defaultFetchTimeout = time.Second
+)
+
+var defaultFieldBoosts = map[string]float64{
+	textproc.FieldTitle:       3,
+	textproc.FieldDescription: 1.5,
+	textproc.FieldKeywords:    1.5,
+	textproc.FieldHeadings:    2,
+	textproc.FieldBody:        1,
+	textproc.FieldAnchor:      2,
+}
+
+// FieldBoosts returns the boost of each of textproc.Fields: cfg's where it
+// sets one, the default otherwise.
+func FieldBoosts(cfg *config.QueryEngineConfig) map[string]float64 {
+	boosts := make(map[string]float64, len(textproc.Fields))
+	for _, field := range textproc.Fields {
+		boosts[field] = defaultFieldBoosts[field]
+		if boost, ok := cfg.FieldBoosts[field]; ok && boost >= 0 {
+			boosts[field] = boost
+		}
+	}
+	return boosts
+}
+
 type QueryEngine struct {
-	pool         *pgxpool.Pool
-	termCache    *LRUCache
-	postingCache *LRUCache
-	idfCache     *LRUCache
-	docCache     *LRUCache
+	index        Index
+	termCache    *cache.LRUCache
+	postingCache *cache.LRUCache
+	idfCache     *cache.LRUCache
+	docCache     *cache.LRUCache
+	resultCache  *cache.LRUCache
+	rankingCache *cache.LRUCache
+	analyzer     *textproc.Analyzer
+	// remote is the cache shared with other instances; nil without one.
+	remote *remoteCache
+
+	// Concurrent identical loads share one database query.
+	termFlight    singleflight.Group
+	postingFlight singleflight.Group
+	resultFlight  singleflight.Group
 
 	totalDocs       atomic.Int64
 	avgTokenCount   atomic.Uint64
 	statsLastUpdate atomic.Int64
+	statsLoaded     atomic.Bool
 
 	maxWorkers       int
 	batchSize        int
 	cacheRefreshTime time.Duration
+	snapshotMinHits  int64
+	slowQuery        time.Duration
+	matchTimeout     time.Duration
+	rankTimeout      time.Duration
+	fetchTimeout     time.Duration
+	// ranker is the ranking profile searches use unless they ask for
+	// another.
+	ranker string
+	// fieldBoosts is indexed like textproc.Fields.
+	fieldBoosts []float64
+
+	vocabulary          atomic.Pointer[vocabulary]
+	spellVocabularySize int
+	spellMaxDocFreq     int64
 
 	//stmtGetTerms    *pgx.PreparedStatement
 	//stmtGetPostings *pgx.PreparedStatement
@@ -32,7 +97,7 @@ type QueryEngine struct {
 	//stmtGetDocFreq  *pgx.PreparedStatement
 }
 
-func NewQueryEngine(pool *pgxpool.Pool, cfg *config.QueryEngineConfig) *QueryEngine {
+func NewQueryEngine(index Index, cfg *config.QueryEngineConfig, analyzer *textproc.Analyzer) *QueryEngine {
 	numWorkers := runtime.NumCPU() * 2
 	if cfg.MaxWorkers > 0 {
 		numWorkers = cfg.MaxWorkers
@@ -48,69 +113,225 @@ func NewQueryEngine(pool *pgxpool.Pool, cfg *config.QueryEngineConfig) *QueryEng
 		cacheRefreshTime = cfg.CacheRefreshTime
 	}
 
+	snapshotMinHits := int64(defaultSnapshotMinHits)
+	if cfg.SnapshotMinHits > 0 {
+		snapshotMinHits = cfg.SnapshotMinHits
+	}
+
+	resultCacheSize := defaultResultCacheSize
+	if cfg.ResultCacheSize > 0 {
+		resultCacheSize = cfg.ResultCacheSize
+	}
+	resultCacheTTL := defaultResultCacheTTL
+	if cfg.ResultCacheTTL > 0 {
+		resultCacheTTL = cfg.ResultCacheTTL
+	}
+
+	matchTimeout := defaultMatchTimeout
+	if cfg.MatchTimeout != 0 {
+		matchTimeout = cfg.MatchTimeout
+	}
+	rankTimeout := defaultRankTimeout
+	if cfg.RankTimeout != 0 {
+		rankTimeout = cfg.RankTimeout
+	}
+	fetchTimeout := defaultFetchTimeout
+	if cfg.FetchTimeout != 0 {
+		fetchTimeout = cfg.FetchTimeout
+	}
+
+	ranker := RankerBM25
+	if cfg.Ranker != "" {
+		ranker = cfg.Ranker
+	}
+
+	spellVocabularySize := defaultSpellVocabularySize
+	if cfg.SpellVocabularySize != 0 {
+		spellVocabularySize = cfg.SpellVocabularySize
+	}
+	spellMaxDocFreq := int64(defaultSpellMaxDocFreq)
+	if cfg.SpellMaxDocFreq > 0 {
+		spellMaxDocFreq = cfg.SpellMaxDocFreq
+	}
+
+	boosts := FieldBoosts(cfg)
+	fieldBoosts := make([]float64, len(textproc.Fields))
+	for i, field := range textproc.Fields {
+		fieldBoosts[i] = boosts[field]
+	}
+
 	engine := &QueryEngine{
-		pool:             pool,
-		termCache:        NewLRUCache(cfg.TermCacheSize, 30*time.Minute),
-		postingCache:     NewLRUCache(cfg.PostingCacheSize, 15*time.Minute),
-		idfCache:         NewLRUCache(10000, time.Hour),
-		docCache:         NewLRUCache(cfg.DocumentCacheSize, 20*time.Minute),
+		index:            index,
+		termCache:        cache.NewLRUCache(cfg.TermCacheSize, 30*time.Minute),
+		postingCache:     cache.NewLRUCache(cfg.PostingCacheSize, 15*time.Minute),
+		idfCache:         cache.NewLRUCache(10000, time.Hour),
+		docCache:         cache.NewLRUCache(cfg.DocumentCacheSize, 20*time.Minute),
+		resultCache:      cache.NewLRUCache(resultCacheSize, resultCacheTTL),
+		rankingCache:     cache.NewLRUCache(resultCacheSize, resultCacheTTL),
 		maxWorkers:       numWorkers,
 		batchSize:        batchSize,
 		cacheRefreshTime: cacheRefreshTime,
+		snapshotMinHits:  snapshotMinHits,
+		slowQuery:        cfg.SlowQueryThreshold,
+		matchTimeout:     matchTimeout,
+		rankTimeout:      rankTimeout,
+		fetchTimeout:     fetchTimeout,
+		ranker:           ranker,
+		fieldBoosts:      fieldBoosts,
+
+		spellVocabularySize: spellVocabularySize,
+		spellMaxDocFreq:     spellMaxDocFreq,
+		// Must match the indexer's analyzer or query terms won't line up
+		// with the indexed ones.
+		analyzer: analyzer,
 	}
 
 	go engine.refreshGlobalStats()
 
+	go engine.loadVocabulary()
+
 	go engine.periodicCacheRefresh()
 
 	return engine
 }
 
 func (e *QueryEngine) Search(ctx context.Context, rawQuery string, page, pageSize int) ([]SearchResult, int, float64, error) {
-	start := time.Now()
+	resp, err := e.SearchWithOptions(ctx, rawQuery, page, pageSize, SearchOptions{})
+	if err != nil {
+		return nil, 0, 0.0, err
+	}
+	return resp.Results, resp.Total, resp.TimeTaken, nil
+}
 
-	plan := Parse(rawQuery, page, pageSize)
-	if len(plan.terms) == 0 {
-		return []SearchResult{}, 0, 0.0, nil
+func (e *QueryEngine) SearchWithOptions(ctx context.Context, rawQuery string, page, pageSize int, opts SearchOptions) (*SearchResponse, error) {
+	start := time.Now()
+	key := resultCacheKey(rawQuery, page, pageSize, opts)
+	ctx, span := tracer.Start(ctx, "query.search", trace.WithAttributes(
+		attribute.String("search.query", rawQuery),
+		attribute.Int("search.page", page),
+		attribute.Int("search.page_size", pageSize),
+		attribute.String("search.field", opts.Field),
+		attribute.String("search.ranker", opts.Ranker),
+	))
+	defer span.End()
+
+	if opts.BypassCache {
+		ctx = withCacheBypass(ctx)
+	} else if cached, ok := e.cachedResponse(ctx, key); ok {
+		span.SetAttributes(attribute.String("search.cache", CacheHit))
+		return &SearchResponse{
+			Results:     cached.results,
+			Total:       cached.total,
+			Suggestion:  cached.suggestion,
+			TimeTaken:   time.Since(start).Seconds(),
+			CacheStatus: CacheHit,
+			CacheAge:    time.Since(cached.cachedAt),
+		}, nil
 	}
 
-	if err := e.resolveTermIDsBatch(ctx, plan); err != nil {
-		return nil, 0, 0.0, fmt.Errorf("term resolution failed: %w", err)
+	flightKey := key
+	if opts.BypassCache {
+		flightKey = "bypass|" + flightKey
+	}
+	if opts.AllowPartial {
+		// Callers that didn't ask for partial results mustn't get them.
+		flightKey = "partial|" + flightKey
+	}
+	resp, err := flight(ctx, &e.resultFlight, flightKey, func(ctx context.Context) (SearchResponse, error) {
+		results, total, timedOut, err := e.execute(ctx, rawQuery, page, pageSize, opts)
+		if err != nil {
+			return SearchResponse{}, err
+		}
+		suggestion := e.suggest(ctx, rawQuery)
+		if !timedOut {
+			cachedAt := time.Now()
+			e.resultCache.Put(key, cachedResult{results: results, total: total, suggestion: suggestion, cachedAt: cachedAt})
+			remoteStore(e.remote, remoteResult, map[string]remoteResultEntry{
+				key: {Results: results, Total: total, Suggestion: suggestion, CachedAt: cachedAt},
+			})
+		}
+		return SearchResponse{Results: results, Total: total, Suggestion: suggestion, TimedOut: timedOut}, nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "search failed")
+		e.logf(ctx, "search %q failed: %v", rawQuery, err)
+		return nil, err
+	}
+	if elapsed := time.Since(start); e.slowQuery > 0 && elapsed > e.slowQuery {
+		e.logf(ctx, "slow query %q page=%d page_size=%d in=%s: %v (%d results)", rawQuery, page, pageSize, opts.Field, elapsed, resp.Total)
+	}
+	if resp.TimedOut {
+		e.logf(ctx, "search %q timed out, returning partial results", rawQuery)
 	}
 
-	if len(plan.termIDs) == 0 {
-		return []SearchResult{}, 0, 0.0, nil
+	resp.CacheStatus = CacheMiss
+	if opts.BypassCache {
+		resp.CacheStatus = CacheBypass
 	}
+	resp.TimeTaken = time.Since(start).Seconds()
+	span.SetAttributes(attribute.String("search.cache", resp.CacheStatus), attribute.Int("search.total", resp.Total),
+		attribute.Bool("search.timed_out", resp.TimedOut))
+	return &resp, nil
+}
 
-	var docIDs []int64
-	var err error
+// cachedResponse returns the response cached under key, locally or, failing
+// that, by another instance.
+func (e *QueryEngine) cachedResponse(ctx context.Context, key string) (cachedResult, bool) {
+	if val, ok := e.resultCache.Get(key); ok {
+		if cached, ok := val.(cachedResult); ok {
+			return cached, true
+		}
+	}
+	shared, ok := remoteLoad[remoteResultEntry](ctx, e.remote, remoteResult, []string{key})[key]
+	if !ok {
+		return cachedResult{}, false
+	}
+	cached := cachedResult{results: shared.Results, total: shared.Total, suggestion: shared.Suggestion, cachedAt: shared.CachedAt}
+	e.resultCache.Put(key, cached)
+	return cached, true
+}
 
-	switch plan.operator {
-	case "PHRASE":
-		docIDs, err = e.phraseSearchOptimized(ctx, plan)
-	default:
-		docIDs, err = e.booleanSearchOptimized(ctx, plan)
+// execute runs a search in three stages, each bounded by its own timeout:
+// matching (term resolution included), ranking, and fetching the page's
+// details and passages. timedOut is set when opts.AllowPartial let a stage
+// that ran out of time return what it had.
+func (e *QueryEngine) execute(ctx context.Context, rawQuery string, page, pageSize int, opts SearchOptions) (results []SearchResult, total int, timedOut bool, err error) {
+	plan := Parse(rawQuery, page, pageSize, e.analyzer)
+	plan.field = opts.Field
+	plan.sort = opts.Sort
+	plan.minShouldMatch = opts.MinimumShouldMatch
+	plan.partial = opts.AllowPartial
+	plan.ranker = e.ranker
+	if opts.Ranker != "" {
+		plan.ranker = opts.Ranker
+	}
+	if len(plan.terms) == 0 {
+		return []SearchResult{}, 0, false, nil
 	}
 
+	resolveCtx, cancel := withStageTimeout(ctx, e.matchTimeout)
+	err = e.resolveTermIDsBatch(resolveCtx, plan)
+	cancel()
 	if err != nil {
-		return nil, 0, 0.0, fmt.Errorf("search failed: %w", err)
+		return nil, 0, false, fmt.Errorf("term resolution failed: %w", stageError(resolveCtx, err))
 	}
 
-	if len(docIDs) == 0 {
-		return []SearchResult{}, 0, 0.0, nil
+	if len(plan.termIDs) == 0 {
+		return []SearchResult{}, 0, false, nil
 	}
 
-	scoredDocs, err := e.rankResultsOptimized(ctx, docIDs, plan)
+	scoredDocs, timedOut, err := e.rankedDocuments(ctx, plan)
 	if err != nil {
-		return nil, 0, 0.0, fmt.Errorf("ranking failed: %w", err)
+		return nil, 0, false, err
 	}
 
-	total := len(scoredDocs)
+	total = len(scoredDocs)
 	startIdx := (plan.page - 1) * plan.pageSize
 	endIdx := startIdx + plan.pageSize
 
 	if startIdx >= total {
-		return []SearchResult{}, total, time.Since(start).Seconds(), nil
+		return []SearchResult{}, total, timedOut, nil
 	}
 	if endIdx > total {
 		endIdx = total
@@ -118,37 +339,121 @@ func (e *QueryEngine) Search(ctx context.Context, rawQuery string, page, pageSiz
 
 	pagedDocs := scoredDocs[startIdx:endIdx]
 
-	results, err := e.fetchDocumentDetailsBatch(ctx, pagedDocs, plan.terms)
+	fetchCtx, cancel := withStageTimeout(ctx, e.fetchTimeout)
+	defer cancel()
+	results, err = e.fetchDocumentDetailsBatch(fetchCtx, pagedDocs, plan)
 	if err != nil {
-		return nil, 0, 0.0, fmt.Errorf("fetch details failed: %w", err)
+		return nil, 0, false, fmt.Errorf("fetch details failed: %w", stageError(fetchCtx, err))
+	}
+
+	if opts.Passages {
+		if err := e.attachBestPassages(fetchCtx, results, plan); err != nil {
+			if fetchCtx.Err() == nil || !plan.partial {
+				return nil, 0, false, fmt.Errorf("passage lookup failed: %w", stageError(fetchCtx, err))
+			}
+			timedOut = true
+		}
 	}
 
-	return results, total, time.Since(start).Seconds(), nil
+	return results, total, timedOut, nil
+}
+
+// rankedDocuments returns the plan's matches ranked at least up to the end
+// of its page. The ranking is cached under the plan, whatever the page, so
+// paging through a query and repeating it skip retrieval and scoring; a
+// later page than was ranked before only orders the documents further.
+//
+// A ranking cut short by the ranking timeout is returned with timedOut set
+// and not cached.
+func (e *QueryEngine) rankedDocuments(ctx context.Context, plan *QueryPlan) (scoredDocs []ScoredDoc, timedOut bool, err error) {
+	key := plan.cacheKey()
+	end := plan.page * plan.pageSize
+	if !cacheBypassed(ctx) {
+		if val, ok := e.rankingCache.Get(key); ok {
+			if ranking, ok := val.(*cachedRanking); ok {
+				if end > ranking.sorted && ranking.sorted < len(ranking.docs) {
+					ranking = ranking.extend(end, plan.order())
+					e.rankingCache.Put(key, ranking)
+				}
+				return ranking.docs, false, nil
+			}
+		}
+	}
+
+	matchCtx, cancel := withStageTimeout(ctx, e.matchTimeout)
+	defer cancel()
+	docIDs, err := e.booleanSearchOptimized(matchCtx, plan)
+	if err != nil {
+		return nil, false, fmt.Errorf("search failed: %w", stageError(matchCtx, err))
+	}
+
+	docIDs, err = e.restrictToField(matchCtx, plan, docIDs)
+	if err != nil {
+		return nil, false, fmt.Errorf("field restriction failed: %w", stageError(matchCtx, err))
+	}
+
+	if len(docIDs) > 0 {
+		rankCtx, cancel := withStageTimeout(ctx, e.rankTimeout)
+		defer cancel()
+		scoredDocs, timedOut, err = e.rankResultsOptimized(rankCtx, docIDs, plan)
+		if err != nil {
+			return nil, false, fmt.Errorf("ranking failed: %w", stageError(rankCtx, err))
+		}
+	}
+	if !timedOut {
+		e.rankingCache.Put(key, &cachedRanking{docs: scoredDocs, sorted: min(end, len(scoredDocs))})
+	}
+	return scoredDocs, timedOut, nil
+}
+
+// withStageTimeout bounds a stage of a search by timeout; zero or less
+// leaves it bounded only by ctx.
+func withStageTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stageError returns the reason a stage run under ctx stopped when it ran
+// out of time, rather than whatever error the database reported for the
+// cancelled query, so the caller can tell a timeout apart.
+func stageError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	return err
 }
 
 func (e *QueryEngine) refreshGlobalStats() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	var totalDocs int64
-	if err := e.pool.QueryRow(ctx, getTotalNoDocs).Scan(&totalDocs); err == nil {
+	totalDocs, avgTokenCount, err := e.index.Stats(ctx)
+	if err == nil {
 		e.totalDocs.Store(totalDocs)
-	}
-
-	var avgTokenCount float64
-	if err := e.pool.QueryRow(ctx, getAvgTokenCount).Scan(&avgTokenCount); err == nil {
-		e.avgTokenCount.Store(uint64(avgTokenCount))
+		e.statsLoaded.Store(true)
+		if avgTokenCount > 0 {
+			e.avgTokenCount.Store(math.Float64bits(avgTokenCount))
+		}
 	}
 
 	e.statsLastUpdate.Store(time.Now().Unix())
 }
 
+// StatsLoaded reports whether the corpus statistics BM25 depends on have been
+// read at least once. Until then every score is computed against zero docs.
+func (e *QueryEngine) StatsLoaded() bool {
+	return e.statsLoaded.Load()
+}
+
 func (e *QueryEngine) periodicCacheRefresh() {
 	ticker := time.NewTicker(e.cacheRefreshTime)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		e.refreshGlobalStats()
+		e.loadVocabulary()
 	}
 }
 
@@ -160,24 +465,43 @@ func (e *QueryEngine) getTotalDocs() int64 {
 }
 
 func (e *QueryEngine) getAvgTokenCount() float64 {
-	return float64(e.avgTokenCount.Load())
+	return math.Float64frombits(e.avgTokenCount.Load())
+}
+
+// InvalidateDocuments drops everything cached about docIDs after they are
+// removed from the index. Cached postings, IDF values and results may list
+// them too and are cleared wholesale; the corpus stats are reloaded. With a
+// remote cache, its postings and results are cleared and every instance
+// sharing it is told to do the same.
+func (e *QueryEngine) InvalidateDocuments(docIDs []int64) {
+	e.invalidateLocal(docIDs)
+	if e.remote != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := e.remote.invalidate(ctx, docIDs); err != nil {
+			log.Printf("Failed to invalidate the remote cache: %v", err)
+		}
+	}
+}
+
+// invalidateLocal drops docIDs from this instance's caches.
+func (e *QueryEngine) invalidateLocal(docIDs []int64) {
+	for _, docID := range docIDs {
+		e.docCache.Delete(fmt.Sprintf("doc_len_%d", docID))
+		e.docCache.Delete(fmt.Sprintf("doc_detail_%d", docID))
+	}
+	e.postingCache.Clear()
+	e.idfCache.Clear()
+	e.resultCache.Clear()
+	e.rankingCache.Clear()
+	go e.refreshGlobalStats()
 }
 
 func (e *QueryEngine) WarmCache(ctx context.Context, topN int) error {
-	rows, err := e.pool.Query(ctx, getTopNQuery, topN)
+	termIDs, err := e.index.TopTerms(ctx, topN)
 	if err != nil {
 		return fmt.Errorf("failed to get top terms: %w", err)
 	}
-	defer rows.Close()
-
-	var termIDs []int64
-	for rows.Next() {
-		var termID int64
-		if err := rows.Scan(&termID); err != nil {
-			return err
-		}
-		termIDs = append(termIDs, termID)
-	}
 
 	batchSize := e.batchSize
 	for i := 0; i < len(termIDs); i += batchSize {
@@ -196,27 +520,10 @@ func (e *QueryEngine) WarmCache(ctx context.Context, topN int) error {
 }
 
 func (e *QueryEngine) warmPostingCacheBatch(ctx context.Context, termIDs []int64) error {
-	rows, err := e.pool.Query(ctx, getPostingsByTermIDBatch, termIDs)
+	postingsByTerm, err := e.index.Postings(ctx, termIDs)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-
-	postingsByTerm := make(map[int64][]Posting)
-
-	for rows.Next() {
-		var termID, docID int64
-		var positions []int32
-
-		if err := rows.Scan(&termID, &docID, &positions); err != nil {
-			continue
-		}
-
-		postingsByTerm[termID] = append(postingsByTerm[termID], Posting{
-			DocID:     docID,
-			Positions: positions,
-		})
-	}
 
 	for termID, postings := range postingsByTerm {
 		e.postingCache.Put(termID, postings)
@@ -224,20 +531,3 @@ func (e *QueryEngine) warmPostingCacheBatch(ctx context.Context, termIDs []int64
 
 	return nil
 }
-
-func (e *QueryEngine) InitializeDatabase(ctx context.Context) error {
-	if _, err := e.pool.Exec(ctx, createOptimizedIndexes); err != nil {
-		return fmt.Errorf("failed to create indexes: %w", err)
-	}
-
-	if _, err := e.pool.Exec(ctx, createTermFrequencyView); err != nil {
-		return fmt.Errorf("failed to create term frequency view: %w", err)
-	}
-
-	return nil
-}
-
-func (e *QueryEngine) RefreshMaterializedViews(ctx context.Context) error {
-	_, err := e.pool.Exec(ctx, refreshTermFrequencies)
-	return err
-}
@@ -0,0 +1,122 @@
+package query
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+)
+
+// restrictToField drops documents whose matches fall outside the requested
+// field: the query must still match counting only terms in the field,
+// negated ones aside.
+func (e *QueryEngine) restrictToField(ctx context.Context, plan *QueryPlan, docIDs []int64) ([]int64, error) {
+	if plan.field == "" || plan.field == FieldAll || len(docIDs) == 0 {
+		return docIDs, nil
+	}
+	field := textproc.FieldIndex(plan.field)
+	if field < 0 {
+		return nil, fmt.Errorf("unknown field %q", plan.field)
+	}
+
+	docLengths, err := e.getDocumentLengthsBatch(ctx, docIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document lengths: %w", err)
+	}
+	postingsByTerm, err := e.getPostingsBatch(ctx, plan.termIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get postings: %w", err)
+	}
+
+	candidates := make(map[int64]struct{}, len(docIDs))
+	for _, docID := range docIDs {
+		candidates[docID] = struct{}{}
+	}
+	hits := make(map[int64]map[int64]bool, len(docIDs))
+	for _, termID := range plan.termIDs {
+		for _, posting := range postingsByTerm[termID] {
+			if _, ok := candidates[posting.DocID]; !ok {
+				continue
+			}
+			if inField(posting, docLengths[posting.DocID], field) {
+				if hits[posting.DocID] == nil {
+					hits[posting.DocID] = make(map[int64]bool)
+				}
+				hits[posting.DocID][termID] = true
+			}
+		}
+	}
+
+	filtered := make([]int64, 0, len(docIDs))
+	for _, docID := range docIDs {
+		if plan.matchesTerms(plan.root, hits[docID]) {
+			filtered = append(filtered, docID)
+		}
+	}
+	return filtered, nil
+}
+
+// matchesTerms reports whether node matches a document holding the terms in
+// present, ignoring negations and term positions.
+func (p *QueryPlan) matchesTerms(node *queryNode, present map[int64]bool) bool {
+	switch node.kind {
+	case nodeTerm, nodePhrase, nodeNear:
+		for _, terms := range node.groups {
+			found := false
+			for _, term := range terms {
+				if id, ok := p.ids[term]; ok && present[id] {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case nodeAnd:
+		for _, child := range node.children {
+			if child.kind != nodeNot && !p.matchesTerms(child, present) {
+				return false
+			}
+		}
+		return true
+	case nodeOr:
+		for _, child := range node.children {
+			if child.kind != nodeNot && p.matchesTerms(child, present) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// inField reports whether posting has an occurrence in field, an index into
+// textproc.Fields. Postings carry a field bitmask; older ones only have
+// positions, checked against the document's field lengths.
+func inField(posting Posting, docLength DocumentLength, field int) bool {
+	if posting.Fields != 0 {
+		return posting.Fields&(1<<field) != 0
+	}
+	for _, pos := range posting.Positions {
+		if docLength.fieldAt(pos) == field {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldAt returns the field holding pos. Documents indexed before field
+// lengths were recorded only know their title length; everything after the
+// title counts as body.
+func (d DocumentLength) fieldAt(pos int32) int {
+	if len(d.FieldLengths) == 0 {
+		if pos < int32(d.TitleTokenCount) {
+			return 0
+		}
+		return textproc.FieldIndex(textproc.FieldBody)
+	}
+	return textproc.FieldAt(d.FieldLengths, d.PositionGap, pos)
+}
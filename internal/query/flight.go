@@ -0,0 +1,36 @@
+package query
+
+import (
+	"context"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// flight runs fn once for all the callers asking for key at the same time
+// and hands each of them its result, so a burst of identical lookups costs
+// one database query. fn runs detached from the caller's cancellation, as
+// others may be waiting on it, but keeps the caller's deadline so a stage
+// timeout still bounds the query and the pool connection it holds. A caller
+// whose ctx is done stops waiting.
+func flight[T any](ctx context.Context, g *singleflight.Group, key string, fn func(context.Context) (T, error)) (T, error) {
+	ch := g.DoChan(key, func() (any, error) {
+		fctx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			fctx, cancel = context.WithDeadline(fctx, deadline)
+			defer cancel()
+		}
+		return fn(fctx)
+	})
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			var zero T
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	}
+}
@@ -0,0 +1,103 @@
+package query
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Index is what the query engine reads the index through. PostgresIndex
+// queries the indexer's tables; the indexer's embedded backends implement
+// it over their own storage. Documents that are deleted but not yet purged
+// may still be posted to terms, but DocumentLengths and Documents leave
+// them out, which drops them from every result.
+type Index interface {
+	// Stats returns the number of live documents and their average token
+	// count, zero while it isn't known.
+	Stats(ctx context.Context) (docs int64, avgTokens float64, err error)
+	// TopTerms returns the ids of the n terms occurring most often.
+	TopTerms(ctx context.Context, n int) ([]int64, error)
+	// TermIDs returns the id of each of terms that is indexed.
+	TermIDs(ctx context.Context, terms []string) (map[string]int64, error)
+	// Postings returns the postings of each of termIDs, by document id.
+	Postings(ctx context.Context, termIDs []int64) (map[int64][]Posting, error)
+	// DocFrequencies returns the number of live documents each of termIDs
+	// is posted to.
+	DocFrequencies(ctx context.Context, termIDs []int64) (map[int64]int64, error)
+	// TermDocFrequencies returns the document frequency of each of terms
+	// that is indexed, as of the indexer's last refresh of them.
+	TermDocFrequencies(ctx context.Context, terms []string) (map[string]int64, error)
+	// Vocabulary returns the n terms in the most documents with their
+	// document frequencies, as of the indexer's last refresh of them.
+	Vocabulary(ctx context.Context, n int) (map[string]int64, error)
+
+	// MatchGroups returns the documents posted to terms of at least
+	// q.Need distinct groups.
+	MatchGroups(ctx context.Context, q GroupMatch) ([]int64, error)
+	// OnSite returns the documents among docIDs on domain or one of its
+	// subdomains.
+	OnSite(ctx context.Context, docIDs []int64, domain string) ([]int64, error)
+	// LinkingTo returns the documents linking to host or its subdomains.
+	LinkingTo(ctx context.Context, host string) ([]int64, error)
+	// PublishedBetween returns the documents published before before and
+	// on or after after, either bound optional.
+	PublishedBetween(ctx context.Context, before, after *time.Time) ([]int64, error)
+	// InLanguage returns the documents in the primary language lang.
+	InLanguage(ctx context.Context, lang string) ([]int64, error)
+	// URLContains returns the documents whose lowercased URL contains part.
+	URLContains(ctx context.Context, part string) ([]int64, error)
+	// InFields returns the documents posted to terms of need distinct
+	// groups, groups giving the group of each of termIDs, in the fields set
+	// in fields.
+	InFields(ctx context.Context, termIDs []int64, groups []int, need int, fields int16) ([]int64, error)
+
+	// DocumentLengths returns the lengths of the live documents among
+	// docIDs, without Normalized, which depends on the corpus.
+	DocumentLengths(ctx context.Context, docIDs []int64) ([]DocumentLength, error)
+	// Positions returns the positions of termIDs in each of docIDs.
+	Positions(ctx context.Context, docIDs, termIDs []int64) ([]DocumentPosting, error)
+	// Offsets returns the positions of termIDs in each of docIDs with the
+	// spans they were analyzed from, for postings recorded with them.
+	Offsets(ctx context.Context, docIDs, termIDs []int64) ([]DocumentPosting, error)
+	// StoredFields returns the stored text of field for each of docIDs.
+	StoredFields(ctx context.Context, docIDs []int64, field string) (map[int64]string, error)
+	// Passages returns the passages of each of docIDs by paragraph number.
+	Passages(ctx context.Context, docIDs []int64) (map[int64][]Passage, error)
+	// Documents returns the live documents among docIDs.
+	Documents(ctx context.Context, docIDs []int64) ([]DocumentDetail, error)
+}
+
+// GroupMatch asks for the documents holding terms of at least Need groups,
+// Groups giving the group of each of TermIDs. Within, unless nil, limits
+// them to its documents and Site, unless empty, to those on a domain.
+type GroupMatch struct {
+	TermIDs []int64
+	Groups  []int
+	Need    int
+	Within  []int64
+	Site    string
+}
+
+// DocumentPosting is the posting of a term in one document. Offsets holds
+// a start and end byte offset per position, -1 where none was recorded.
+type DocumentPosting struct {
+	DocID     int64
+	TermID    int64
+	Positions []int32
+	Offsets   []int32
+}
+
+// Passage is a paragraph of a document and the half-open range of token
+// positions it covers.
+type Passage struct {
+	No    int
+	Start int32
+	End   int32
+	Body  string
+}
+
+// OnDomain reports whether a document's domain is site or one of its
+// subdomains.
+func OnDomain(domain, site string) bool {
+	return domain == site || strings.HasSuffix(domain, "."+site)
+}
@@ -1,15 +1,204 @@
 package query
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+)
+
+const (
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheBypass = "BYPASS"
+)
+
+// Fields a search can be restricted to.
+const (
+	FieldAll         = "all"
+	FieldTitle       = textproc.FieldTitle
+	FieldDescription = textproc.FieldDescription
+	FieldKeywords    = textproc.FieldKeywords
+	FieldHeadings    = textproc.FieldHeadings
+	FieldBody        = textproc.FieldBody
+)
+
+// Orders results can be sorted in.
+const (
+	SortRelevance = "relevance"
+	SortDate      = "date"
+)
+
+// Ranking profiles a search can be scored with.
+const (
+	RankerBM25   = "bm25"
+	RankerTFIDF  = "tfidf"
+	RankerCosine = "cosine"
+	// RankerHybrid is BM25 boosted for phrases, short pages, matching
+	// several terms and the source's quality.
+	RankerHybrid = "hybrid"
+)
+
+// ErrInvalidFilter is returned for a query filter whose value can't be
+// understood, such as before: with something other than a date.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+type SearchOptions struct {
+	// BypassCache skips every cache read for the query so freshly indexed
+	// data is served; the fresh results still repopulate the caches.
+	BypassCache bool
+	// Field restricts matching to one of textproc.Fields; empty means
+	// FieldAll.
+	Field string
+	// Passages attaches each result's best-matching paragraph. It needs an
+	// index built with IndexPassages.
+	Passages bool
+	// Sort orders results by SortRelevance, the default, or SortDate:
+	// newest published first, undated pages last.
+	Sort string
+	// MinimumShouldMatch is how many alternatives of an OR a document must
+	// match: a count ("2"), all but a count ("-1") or a share of them
+	// ("75%", rounded down). Empty means one.
+	MinimumShouldMatch string
+	// AllowPartial returns the results ranked so far, with
+	// SearchResponse.TimedOut set, when scoring or attaching passages runs
+	// out of time, instead of failing the search.
+	AllowPartial bool
+	// Ranker scores the results with one of the ranking profiles instead
+	// of the engine's configured one, to compare them.
+	Ranker string
+}
+
+type SearchResponse struct {
+	Results []SearchResult
+	Total   int
+	// Suggestion is the query with its likely misspellings corrected, or
+	// "" if none were found.
+	Suggestion  string
+	TimeTaken   float64
+	CacheStatus string
+	CacheAge    time.Duration
+	// TimedOut marks partial results: only the documents scored in time
+	// are ranked and counted, or passages are missing.
+	TimedOut bool
+}
 
 type QueryPlan struct {
 	rawQuery string
 	terms    []string
 	termIDs  []int64
-	operator string
-	page     int
-	pageSize int
-	filters  map[string]string
+	// termGroups and idGroups give the query position of each entry of
+	// terms and termIDs. AND and phrase queries need one term per group.
+	termGroups []int
+	idGroups   []int
+	operator   string
+	page       int
+	pageSize   int
+	filters    map[string]string
+	field      string
+	sort       string
+	// minShouldMatch is SearchOptions.MinimumShouldMatch.
+	minShouldMatch string
+	// partial is SearchOptions.AllowPartial.
+	partial bool
+	// ranker names the ranking profile the plan is scored with.
+	ranker string
+	// root is the parsed query; nil when nothing in it is searchable.
+	root *queryNode
+	// ids maps every term in root, negated ones included, to its id once
+	// resolved; terms missing from the index are left out.
+	ids map[string]int64
+}
+
+// minimumShouldMatch returns how many of n alternatives must match, at
+// least one and at most n.
+func (p *QueryPlan) minimumShouldMatch(n int) int {
+	need, err := resolveMinimumShouldMatch(p.minShouldMatch, n)
+	if err != nil {
+		return 1
+	}
+	return need
+}
+
+// CheckMinimumShouldMatch reports whether spec is a valid
+// SearchOptions.MinimumShouldMatch.
+func CheckMinimumShouldMatch(spec string) error {
+	_, err := resolveMinimumShouldMatch(spec, 1)
+	return err
+}
+
+func resolveMinimumShouldMatch(spec string, n int) (int, error) {
+	if spec == "" {
+		return 1, nil
+	}
+	var need int
+	if percent, ok := strings.CutSuffix(spec, "%"); ok {
+		share, err := strconv.Atoi(percent)
+		if err != nil || share < -100 || share > 100 {
+			return 0, fmt.Errorf("minimum_should_match %q is not a percentage between -100%% and 100%%", spec)
+		}
+		need = n * share / 100
+		if share < 0 {
+			need = n + need
+		}
+	} else {
+		count, err := strconv.Atoi(spec)
+		if err != nil {
+			return 0, fmt.Errorf("minimum_should_match %q is not a count or percentage", spec)
+		}
+		need = count
+		if count < 0 {
+			need = n + count
+		}
+	}
+	return min(max(need, 1), n), nil
+}
+
+// siteFilter returns the host of the plan's site: filter, lowercased and
+// without "www.", as documents store their domain. A scheme, port or path
+// in the filter is ignored.
+func (p *QueryPlan) siteFilter() (domain string, ok bool) {
+	site, ok := p.filters["site"]
+	if !ok {
+		return "", false
+	}
+	site = strings.ToLower(site)
+	if _, rest, found := strings.Cut(site, "://"); found {
+		site = rest
+	}
+	if i := strings.IndexAny(site, "/:?#"); i >= 0 {
+		site = site[:i]
+	}
+	domain = strings.TrimPrefix(site, "www.")
+	if domain == "" {
+		return "", false
+	}
+	return domain, true
+}
+
+// resolveGroups returns the ids of the indexed terms of groups, each with
+// the index of its group, or false if a group has none.
+func (p *QueryPlan) resolveGroups(groups [][]string) ([]int64, []int, bool) {
+	var termIDs []int64
+	var idGroups []int
+	for i, terms := range groups {
+		found := false
+		for _, term := range terms {
+			if id, ok := p.ids[term]; ok {
+				termIDs = append(termIDs, id)
+				idGroups = append(idGroups, i)
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil, false
+		}
+	}
+	return termIDs, idGroups, true
 }
 
 type SearchResult struct {
@@ -19,16 +208,27 @@ type SearchResult struct {
 	Description string  `json:"description"`
 	Score       float64 `json:"score"`
 	Snippet     string  `json:"snippet"`
+
+	SourceQuality float64 `json:"source_quality,omitempty"`
+
+	Passage   string `json:"passage,omitempty"`
+	PassageNo *int   `json:"passage_no,omitempty"`
 }
 
 type ScoredDoc struct {
 	DocID int64
 	Score float64
+	// published orders results under SortDate.
+	published *time.Time
 }
 
+// Posting is a term's occurrences in one document. Bit i of Fields is set
+// when the term occurs in textproc.Fields[i]; zero for postings indexed
+// before fields were recorded.
 type Posting struct {
 	DocID     int64
 	Positions []int32
+	Fields    int16
 }
 
 type TermBatch struct {
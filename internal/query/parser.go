@@ -1,12 +1,59 @@
 package query
 
 import (
-	crawler "github.com/amankumarsingh77/search_engine/internal/common"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-func Parse(rawQuery string, page, pageSize int) *QueryPlan {
+// nodeKind is the kind of a node in a parsed query.
+type nodeKind int
+
+const (
+	nodeTerm nodeKind = iota
+	nodePhrase
+	nodeAnd
+	nodeOr
+	nodeNot
+	nodeNear
+)
+
+// defaultNearDistance is how many other words NEAR allows between its
+// operands when no distance is given.
+const defaultNearDistance = 5
+
+// maxSlop caps a phrase's slop and NEAR's distance. Fields are indexed
+// textproc.FieldPositionGap positions apart, so under the cap a phrase or
+// near of fewer than maxSlop words can't be matched across two fields.
+const maxSlop = textproc.FieldPositionGap / 2
+
+// queryNode is a node of the query tree. Terms and phrases carry their
+// analyzed positions in groups, the terms at one position being
+// alternatives: a term needs a term of every position, a phrase needs them
+// at consecutive positions, or with up to slop other words among them. A
+// near needs them in any order, with up to slop other words among them.
+// And, or and not combine their children.
+type queryNode struct {
+	kind     nodeKind
+	groups   [][]string
+	slop     int
+	children []*queryNode
+}
+
+var filterRegex = regexp.MustCompile(`(\w+):("([^"]+)"|(\S+))`)
+
+// Parse turns a query into a plan. Filters (name:value) are taken out
+// first; the rest follows this grammar, where juxtaposed expressions are
+// ANDed and anything malformed is read as leniently as possible rather than
+// rejected:
+//
+//	or      = and { "OR" and }
+//	and     = near { [ "AND" ] near }
+//	near    = unary { ( "NEAR" | "NEAR/" digits ) unary }
+//	unary   = ( "-" | "NOT" ) unary | primary
+//	primary = "(" or ")" | '"' words '"' [ "~" digits ] | word
+func Parse(rawQuery string, page, pageSize int, analyzer *textproc.Analyzer) *QueryPlan {
 	plan := &QueryPlan{
 		rawQuery: rawQuery,
 		page:     page,
@@ -14,7 +61,6 @@ func Parse(rawQuery string, page, pageSize int) *QueryPlan {
 		operator: "AND",
 		filters:  make(map[string]string),
 	}
-	filterRegex := regexp.MustCompile(`(\w+):("([^"]+)"|(\S+))`)
 	matches := filterRegex.FindAllStringSubmatch(rawQuery, -1)
 	for _, match := range matches {
 		filterType := strings.ToLower(match[1])
@@ -25,16 +71,324 @@ func Parse(rawQuery string, page, pageSize int) *QueryPlan {
 		plan.filters[filterType] = filterValue
 		rawQuery = strings.Replace(rawQuery, match[0], "", 1)
 	}
-	if strings.Contains(rawQuery, `"`) {
-		plan.operator = "PHRASE"
-	} else if strings.Contains(rawQuery, "OR") {
-		plan.operator = "OR"
-	}
-	terms := crawler.NormalizeText(rawQuery)
-	for _, term := range terms {
-		if term != "" {
-			plan.terms = append(plan.terms, term)
+
+	p := &parser{tokens: lexQuery(rawQuery), analyzer: analyzer}
+	plan.root = p.parseQuery()
+	if plan.root != nil {
+		switch plan.root.kind {
+		case nodeOr:
+			plan.operator = "OR"
+		case nodePhrase:
+			plan.operator = "PHRASE"
 		}
 	}
+	// The plan's terms are those a matching document may contain, each
+	// position of each term or phrase a group, for ranking and snippets.
+	group := 0
+	plan.root.walkPositive(func(n *queryNode) {
+		for _, terms := range n.groups {
+			for _, term := range terms {
+				plan.terms = append(plan.terms, term)
+				plan.termGroups = append(plan.termGroups, group)
+			}
+			group++
+		}
+	})
 	return plan
 }
+
+// walkPositive calls fn for every term and phrase outside a not.
+func (n *queryNode) walkPositive(fn func(*queryNode)) {
+	if n == nil {
+		return
+	}
+	switch n.kind {
+	case nodeTerm, nodePhrase, nodeNear:
+		fn(n)
+	case nodeAnd, nodeOr:
+		for _, child := range n.children {
+			child.walkPositive(fn)
+		}
+	}
+}
+
+// walk calls fn for every term and phrase, negated or not.
+func (n *queryNode) walk(fn func(*queryNode)) {
+	if n == nil {
+		return
+	}
+	if n.kind == nodeTerm || n.kind == nodePhrase || n.kind == nodeNear {
+		fn(n)
+		return
+	}
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenPhrase
+	tokenOpen
+	tokenClose
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenNear
+)
+
+// A phrase's slop and NEAR's distance are -1 when not given.
+type token struct {
+	kind tokenKind
+	text string
+	slop int
+}
+
+// lexQuery splits a query into tokens. AND, OR and NOT are operators only
+// in capitals, and a - only negates at the start of a word, so "e-mail"
+// stays a word. An unterminated quote runs to the end; a closing one may be
+// followed by ~N to allow N other words in the phrase.
+func lexQuery(query string) []token {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose})
+			i++
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			tok := token{kind: tokenPhrase, text: query[i+1 : i+1+end], slop: -1}
+			i += end + 2
+			if i < len(query) && query[i] == '~' {
+				digits := i + 1
+				for digits < len(query) && query[digits] >= '0' && query[digits] <= '9' {
+					digits++
+				}
+				if slop, err := strconv.Atoi(query[i+1 : digits]); err == nil {
+					tok.slop = min(slop, maxSlop)
+					i = digits
+				}
+			}
+			tokens = append(tokens, tok)
+		case c == '-':
+			// A dash on its own negates nothing.
+			if i+1 < len(query) && !strings.ContainsRune(" \t\n\r", rune(query[i+1])) {
+				tokens = append(tokens, token{kind: tokenNot})
+			}
+			i++
+		default:
+			end := i
+			for end < len(query) && !strings.ContainsRune(" \t\n\r()\"", rune(query[end])) {
+				end++
+			}
+			word := query[i:end]
+			switch word {
+			case "AND":
+				tokens = append(tokens, token{kind: tokenAnd})
+			case "OR":
+				tokens = append(tokens, token{kind: tokenOr})
+			case "NOT":
+				tokens = append(tokens, token{kind: tokenNot})
+			case "NEAR":
+				tokens = append(tokens, token{kind: tokenNear, slop: -1})
+			default:
+				if distance, ok := strings.CutPrefix(word, "NEAR/"); ok {
+					if n, err := strconv.Atoi(distance); err == nil && n >= 0 {
+						tokens = append(tokens, token{kind: tokenNear, slop: min(n, maxSlop)})
+						break
+					}
+				}
+				tokens = append(tokens, token{kind: tokenWord, text: word})
+			}
+			i = end
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens   []token
+	pos      int
+	analyzer *textproc.Analyzer
+}
+
+func (p *parser) peek() (tokenKind, bool) {
+	if p.pos >= len(p.tokens) {
+		return 0, false
+	}
+	return p.tokens[p.pos].kind, true
+}
+
+// parseQuery parses the whole query, skipping unmatched closing
+// parentheses.
+func (p *parser) parseQuery() *queryNode {
+	var parts []*queryNode
+	for p.pos < len(p.tokens) {
+		parts = append(parts, p.parseOr(false))
+		if kind, ok := p.peek(); ok && kind == tokenClose {
+			p.pos++
+		}
+	}
+	return combine(nodeAnd, parts)
+}
+
+func (p *parser) parseOr(negated bool) *queryNode {
+	parts := []*queryNode{p.parseAnd(negated)}
+	for {
+		kind, ok := p.peek()
+		if !ok || kind != tokenOr {
+			break
+		}
+		p.pos++
+		parts = append(parts, p.parseAnd(negated))
+	}
+	return combine(nodeOr, parts)
+}
+
+func (p *parser) parseAnd(negated bool) *queryNode {
+	var parts []*queryNode
+	for {
+		kind, ok := p.peek()
+		if !ok || kind == tokenClose || kind == tokenOr {
+			break
+		}
+		if kind == tokenAnd {
+			p.pos++
+			continue
+		}
+		parts = append(parts, p.parseNear(negated))
+	}
+	return combine(nodeAnd, parts)
+}
+
+// parseNear joins words and phrases around NEAR into one near node, their
+// words in any order. NEAR next to anything else, or with an operand
+// missing, is read as AND.
+func (p *parser) parseNear(negated bool) *queryNode {
+	parts := []*queryNode{p.parseUnary(negated)}
+	distance := -1
+	for {
+		kind, ok := p.peek()
+		if !ok || kind != tokenNear {
+			break
+		}
+		if d := p.tokens[p.pos].slop; d >= 0 && (distance < 0 || d < distance) {
+			distance = d
+		}
+		p.pos++
+		parts = append(parts, p.parseUnary(negated))
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	if distance < 0 {
+		distance = defaultNearDistance
+	}
+	near := &queryNode{kind: nodeNear, slop: distance}
+	for _, part := range parts {
+		if part == nil || (part.kind != nodeTerm && part.kind != nodePhrase) {
+			return combine(nodeAnd, parts)
+		}
+		near.groups = append(near.groups, part.groups...)
+	}
+	if len(near.groups) < 2 {
+		return combine(nodeAnd, parts)
+	}
+	return near
+}
+
+func (p *parser) parseUnary(negated bool) *queryNode {
+	if kind, ok := p.peek(); ok && kind == tokenNot {
+		p.pos++
+		child := p.parseUnary(!negated)
+		if child == nil {
+			return nil
+		}
+		if child.kind == nodeNot {
+			return child.children[0]
+		}
+		return &queryNode{kind: nodeNot, children: []*queryNode{child}}
+	}
+	return p.parsePrimary(negated)
+}
+
+// parsePrimary parses a parenthesized expression, phrase or word. Negated
+// words keep only their forms, not their synonyms.
+func (p *parser) parsePrimary(negated bool) *queryNode {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case tokenOpen:
+		n := p.parseOr(negated)
+		if kind, ok := p.peek(); ok && kind == tokenClose {
+			p.pos++
+		}
+		return n
+	case tokenWord, tokenPhrase:
+		var positions [][]string
+		if negated {
+			positions = p.analyzer.AnalyzeQueryForms(tok.text)
+		} else {
+			positions = p.analyzer.AnalyzeQuery(tok.text)
+		}
+		var groups [][]string
+		for _, terms := range positions {
+			var kept []string
+			for _, term := range terms {
+				if term != "" {
+					kept = append(kept, term)
+				}
+			}
+			if len(kept) > 0 {
+				groups = append(groups, kept)
+			}
+		}
+		if len(groups) == 0 {
+			return nil
+		}
+		if tok.kind == tokenPhrase && len(groups) > 1 {
+			return &queryNode{kind: nodePhrase, groups: groups, slop: max(tok.slop, 0)}
+		}
+		return &queryNode{kind: nodeTerm, groups: groups}
+	default:
+		// A stray closing parenthesis or operator where an operand
+		// belongs; it has been consumed.
+		return nil
+	}
+}
+
+// combine joins parts under kind, dropping empty ones and merging nested
+// nodes of the same kind.
+func combine(kind nodeKind, parts []*queryNode) *queryNode {
+	var children []*queryNode
+	for _, part := range parts {
+		switch {
+		case part == nil:
+		case part.kind == kind:
+			children = append(children, part.children...)
+		default:
+			children = append(children, part)
+		}
+	}
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	}
+	return &queryNode{kind: kind, children: children}
+}
@@ -0,0 +1,65 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// attachBestPassages picks, for every result, the paragraph holding the most
+// query term occurrences (ties go to the earlier paragraph) and sets it on the
+// result. Documents indexed without passages are left untouched.
+func (e *QueryEngine) attachBestPassages(ctx context.Context, results []SearchResult, plan *QueryPlan) error {
+	if len(results) == 0 {
+		return nil
+	}
+	docIDs := make([]int64, len(results))
+	for i, r := range results {
+		docIDs[i] = r.DocID
+	}
+
+	passagesByDoc, err := e.index.Passages(ctx, docIDs)
+	if err != nil {
+		return fmt.Errorf("failed to fetch passages: %w", err)
+	}
+	if len(passagesByDoc) == 0 {
+		return nil
+	}
+
+	postingsByTerm, err := e.getPostingsBatch(ctx, plan.termIDs)
+	if err != nil {
+		return fmt.Errorf("failed to get postings: %w", err)
+	}
+	positionsByDoc := make(map[int64][]int32, len(passagesByDoc))
+	for _, termID := range plan.termIDs {
+		for _, posting := range postingsByTerm[termID] {
+			if _, ok := passagesByDoc[posting.DocID]; ok {
+				positionsByDoc[posting.DocID] = append(positionsByDoc[posting.DocID], posting.Positions...)
+			}
+		}
+	}
+
+	for i := range results {
+		passages := passagesByDoc[results[i].DocID]
+		positions := positionsByDoc[results[i].DocID]
+		if len(passages) == 0 || len(positions) == 0 {
+			continue
+		}
+		sort.Slice(positions, func(a, b int) bool { return positions[a] < positions[b] })
+
+		best, bestHits := -1, 0
+		for j, p := range passages {
+			lo := sort.Search(len(positions), func(k int) bool { return positions[k] >= p.Start })
+			hi := sort.Search(len(positions), func(k int) bool { return positions[k] >= p.End })
+			if hits := hi - lo; hits > bestHits {
+				best, bestHits = j, hits
+			}
+		}
+		if best >= 0 {
+			no := passages[best].No
+			results[i].Passage = passages[best].Body
+			results[i].PassageNo = &no
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresIndex reads the tables the indexer writes to Postgres.
+type PostgresIndex struct {
+	pool *pgxpool.Pool
+}
+
+var _ Index = (*PostgresIndex)(nil)
+
+func NewPostgresIndex(pool *pgxpool.Pool) *PostgresIndex {
+	return &PostgresIndex{pool: pool}
+}
+
+// Initialize creates the indexes and the term_frequencies view searches
+// rely on, for databases the indexer's migrations haven't set up.
+func (x *PostgresIndex) Initialize(ctx context.Context) error {
+	if _, err := x.pool.Exec(ctx, createOptimizedIndexes); err != nil {
+		return fmt.Errorf("failed to create indexes: %w", err)
+	}
+
+	if _, err := x.pool.Exec(ctx, createTermFrequencyView); err != nil {
+		return fmt.Errorf("failed to create term frequency view: %w", err)
+	}
+
+	return nil
+}
+
+func (x *PostgresIndex) Stats(ctx context.Context) (int64, float64, error) {
+	var totalDocs int64
+	var avgTokenCount *float64
+	if err := x.pool.QueryRow(ctx, getIndexStats).Scan(&totalDocs, &avgTokenCount); err == nil {
+		if avgTokenCount == nil {
+			return totalDocs, 0, nil
+		}
+		return totalDocs, *avgTokenCount, nil
+	}
+	// index_stats is maintained by the indexer; fall back to full scans
+	// against databases that predate it.
+	if err := x.pool.QueryRow(ctx, getTotalNoDocs).Scan(&totalDocs); err != nil {
+		return 0, 0, fmt.Errorf("failed to count documents: %w", err)
+	}
+	var avg *float64
+	if err := x.pool.QueryRow(ctx, getAvgTokenCount).Scan(&avg); err != nil || avg == nil {
+		return totalDocs, 0, nil
+	}
+	return totalDocs, *avg, nil
+}
+
+func (x *PostgresIndex) TopTerms(ctx context.Context, n int) ([]int64, error) {
+	return x.queryIDs(ctx, getTopNQuery, n)
+}
+
+func (x *PostgresIndex) TermIDs(ctx context.Context, terms []string) (map[string]int64, error) {
+	rows, err := x.pool.Query(ctx, getTermsBatch, terms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int64, len(terms))
+	for rows.Next() {
+		var term string
+		var id int64
+		if err := rows.Scan(&term, &id); err != nil {
+			continue
+		}
+		ids[term] = id
+	}
+	return ids, rows.Err()
+}
+
+func (x *PostgresIndex) Postings(ctx context.Context, termIDs []int64) (map[int64][]Posting, error) {
+	rows, err := x.pool.Query(ctx, getPostingsByTermIDBatch, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	postingsByTerm := make(map[int64][]Posting)
+	for rows.Next() {
+		var termID, docID int64
+		var positions []int32
+		var fields int16
+
+		if err := rows.Scan(&termID, &docID, &positions, &fields); err != nil {
+			continue
+		}
+
+		postingsByTerm[termID] = append(postingsByTerm[termID], Posting{
+			DocID:     docID,
+			Positions: positions,
+			Fields:    fields,
+		})
+	}
+	return postingsByTerm, rows.Err()
+}
+
+func (x *PostgresIndex) DocFrequencies(ctx context.Context, termIDs []int64) (map[int64]int64, error) {
+	rows, err := x.pool.Query(ctx, getDocFrequencyBatch, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docFreqs := make(map[int64]int64, len(termIDs))
+	for rows.Next() {
+		var termID, docFreq int64
+		if err := rows.Scan(&termID, &docFreq); err != nil {
+			continue
+		}
+		docFreqs[termID] = docFreq
+	}
+	return docFreqs, rows.Err()
+}
+
+// TermDocFrequencies and Vocabulary read the term_frequencies view, which
+// the indexer refreshes.
+func (x *PostgresIndex) TermDocFrequencies(ctx context.Context, terms []string) (map[string]int64, error) {
+	return x.queryTermCounts(ctx, getTermDocFrequencies, terms)
+}
+
+func (x *PostgresIndex) Vocabulary(ctx context.Context, n int) (map[string]int64, error) {
+	return x.queryTermCounts(ctx, getSpellVocabulary, n)
+}
+
+func (x *PostgresIndex) queryTermCounts(ctx context.Context, sql string, args ...any) (map[string]int64, error) {
+	rows, err := x.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var term string
+		var count int64
+		if err := rows.Scan(&term, &count); err != nil {
+			continue
+		}
+		counts[term] = count
+	}
+	return counts, rows.Err()
+}
+
+func (x *PostgresIndex) MatchGroups(ctx context.Context, q GroupMatch) ([]int64, error) {
+	var domain, subdomains *string
+	if q.Site != "" {
+		pattern := subdomainPattern(q.Site)
+		domain, subdomains = &q.Site, &pattern
+	}
+	return x.queryIDs(ctx, getBooleanIntersection, q.TermIDs, q.Groups, q.Need, q.Within, domain, subdomains)
+}
+
+func (x *PostgresIndex) OnSite(ctx context.Context, docIDs []int64, domain string) ([]int64, error) {
+	return x.queryIDs(ctx, getSiteDocs, docIDs, domain, subdomainPattern(domain))
+}
+
+// subdomainPattern is a LIKE pattern matching the reversed domains of the
+// subdomains of domain, which the documents' reverse(domain) index serves.
+func subdomainPattern(domain string) string {
+	reversed := []rune(domain)
+	slices.Reverse(reversed)
+	return likeEscaper.Replace(string(reversed)) + ".%"
+}
+
+func (x *PostgresIndex) LinkingTo(ctx context.Context, host string) ([]int64, error) {
+	return x.queryIDs(ctx, getLinksToFilteredDocs, host, "%."+likeEscaper.Replace(host))
+}
+
+func (x *PostgresIndex) PublishedBetween(ctx context.Context, before, after *time.Time) ([]int64, error) {
+	return x.queryIDs(ctx, getPublishedFilteredDocs, before, after)
+}
+
+func (x *PostgresIndex) InLanguage(ctx context.Context, lang string) ([]int64, error) {
+	return x.queryIDs(ctx, getLanguageFilteredDocs, lang)
+}
+
+func (x *PostgresIndex) URLContains(ctx context.Context, part string) ([]int64, error) {
+	return x.queryIDs(ctx, getURLFilteredDocs, "%"+likeEscaper.Replace(part)+"%")
+}
+
+func (x *PostgresIndex) InFields(ctx context.Context, termIDs []int64, groups []int, need int, fields int16) ([]int64, error) {
+	return x.queryIDs(ctx, getFieldFilteredDocs, termIDs, groups, need, fields)
+}
+
+func (x *PostgresIndex) queryIDs(ctx context.Context, sql string, args ...any) ([]int64, error) {
+	rows, err := x.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (x *PostgresIndex) DocumentLengths(ctx context.Context, docIDs []int64) ([]DocumentLength, error) {
+	rows, err := x.pool.Query(ctx, getDocumentLengthsBatch, docIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lengths := make([]DocumentLength, 0, len(docIDs))
+	for rows.Next() {
+		var d DocumentLength
+		if err := rows.Scan(&d.DocID, &d.TokenCount, &d.SourceQuality, &d.TitleTokenCount, &d.FieldLengths, &d.PositionGap, &d.PublishedAt); err != nil {
+			continue
+		}
+		lengths = append(lengths, d)
+	}
+	return lengths, rows.Err()
+}
+
+func (x *PostgresIndex) Positions(ctx context.Context, docIDs, termIDs []int64) ([]DocumentPosting, error) {
+	rows, err := x.pool.Query(ctx, getPositionsBatch, docIDs, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	return scanDocumentPostings(rows, func(p *DocumentPosting) []any {
+		return []any{&p.DocID, &p.TermID, &p.Positions}
+	})
+}
+
+func (x *PostgresIndex) Offsets(ctx context.Context, docIDs, termIDs []int64) ([]DocumentPosting, error) {
+	rows, err := x.pool.Query(ctx, getBodyOffsetsBatch, docIDs, termIDs)
+	if err != nil {
+		return nil, err
+	}
+	return scanDocumentPostings(rows, func(p *DocumentPosting) []any {
+		return []any{&p.DocID, &p.TermID, &p.Positions, &p.Offsets}
+	})
+}
+
+func scanDocumentPostings(rows pgx.Rows, dest func(p *DocumentPosting) []any) ([]DocumentPosting, error) {
+	defer rows.Close()
+	var postings []DocumentPosting
+	for rows.Next() {
+		var p DocumentPosting
+		if err := rows.Scan(dest(&p)...); err != nil {
+			continue
+		}
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+func (x *PostgresIndex) StoredFields(ctx context.Context, docIDs []int64, field string) (map[int64]string, error) {
+	rows, err := x.pool.Query(ctx, getStoredBodiesBatch, docIDs, field)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bodies := make(map[int64]string, len(docIDs))
+	for rows.Next() {
+		var docID int64
+		var text string
+		if err := rows.Scan(&docID, &text); err != nil {
+			continue
+		}
+		bodies[docID] = text
+	}
+	return bodies, rows.Err()
+}
+
+func (x *PostgresIndex) Passages(ctx context.Context, docIDs []int64) (map[int64][]Passage, error) {
+	rows, err := x.pool.Query(ctx, getPassagesBatch, docIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passagesByDoc := make(map[int64][]Passage, len(docIDs))
+	for rows.Next() {
+		var docID int64
+		var p Passage
+		if err := rows.Scan(&docID, &p.No, &p.Start, &p.End, &p.Body); err != nil {
+			continue
+		}
+		passagesByDoc[docID] = append(passagesByDoc[docID], p)
+	}
+	return passagesByDoc, rows.Err()
+}
+
+func (x *PostgresIndex) Documents(ctx context.Context, docIDs []int64) ([]DocumentDetail, error) {
+	rows, err := x.pool.Query(ctx, getDocumentsBatch, docIDs, docIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := make([]DocumentDetail, 0, len(docIDs))
+	for rows.Next() {
+		var detail DocumentDetail
+		if err := rows.Scan(&detail.ID, &detail.URL, &detail.Title, &detail.Description, &detail.TokenCount, &detail.SourceQuality); err != nil {
+			continue
+		}
+		docs = append(docs, detail)
+	}
+	return docs, rows.Err()
+}
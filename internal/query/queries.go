@@ -1,5 +1,8 @@
 package query
 
+// postings is hash-partitioned on term_id, so every postings query on the
+// search path filters on term_id to read only the partitions of the query's
+// terms. Warm-up and the term_frequencies view scan them all.
 const (
 	getTopNQuery = `
 		SELECT term_id 
@@ -9,9 +12,15 @@ const (
 		LIMIT $1
 	`
 
-	getAvgTokenCount = `SELECT AVG(token_count)::float FROM documents`
+	getAvgTokenCount = `SELECT AVG(token_count)::float FROM documents WHERE deleted_at IS NULL`
 
-	getTotalNoDocs = `SELECT COUNT(*)::int FROM documents`
+	getTotalNoDocs = `SELECT COUNT(*)::int FROM documents WHERE deleted_at IS NULL`
+
+	getIndexStats = `
+		SELECT doc_count, total_tokens::float8 / NULLIF(doc_count, 0)
+		FROM index_stats
+		WHERE id = 1
+	`
 
 	getTermsBatch = `
 		SELECT term, id FROM terms 
@@ -19,39 +28,58 @@ const (
 	`
 
 	getPostingsByTermIDBatch = `
-		SELECT term_id, doc_id, positions 
+		SELECT term_id, doc_id, positions, fields
 		FROM postings 
 		WHERE term_id = ANY($1)
 		ORDER BY term_id, doc_id
 	`
 
 	getDocFrequencyBatch = `
-		SELECT term_id, COUNT(DISTINCT doc_id) as doc_frequency
-		FROM postings
-		WHERE term_id = ANY($1)
-		GROUP BY term_id
+		SELECT p.term_id, COUNT(DISTINCT p.doc_id) as doc_frequency
+		FROM postings p
+		JOIN documents d ON d.id = p.doc_id
+		WHERE p.term_id = ANY($1) AND d.deleted_at IS NULL
+		GROUP BY p.term_id
+	`
+
+	getSpellVocabulary = `
+		SELECT t.term, tf.doc_frequency
+		FROM term_frequencies tf
+		JOIN terms t ON t.id = tf.term_id
+		ORDER BY tf.doc_frequency DESC
+		LIMIT $1
+	`
+
+	getTermDocFrequencies = `
+		SELECT t.term, tf.doc_frequency
+		FROM terms t
+		JOIN term_frequencies tf ON tf.term_id = t.id
+		WHERE t.term = ANY($1)
 	`
 
 	getBooleanIntersection = `
-		WITH term_docs AS (
-			SELECT term_id, doc_id
-			FROM postings
-			WHERE term_id = ANY($1)
+		WITH query_terms AS (
+			SELECT * FROM unnest($1::bigint[], $2::int[]) AS q(term_id, grp)
 		),
-		doc_term_counts AS (
-			SELECT doc_id, COUNT(DISTINCT term_id) as term_count
-			FROM term_docs
-			GROUP BY doc_id
+		doc_group_counts AS (
+			SELECT p.doc_id, COUNT(DISTINCT q.grp) as group_count
+			FROM postings p
+			JOIN query_terms q ON q.term_id = p.term_id
+			WHERE p.term_id = ANY($1) AND ($4::bigint[] IS NULL OR p.doc_id = ANY($4))
+			GROUP BY p.doc_id
 		)
-		SELECT doc_id
-		FROM doc_term_counts
-		WHERE term_count = $2
+		SELECT g.doc_id
+		FROM doc_group_counts g
+		JOIN documents d ON d.id = g.doc_id
+		WHERE g.group_count >= $3
+			AND ($5::text IS NULL OR d.domain = $5 OR reverse(d.domain) LIKE $6)
 	`
 
-	getBooleanUnion = `
-		SELECT DISTINCT doc_id
-		FROM postings
-		WHERE term_id = ANY($1)
+	// getSiteDocs keeps the documents among $1 on the domain $2 or, by the
+	// reversed-domain pattern $3, its subdomains.
+	getSiteDocs = `
+		SELECT id FROM documents
+		WHERE id = ANY($1) AND (domain = $2 OR reverse(domain) LIKE $3)
 	`
 
 	getPhraseSearch = `
@@ -82,9 +110,37 @@ const (
 		WHERE term_index = $3
 	`
 
-	getSiteFilteredDocs = `
-		SELECT id FROM documents 
-		WHERE url LIKE '%' || $1 || '%'
+	getLinksToFilteredDocs = `
+		SELECT DISTINCT doc_id FROM document_links
+		WHERE host = $1 OR host LIKE $2
+	`
+
+	// getPublishedFilteredDocs returns the documents published before $1
+	// and on or after $2, either bound optional, in one range scan.
+	getPublishedFilteredDocs = `
+		SELECT id FROM documents
+		WHERE published_at IS NOT NULL
+			AND ($1::timestamptz IS NULL OR published_at < $1)
+			AND ($2::timestamptz IS NULL OR published_at >= $2)
+	`
+
+	getLanguageFilteredDocs = `SELECT id FROM documents WHERE language = $1`
+
+	// $1 is a LIKE pattern, served by the trigram index on lower(url).
+	getURLFilteredDocs = `SELECT id FROM documents WHERE lower(url) LIKE $1`
+
+	// getFieldFilteredDocs returns the documents with a term of every group
+	// in the fields set in $4.
+	getFieldFilteredDocs = `
+		WITH query_terms AS (
+			SELECT * FROM unnest($1::bigint[], $2::int[]) AS q(term_id, grp)
+		)
+		SELECT p.doc_id
+		FROM postings p
+		JOIN query_terms q ON q.term_id = p.term_id
+		WHERE p.term_id = ANY($1) AND p.fields & $4::smallint <> 0
+		GROUP BY p.doc_id
+		HAVING COUNT(DISTINCT q.grp) = $3
 	`
 
 	createTermFrequencyView = `
@@ -104,18 +160,9 @@ const (
 		ON term_frequencies(total_frequency DESC);
 	`
 
-	refreshTermFrequencies = `REFRESH MATERIALIZED VIEW CONCURRENTLY term_frequencies`
-
+	// Postings indexes come from the indexer's migrations: postings is
+	// partitioned, and partitioned tables can't be indexed concurrently.
 	createOptimizedIndexes = `
-		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_postings_term_doc 
-		ON postings(term_id, doc_id);
-		
-		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_postings_doc_term 
-		ON postings(doc_id, term_id);
-		
-		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_postings_positions_gin 
-		ON postings USING GIN(positions);
-		
 		CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_documents_url 
 		ON documents(url);
 		
@@ -126,10 +173,43 @@ const (
 		ON terms(term);
 	`
 
+	getPassagesBatch = `
+		SELECT doc_id, paragraph_no, start_pos, end_pos, body
+		FROM document_passages
+		WHERE doc_id = ANY($1)
+		ORDER BY doc_id, paragraph_no
+	`
+
+	// Deleted documents keep their postings until they are purged; leaving
+	// them out here drops them from every result.
+	getDocumentLengthsBatch = `
+		SELECT id, token_count, source_quality, title_token_count, COALESCE(field_lengths, '{}'), position_gap, published_at
+		FROM documents
+		WHERE id = ANY($1) AND deleted_at IS NULL
+	`
+
+	getPositionsBatch = `
+		SELECT doc_id, term_id, positions
+		FROM postings
+		WHERE doc_id = ANY($1) AND term_id = ANY($2)
+	`
+
+	getBodyOffsetsBatch = `
+		SELECT doc_id, term_id, positions, offsets
+		FROM postings
+		WHERE doc_id = ANY($1) AND term_id = ANY($2) AND offsets IS NOT NULL
+	`
+
+	getStoredBodiesBatch = `
+		SELECT doc_id, body
+		FROM stored_fields
+		WHERE doc_id = ANY($1) AND field = $2
+	`
+
 	getDocumentsBatch = `
-		SELECT id, url, title, description, token_count 
+		SELECT id, url, title, description, token_count, source_quality
 		FROM documents 
-		WHERE id = ANY($1)
+		WHERE id = ANY($1) AND deleted_at IS NULL
 		ORDER BY CASE 
 			WHEN id = ANY($2) THEN array_position($2, id)
 			ELSE 999999
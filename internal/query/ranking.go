@@ -1,11 +1,14 @@
 package query
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
 	"math"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -13,13 +16,79 @@ const (
 	BM25_B  = 0.75
 )
 
+// scoreFunc scores a document against the plan's terms.
+type scoreFunc func(e *QueryEngine, docID int64, plan *QueryPlan, docLength DocumentLength, idfValues map[int64]float64, termFreqs map[string]float64) float64
+
+// rankers are the ranking profiles by name.
+var rankers = map[string]scoreFunc{
+	RankerBM25: func(e *QueryEngine, docID int64, plan *QueryPlan, docLength DocumentLength, idfValues map[int64]float64, termFreqs map[string]float64) float64 {
+		return e.calculateBM25Score(docID, plan.termIDs, docLength, idfValues, termFreqs)
+	},
+	RankerTFIDF: func(e *QueryEngine, docID int64, plan *QueryPlan, docLength DocumentLength, idfValues map[int64]float64, termFreqs map[string]float64) float64 {
+		return e.calculateTFIDFScore(docID, plan.termIDs, docLength, idfValues, termFreqs)
+	},
+	RankerCosine: func(e *QueryEngine, docID int64, plan *QueryPlan, docLength DocumentLength, idfValues map[int64]float64, termFreqs map[string]float64) float64 {
+		return e.calculateCosineSimilarity(docID, plan.termIDs, docLength, idfValues, termFreqs)
+	},
+	RankerHybrid: func(e *QueryEngine, docID int64, plan *QueryPlan, docLength DocumentLength, idfValues map[int64]float64, termFreqs map[string]float64) float64 {
+		return e.calculateHybridScore(docID, plan.termIDs, docLength, idfValues, termFreqs, plan)
+	},
+}
+
+// CheckRanker reports whether name is a ranking profile; empty stands for
+// the default.
+func CheckRanker(name string) error {
+	if _, ok := rankers[name]; ok || name == "" {
+		return nil
+	}
+	names := make([]string, 0, len(rankers))
+	for name := range rankers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("ranker must be one of %s", strings.Join(names, ", "))
+}
+
+// rankChunkSize is how many documents are scored at a time, so a search
+// that runs out of time still has the chunks scored before it to return.
+const rankChunkSize = 10000
+
 type DocumentLength struct {
-	DocID      int64
-	TokenCount int
-	Normalized float64
+	DocID           int64
+	TokenCount      int
+	TitleTokenCount int
+	FieldLengths    []int32
+	PositionGap     int32
+	Normalized      float64
+	SourceQuality   float64
+	// PublishedAt is nil for pages that don't declare a date.
+	PublishedAt *time.Time
 }
 
-func (e *QueryEngine) rankResultsOptimized(ctx context.Context, docIDs []int64, plan *QueryPlan) ([]ScoredDoc, error) {
+// rankResultsOptimized scores docIDs a chunk at a time and orders them up to
+// the end of the plan's page. If ctx is done after some chunks were scored
+// and the plan allows partial results, the scored documents are ranked and
+// returned with timedOut set instead of an error.
+func (e *QueryEngine) rankResultsOptimized(ctx context.Context, docIDs []int64, plan *QueryPlan) (scoredDocs []ScoredDoc, timedOut bool, err error) {
+	for start := 0; start < len(docIDs); start += rankChunkSize {
+		chunk, err := e.scoreDocuments(ctx, docIDs[start:min(start+rankChunkSize, len(docIDs))], plan)
+		if err != nil {
+			if ctx.Err() != nil && plan.partial && len(scoredDocs) > 0 {
+				timedOut = true
+				break
+			}
+			return nil, false, err
+		}
+		scoredDocs = append(scoredDocs, chunk...)
+	}
+
+	// Only the documents up to the end of the requested page need to be in
+	// order; the rest just count towards the total.
+	return topScored(scoredDocs, plan.page*plan.pageSize, plan.order()), timedOut, nil
+}
+
+// scoreDocuments returns the BM25F score of each of docIDs that is live.
+func (e *QueryEngine) scoreDocuments(ctx context.Context, docIDs []int64, plan *QueryPlan) ([]ScoredDoc, error) {
 	if len(docIDs) == 0 {
 		return nil, nil
 	}
@@ -28,17 +97,22 @@ func (e *QueryEngine) rankResultsOptimized(ctx context.Context, docIDs []int64,
 	if err != nil {
 		return nil, fmt.Errorf("failed to get document lengths: %w", err)
 	}
+	docIDs = liveDocIDs(docIDs, docLengths)
+	if len(docIDs) == 0 {
+		return nil, nil
+	}
 
 	idfValues, err := e.getIDFBatch(ctx, plan.termIDs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get IDF values: %w", err)
 	}
 
-	termFreqs, err := e.getTermFrequenciesBatch(ctx, docIDs, plan.termIDs)
+	termFreqs, err := e.getTermFrequenciesBatch(ctx, docIDs, plan.termIDs, docLengths)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get term frequencies: %w", err)
 	}
 
+	score := rankers[plan.ranker]
 	scoredDocs := make([]ScoredDoc, len(docIDs))
 	var wg sync.WaitGroup
 
@@ -54,9 +128,15 @@ func (e *QueryEngine) rankResultsOptimized(ctx context.Context, docIDs []int64,
 		go func() {
 			defer wg.Done()
 			for idx := range jobChan {
+				if ctx.Err() != nil {
+					return
+				}
 				docID := docIDs[idx]
-				score := e.calculateBM25Score(docID, plan.termIDs, docLengths[docID], idfValues, termFreqs)
-				scoredDocs[idx] = ScoredDoc{DocID: docID, Score: score}
+				scoredDocs[idx] = ScoredDoc{
+					DocID:     docID,
+					Score:     score(e, docID, plan, docLengths[docID], idfValues, termFreqs),
+					published: docLengths[docID].PublishedAt,
+				}
 			}
 		}()
 	}
@@ -69,21 +149,20 @@ func (e *QueryEngine) rankResultsOptimized(ctx context.Context, docIDs []int64,
 	}()
 
 	wg.Wait()
-
-	sort.Slice(scoredDocs, func(i, j int) bool {
-		return scoredDocs[i].Score > scoredDocs[j].Score
-	})
-
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return scoredDocs, nil
 }
 
 func (e *QueryEngine) getDocumentLengthsBatch(ctx context.Context, docIDs []int64) (map[int64]DocumentLength, error) {
 	result := make(map[int64]DocumentLength, len(docIDs))
 	var missingDocIDs []int64
+	bypass := cacheBypassed(ctx)
 
 	for _, docID := range docIDs {
 		cacheKey := fmt.Sprintf("doc_len_%d", docID)
-		if val, ok := e.docCache.Get(cacheKey); ok {
+		if val, ok := e.docCache.Get(cacheKey); ok && !bypass {
 			if docLen, ok := val.(DocumentLength); ok {
 				result[docID] = docLen
 				continue
@@ -95,29 +174,17 @@ func (e *QueryEngine) getDocumentLengthsBatch(ctx context.Context, docIDs []int6
 	if len(missingDocIDs) > 0 {
 		avgTokenCount := e.getAvgTokenCount()
 
-		rows, err := e.pool.Query(ctx, "SELECT id, token_count FROM documents WHERE id = ANY($1)", missingDocIDs)
+		lengths, err := e.index.DocumentLengths(ctx, missingDocIDs)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch document lengths: %w", err)
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var docID int64
-			var tokenCount int
 
-			if err := rows.Scan(&docID, &tokenCount); err != nil {
-				continue
-			}
-
-			docLen := DocumentLength{
-				DocID:      docID,
-				TokenCount: tokenCount,
-				Normalized: float64(tokenCount) / avgTokenCount,
-			}
+		for _, docLen := range lengths {
+			docLen.Normalized = float64(docLen.TokenCount) / avgTokenCount
 
-			result[docID] = docLen
+			result[docLen.DocID] = docLen
 
-			cacheKey := fmt.Sprintf("doc_len_%d", docID)
+			cacheKey := fmt.Sprintf("doc_len_%d", docLen.DocID)
 			e.docCache.Put(cacheKey, docLen)
 		}
 	}
@@ -125,30 +192,25 @@ func (e *QueryEngine) getDocumentLengthsBatch(ctx context.Context, docIDs []int6
 	return result, nil
 }
 
-func (e *QueryEngine) getTermFrequenciesBatch(ctx context.Context, docIDs []int64, termIDs []int64) (map[string]int, error) {
-	result := make(map[string]int)
+// getTermFrequenciesBatch returns each term's field-weighted frequency in
+// each document, keyed "docID_termID": every occurrence counts the boost of
+// the field it is in, BM25F-style, so a title hit can outweigh several body
+// hits.
+func (e *QueryEngine) getTermFrequenciesBatch(ctx context.Context, docIDs []int64, termIDs []int64, docLengths map[int64]DocumentLength) (map[string]float64, error) {
+	result := make(map[string]float64)
 
-	query := `
-		SELECT doc_id, term_id, array_length(positions, 1) as tf
-		FROM postings 
-		WHERE doc_id = ANY($1) AND term_id = ANY($2)
-	`
-
-	rows, err := e.pool.Query(ctx, query, docIDs, termIDs)
+	postings, err := e.index.Positions(ctx, docIDs, termIDs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch term frequencies: %w", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var docID, termID int64
-		var tf int
 
-		if err := rows.Scan(&docID, &termID, &tf); err != nil {
-			continue
+	for _, p := range postings {
+		docLength := docLengths[p.DocID]
+		tf := 0.0
+		for _, pos := range p.Positions {
+			tf += e.fieldBoosts[docLength.fieldAt(pos)]
 		}
-
-		key := fmt.Sprintf("%d_%d", docID, termID)
+		key := fmt.Sprintf("%d_%d", p.DocID, p.TermID)
 		result[key] = tf
 	}
 
@@ -160,7 +222,7 @@ func (e *QueryEngine) calculateBM25Score(
 	termIDs []int64,
 	docLength DocumentLength,
 	idfValues map[int64]float64,
-	termFreqs map[string]int,
+	termFreqs map[string]float64,
 ) float64 {
 	score := 0.0
 
@@ -178,8 +240,10 @@ func (e *QueryEngine) calculateBM25Score(
 		}
 
 		// BM25 formula: IDF * (tf * (k1 + 1)) / (tf + k1 * (1 - b + b * (|d| / avgdl)))
-		tfComponent := float64(tf) * (BM25_K1 + 1)
-		denominator := float64(tf) + BM25_K1*(1-BM25_B+BM25_B*docLength.Normalized)
+		// with tf weighted by field; length is normalized over the whole
+		// document rather than per field.
+		tfComponent := tf * (BM25_K1 + 1)
+		denominator := tf + BM25_K1*(1-BM25_B+BM25_B*docLength.Normalized)
 
 		score += idf * (tfComponent / denominator)
 	}
@@ -187,14 +251,14 @@ func (e *QueryEngine) calculateBM25Score(
 	return score
 }
 
-// Alternative scoring methods for experimentation
+// Alternative ranking profiles, for comparison with BM25.
 
 func (e *QueryEngine) calculateTFIDFScore(
 	docID int64,
 	termIDs []int64,
 	docLength DocumentLength,
 	idfValues map[int64]float64,
-	termFreqs map[string]int,
+	termFreqs map[string]float64,
 ) float64 {
 	score := 0.0
 
@@ -212,7 +276,7 @@ func (e *QueryEngine) calculateTFIDFScore(
 		}
 
 		// TF-IDF: (1 + log(tf)) * IDF
-		tfScore := 1.0 + math.Log(float64(tf))
+		tfScore := 1.0 + math.Log(tf)
 		score += tfScore * idf
 	}
 
@@ -224,7 +288,7 @@ func (e *QueryEngine) calculateCosineSimilarity(
 	termIDs []int64,
 	docLength DocumentLength,
 	idfValues map[int64]float64,
-	termFreqs map[string]int,
+	termFreqs map[string]float64,
 ) float64 {
 	var dotProduct, docNorm, queryNorm float64
 
@@ -237,7 +301,7 @@ func (e *QueryEngine) calculateCosineSimilarity(
 			continue
 		}
 
-		docWeight := float64(tf) * idf
+		docWeight := tf * idf
 		queryWeight := 1.0 * idf
 		dotProduct += docWeight * queryWeight
 		docNorm += docWeight * docWeight
@@ -256,7 +320,7 @@ func (e *QueryEngine) calculateHybridScore(
 	termIDs []int64,
 	docLength DocumentLength,
 	idfValues map[int64]float64,
-	termFreqs map[string]int,
+	termFreqs map[string]float64,
 	plan *QueryPlan,
 ) float64 {
 	bm25Score := e.calculateBM25Score(docID, termIDs, docLength, idfValues, termFreqs)
@@ -283,6 +347,10 @@ func (e *QueryEngine) calculateHybridScore(
 		score *= 1.0 + (0.1 * float64(termCount-1))
 	}
 
+	if docLength.SourceQuality > 0 {
+		score *= docLength.SourceQuality
+	}
+
 	return score
 }
 
@@ -296,7 +364,7 @@ func (e *QueryEngine) batchScoreDocuments(ctx context.Context, docIDs []int64, p
 		}
 
 		batch := docIDs[i:end]
-		scoredBatch, err := e.rankResultsOptimized(ctx, batch, plan)
+		scoredBatch, _, err := e.rankResultsOptimized(ctx, batch, plan)
 		if err != nil {
 			return nil, err
 		}
@@ -310,3 +378,92 @@ func (e *QueryEngine) batchScoreDocuments(ctx context.Context, docIDs []int64, p
 
 	return allScoredDocs, nil
 }
+
+// byScore orders documents best score first.
+func byScore(a, b ScoredDoc) bool {
+	return a.Score > b.Score
+}
+
+// byDate orders documents newest published first, undated ones last, and
+// by score among equal dates.
+func byDate(a, b ScoredDoc) bool {
+	x, y := a.published, b.published
+	switch {
+	case x == nil && y == nil:
+	case x == nil:
+		return false
+	case y == nil:
+		return true
+	case !x.Equal(*y):
+		return x.After(*y)
+	}
+	return a.Score > b.Score
+}
+
+// order returns the ordering the plan's results are sorted in.
+func (p *QueryPlan) order() func(a, b ScoredDoc) bool {
+	if p.sort == SortDate {
+		return byDate
+	}
+	return byScore
+}
+
+// topScored returns docs with the k that come first under less at the
+// front, in order, and the rest after them unordered. It keeps the best k
+// seen so far in a heap topped by the worst of them, which takes
+// O(n log k) rather than the O(n log n) of sorting every candidate when
+// only the first pages are read.
+func topScored(docs []ScoredDoc, k int, less func(a, b ScoredDoc) bool) []ScoredDoc {
+	if k <= 0 || k >= len(docs) {
+		sort.Slice(docs, func(i, j int) bool { return less(docs[i], docs[j]) })
+		return docs
+	}
+
+	h := &scoredHeap{docs: make([]ScoredDoc, 0, k), less: less}
+	rest := make([]ScoredDoc, 0, len(docs)-k)
+	for _, doc := range docs {
+		switch {
+		case h.Len() < k:
+			heap.Push(h, doc)
+		case less(doc, h.docs[0]):
+			rest = append(rest, h.docs[0])
+			h.docs[0] = doc
+			heap.Fix(h, 0)
+		default:
+			rest = append(rest, doc)
+		}
+	}
+
+	top := h.docs
+	sort.Slice(top, func(i, j int) bool { return less(top[i], top[j]) })
+	return append(top, rest...)
+}
+
+// scoredHeap is a heap.Interface whose top is the document that comes last
+// under less.
+type scoredHeap struct {
+	docs []ScoredDoc
+	less func(a, b ScoredDoc) bool
+}
+
+func (h *scoredHeap) Len() int           { return len(h.docs) }
+func (h *scoredHeap) Less(i, j int) bool { return h.less(h.docs[j], h.docs[i]) }
+func (h *scoredHeap) Swap(i, j int)      { h.docs[i], h.docs[j] = h.docs[j], h.docs[i] }
+func (h *scoredHeap) Push(x any)         { h.docs = append(h.docs, x.(ScoredDoc)) }
+func (h *scoredHeap) Pop() any {
+	doc := h.docs[len(h.docs)-1]
+	h.docs = h.docs[:len(h.docs)-1]
+	return doc
+}
+
+// liveDocIDs drops the documents without a length, which have been deleted
+// but whose postings are not yet purged.
+func liveDocIDs(docIDs []int64, docLengths map[int64]DocumentLength) []int64 {
+	live := docIDs[:0:0]
+	for _, docID := range docIDs {
+		if _, ok := docLengths[docID]; ok {
+			live = append(live, docID)
+		}
+	}
+	return live
+}
@@ -0,0 +1,53 @@
+package query
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// scoredCandidates returns n documents with random scores, the same ones
+// for every call with the same n.
+func scoredCandidates(n int) []ScoredDoc {
+	rng := rand.New(rand.NewSource(int64(n)))
+	docs := make([]ScoredDoc, n)
+	for i := range docs {
+		docs[i] = ScoredDoc{DocID: int64(i), Score: rng.Float64() * 20}
+	}
+	return docs
+}
+
+// BenchmarkTopScored ranks the first page, and the first ten pages, out of
+// large candidate sets.
+func BenchmarkTopScored(b *testing.B) {
+	for _, n := range []int{50_000, 200_000} {
+		for _, k := range []int{10, 100} {
+			b.Run(fmt.Sprintf("n=%d/k=%d", n, k), func(b *testing.B) {
+				candidates := scoredCandidates(n)
+				docs := make([]ScoredDoc, n)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					copy(docs, candidates)
+					topScored(docs, k, byScore)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkFullSort orders every candidate, which is what topScored saves
+// when only the first pages are read.
+func BenchmarkFullSort(b *testing.B) {
+	for _, n := range []int{50_000, 200_000} {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			candidates := scoredCandidates(n)
+			docs := make([]ScoredDoc, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				copy(docs, candidates)
+				sort.Slice(docs, func(i, j int) bool { return byScore(docs[i], docs[j]) })
+			}
+		})
+	}
+}
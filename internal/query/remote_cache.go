@@ -0,0 +1,195 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRemoteCachePrefix = "query_cache"
+	defaultRemoteCacheTTL    = 10 * time.Minute
+	// remoteCacheTimeout bounds a Redis round trip; past it the lookup
+	// counts as a miss and the database is asked instead.
+	remoteCacheTimeout = 100 * time.Millisecond
+	// remotePostingsLimit keeps the posting lists of very common terms out
+	// of Redis, where they would cost more to move than to query.
+	remotePostingsLimit = 10000
+)
+
+// Kinds of entries in the remote cache.
+const (
+	remoteTerm     = "term"
+	remotePostings = "postings"
+	remoteResult   = "result"
+)
+
+// remoteCache is a Redis cache shared by every search API instance, behind
+// each one's own LRU caches: a local miss is looked up here before the
+// database, and whatever is loaded from the database is written to both.
+// Entries are gob-encoded. Redis failing only costs the lookups.
+type remoteCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// remoteResultEntry is a cached search response as stored in Redis.
+type remoteResultEntry struct {
+	Results    []SearchResult
+	Total      int
+	Suggestion string
+	CachedAt   time.Time
+}
+
+// UseRemoteCache puts client behind the engine's term, posting and result
+// caches so instances sharing it share what they have loaded. Invalidations
+// are broadcast through it too, and until ctx is done the engine drops the
+// documents other instances invalidate from its own caches.
+func (e *QueryEngine) UseRemoteCache(ctx context.Context, client *redis.Client, cfg *config.RemoteCacheConfig) {
+	prefix := defaultRemoteCachePrefix
+	if cfg.Prefix != "" {
+		prefix = cfg.Prefix
+	}
+	ttl := defaultRemoteCacheTTL
+	if cfg.TTL > 0 {
+		ttl = cfg.TTL
+	}
+	e.remote = &remoteCache{client: client, prefix: prefix, ttl: ttl}
+	go e.followInvalidations(ctx)
+}
+
+func (r *remoteCache) key(kind, id string) string {
+	return r.prefix + ":" + kind + ":" + id
+}
+
+func (r *remoteCache) invalidationChannel() string {
+	return r.prefix + ":invalidate"
+}
+
+// remoteLoad returns the entries of kind cached under ids that decode as T.
+func remoteLoad[T any](ctx context.Context, r *remoteCache, kind string, ids []string) map[string]T {
+	if r == nil || len(ids) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, remoteCacheTimeout)
+	defer cancel()
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = r.key(kind, id)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("Remote cache lookup failed: %v", err)
+		return nil
+	}
+	found := make(map[string]T, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var v T
+		if err := gob.NewDecoder(bytes.NewReader([]byte(data))).Decode(&v); err != nil {
+			continue
+		}
+		found[ids[i]] = v
+	}
+	return found
+}
+
+// remoteStore caches entries of kind in the background, so searches don't
+// wait on the write.
+func remoteStore[T any](r *remoteCache, kind string, entries map[string]T) {
+	if r == nil || len(entries) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		pipe := r.client.Pipeline()
+		for id, v := range entries {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+				continue
+			}
+			pipe.Set(ctx, r.key(kind, id), buf.Bytes(), r.ttl)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("Remote cache write failed: %v", err)
+		}
+	}()
+}
+
+// invalidate removes the entries that may list deleted documents, postings
+// and results, and tells the other instances which documents went.
+func (r *remoteCache) invalidate(ctx context.Context, docIDs []int64) error {
+	for _, kind := range []string{remotePostings, remoteResult} {
+		iter := r.client.Scan(ctx, 0, r.key(kind, "*"), 1000).Iterator()
+		var keys []string
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+			if len(keys) == 1000 {
+				if err := r.client.Unlink(ctx, keys...).Err(); err != nil {
+					return err
+				}
+				keys = keys[:0]
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := r.client.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	data, err := json.Marshal(docIDs)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.invalidationChannel(), data).Err()
+}
+
+// followInvalidations drops the documents other instances invalidate from
+// the local caches until ctx is done.
+func (e *QueryEngine) followInvalidations(ctx context.Context) {
+	sub := e.remote.client.Subscribe(ctx, e.remote.invalidationChannel())
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var docIDs []int64
+			if err := json.Unmarshal([]byte(msg.Payload), &docIDs); err != nil {
+				log.Printf("Ignoring malformed cache invalidation: %v", err)
+				continue
+			}
+			e.invalidateLocal(docIDs)
+		}
+	}
+}
+
+// termIDKeys returns the remote cache ids of termIDs.
+func termIDKeys(termIDs []int64) []string {
+	ids := make([]string, len(termIDs))
+	for i, termID := range termIDs {
+		ids[i] = strconv.FormatInt(termID, 10)
+	}
+	return ids
+}
@@ -0,0 +1,30 @@
+package query
+
+import (
+	"context"
+	"log"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID tags ctx with the API request ID so that every log line the
+// engine writes while serving the request can be correlated with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs with the request ID from ctx, when there is one.
+func (e *QueryEngine) logf(ctx context.Context, format string, args ...any) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		format = "[request_id=" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
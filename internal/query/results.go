@@ -9,15 +9,18 @@ import (
 	"unicode/utf8"
 )
 
+const snippetLength = 150
+
 type DocumentDetail struct {
-	ID          int64
-	URL         string
-	Title       string
-	Description string
-	TokenCount  int
+	ID            int64
+	URL           string
+	Title         string
+	Description   string
+	TokenCount    int
+	SourceQuality float64
 }
 
-func (e *QueryEngine) fetchDocumentDetailsBatch(ctx context.Context, scoredDocs []ScoredDoc, queryTerms []string) ([]SearchResult, error) {
+func (e *QueryEngine) fetchDocumentDetailsBatch(ctx context.Context, scoredDocs []ScoredDoc, plan *QueryPlan) ([]SearchResult, error) {
 	if len(scoredDocs) == 0 {
 		return nil, nil
 	}
@@ -32,6 +35,14 @@ func (e *QueryEngine) fetchDocumentDetailsBatch(ctx context.Context, scoredDocs
 		return nil, fmt.Errorf("failed to fetch document details: %w", err)
 	}
 
+	// Snippets come from the body where it has matches; the description
+	// is the fallback for other documents and for older indexes.
+	bodySnippets, err := e.bodySnippets(ctx, docIDs, plan, snippetLength)
+	if err != nil {
+		return nil, err
+	}
+	queryTerms := plan.terms
+
 	results := make([]SearchResult, 0, len(scoredDocs))
 
 	for _, sd := range scoredDocs {
@@ -40,7 +51,10 @@ func (e *QueryEngine) fetchDocumentDetailsBatch(ctx context.Context, scoredDocs
 			continue
 		}
 
-		snippet := e.generateEnhancedSnippet(doc.Description, queryTerms, 150)
+		snippet, ok := bodySnippets[sd.DocID]
+		if !ok {
+			snippet = e.generateEnhancedSnippet(doc.Description, queryTerms, snippetLength)
+		}
 
 		results = append(results, SearchResult{
 			DocID:       sd.DocID,
@@ -49,6 +63,8 @@ func (e *QueryEngine) fetchDocumentDetailsBatch(ctx context.Context, scoredDocs
 			Description: doc.Description,
 			Score:       sd.Score,
 			Snippet:     snippet,
+
+			SourceQuality: doc.SourceQuality,
 		})
 	}
 
@@ -58,10 +74,11 @@ func (e *QueryEngine) fetchDocumentDetailsBatch(ctx context.Context, scoredDocs
 func (e *QueryEngine) getDocumentDetailsBatch(ctx context.Context, docIDs []int64) (map[int64]DocumentDetail, error) {
 	result := make(map[int64]DocumentDetail, len(docIDs))
 	var missingDocIDs []int64
+	bypass := cacheBypassed(ctx)
 
 	for _, docID := range docIDs {
 		cacheKey := fmt.Sprintf("doc_detail_%d", docID)
-		if val, ok := e.docCache.Get(cacheKey); ok {
+		if val, ok := e.docCache.Get(cacheKey); ok && !bypass {
 			if detail, ok := val.(DocumentDetail); ok {
 				result[docID] = detail
 				continue
@@ -71,18 +88,12 @@ func (e *QueryEngine) getDocumentDetailsBatch(ctx context.Context, docIDs []int6
 	}
 
 	if len(missingDocIDs) > 0 {
-		rows, err := e.pool.Query(ctx, getDocumentsBatch, missingDocIDs, missingDocIDs)
+		details, err := e.index.Documents(ctx, missingDocIDs)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch documents: %w", err)
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var detail DocumentDetail
-			if err := rows.Scan(&detail.ID, &detail.URL, &detail.Title, &detail.Description, &detail.TokenCount); err != nil {
-				continue
-			}
 
+		for _, detail := range details {
 			result[detail.ID] = detail
 
 			cacheKey := fmt.Sprintf("doc_detail_%d", detail.ID)
@@ -221,56 +232,6 @@ func (e *QueryEngine) highlightTerms(text string, queryTerms []string) string {
 	return result
 }
 
-func (e *QueryEngine) rankResults(results []SearchResult, queryTerms []string) []SearchResult {
-	if len(results) <= 1 {
-		return results
-	}
-
-	for i := range results {
-		results[i].Score = e.calculateEnhancedScore(results[i], queryTerms)
-	}
-
-	for i := 0; i < len(results); i++ {
-		for j := i + 1; j < len(results); j++ {
-			if results[i].Score < results[j].Score {
-				results[i], results[j] = results[j], results[i]
-			}
-		}
-	}
-
-	return results
-}
-
-func (e *QueryEngine) calculateEnhancedScore(result SearchResult, queryTerms []string) float64 {
-	score := result.Score
-
-	titleLower := strings.ToLower(result.Title)
-	titleBoost := 0.0
-	for _, term := range queryTerms {
-		termLower := strings.ToLower(term)
-		if strings.Contains(titleLower, termLower) {
-			titleBoost += 0.2 * float64(len(term))
-		}
-	}
-
-	urlBoost := 0.0
-	if len(result.URL) < 50 {
-		urlBoost = 0.1
-	} else if len(result.URL) > 200 {
-		urlBoost = -0.1
-	}
-
-	descBoost := 0.0
-	descLower := strings.ToLower(result.Description)
-	for _, term := range queryTerms {
-		termLower := strings.ToLower(term)
-		count := strings.Count(descLower, termLower)
-		descBoost += float64(count) * 0.05
-	}
-
-	return score + titleBoost + urlBoost + descBoost
-}
-
 func truncateByWords(text string, maxWords int) string {
 	words := strings.Fields(text)
 	if len(words) <= maxWords {
@@ -0,0 +1,88 @@
+package query
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultSnapshotMinHits = 2
+
+type cacheSnapshot struct {
+	SavedAt  time.Time
+	Terms    map[string]int64
+	Postings map[int64][]Posting
+}
+
+// SaveCacheSnapshot writes the hottest term and posting cache entries to path
+// so that a restarted engine can serve popular queries without a cold start.
+func (e *QueryEngine) SaveCacheSnapshot(path string) error {
+	snapshot := cacheSnapshot{
+		SavedAt:  time.Now(),
+		Terms:    make(map[string]int64),
+		Postings: make(map[int64][]Posting),
+	}
+	for _, entry := range e.termCache.Entries(e.snapshotMinHits) {
+		term, ok := entry.Key.(string)
+		if !ok {
+			continue
+		}
+		if id, ok := entry.Value.(int64); ok {
+			snapshot.Terms[term] = id
+		}
+	}
+	for _, entry := range e.postingCache.Entries(e.snapshotMinHits) {
+		termID, ok := entry.Key.(int64)
+		if !ok {
+			continue
+		}
+		if postings, ok := entry.Value.([]Posting); ok {
+			snapshot.Postings[termID] = postings
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = gob.NewEncoder(tmp).Encode(&snapshot); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to move cache snapshot into place: %w", err)
+	}
+	return nil
+}
+
+// LoadCacheSnapshot populates the term and posting caches from a snapshot
+// written by SaveCacheSnapshot. A missing file is not an error.
+func (e *QueryEngine) LoadCacheSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open cache snapshot: %w", err)
+	}
+	defer f.Close()
+
+	var snapshot cacheSnapshot
+	if err = gob.NewDecoder(f).Decode(&snapshot); err != nil {
+		return 0, fmt.Errorf("failed to decode cache snapshot: %w", err)
+	}
+	for term, id := range snapshot.Terms {
+		e.termCache.Put(term, id)
+	}
+	for termID, postings := range snapshot.Postings {
+		e.postingCache.Put(termID, postings)
+	}
+	return len(snapshot.Terms) + len(snapshot.Postings), nil
+}
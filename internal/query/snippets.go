@@ -0,0 +1,138 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+)
+
+type span struct {
+	start, end int
+}
+
+// bodySnippets builds a snippet for each document from its stored body
+// text, highlighting the exact spans the query terms were indexed from.
+// Where no spans are recorded, as in postings written before offsets were,
+// the body passage mentioning the query terms most is used instead.
+// Documents without a stored body or a match in it are left out.
+func (e *QueryEngine) bodySnippets(ctx context.Context, docIDs []int64, plan *QueryPlan, maxLength int) (map[int64]string, error) {
+	if len(docIDs) == 0 || len(plan.termIDs) == 0 {
+		return nil, nil
+	}
+	docLengths, err := e.getDocumentLengthsBatch(ctx, docIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document lengths: %w", err)
+	}
+
+	postings, err := e.index.Offsets(ctx, docIDs, plan.termIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets: %w", err)
+	}
+	body := textproc.FieldIndex(textproc.FieldBody)
+	spansByDoc := make(map[int64][]span)
+	for _, p := range postings {
+		for i, pos := range p.Positions {
+			if 2*i+1 >= len(p.Offsets) || p.Offsets[2*i] < 0 || docLengths[p.DocID].fieldAt(pos) != body {
+				continue
+			}
+			spansByDoc[p.DocID] = append(spansByDoc[p.DocID], span{int(p.Offsets[2*i]), int(p.Offsets[2*i+1])})
+		}
+	}
+
+	bodies, err := e.index.StoredFields(ctx, docIDs, textproc.FieldBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bodies: %w", err)
+	}
+
+	snippets := make(map[int64]string, len(docIDs))
+	for docID, text := range bodies {
+		if snippet := highlightSpans(text, spansByDoc[docID], maxLength); snippet != "" {
+			snippets[docID] = snippet
+			continue
+		}
+		if mentionsAny(text, plan.terms) {
+			snippets[docID] = e.generateEnhancedSnippet(text, plan.terms, maxLength)
+		}
+	}
+	return snippets, nil
+}
+
+// mentionsAny reports whether text contains any of terms, ignoring case.
+func mentionsAny(text string, terms []string) bool {
+	lower := strings.ToLower(text)
+	for _, term := range terms {
+		if len(term) > 2 && strings.Contains(lower, strings.ToLower(term)) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightSpans cuts the window of text holding the most spans, extended
+// to whole words, and marks every span inside it.
+func highlightSpans(text string, spans []span, maxLength int) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	kept := spans[:0]
+	for _, s := range spans {
+		if s.end > len(text) || (len(kept) > 0 && s.start < kept[len(kept)-1].end) {
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+
+	best, bestHits := 0, 0
+	for i := range kept {
+		hits := 0
+		for j := i; j < len(kept) && kept[j].end-kept[i].start <= maxLength; j++ {
+			hits++
+		}
+		if hits > bestHits {
+			best, bestHits = i, hits
+		}
+	}
+
+	// Lead in with a little context before the first match.
+	start := kept[best].start - maxLength/4
+	if start <= 0 {
+		start = 0
+	} else if i := strings.IndexByte(text[start:kept[best].start], ' '); i >= 0 {
+		start += i + 1
+	} else {
+		start = kept[best].start
+	}
+	end := start + maxLength
+	if end >= len(text) {
+		end = len(text)
+	} else if i := strings.LastIndexByte(text[kept[best].end:end], ' '); i >= 0 {
+		end = kept[best].end + i
+	} else {
+		end = kept[best].end
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	cursor := start
+	for _, s := range kept[best:] {
+		if s.end > end {
+			break
+		}
+		b.WriteString(text[cursor:s.start])
+		b.WriteString("<mark>")
+		b.WriteString(text[s.start:s.end])
+		b.WriteString("</mark>")
+		cursor = s.end
+	}
+	b.WriteString(text[cursor:end])
+	if end < len(text) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
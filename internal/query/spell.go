@@ -0,0 +1,249 @@
+package query
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultSpellVocabularySize = 100000
+	defaultSpellMaxDocFreq     = 2
+	// spellDocFreqRatio is how many times more documents a correction must
+	// be in than the word it replaces.
+	spellDocFreqRatio = 10
+)
+
+var queryWordRegex = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// vocabulary holds the most common indexed terms and their document
+// frequencies, the terms of each length ordered most frequent first.
+type vocabulary struct {
+	docFreq  map[string]int64
+	byLength map[int][]string
+	// complete is false when more terms are indexed than were loaded, so
+	// a term missing from docFreq may still be common.
+	complete bool
+}
+
+// loadVocabulary reloads the vocabulary suggestions are drawn from. It
+// reads the term_frequencies view, which the indexer refreshes.
+func (e *QueryEngine) loadVocabulary() {
+	if e.spellVocabularySize <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	docFreqs, err := e.index.Vocabulary(ctx, e.spellVocabularySize)
+	if err != nil {
+		e.logf(ctx, "failed to load the spelling vocabulary: %v", err)
+		return
+	}
+
+	v := &vocabulary{docFreq: docFreqs, byLength: make(map[int][]string)}
+	for term := range docFreqs {
+		n := utf8.RuneCountInString(term)
+		v.byLength[n] = append(v.byLength[n], term)
+	}
+	for _, terms := range v.byLength {
+		slices.SortFunc(terms, func(a, b string) int {
+			if c := cmp.Compare(docFreqs[b], docFreqs[a]); c != 0 {
+				return c
+			}
+			return strings.Compare(a, b)
+		})
+	}
+	v.complete = len(v.docFreq) < e.spellVocabularySize
+	e.vocabulary.Store(v)
+}
+
+// suggest returns rawQuery with each word that looks misspelled replaced by
+// its likeliest correction, or "" if no word was replaced. A word looks
+// misspelled when it is in at most spellMaxDocFreq documents; its
+// correction is the vocabulary term fewest edits away, the most common
+// one on a tie, that is in spellDocFreqRatio times more documents.
+// Operators, filters and words with digits are left alone.
+func (e *QueryEngine) suggest(ctx context.Context, rawQuery string) string {
+	v := e.vocabulary.Load()
+	if v == nil {
+		return ""
+	}
+	filters := filterRegex.FindAllStringIndex(rawQuery, -1)
+	inFilter := func(pos int) bool {
+		for _, f := range filters {
+			if pos >= f[0] && pos < f[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	type word struct {
+		start, end int
+		term       string
+	}
+	var words []word
+	var unknown []string
+	for _, loc := range queryWordRegex.FindAllStringIndex(rawQuery, -1) {
+		text := rawQuery[loc[0]:loc[1]]
+		switch text {
+		case "AND", "OR", "NOT", "NEAR":
+			continue
+		}
+		if inFilter(loc[0]) || strings.IndexFunc(text, unicode.IsDigit) >= 0 {
+			continue
+		}
+		positions := e.analyzer.AnalyzeQueryForms(text)
+		if len(positions) != 1 || len(positions[0]) == 0 {
+			continue
+		}
+		term := positions[0][0]
+		words = append(words, word{start: loc[0], end: loc[1], term: term})
+		if _, ok := v.docFreq[term]; !ok {
+			unknown = append(unknown, term)
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+
+	docFreq := func(term string) int64 { return v.docFreq[term] }
+	if !v.complete && len(unknown) > 0 {
+		// Terms past the vocabulary's cut-off may still be common enough
+		// to be spelled right.
+		found, err := e.termDocFrequencies(ctx, unknown)
+		if err != nil {
+			e.logf(ctx, "spelling suggestion for %q failed: %v", rawQuery, err)
+			return ""
+		}
+		docFreq = func(term string) int64 {
+			if df, ok := v.docFreq[term]; ok {
+				return df
+			}
+			return found[term]
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	changed := false
+	for _, w := range words {
+		df := docFreq(w.term)
+		if df > e.spellMaxDocFreq {
+			continue
+		}
+		correction := v.correct(w.term, max(e.spellMaxDocFreq+1, df*spellDocFreqRatio))
+		if correction == "" {
+			continue
+		}
+		b.WriteString(rawQuery[last:w.start])
+		b.WriteString(e.surfaceForm(rawQuery[w.start:w.end], w.term, correction))
+		last = w.end
+		changed = true
+	}
+	if !changed {
+		return ""
+	}
+	b.WriteString(rawQuery[last:])
+	return b.String()
+}
+
+// termDocFrequencies returns the document frequency of each of terms that
+// is indexed.
+func (e *QueryEngine) termDocFrequencies(ctx context.Context, terms []string) (map[string]int64, error) {
+	found, err := e.index.TermDocFrequencies(ctx, terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch document frequencies: %w", err)
+	}
+	return found, nil
+}
+
+// correct returns the term within reach of term with at least minDocFreq
+// documents, fewest edits away first and most common first among those;
+// "" if there is none. Short terms are allowed one edit, longer ones two,
+// and terms under three letters none.
+func (v *vocabulary) correct(term string, minDocFreq int64) string {
+	n := utf8.RuneCountInString(term)
+	if n < 3 {
+		return ""
+	}
+	maxEdits := 1
+	if n > 4 {
+		maxEdits = 2
+	}
+	best := ""
+	bestEdits := maxEdits + 1
+	var bestDocFreq int64
+	for length := n - maxEdits; length <= n+maxEdits; length++ {
+		for _, candidate := range v.byLength[length] {
+			docFreq := v.docFreq[candidate]
+			if docFreq < minDocFreq {
+				// The rest of this length are rarer still.
+				break
+			}
+			edits := editDistance(term, candidate, min(bestEdits, maxEdits)+1)
+			if edits < bestEdits || (edits == bestEdits && edits <= maxEdits && docFreq > bestDocFreq) {
+				best, bestEdits, bestDocFreq = candidate, edits, docFreq
+			}
+		}
+	}
+	return best
+}
+
+// surfaceForm spells correction the way word was written: word minus its
+// analyzed term, with correction in the term's place, when that analyzes
+// back to correction. Otherwise it falls back to correction itself.
+func (e *QueryEngine) surfaceForm(word, term, correction string) string {
+	lower := strings.ToLower(word)
+	if suffix, ok := strings.CutPrefix(lower, term); ok {
+		candidate := correction + suffix
+		if positions := e.analyzer.AnalyzeQueryForms(candidate); len(positions) == 1 && slices.Contains(positions[0], correction) {
+			return candidate
+		}
+	}
+	return correction
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b, counting insertions, deletions, substitutions and transpositions of
+// adjacent letters. It gives up at limit and returns limit once the
+// distance can't be less.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d >= limit || -d >= limit {
+		return limit
+	}
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin >= limit {
+			return limit
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return min(prev[len(rb)], limit)
+}
@@ -4,7 +4,13 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
 )
 
 func (e *QueryEngine) resolveTermIDsBatch(ctx context.Context, plan *QueryPlan) error {
@@ -12,11 +18,38 @@ func (e *QueryEngine) resolveTermIDsBatch(ctx context.Context, plan *QueryPlan)
 		return nil
 	}
 
-	termMap := make(map[string]int64, len(plan.terms))
+	var all []string
+	plan.root.walk(func(n *queryNode) {
+		for _, terms := range n.groups {
+			all = append(all, terms...)
+		}
+	})
+	termMap, err := e.lookupTermIDs(ctx, all)
+	if err != nil {
+		return err
+	}
+	plan.ids = termMap
+
+	plan.termIDs = make([]int64, 0, len(plan.terms))
+	plan.idGroups = make([]int, 0, len(plan.terms))
+	for i, term := range plan.terms {
+		if id, ok := termMap[term]; ok {
+			plan.termIDs = append(plan.termIDs, id)
+			plan.idGroups = append(plan.idGroups, plan.termGroups[i])
+		}
+	}
+
+	return nil
+}
+
+// lookupTermIDs returns the ids of the indexed terms among terms.
+func (e *QueryEngine) lookupTermIDs(ctx context.Context, terms []string) (map[string]int64, error) {
+	termMap := make(map[string]int64, len(terms))
 	var missingTerms []string
+	bypass := cacheBypassed(ctx)
 
-	for _, term := range plan.terms {
-		if val, ok := e.termCache.Get(term); ok {
+	for _, term := range terms {
+		if val, ok := e.termCache.Get(term); ok && !bypass {
 			if id, ok := val.(int64); ok {
 				termMap[term] = id
 				continue
@@ -25,40 +58,59 @@ func (e *QueryEngine) resolveTermIDsBatch(ctx context.Context, plan *QueryPlan)
 		missingTerms = append(missingTerms, term)
 	}
 
-	if len(missingTerms) > 0 {
-		rows, err := e.pool.Query(ctx, getTermsBatch, missingTerms)
-		if err != nil {
-			return fmt.Errorf("failed to fetch terms: %w", err)
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var term string
-			var id int64
-			if err := rows.Scan(&term, &id); err != nil {
+	if len(missingTerms) > 0 && !bypass {
+		shared := remoteLoad[int64](ctx, e.remote, remoteTerm, missingTerms)
+		missingTerms = missingTerms[:0:0]
+		for _, term := range terms {
+			if _, ok := termMap[term]; ok {
 				continue
 			}
-			termMap[term] = id
-			e.termCache.Put(term, id)
+			if id, ok := shared[term]; ok {
+				termMap[term] = id
+				e.termCache.Put(term, id)
+				continue
+			}
+			missingTerms = append(missingTerms, term)
 		}
 	}
 
-	plan.termIDs = make([]int64, 0, len(plan.terms))
-	for _, term := range plan.terms {
-		if id, ok := termMap[term]; ok {
-			plan.termIDs = append(plan.termIDs, id)
+	if len(missingTerms) > 0 {
+		key := slices.Clone(missingTerms)
+		slices.Sort(key)
+		loaded, err := flight(ctx, &e.termFlight, strings.Join(key, "\x00"), func(ctx context.Context) (map[string]int64, error) {
+			return e.loadTermIDs(ctx, missingTerms)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for term, id := range loaded {
+			termMap[term] = id
 		}
 	}
 
-	return nil
+	return termMap, nil
+}
+
+// loadTermIDs reads the ids of terms from the database into the caches.
+func (e *QueryEngine) loadTermIDs(ctx context.Context, terms []string) (map[string]int64, error) {
+	loaded, err := e.index.TermIDs(ctx, terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch terms: %w", err)
+	}
+	for term, id := range loaded {
+		e.termCache.Put(term, id)
+	}
+	remoteStore(e.remote, remoteTerm, loaded)
+	return loaded, nil
 }
 
 func (e *QueryEngine) getPostingsBatch(ctx context.Context, termIDs []int64) (map[int64][]Posting, error) {
 	result := make(map[int64][]Posting, len(termIDs))
 	var missingTermIDs []int64
+	bypass := cacheBypassed(ctx)
 
 	for _, termID := range termIDs {
-		if val, ok := e.postingCache.Get(termID); ok {
+		if val, ok := e.postingCache.Get(termID); ok && !bypass {
 			if postings, ok := val.([]Posting); ok {
 				result[termID] = postings
 				continue
@@ -67,164 +119,424 @@ func (e *QueryEngine) getPostingsBatch(ctx context.Context, termIDs []int64) (ma
 		missingTermIDs = append(missingTermIDs, termID)
 	}
 
-	if len(missingTermIDs) > 0 {
-		rows, err := e.pool.Query(ctx, getPostingsByTermIDBatch, missingTermIDs)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch postings: %w", err)
-		}
-		defer rows.Close()
-
-		postingsByTerm := make(map[int64][]Posting)
-		for rows.Next() {
-			var termID, docID int64
-			var positions []int32
-
-			if err := rows.Scan(&termID, &docID, &positions); err != nil {
+	if len(missingTermIDs) > 0 && !bypass {
+		shared := remoteLoad[[]Posting](ctx, e.remote, remotePostings, termIDKeys(missingTermIDs))
+		stillMissing := missingTermIDs[:0:0]
+		for _, termID := range missingTermIDs {
+			if postings, ok := shared[strconv.FormatInt(termID, 10)]; ok {
+				result[termID] = postings
+				e.postingCache.Put(termID, postings)
 				continue
 			}
-
-			postingsByTerm[termID] = append(postingsByTerm[termID], Posting{
-				DocID:     docID,
-				Positions: positions,
-			})
+			stillMissing = append(stillMissing, termID)
 		}
+		missingTermIDs = stillMissing
+	}
 
-		for termID, postings := range postingsByTerm {
+	if len(missingTermIDs) > 0 {
+		key := slices.Clone(missingTermIDs)
+		slices.Sort(key)
+		loaded, err := flight(ctx, &e.postingFlight, strings.Join(termIDKeys(key), ","), func(ctx context.Context) (map[int64][]Posting, error) {
+			return e.loadPostings(ctx, missingTermIDs)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for termID, postings := range loaded {
 			result[termID] = postings
-			e.postingCache.Put(termID, postings)
 		}
 	}
 
 	return result, nil
 }
 
-func (e *QueryEngine) booleanSearchOptimized(ctx context.Context, plan *QueryPlan) ([]int64, error) {
-	if len(plan.termIDs) == 0 {
-		return nil, nil
+// loadPostings reads the postings of termIDs from the database into the
+// caches.
+func (e *QueryEngine) loadPostings(ctx context.Context, termIDs []int64) (map[int64][]Posting, error) {
+	postingsByTerm, err := e.index.Postings(ctx, termIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch postings: %w", err)
 	}
 
-	var siteFilteredDocs map[int64]struct{}
-	if site, ok := plan.filters["site"]; ok {
-		rows, err := e.pool.Query(ctx, getSiteFilteredDocs, site)
-		if err != nil {
-			return nil, fmt.Errorf("site filter failed: %w", err)
-		}
-		defer rows.Close()
-
-		siteFilteredDocs = make(map[int64]struct{})
-		for rows.Next() {
-			var docID int64
-			if err := rows.Scan(&docID); err != nil {
-				continue
-			}
-			siteFilteredDocs[docID] = struct{}{}
-		}
-
-		if len(siteFilteredDocs) == 0 {
-			return nil, nil
+	shareable := make(map[string][]Posting)
+	for termID, postings := range postingsByTerm {
+		e.postingCache.Put(termID, postings)
+		if len(postings) <= remotePostingsLimit {
+			shareable[strconv.FormatInt(termID, 10)] = postings
 		}
 	}
+	remoteStore(e.remote, remotePostings, shareable)
+	return postingsByTerm, nil
+}
 
-	var docIDs []int64
-	var err error
+// booleanSearchOptimized evaluates the query tree, within the documents the
+// plan's filters allow.
+func (e *QueryEngine) booleanSearchOptimized(ctx context.Context, plan *QueryPlan) ([]int64, error) {
+	if len(plan.termIDs) == 0 {
+		return nil, nil
+	}
 
-	if plan.operator == "AND" {
-		docIDs, err = e.performIntersectionSearch(ctx, plan.termIDs, siteFilteredDocs)
-	} else {
-		docIDs, err = e.performUnionSearch(ctx, plan.termIDs, siteFilteredDocs)
+	allowedDocs, err := e.filterDocuments(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	if allowedDocs != nil && len(allowedDocs) == 0 {
+		return nil, nil
 	}
 
+	matched, err := e.evaluate(ctx, plan, plan.root, allowedDocs)
 	if err != nil {
 		return nil, fmt.Errorf("boolean search failed: %w", err)
 	}
 
+	docIDs := make([]int64, 0, len(matched))
+	for docID := range matched {
+		docIDs = append(docIDs, docID)
+	}
 	return docIDs, nil
 }
 
-func (e *QueryEngine) performIntersectionSearch(ctx context.Context, termIDs []int64, siteFilter map[int64]struct{}) ([]int64, error) {
-	rows, err := e.pool.Query(ctx, getBooleanIntersection, termIDs, len(termIDs))
+// filterDocuments resolves the document-restricting filters in the plan
+// (links_to:, lang:, inurl:, title:, before:, after:) into the set of
+// allowed doc IDs. A nil set means the plan has no such filters. site: is
+// left to the queries matching terms, which check it per matched document.
+func (e *QueryEngine) filterDocuments(ctx context.Context, plan *QueryPlan) (map[int64]struct{}, error) {
+	before, err := filterDate(plan, "before")
+	if err != nil {
+		return nil, err
+	}
+	after, err := filterDate(plan, "after")
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	docIDs := GetDocIDSlice()
-	defer PutDocIDSlice(docIDs)
+	var allowed map[int64]struct{}
+	apply := func(name string, docIDs []int64, err error) error {
+		if err != nil {
+			return fmt.Errorf("%s filter failed: %w", name, err)
+		}
 
-	for rows.Next() {
-		var docID int64
-		if err := rows.Scan(&docID); err != nil {
-			continue
+		matched := make(map[int64]struct{})
+		for _, docID := range docIDs {
+			if allowed != nil {
+				if _, ok := allowed[docID]; !ok {
+					continue
+				}
+			}
+			matched[docID] = struct{}{}
 		}
+		allowed = matched
+		return nil
+	}
 
-		if siteFilter != nil {
-			if _, ok := siteFilter[docID]; !ok {
+	if host, ok := plan.filters["links_to"]; ok {
+		host = strings.TrimPrefix(strings.ToLower(host), "www.")
+		docIDs, err := e.index.LinkingTo(ctx, host)
+		if err := apply("links_to", docIDs, err); err != nil {
+			return nil, err
+		}
+	}
+	if before != nil || after != nil {
+		docIDs, err := e.index.PublishedBetween(ctx, before, after)
+		if err := apply("date", docIDs, err); err != nil {
+			return nil, err
+		}
+	}
+	if lang, ok := plan.filters["lang"]; ok {
+		// Documents record the primary language subtag, hi for hi-IN.
+		lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+		docIDs, err := e.index.InLanguage(ctx, lang)
+		if err := apply("lang", docIDs, err); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range []string{"inurl", "url"} {
+		if part, ok := plan.filters[name]; ok {
+			docIDs, err := e.index.URLContains(ctx, strings.ToLower(part))
+			if err := apply(name, docIDs, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range []string{"title", "intitle"} {
+		if text, ok := plan.filters[name]; ok {
+			termIDs, idGroups, groups, err := e.filterTermIDs(ctx, text)
+			if err != nil {
+				return nil, fmt.Errorf("%s filter failed: %w", name, err)
+			}
+			if groups == 0 {
 				continue
 			}
+			if termIDs == nil {
+				// A word nothing is indexed under is in no title.
+				return make(map[int64]struct{}), nil
+			}
+			titleBit := int16(1) << textproc.FieldIndex(textproc.FieldTitle)
+			docIDs, err := e.index.InFields(ctx, termIDs, idGroups, groups, titleBit)
+			if err := apply(name, docIDs, err); err != nil {
+				return nil, err
+			}
 		}
-
-		docIDs = append(docIDs, docID)
 	}
+	return allowed, nil
+}
 
-	result := make([]int64, len(docIDs))
-	copy(result, docIDs)
-	return result, nil
+// filterDate returns the date of the plan's before: or after: filter, nil
+// if it has none. A day, month or year in UTC stands for the start of it.
+func filterDate(plan *QueryPlan, name string) (*time.Time, error) {
+	value, ok := plan.filters[name]
+	if !ok {
+		return nil, nil
+	}
+	for _, layout := range []string{time.DateOnly, "2006-01", "2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s:%s is not a date like 2024-01-31, 2024-01 or 2024", ErrInvalidFilter, name, value)
 }
 
-func (e *QueryEngine) performUnionSearch(ctx context.Context, termIDs []int64, siteFilter map[int64]struct{}) ([]int64, error) {
-	rows, err := e.pool.Query(ctx, getBooleanUnion, termIDs)
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// filterTermIDs analyzes a filter's text like a query and resolves its
+// terms, returning each id with the index of its position and the number
+// of positions. The ids are nil when a position has no indexed term.
+func (e *QueryEngine) filterTermIDs(ctx context.Context, text string) ([]int64, []int, int, error) {
+	var positions [][]string
+	var terms []string
+	for _, group := range e.analyzer.AnalyzeQuery(text) {
+		var kept []string
+		for _, term := range group {
+			if term != "" {
+				kept = append(kept, term)
+			}
+		}
+		if len(kept) > 0 {
+			positions = append(positions, kept)
+			terms = append(terms, kept...)
+		}
+	}
+	if len(positions) == 0 {
+		return nil, nil, 0, nil
+	}
+	ids, err := e.lookupTermIDs(ctx, terms)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
-	defer rows.Close()
-
-	docIDs := GetDocIDSlice()
-	defer PutDocIDSlice(docIDs)
+	plan := &QueryPlan{ids: ids}
+	termIDs, idGroups, ok := plan.resolveGroups(positions)
+	if !ok {
+		return nil, nil, len(positions), nil
+	}
+	return termIDs, idGroups, len(positions), nil
+}
 
-	for rows.Next() {
-		var docID int64
-		if err := rows.Scan(&docID); err != nil {
-			continue
+// evaluate returns the documents matching node among candidates, or among
+// all documents when candidates is nil. Plain terms under an and are
+// intersected in one query; phrases and nested expressions then narrow the
+// result, and negated children are subtracted from it last, each only
+// looked up among the documents still left. A not anywhere else would match
+// nearly everything and matches nothing instead. The result is never nil:
+// a nil set of candidates means unrestricted, so no match is an empty set.
+func (e *QueryEngine) evaluate(ctx context.Context, plan *QueryPlan, node *queryNode, candidates map[int64]struct{}) (map[int64]struct{}, error) {
+	if node == nil {
+		return make(map[int64]struct{}), nil
+	}
+	switch node.kind {
+	case nodeTerm:
+		return e.termDocuments(ctx, plan, node.groups, candidates)
+	case nodePhrase, nodeNear:
+		return e.phraseDocuments(ctx, plan, node, candidates)
+	case nodeOr:
+		if need := plan.minimumShouldMatch(len(node.children)); need > 1 {
+			return e.evaluateAtLeast(ctx, plan, node.children, need, candidates)
+		}
+		union := make(map[int64]struct{})
+		for _, child := range node.children {
+			docs, err := e.evaluate(ctx, plan, child, candidates)
+			if err != nil {
+				return nil, err
+			}
+			for docID := range docs {
+				union[docID] = struct{}{}
+			}
+		}
+		return union, nil
+	case nodeAnd:
+		var terms [][]string
+		var rest, negated []*queryNode
+		for _, child := range node.children {
+			switch child.kind {
+			case nodeTerm:
+				terms = append(terms, child.groups...)
+			case nodeNot:
+				negated = append(negated, child.children[0])
+			default:
+				rest = append(rest, child)
+			}
+		}
+		if len(terms) == 0 && len(rest) == 0 {
+			return make(map[int64]struct{}), nil
+		}
+		matched := candidates
+		if len(terms) > 0 {
+			docs, err := e.termDocuments(ctx, plan, terms, matched)
+			if err != nil {
+				return nil, err
+			}
+			matched = docs
+		}
+		for _, child := range rest {
+			if matched != nil && len(matched) == 0 {
+				return matched, nil
+			}
+			docs, err := e.evaluate(ctx, plan, child, matched)
+			if err != nil {
+				return nil, err
+			}
+			matched = docs
 		}
+		for _, child := range negated {
+			if len(matched) == 0 {
+				break
+			}
+			docs, err := e.evaluate(ctx, plan, child, matched)
+			if err != nil {
+				return nil, err
+			}
+			for docID := range docs {
+				delete(matched, docID)
+			}
+		}
+		return matched, nil
+	default:
+		return make(map[int64]struct{}), nil
+	}
+}
 
-		if siteFilter != nil {
-			if _, ok := siteFilter[docID]; !ok {
-				continue
+// evaluateAtLeast returns the documents among candidates matching at least
+// need of children. When they are all single words it is one query
+// counting the words each document holds, as for an and.
+func (e *QueryEngine) evaluateAtLeast(ctx context.Context, plan *QueryPlan, children []*queryNode, need int, candidates map[int64]struct{}) (map[int64]struct{}, error) {
+	words := true
+	for _, child := range children {
+		if child.kind != nodeTerm || len(child.groups) != 1 {
+			words = false
+			break
+		}
+	}
+	if words {
+		var termIDs []int64
+		var idGroups []int
+		indexed := 0
+		for i, child := range children {
+			found := false
+			for _, term := range child.groups[0] {
+				if id, ok := plan.ids[term]; ok {
+					termIDs = append(termIDs, id)
+					idGroups = append(idGroups, i)
+					found = true
+				}
+			}
+			if found {
+				indexed++
 			}
 		}
+		if indexed < need {
+			return make(map[int64]struct{}), nil
+		}
+		return e.groupDocuments(ctx, plan, termIDs, idGroups, need, candidates)
+	}
 
-		docIDs = append(docIDs, docID)
+	counts := make(map[int64]int)
+	for _, child := range children {
+		docs, err := e.evaluate(ctx, plan, child, candidates)
+		if err != nil {
+			return nil, err
+		}
+		for docID := range docs {
+			counts[docID]++
+		}
 	}
+	matched := make(map[int64]struct{})
+	for docID, n := range counts {
+		if n >= need {
+			matched[docID] = struct{}{}
+		}
+	}
+	return matched, nil
+}
 
-	result := make([]int64, len(docIDs))
-	copy(result, docIDs)
-	return result, nil
+// termDocuments returns the documents among candidates holding a term of
+// every group. A group with no indexed term matches nothing.
+func (e *QueryEngine) termDocuments(ctx context.Context, plan *QueryPlan, groups [][]string, candidates map[int64]struct{}) (map[int64]struct{}, error) {
+	termIDs, idGroups, ok := plan.resolveGroups(groups)
+	if !ok {
+		return make(map[int64]struct{}), nil
+	}
+	return e.groupDocuments(ctx, plan, termIDs, idGroups, len(groups), candidates)
+}
+
+// groupDocuments returns the documents among candidates holding terms of at
+// least need distinct groups, idGroups giving the group of each of termIDs.
+func (e *QueryEngine) groupDocuments(ctx context.Context, plan *QueryPlan, termIDs []int64, idGroups []int, need int, candidates map[int64]struct{}) (map[int64]struct{}, error) {
+	var within []int64
+	if candidates != nil {
+		within = make([]int64, 0, len(candidates))
+		for docID := range candidates {
+			within = append(within, docID)
+		}
+	}
+	site, _ := plan.siteFilter()
+	docIDs, err := e.index.MatchGroups(ctx, GroupMatch{TermIDs: termIDs, Groups: idGroups, Need: need, Within: within, Site: site})
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make(map[int64]struct{}, len(docIDs))
+	for _, docID := range docIDs {
+		docs[docID] = struct{}{}
+	}
+	return docs, nil
 }
 
-func (e *QueryEngine) phraseSearchOptimized(ctx context.Context, plan *QueryPlan) ([]int64, error) {
-	if len(plan.termIDs) < 2 {
-		return e.booleanSearchOptimized(ctx, plan)
+// phraseDocuments returns the documents among candidates matching a phrase
+// or near node: holding a term of each of its groups close enough together.
+func (e *QueryEngine) phraseDocuments(ctx context.Context, plan *QueryPlan, node *queryNode, candidates map[int64]struct{}) (map[int64]struct{}, error) {
+	groups := node.groups
+	termIDs, idGroups, ok := plan.resolveGroups(groups)
+	if !ok {
+		return make(map[int64]struct{}), nil
+	}
+	grouped := make([][]int64, len(groups))
+	for i, termID := range termIDs {
+		grouped[idGroups[i]] = append(grouped[idGroups[i]], termID)
 	}
 
-	postingsByTerm, err := e.getPostingsBatch(ctx, plan.termIDs)
+	postingsByTerm, err := e.getPostingsBatch(ctx, termIDs)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, termID := range plan.termIDs {
-		if len(postingsByTerm[termID]) == 0 {
-			return nil, nil
+	commonDocs := e.findCommonDocuments(postingsByTerm, grouped)
+	if candidates != nil {
+		for docID := range commonDocs {
+			if _, ok := candidates[docID]; !ok {
+				delete(commonDocs, docID)
+			}
 		}
 	}
-
-	commonDocs := e.findCommonDocuments(postingsByTerm, plan.termIDs)
 	if len(commonDocs) == 0 {
-		return nil, nil
+		return make(map[int64]struct{}), nil
+	}
+	if commonDocs, err = e.restrictToSite(ctx, plan, commonDocs); err != nil {
+		return nil, err
+	}
+	if len(commonDocs) == 0 {
+		return make(map[int64]struct{}), nil
 	}
 
-	docIDs := GetDocIDSlice()
-	defer PutDocIDSlice(docIDs)
-
+	docs := make(map[int64]struct{})
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 
@@ -240,43 +552,72 @@ func (e *QueryEngine) phraseSearchOptimized(ctx context.Context, plan *QueryPlan
 		go func() {
 			defer wg.Done()
 			for docID := range docChan {
-				if e.checkPhraseMatch(docID, plan.termIDs, postingsByTerm) {
+				if ctx.Err() != nil {
+					return
+				}
+				if e.checkPhraseMatch(docID, grouped, postingsByTerm, node.slop, node.kind == nodePhrase) {
 					mu.Lock()
-					docIDs = append(docIDs, docID)
+					docs[docID] = struct{}{}
 					mu.Unlock()
 				}
 			}
 		}()
 	}
 
-	go func() {
-		for docID := range commonDocs {
-			docChan <- docID
-		}
-		close(docChan)
-	}()
+	for docID := range commonDocs {
+		docChan <- docID
+	}
+	close(docChan)
 
 	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
 
-	result := make([]int64, len(docIDs))
-	copy(result, docIDs)
-	return result, nil
+// restrictToSite keeps the documents among docs on the plan's site: domain
+// or its subdomains.
+func (e *QueryEngine) restrictToSite(ctx context.Context, plan *QueryPlan, docs map[int64]struct{}) (map[int64]struct{}, error) {
+	domain, ok := plan.siteFilter()
+	if !ok {
+		return docs, nil
+	}
+	docIDs := make([]int64, 0, len(docs))
+	for docID := range docs {
+		docIDs = append(docIDs, docID)
+	}
+	siteDocIDs, err := e.index.OnSite(ctx, docIDs, domain)
+	if err != nil {
+		return nil, fmt.Errorf("site filter failed: %w", err)
+	}
+
+	onSite := make(map[int64]struct{}, len(siteDocIDs))
+	for _, docID := range siteDocIDs {
+		onSite[docID] = struct{}{}
+	}
+	return onSite, nil
 }
 
-func (e *QueryEngine) findCommonDocuments(postingsByTerm map[int64][]Posting, termIDs []int64) map[int64]struct{} {
-	if len(termIDs) == 0 {
+// findCommonDocuments returns the documents holding a term of every group.
+func (e *QueryEngine) findCommonDocuments(postingsByTerm map[int64][]Posting, groups [][]int64) map[int64]struct{} {
+	if len(groups) == 0 {
 		return nil
 	}
 
 	commonDocs := make(map[int64]struct{})
-	for _, posting := range postingsByTerm[termIDs[0]] {
-		commonDocs[posting.DocID] = struct{}{}
+	for _, termID := range groups[0] {
+		for _, posting := range postingsByTerm[termID] {
+			commonDocs[posting.DocID] = struct{}{}
+		}
 	}
 
-	for i := 1; i < len(termIDs); i++ {
+	for i := 1; i < len(groups); i++ {
 		termDocs := make(map[int64]struct{})
-		for _, posting := range postingsByTerm[termIDs[i]] {
-			termDocs[posting.DocID] = struct{}{}
+		for _, termID := range groups[i] {
+			for _, posting := range postingsByTerm[termID] {
+				termDocs[posting.DocID] = struct{}{}
+			}
 		}
 
 		newCommonDocs := make(map[int64]struct{})
@@ -295,15 +636,20 @@ func (e *QueryEngine) findCommonDocuments(postingsByTerm map[int64][]Posting, te
 	return commonDocs
 }
 
-func (e *QueryEngine) checkPhraseMatch(docID int64, termIDs []int64, postingsByTerm map[int64][]Posting) bool {
+// checkPhraseMatch reports whether docID has a term of each group with at
+// most slop other words among them, in group order if ordered. An ordered
+// match with no slop is an exact phrase.
+func (e *QueryEngine) checkPhraseMatch(docID int64, groups [][]int64, postingsByTerm map[int64][]Posting, slop int, ordered bool) bool {
 
-	termPositions := make([][]int32, len(termIDs))
+	termPositions := make([][]int32, len(groups))
 
-	for i, termID := range termIDs {
-		for _, posting := range postingsByTerm[termID] {
-			if posting.DocID == docID {
-				termPositions[i] = posting.Positions
-				break
+	for i, group := range groups {
+		for _, termID := range group {
+			for _, posting := range postingsByTerm[termID] {
+				if posting.DocID == docID {
+					termPositions[i] = append(termPositions[i], posting.Positions...)
+					break
+				}
 			}
 		}
 
@@ -312,9 +658,84 @@ func (e *QueryEngine) checkPhraseMatch(docID int64, termIDs []int64, postingsByT
 		}
 	}
 
+	switch {
+	case !ordered:
+		return e.hasPositionsNear(termPositions, slop)
+	case slop > 0:
+		return e.hasPositionsInOrder(termPositions, slop)
+	}
 	return e.hasConsecutivePositions(termPositions)
 }
 
+// hasPositionsInOrder reports whether a position can be taken from each
+// list, each after the one before, with at most slop positions skipped
+// overall. Taking the earliest position after the previous one each time
+// keeps the span from a given start as short as it can be.
+func (e *QueryEngine) hasPositionsInOrder(termPositions [][]int32, slop int) bool {
+	sorted := make([][]int32, len(termPositions))
+	for i, positions := range termPositions {
+		sorted[i] = slices.Clone(positions)
+		slices.Sort(sorted[i])
+	}
+	limit := int32(slop + len(sorted) - 1)
+	for _, start := range sorted[0] {
+		prev := start
+		matched := true
+		for _, positions := range sorted[1:] {
+			next, _ := slices.BinarySearch(positions, prev+1)
+			if next == len(positions) || positions[next]-start > limit {
+				matched = false
+				break
+			}
+			prev = positions[next]
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPositionsNear reports whether a position can be taken from each list,
+// in any order, spanning at most slop positions besides their own. It
+// slides a window over all positions in order, shrinking it from the left
+// while it still covers every list.
+func (e *QueryEngine) hasPositionsNear(termPositions [][]int32, slop int) bool {
+	type occurrence struct {
+		pos   int32
+		group int
+	}
+	var all []occurrence
+	for group, positions := range termPositions {
+		for _, pos := range positions {
+			all = append(all, occurrence{pos: pos, group: group})
+		}
+	}
+	slices.SortFunc(all, func(a, b occurrence) int { return int(a.pos - b.pos) })
+
+	limit := int32(slop + len(termPositions) - 1)
+	counts := make([]int, len(termPositions))
+	covered := 0
+	left := 0
+	for _, o := range all {
+		if counts[o.group] == 0 {
+			covered++
+		}
+		counts[o.group]++
+		for covered == len(termPositions) {
+			if o.pos-all[left].pos <= limit {
+				return true
+			}
+			counts[all[left].group]--
+			if counts[all[left].group] == 0 {
+				covered--
+			}
+			left++
+		}
+	}
+	return false
+}
+
 func (e *QueryEngine) hasConsecutivePositions(termPositions [][]int32) bool {
 	if len(termPositions) == 0 {
 		return false
@@ -381,8 +802,9 @@ func (e *QueryEngine) checkConsecutiveFromPositionOptimized(startPos int32, term
 func (e *QueryEngine) getIDFBatch(ctx context.Context, termIDs []int64) (map[int64]float64, error) {
 	result := make(map[int64]float64, len(termIDs))
 	var missingTermIDs []int64
+	bypass := cacheBypassed(ctx)
 	for _, termID := range termIDs {
-		if val, ok := e.idfCache.Get(termID); ok {
+		if val, ok := e.idfCache.Get(termID); ok && !bypass {
 			if idf, ok := val.(float64); ok {
 				result[termID] = idf
 				continue
@@ -393,19 +815,12 @@ func (e *QueryEngine) getIDFBatch(ctx context.Context, termIDs []int64) (map[int
 	if len(missingTermIDs) > 0 {
 		totalDocs := float64(e.getTotalDocs())
 
-		rows, err := e.pool.Query(ctx, getDocFrequencyBatch, missingTermIDs)
+		docFreqs, err := e.index.DocFrequencies(ctx, missingTermIDs)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch document frequencies: %w", err)
 		}
-		defer rows.Close()
 
-		for rows.Next() {
-			var termID int64
-			var docFreq int64
-
-			if err := rows.Scan(&termID, &docFreq); err != nil {
-				continue
-			}
+		for termID, docFreq := range docFreqs {
 			idf := math.Log(totalDocs / float64(docFreq+1))
 			result[termID] = idf
 			e.idfCache.Put(termID, idf)
@@ -0,0 +1,136 @@
+package query
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/amankumarsingh77/search_engine/internal/cache"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
+)
+
+// memoryIndex serves the term lookups of query matching from a few
+// documents analyzed with the default pipeline, document i+1 being texts[i].
+type memoryIndex struct {
+	Index
+	terms    map[string]int64
+	postings map[int64][]Posting
+}
+
+func newMemoryIndex(texts ...string) *memoryIndex {
+	var analyzer *textproc.Analyzer
+	idx := &memoryIndex{terms: make(map[string]int64), postings: make(map[int64][]Posting)}
+	for i, text := range texts {
+		docID := int64(i + 1)
+		positions := make(map[int64][]int32)
+		var order []int64
+		for pos, terms := range analyzer.Analyze(text) {
+			for _, term := range terms {
+				termID, ok := idx.terms[term]
+				if !ok {
+					termID = int64(len(idx.terms) + 1)
+					idx.terms[term] = termID
+				}
+				if _, ok := positions[termID]; !ok {
+					order = append(order, termID)
+				}
+				positions[termID] = append(positions[termID], int32(pos))
+			}
+		}
+		for _, termID := range order {
+			idx.postings[termID] = append(idx.postings[termID], Posting{DocID: docID, Positions: positions[termID]})
+		}
+	}
+	return idx
+}
+
+func (m *memoryIndex) TermIDs(ctx context.Context, terms []string) (map[string]int64, error) {
+	ids := make(map[string]int64)
+	for _, term := range terms {
+		if id, ok := m.terms[term]; ok {
+			ids[term] = id
+		}
+	}
+	return ids, nil
+}
+
+func (m *memoryIndex) Postings(ctx context.Context, termIDs []int64) (map[int64][]Posting, error) {
+	postings := make(map[int64][]Posting)
+	for _, termID := range termIDs {
+		if p, ok := m.postings[termID]; ok {
+			postings[termID] = p
+		}
+	}
+	return postings, nil
+}
+
+func (m *memoryIndex) MatchGroups(ctx context.Context, q GroupMatch) ([]int64, error) {
+	groups := make(map[int64]map[int]struct{})
+	for i, termID := range q.TermIDs {
+		for _, p := range m.postings[termID] {
+			if q.Within != nil && !slices.Contains(q.Within, p.DocID) {
+				continue
+			}
+			if groups[p.DocID] == nil {
+				groups[p.DocID] = make(map[int]struct{})
+			}
+			groups[p.DocID][q.Groups[i]] = struct{}{}
+		}
+	}
+	var docIDs []int64
+	for docID, matched := range groups {
+		if len(matched) >= q.Need {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	return docIDs, nil
+}
+
+// TestEvaluateNoMatchRestricts checks that a term or phrase matching nothing
+// under an and leaves nothing for its other children, rather than leaving
+// them unrestricted.
+func TestEvaluateNoMatchRestricts(t *testing.T) {
+	index := newMemoryIndex(
+		"shahrukh khan",
+		"salman khan",
+		"box shahrukh",
+		"office salman",
+	)
+	e := &QueryEngine{
+		index:        index,
+		termCache:    cache.NewLRUCache(100, time.Minute),
+		postingCache: cache.NewLRUCache(100, time.Minute),
+		maxWorkers:   2,
+	}
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{`unindexedword (shahrukh OR salman)`, nil},
+		{`"box office" (shahrukh OR salman)`, nil},
+		{`(shahrukh OR salman) "box office"`, nil},
+		{`shahrukh (khan OR box)`, []int64{1, 3}},
+		{`"shahrukh khan" (shahrukh OR salman)`, []int64{1}},
+	}
+	for _, tt := range tests {
+		plan := Parse(tt.query, 1, 10, nil)
+		ctx := context.Background()
+		if err := e.resolveTermIDsBatch(ctx, plan); err != nil {
+			t.Fatalf("%s: resolving terms: %v", tt.query, err)
+		}
+		docs, err := e.evaluate(ctx, plan, plan.root, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var got []int64
+		for docID := range docs {
+			got = append(got, docID)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s matched %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
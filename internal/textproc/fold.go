@@ -0,0 +1,48 @@
+package textproc
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// latinLigatures are letters that don't decompose into a base letter and
+// accents.
+var latinLigatures = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'ł': "l", 'Ł': "L", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "TH",
+	'ı': "i", 'ĸ': "k", 'ŋ': "n", 'Ŋ': "N", 'ſ': "s",
+}
+
+// asciiFold folds accented Latin letters to their ASCII base, so é becomes
+// e and ñ becomes n instead of being dropped by the standard tokenizer.
+// Other scripts are left alone: Devanagari vowel signs are combining marks
+// too and must stay attached.
+func asciiFold(text string) string {
+	if isASCII(text) {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range norm.NFC.String(text) {
+		if r < 0x80 {
+			b.WriteRune(r)
+			continue
+		}
+		if folded, ok := latinLigatures[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		if !unicode.Is(unicode.Latin, r) {
+			b.WriteRune(r)
+			continue
+		}
+		for _, d := range norm.NFD.String(string(r)) {
+			if !unicode.Is(unicode.Mn, d) {
+				b.WriteRune(d)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package textproc
+
+import (
+	"fmt"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/lemmatizer"
+)
+
+// Document fields, indexed one after another in Fields order,
+// FieldPositionGap positions apart. Title has its own morphology; the others
+// share the body's. Anchor is the text of links pointing at the document; it
+// comes after the body so postings indexed before it existed keep their
+// field bits.
+const (
+	FieldTitle       = "title"
+	FieldDescription = "description"
+	FieldKeywords    = "keywords"
+	FieldHeadings    = "headings"
+	FieldBody        = "body"
+	FieldAnchor      = "anchor"
+)
+
+var Fields = []string{FieldTitle, FieldDescription, FieldKeywords, FieldHeadings, FieldBody, FieldAnchor}
+
+// FieldPositionGap is how many positions are left empty after each field, so
+// that phrase and proximity matches can't run from the end of one field into
+// the start of the next.
+const FieldPositionGap = 100
+
+// FieldIndex returns the index in Fields of field, or -1.
+func FieldIndex(field string) int {
+	for i, f := range Fields {
+		if f == field {
+			return i
+		}
+	}
+	return -1
+}
+
+// FieldAt returns the index in Fields of the field holding position pos of
+// a document whose fields have the given token counts and were laid out gap
+// positions apart. Documents indexed before fields were spaced have a gap of
+// 0. Positions past the recorded fields belong to the body.
+func FieldAt(lengths []int32, gap int32, pos int32) int {
+	var end int32
+	for i, n := range lengths {
+		end += n
+		if pos < end {
+			return i
+		}
+		end += gap
+	}
+	return bodyIndex
+}
+
+var bodyIndex = FieldIndex(FieldBody)
+
+const (
+	MorphStem  = "stem"
+	MorphLemma = "lemma"
+	MorphNone  = "none"
+)
+
+// reducer maps a token to its index term. Unlike the porter_stem filter it
+// never drops a token, so every field yields the same positions and a query
+// can carry each field's form of a word as alternatives.
+type reducer func(token string) string
+
+func newReducers(cfg config.MorphologyConfig) (map[string]reducer, error) {
+	reducers := make(map[string]reducer, 2)
+	for field, morph := range map[string]string{FieldTitle: cfg.Title, FieldBody: cfg.Body} {
+		r, err := newReducer(morph, cfg.Lang)
+		if err != nil {
+			return nil, fmt.Errorf("%s morphology: %w", field, err)
+		}
+		reducers[field] = r
+	}
+	return reducers, nil
+}
+
+func newReducer(morph, lang string) (reducer, error) {
+	switch morph {
+	case "", MorphStem:
+		return stemToken, nil
+	case MorphLemma:
+		lemmas, err := lemmatizer.New(lang)
+		if err != nil {
+			return nil, err
+		}
+		return lemmas.Lemma, nil
+	case MorphNone:
+		return func(token string) string { return token }, nil
+	default:
+		return nil, fmt.Errorf("unknown morphology %q", morph)
+	}
+}
+
+// stemToken stems English words and keeps anything Stem would pass through
+// or drop unchanged.
+func stemToken(token string) string {
+	if !isASCII(token) || hasDigit.MatchString(token) {
+		return token
+	}
+	if stemmed, ok := stemWord(token); ok {
+		return stemmed
+	}
+	return token
+}
@@ -0,0 +1,448 @@
+package textproc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/lemmatizer"
+)
+
+// CharFilter rewrites text before it is tokenized.
+type CharFilter func(text string) string
+
+// Tokenizer splits filtered text into tokens.
+type Tokenizer func(text string) []string
+
+// TokenFilter drops, rewrites or adds tokens. It may modify the slice it is
+// given and returns the tokens to pass on.
+type TokenFilter func(tokens []string) []string
+
+// Expander returns extra terms for a token, indexed or searched at the
+// token's own position: edge n-grams, synonyms.
+type Expander func(token string) []string
+
+type (
+	CharFilterFactory  func(cfg config.FilterConfig) (CharFilter, error)
+	TokenFilterFactory func(cfg config.FilterConfig) (TokenFilter, error)
+	// ExpanderFactory gets analyze, the rest of the pipeline, to bring any
+	// words it loads into the same form as the tokens it will see. analyze
+	// returns terms by position like Analyze, without expansions.
+	ExpanderFactory func(cfg config.FilterConfig, analyze func(text string) [][]string) (Expander, error)
+)
+
+var (
+	registryMu  sync.RWMutex
+	charFilters = map[string]CharFilterFactory{
+		"utf8":            func(config.FilterConfig) (CharFilter, error) { return RemoveInvalidUTF8, nil },
+		"lowercase":       func(config.FilterConfig) (CharFilter, error) { return strings.ToLower, nil },
+		"noise":           func(config.FilterConfig) (CharFilter, error) { return stripNoise, nil },
+		"ascii_fold":      func(config.FilterConfig) (CharFilter, error) { return asciiFold, nil },
+		"pattern_replace": newPatternReplace,
+	}
+	tokenizers = map[string]Tokenizer{
+		"standard":             standardTokenizer,
+		"alphanumeric":         alphanumericTokenizer,
+		"whitespace":           strings.Fields,
+		"unicode":              unicodeTokenizer,
+		"unicode_alphanumeric": unicodeAlphanumericTokenizer,
+	}
+	tokenFilters = map[string]TokenFilterFactory{
+		"length":      newLengthFilter,
+		"stop":        newStopFilter,
+		"junk":        func(config.FilterConfig) (TokenFilter, error) { return dropTokens(isJunk), nil },
+		"pattern":     newPatternFilter,
+		"porter_stem": func(config.FilterConfig) (TokenFilter, error) { return Stem, nil },
+		"lemmatize":   newLemmaFilter,
+		"transliterate": func(config.FilterConfig) (TokenFilter, error) {
+			return transliterate, nil
+		},
+	}
+	expanders = map[string]ExpanderFactory{
+		"edge_ngram": newEdgeNGramExpander,
+		"synonym":    newSynonymExpander,
+	}
+	// Edge n-grams only make sense in the index; expanding the query the
+	// same way would match any word sharing two letters. Synonyms default
+	// to query time so changing them doesn't need a re-index.
+	defaultStages = map[string]string{"edge_ngram": StageIndex, "synonym": StageQuery}
+)
+
+const (
+	StageIndex = "index"
+	StageQuery = "query"
+)
+
+const (
+	defaultMinGram = 2
+	defaultMaxGram = 10
+)
+
+// RegisterCharFilter makes a char filter available to the YAML config under
+// name. Registering a name twice replaces the earlier filter.
+func RegisterCharFilter(name string, factory CharFilterFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	charFilters[name] = factory
+}
+
+func RegisterTokenizer(name string, tokenizer Tokenizer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	tokenizers[name] = tokenizer
+}
+
+// RegisterTokenFilter makes a token filter available to the YAML config
+// under name. The filter should handle each token on its own: the indexer
+// runs it a token at a time to keep track of the text every term came from.
+func RegisterTokenFilter(name string, factory TokenFilterFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	tokenFilters[name] = factory
+}
+
+// RegisterExpander makes an expander available in the TokenFilters list.
+// Expanders run after every token filter, whatever their place in the list.
+func RegisterExpander(name string, factory ExpanderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	expanders[name] = factory
+}
+
+var (
+	defaultCharFilters  = []config.FilterConfig{{Type: "utf8"}, {Type: "lowercase"}, {Type: "ascii_fold"}, {Type: "noise"}}
+	defaultTokenizer    = "standard"
+	defaultTokenFilters = []config.FilterConfig{{Type: "length", Min: 2}, {Type: "stop"}, {Type: "junk"}, {Type: "porter_stem"}}
+)
+
+// Analyzer runs text through char filters, a tokenizer and token filters.
+// A nil Analyzer uses the default pipeline.
+type Analyzer struct {
+	charFilters  []CharFilter
+	tokenizer    Tokenizer
+	tokenFilters []stagedFilter
+	expanders    []stagedExpander
+	// reducers hold each field's morphology; nil when the token filters
+	// take care of it.
+	reducers map[string]reducer
+}
+
+type stagedFilter struct {
+	filter TokenFilter
+	stage  string
+}
+
+type stagedExpander struct {
+	expand Expander
+	stage  string
+}
+
+var defaultAnalyzer, _ = New(&config.AnalyzerConfig{})
+
+// New builds the pipeline described by cfg. Any part left empty gets the
+// defaults: utf8, lowercase, ascii_fold and noise char filters, the standard tokenizer,
+// and length (min 2), stop, junk and porter_stem token filters.
+func New(cfg *config.AnalyzerConfig) (*Analyzer, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	a := &Analyzer{}
+	chars := cfg.CharFilters
+	if len(chars) == 0 {
+		chars = defaultCharFilters
+	}
+	for _, fc := range chars {
+		factory, ok := charFilters[fc.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown char filter %q", fc.Type)
+		}
+		f, err := factory(fc)
+		if err != nil {
+			return nil, fmt.Errorf("char filter %s: %w", fc.Type, err)
+		}
+		a.charFilters = append(a.charFilters, f)
+	}
+
+	name := cfg.Tokenizer
+	if name == "" {
+		name = defaultTokenizer
+	}
+	var ok bool
+	if a.tokenizer, ok = tokenizers[name]; !ok {
+		return nil, fmt.Errorf("unknown tokenizer %q", name)
+	}
+
+	filters := cfg.TokenFilters
+	morphology := cfg.Morphology.Title != "" || cfg.Morphology.Body != ""
+	if len(filters) == 0 {
+		filters = defaultTokenFilters
+		if morphology {
+			filters = filters[:len(filters)-1]
+		}
+	}
+	if morphology {
+		for _, fc := range filters {
+			if fc.Type == "porter_stem" || fc.Type == "lemmatize" {
+				return nil, fmt.Errorf("token filter %s can't be combined with Morphology", fc.Type)
+			}
+		}
+		reducers, err := newReducers(cfg.Morphology)
+		if err != nil {
+			return nil, err
+		}
+		a.reducers = reducers
+	}
+	var expanderConfigs []config.FilterConfig
+	for _, fc := range filters {
+		stage := fc.Stage
+		if stage == "" {
+			stage = defaultStages[fc.Type]
+		}
+		if stage != "" && stage != StageIndex && stage != StageQuery {
+			return nil, fmt.Errorf("token filter %s: unknown stage %q", fc.Type, stage)
+		}
+		fc.Stage = stage
+		if _, ok := expanders[fc.Type]; ok {
+			expanderConfigs = append(expanderConfigs, fc)
+			continue
+		}
+		factory, ok := tokenFilters[fc.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown token filter %q", fc.Type)
+		}
+		f, err := factory(fc)
+		if err != nil {
+			return nil, fmt.Errorf("token filter %s: %w", fc.Type, err)
+		}
+		a.tokenFilters = append(a.tokenFilters, stagedFilter{filter: f, stage: stage})
+	}
+	// Expanders are built once every token filter is in place, so the words
+	// they load are analyzed exactly like the tokens they'll be matched to.
+	for _, fc := range expanderConfigs {
+		stage := fc.Stage
+		if stage == "" {
+			stage = StageIndex
+		}
+		analyze := func(text string) [][]string { return a.positions(text, stage, allFields, false) }
+		x, err := expanders[fc.Type](fc, analyze)
+		if err != nil {
+			return nil, fmt.Errorf("token filter %s: %w", fc.Type, err)
+		}
+		a.expanders = append(a.expanders, stagedExpander{expand: x, stage: fc.Stage})
+	}
+	return a, nil
+}
+
+var allFields = []string{FieldTitle, FieldBody}
+
+// Analyze returns the terms of a document's body text by position, running
+// the index-time filters. Each position holds its token followed by the
+// terms expanded from it.
+func (a *Analyzer) Analyze(text string) [][]string {
+	return a.AnalyzeField(FieldBody, text)
+}
+
+// AnalyzeField is Analyze with field's morphology.
+func (a *Analyzer) AnalyzeField(field, text string) [][]string {
+	if a == nil {
+		a = defaultAnalyzer
+	}
+	return a.positions(text, StageIndex, []string{field}, true)
+}
+
+// AnalyzeQuery returns the terms of a query by position, running the
+// query-time filters. The terms at one position are alternatives, including
+// the word's form under each field's morphology.
+func (a *Analyzer) AnalyzeQuery(text string) [][]string {
+	if a == nil {
+		a = defaultAnalyzer
+	}
+	return a.positions(text, StageQuery, allFields, true)
+}
+
+// AnalyzeQueryForms is AnalyzeQuery without the expansions, leaving each
+// word's forms: the terms a query excludes, where excluding a synonym too
+// would drop documents the user didn't ask to drop.
+func (a *Analyzer) AnalyzeQueryForms(text string) [][]string {
+	if a == nil {
+		a = defaultAnalyzer
+	}
+	return a.positions(text, StageQuery, allFields, false)
+}
+
+func (a *Analyzer) positions(text, stage string, fields []string, expand bool) [][]string {
+	return a.expand(a.tokens(text, stage), stage, fields, expand)
+}
+
+// expand turns tokens into positions: each token's form under the
+// morphology of fields, followed by its expansions when expand is set.
+func (a *Analyzer) expand(tokens []string, stage string, fields []string, expand bool) [][]string {
+	positions := make([][]string, len(tokens))
+	for i, token := range tokens {
+		terms := []string{}
+		if a.reducers == nil {
+			terms = append(terms, token)
+		} else {
+			for _, field := range fields {
+				if term := a.reducer(field)(token); !slices.Contains(terms, term) {
+					terms = append(terms, term)
+				}
+			}
+		}
+		if expand {
+			forms := len(terms)
+			for _, x := range a.expanders {
+				if x.stage != "" && x.stage != stage {
+					continue
+				}
+				for _, form := range terms[:forms] {
+					for _, term := range x.expand(form) {
+						if !slices.Contains(terms, term) {
+							terms = append(terms, term)
+						}
+					}
+				}
+			}
+		}
+		positions[i] = terms
+	}
+	return positions
+}
+
+func (a *Analyzer) reducer(field string) reducer {
+	if r, ok := a.reducers[field]; ok {
+		return r
+	}
+	return a.reducers[FieldBody]
+}
+
+// tokens runs text through the char filters, tokenizer and the token
+// filters for stage.
+func (a *Analyzer) tokens(text, stage string) []string {
+	for _, f := range a.charFilters {
+		text = f(text)
+	}
+	tokens := a.tokenizer(text)
+	for _, f := range a.tokenFilters {
+		if len(tokens) == 0 {
+			break
+		}
+		if f.stage != "" && f.stage != stage {
+			continue
+		}
+		tokens = f.filter(tokens)
+	}
+	return tokens
+}
+
+// dropTokens filters out the tokens drop reports true for, in place.
+func dropTokens(drop func(token string) bool) TokenFilter {
+	return func(tokens []string) []string {
+		kept := tokens[:0]
+		for _, token := range tokens {
+			if !drop(token) {
+				kept = append(kept, token)
+			}
+		}
+		return kept
+	}
+}
+
+// newPatternReplace replaces every match of Pattern with Replacement, which
+// may refer to capture groups as $1.
+func newPatternReplace(cfg config.FilterConfig) (CharFilter, error) {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(text string) string {
+		return re.ReplaceAllString(text, cfg.Replacement)
+	}, nil
+}
+
+// newLengthFilter drops tokens shorter than Min or longer than Max
+// characters; a zero Max means no upper limit.
+func newLengthFilter(cfg config.FilterConfig) (TokenFilter, error) {
+	if cfg.Max > 0 && cfg.Max < cfg.Min {
+		return nil, fmt.Errorf("max %d is below min %d", cfg.Max, cfg.Min)
+	}
+	return dropTokens(func(token string) bool {
+		n := utf8.RuneCountInString(token)
+		return n < cfg.Min || (cfg.Max > 0 && n > cfg.Max)
+	}), nil
+}
+
+// newStopFilter drops stop words. The list is read from File when set and
+// is otherwise the built-in one for Lang (English by default); Words are
+// then added to it and Remove taken out.
+func newStopFilter(cfg config.FilterConfig) (TokenFilter, error) {
+	var words map[string]bool
+	var err error
+	switch {
+	case cfg.File != "":
+		words, err = loadWordFile(cfg.File)
+	case cfg.Lang != "":
+		words, err = loadStopWords(cfg.Lang)
+	default:
+		words, err = loadStopWords("en")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range cfg.Words {
+		words[normalizeWord(w)] = true
+	}
+	for _, w := range cfg.Remove {
+		delete(words, normalizeWord(w))
+	}
+	return dropTokens(func(token string) bool { return words[token] }), nil
+}
+
+// newPatternFilter drops tokens matching Pattern.
+func newPatternFilter(cfg config.FilterConfig) (TokenFilter, error) {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return dropTokens(re.MatchString), nil
+}
+
+// newEdgeNGramExpander expands each token to its leading Min to Max
+// character prefixes (2 and 10 by default), so a query for a prefix matches
+// the indexed gram instead of scanning the terms table.
+func newEdgeNGramExpander(cfg config.FilterConfig, _ func(string) [][]string) (Expander, error) {
+	min, max := defaultMinGram, defaultMaxGram
+	if cfg.Min > 0 {
+		min = cfg.Min
+	}
+	if cfg.Max > 0 {
+		max = cfg.Max
+	}
+	if max < min {
+		return nil, fmt.Errorf("max %d is below min %d", max, min)
+	}
+	return func(token string) []string {
+		runes := []rune(token)
+		var grams []string
+		for n := min; n <= max && n < len(runes); n++ {
+			grams = append(grams, string(runes[:n]))
+		}
+		return grams
+	}, nil
+}
+
+func newLemmaFilter(cfg config.FilterConfig) (TokenFilter, error) {
+	lang := cfg.Lang
+	if lang == "" {
+		lang = "en"
+	}
+	lemmas, err := lemmatizer.New(lang)
+	if err != nil {
+		return nil, err
+	}
+	return lemmas.LemmatizeTokens, nil
+}
@@ -0,0 +1,90 @@
+package textproc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Span is the byte range [Start, End) of the text a term came from. Start is
+// -1 for terms that can't be traced back, like text a pattern_replace char
+// filter made up.
+type Span struct {
+	Start int32
+	End   int32
+}
+
+// AnalyzeFieldSpans is AnalyzeField that also returns, for each position,
+// the span of text it was analyzed from. Token filters are applied one token
+// at a time to keep track of which token each position came from; every
+// token a filter turns one token into keeps that token's span. That gives
+// the same positions as AnalyzeField for any filter that handles each token
+// on its own, which all the built-in ones do.
+func (a *Analyzer) AnalyzeFieldSpans(field, text string) ([][]string, []Span) {
+	if a == nil {
+		a = defaultAnalyzer
+	}
+	filtered := text
+	for _, f := range a.charFilters {
+		filtered = f(filtered)
+	}
+	tokens := a.tokenizer(filtered)
+	spans := alignTokens(text, tokens)
+
+	// A filter may turn a token into several, so these can outgrow the
+	// tokens they are built from and don't reuse their arrays.
+	kept := make([]string, 0, len(tokens))
+	keptSpans := make([]Span, 0, len(spans))
+	for i, token := range tokens {
+		out := []string{token}
+		for _, f := range a.tokenFilters {
+			if f.stage != "" && f.stage != StageIndex {
+				continue
+			}
+			if out = f.filter(out); len(out) == 0 {
+				break
+			}
+		}
+		for _, t := range out {
+			kept = append(kept, t)
+			keptSpans = append(keptSpans, spans[i])
+		}
+	}
+	return a.expand(kept, StageIndex, []string{field}, true), keptSpans
+}
+
+// alignTokens finds each token, in order, in a lower-cased and ASCII-folded
+// copy of text, which is what the default char filters leave of it.
+func alignTokens(text string, tokens []string) []Span {
+	var folded strings.Builder
+	// start and end map each byte of folded to the rune of text it came
+	// from.
+	var start, end []int32
+	for i, r := range text {
+		size := utf8.RuneLen(r)
+		if r == utf8.RuneError {
+			size = 1
+		}
+		f := asciiFold(strings.ToLower(string(r)))
+		folded.WriteString(f)
+		for range len(f) {
+			start = append(start, int32(i))
+			end = append(end, int32(i+size))
+		}
+	}
+
+	haystack := folded.String()
+	spans := make([]Span, len(tokens))
+	cursor := 0
+	for i, token := range tokens {
+		idx := strings.Index(haystack[cursor:], token)
+		if idx < 0 || token == "" {
+			spans[i] = Span{Start: -1, End: -1}
+			continue
+		}
+		s := cursor + idx
+		e := s + len(token)
+		spans[i] = Span{Start: start[s], End: end[e-1]}
+		cursor = e
+	}
+	return spans
+}
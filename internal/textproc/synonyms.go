@@ -0,0 +1,71 @@
+package textproc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/config"
+)
+
+// newSynonymExpander expands a token to the other words of its synonym
+// groups. Groups are comma-separated lines in File ("film, movie, picture",
+// # comments) and entries of Words in the same form. Words are analyzed with
+// the rest of the pipeline, so "movies" is stored as the stemmed "movi",
+// along with its form for every field's morphology. Words that don't come
+// out as exactly one position, like stop words or phrases, are left out.
+func newSynonymExpander(cfg config.FilterConfig, analyze func(string) [][]string) (Expander, error) {
+	groups := cfg.Words
+	if cfg.File != "" {
+		lines, err := readSynonymFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(lines, groups...)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no synonym groups: set File or Words")
+	}
+
+	synonyms := make(map[string][]string)
+	for _, group := range groups {
+		var terms []string
+		for _, word := range strings.Split(group, ",") {
+			analyzed := analyze(word)
+			if len(analyzed) != 1 {
+				continue
+			}
+			terms = append(terms, analyzed[0]...)
+		}
+		for _, term := range terms {
+			for _, other := range terms {
+				if other != term {
+					synonyms[term] = append(synonyms[term], other)
+				}
+			}
+		}
+	}
+	return func(token string) []string { return synonyms[token] }, nil
+}
+
+func readSynonymFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open synonyms: %w", err)
+	}
+	defer f.Close()
+	var groups []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		groups = append(groups, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read synonyms %s: %w", path, err)
+	}
+	return groups, nil
+}
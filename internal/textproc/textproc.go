@@ -0,0 +1,162 @@
+// Package textproc turns raw text into index terms. The crawler, indexer and
+// query engine all go through it, so a query is analyzed exactly the way the
+// documents it should match were.
+package textproc
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/reiver/go-porterstemmer"
+	"golang.org/x/text/unicode/norm"
+)
+
+var stopWords, _ = loadStopWords("en")
+
+// noise is stripped before tokenizing: markup, inline CSS and JSON, hashed
+// asset names, URLs, long number runs, call expressions and wiki-style
+// citations and links.
+var noise = []*regexp.Regexp{
+	regexp.MustCompile(`<[^>]*>`),
+	regexp.MustCompile(`[a-z\-]+:\s*[^;]+;`),
+	regexp.MustCompile(`"[^"]+"\s*:\s*"?[^",}{\[\]]*"?`),
+	regexp.MustCompile(`[a-f0-9]{32,}\.(jpg|jpeg|png|svg|webp)`),
+	regexp.MustCompile(`https?://[^\s"]+`),
+	regexp.MustCompile(`[\d\-_\.]{6,}`),
+	regexp.MustCompile(`[a-z_]+\([^\)]*\)`),
+	regexp.MustCompile(`\[\d+[a-z]*]`),
+	regexp.MustCompile(`\[(.*?)\]\((.*?)\)`),
+}
+
+var (
+	nonAlpha    = regexp.MustCompile(`[^a-z\s]`)
+	nonAlphaNum = regexp.MustCompile(`[^a-z0-9\s]`)
+	hasVowel    = regexp.MustCompile(`[aeiou]`)
+	hasDigit    = regexp.MustCompile(`[0-9]`)
+)
+
+// RemoveInvalidUTF8 drops bytes that aren't valid UTF-8, which Postgres rejects.
+func RemoveInvalidUTF8(s string) string {
+	valid := make([]rune, 0, len(s))
+	for i, r := range s {
+		if r == utf8.RuneError {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			if size == 1 {
+				continue
+			}
+		}
+		valid = append(valid, r)
+	}
+	return string(valid)
+}
+
+func stripNoise(text string) string {
+	for _, r := range noise {
+		text = r.ReplaceAllString(text, " ")
+	}
+	return text
+}
+
+// standardTokenizer splits on anything that isn't a letter a-z.
+func standardTokenizer(text string) []string {
+	return splitASCII(text, nonAlpha)
+}
+
+// alphanumericTokenizer is the standard tokenizer keeping digits too, so
+// years and model numbers like 2024 or rtx4090 become terms.
+func alphanumericTokenizer(text string) []string {
+	return splitASCII(text, nonAlphaNum)
+}
+
+func splitASCII(text string, drop *regexp.Regexp) []string {
+	text = strings.ReplaceAll(text, "-", " ")
+	text = strings.ReplaceAll(text, "/", " ")
+	text = drop.ReplaceAllString(text, " ")
+	return strings.Fields(norm.NFC.String(text))
+}
+
+// Normalize runs the default char filters and the unicode_alphanumeric
+// tokenizer and joins the words back together: lower-cased letters and
+// digits, in any script, separated by single spaces. Digits are kept so an
+// analyzer that indexes numbers still finds them in stored page text.
+func Normalize(text string) string {
+	return strings.Join(unicodeAlphanumericTokenizer(stripNoise(strings.ToLower(RemoveInvalidUTF8(text)))), " ")
+}
+
+// Tokenize splits normalized text into words, dropping stop words, single
+// letters and tokens that look like junk (no vowels, or a letter repeated
+// three times in a row).
+func Tokenize(text string) []string {
+	var filtered []string
+	for _, token := range strings.Fields(text) {
+		if utf8.RuneCountInString(token) <= 1 || stopWords[token] || isJunk(token) {
+			continue
+		}
+		filtered = append(filtered, token)
+	}
+	return filtered
+}
+
+// isJunk only judges Latin words; the vowel test means nothing in other
+// scripts or in numbers and model numbers.
+func isJunk(token string) bool {
+	if !isASCII(token) || hasDigit.MatchString(token) {
+		return false
+	}
+	return !hasVowel.MatchString(token) || hasRepeatedChars(token, 3)
+}
+
+func hasRepeatedChars(token string, n int) bool {
+	if len(token) < n {
+		return false
+	}
+	count := 1
+	prev := rune(token[0])
+	for _, c := range token[1:] {
+		if c == prev {
+			count++
+			if count >= n {
+				return true
+			}
+		} else {
+			count = 1
+			prev = c
+		}
+	}
+	return false
+}
+
+// Stem reduces tokens with the Porter stemmer. Stems that end up a single
+// letter or without a vowel are dropped. Tokens outside ASCII aren't English
+// and tokens with digits aren't words; both pass through unchanged.
+func Stem(tokens []string) []string {
+	var res []string
+	for _, token := range tokens {
+		if !isASCII(token) || hasDigit.MatchString(token) {
+			res = append(res, token)
+			continue
+		}
+		if stemmed, ok := stemWord(token); ok {
+			res = append(res, stemmed)
+		}
+	}
+	return res
+}
+
+// stemWord returns the Porter stem of token, or false when the stem is a
+// single letter or has no vowel.
+func stemWord(token string) (stemmed string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("WARNING: Recovered from panic while stemming token '%s': %v", token, r)
+			ok = false
+		}
+	}()
+	stemmed = porterstemmer.StemString(token)
+	if len(stemmed) <= 1 || !hasVowel.MatchString(stemmed) {
+		return "", false
+	}
+	return stemmed, true
+}
@@ -0,0 +1,189 @@
+package textproc
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//go:embed data/*.txt
+var stopLists embed.FS
+
+// loadStopWords reads the built-in stop word list for lang.
+func loadStopWords(lang string) (map[string]bool, error) {
+	f, err := stopLists.Open("data/" + lang + ".txt")
+	if err != nil {
+		return nil, fmt.Errorf("no stop word list for language %q", lang)
+	}
+	defer f.Close()
+	return readWordList(f)
+}
+
+// loadWordFile reads a user-supplied word list.
+func loadWordFile(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open word list: %w", err)
+	}
+	defer f.Close()
+	words, err := readWordList(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read word list %s: %w", path, err)
+	}
+	return words, nil
+}
+
+// readWordList reads one word per line, skipping blank lines and # comments.
+// Words are lower-cased and NFC-normalized to match analyzed tokens.
+func readWordList(r io.Reader) (map[string]bool, error) {
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words[normalizeWord(line)] = true
+	}
+	return words, scanner.Err()
+}
+
+func normalizeWord(word string) string {
+	return norm.NFC.String(strings.ToLower(strings.TrimSpace(word)))
+}
+
+// unicodeTokenizer splits text into runs of letters and combining marks in
+// any script, so Devanagari vowel signs stay attached to their consonants.
+// Digits and punctuation separate tokens like the standard tokenizer.
+func unicodeTokenizer(text string) []string {
+	return strings.FieldsFunc(norm.NFC.String(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsMark(r)
+	})
+}
+
+// unicodeAlphanumericTokenizer is the unicode tokenizer keeping digits in
+// any script too.
+func unicodeAlphanumericTokenizer(text string) []string {
+	return strings.FieldsFunc(norm.NFC.String(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r)
+	})
+}
+
+func isASCII(token string) bool {
+	for i := 0; i < len(token); i++ {
+		if token[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// Devanagari to Latin, roughly as Hindi is written in romanized form on the
+// web: no diacritics, long and short vowels folded together.
+var (
+	devanagariVowels = map[rune]string{
+		'अ': "a", 'आ': "a", 'इ': "i", 'ई': "i", 'उ': "u", 'ऊ': "u", 'ऋ': "ri",
+		'ए': "e", 'ऐ': "ai", 'ओ': "o", 'औ': "au", 'ऍ': "e", 'ऑ': "o",
+	}
+	devanagariSigns = map[rune]string{
+		'ा': "a", 'ि': "i", 'ी': "i", 'ु': "u", 'ू': "u", 'ृ': "ri",
+		'े': "e", 'ै': "ai", 'ो': "o", 'ौ': "au", 'ॅ': "e", 'ॉ': "o",
+	}
+	devanagariConsonants = map[rune]string{
+		'क': "k", 'ख': "kh", 'ग': "g", 'घ': "gh", 'ङ': "n",
+		'च': "ch", 'छ': "chh", 'ज': "j", 'झ': "jh", 'ञ': "n",
+		'ट': "t", 'ठ': "th", 'ड': "d", 'ढ': "dh", 'ण': "n",
+		'त': "t", 'थ': "th", 'द': "d", 'ध': "dh", 'न': "n",
+		'प': "p", 'फ': "f", 'ब': "b", 'भ': "bh", 'म': "m",
+		'य': "y", 'र': "r", 'ल': "l", 'व': "v",
+		'श': "sh", 'ष': "sh", 'स': "s", 'ह': "h",
+	}
+	// Nukta consonants. NFC leaves them as base consonant plus U+093C, so
+	// they are read as a pair.
+	nuktaConsonants = map[rune]string{
+		'क': "q", 'ख': "kh", 'ग': "g", 'ज': "z", 'ड': "r", 'ढ': "rh", 'फ': "f",
+	}
+)
+
+const (
+	devanagariVirama = '्'
+	devanagariNukta  = '़'
+)
+
+// transliterateDevanagari romanizes a Devanagari token. Consonants carry an
+// inherent "a" unless followed by a vowel sign or virama; like spoken Hindi,
+// the inherent vowel of the last consonant is dropped. Medial ones are kept,
+// so शाहरुख becomes shaharukh. Tokens in other scripts are returned
+// unchanged.
+func transliterateDevanagari(token string) string {
+	if !strings.ContainsFunc(token, func(r rune) bool { return unicode.Is(unicode.Devanagari, r) }) {
+		return token
+	}
+	runes := []rune(token)
+	var b strings.Builder
+	inherent := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if latin, ok := devanagariConsonants[r]; ok {
+			if inherent {
+				b.WriteByte('a')
+			}
+			if i+1 < len(runes) && runes[i+1] == devanagariNukta {
+				if nukta, ok := nuktaConsonants[r]; ok {
+					latin = nukta
+				}
+				i++
+			}
+			b.WriteString(latin)
+			inherent = true
+			continue
+		}
+		switch {
+		case r == devanagariVirama:
+			inherent = false
+		case devanagariSigns[r] != "":
+			b.WriteString(devanagariSigns[r])
+			inherent = false
+		case devanagariVowels[r] != "":
+			if inherent {
+				b.WriteByte('a')
+			}
+			b.WriteString(devanagariVowels[r])
+			inherent = false
+		case r == 'ं' || r == 'ँ':
+			if inherent {
+				b.WriteByte('a')
+			}
+			b.WriteByte('n')
+			inherent = false
+		case r == 'ः':
+			if inherent {
+				b.WriteByte('a')
+			}
+			b.WriteByte('h')
+			inherent = false
+		default:
+			if inherent {
+				b.WriteByte('a')
+			}
+			inherent = false
+			if !unicode.Is(unicode.Devanagari, r) {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+func transliterate(tokens []string) []string {
+	for i, token := range tokens {
+		tokens[i] = transliterateDevanagari(token)
+	}
+	return tokens
+}
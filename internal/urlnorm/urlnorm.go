@@ -0,0 +1,60 @@
+// Package urlnorm holds the URL normalization shared by the crawler's
+// frontier and the indexer.
+package urlnorm
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Normalize lowercases the scheme and host, drops "www." and the fragment,
+// converts the host to ASCII and gives an empty path "/". A URL without a
+// scheme is taken to be https.
+func Normalize(rawUrl string) (string, error) {
+	rawUrl = strings.TrimSpace(rawUrl)
+
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "https://" + rawUrl
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", fmt.Errorf("error parsing URL: %w", err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	host := strings.ToLower(u.Host)
+	host = strings.TrimPrefix(host, "www.")
+
+	p := idna.New(idna.ValidateForRegistration())
+	asciiHost, err := p.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("could not convert host to ASCII: %w", err)
+	}
+	u.Host = asciiHost
+
+	if u.Host != "" && u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+
+	return u.String(), nil
+}
+
+// Canonical is Normalize with http taken to be https, so the variants of a
+// page a site serves over both schemes share one key. The frontier keeps
+// the scheme it found to fetch with; the index stores pages by this key.
+func Canonical(rawUrl string) (string, error) {
+	normalized, err := Normalize(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(normalized, "http://"); ok {
+		return "https://" + rest, nil
+	}
+	return normalized, nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// CrawlJob is a named, isolated crawl. Each job keeps its own frontier and
+// bloom filter in Redis and writes pages to its own Mongo collection, so
+// several jobs can run from the same binary without mixing their results.
+type CrawlJob struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name     string             `bson:"name" json:"name"`
+	Seeds    []string           `bson:"seeds" json:"seeds"`
+	Filters  CrawlJobFilters    `bson:"filters" json:"filters"`
+	MaxDepth int64              `bson:"max_depth" json:"max_depth"`
+	// Schedule is a cron expression for recurring runs; empty means the job
+	// only runs when started by hand.
+	Schedule string `bson:"schedule,omitempty" json:"schedule,omitempty"`
+	// Collection overrides the Mongo collection pages are written to.
+	Collection string `bson:"collection,omitempty" json:"collection,omitempty"`
+
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+	UpdatedAt primitive.DateTime `bson:"updated_at" json:"updated_at"`
+}
+
+// CrawlJobFilters mirrors the crawler's URL filter settings for one job.
+type CrawlJobFilters struct {
+	AllowDomains []string `bson:"allow_domains,omitempty" json:"allow_domains,omitempty"`
+	BlockDomains []string `bson:"block_domains,omitempty" json:"block_domains,omitempty"`
+	AllowPaths   []string `bson:"allow_paths,omitempty" json:"allow_paths,omitempty"`
+	BlockPaths   []string `bson:"block_paths,omitempty" json:"block_paths,omitempty"`
+}
+
+// PagesCollection returns the collection the job's pages are stored in.
+func (j *CrawlJob) PagesCollection() string {
+	if j.Collection != "" {
+		return j.Collection
+	}
+	return "pages_" + j.Name
+}
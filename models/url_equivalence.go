@@ -0,0 +1,14 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// URLEquivalence links one raw URL form to the normalized URL the frontier
+// keys on and the canonical URL the page declares. The raw form is kept so the
+// table can be replayed when normalization rules change.
+type URLEquivalence struct {
+	RawURL        string             `bson:"raw_url" json:"raw_url"`
+	NormalizedURL string             `bson:"normalized_url" json:"normalized_url"`
+	CanonicalURL  string             `bson:"canonical_url,omitempty" json:"canonical_url,omitempty"`
+	FinalURL      string             `bson:"final_url,omitempty" json:"final_url,omitempty"`
+	UpdatedAt     primitive.DateTime `bson:"updated_at" json:"updated_at"`
+}
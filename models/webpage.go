@@ -1,21 +1,84 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type WebPage struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	URL         string             `bson:"url" json:"url"`
-	Title       string             `bson:"title" json:"title"`
-	Description string             `bson:"description" json:"description"`
-	Keywords    []string           `bson:"keywords" json:"keywords"`
-	TokenCount  int                `json:"token_count"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	URL             string             `bson:"url" json:"url"`
+	Title           string             `bson:"title" json:"title"`
+	Description     string             `bson:"description" json:"description"`
+	Keywords        []string           `bson:"keywords" json:"keywords"`
+	TokenCount      int                `json:"token_count"`
+	TitleTokenCount int                `json:"title_token_count"`
+	// FieldLengths are the token counts of each of textproc.Fields, set by
+	// the indexer.
+	FieldLengths []int32 `bson:"-" json:"field_lengths,omitempty"`
+	// PositionGap is how many positions the indexer left between fields.
+	PositionGap int32 `bson:"-" json:"position_gap,omitempty"`
+	// AnchorText is the text of links pointing at the page, set by the
+	// indexer from other pages' Anchors.
+	AnchorText []string `bson:"-" json:"anchor_text,omitempty"`
 
 	Headings      map[string][]string `bson:"headings" json:"headings"`
 	Paragraphs    []string            `bson:"paragraphs" json:"paragraphs"`
 	BodyText      string              `bson:"body_text" json:"body_text"`
 	InternalLinks []string            `bson:"internal_links" json:"internal_links"`
-	ExternalLinks []string            `bson:"external_links" json:"external_links"`
-	ErrorString   string              `bson:"error_string,omitempty" json:"error_string,omitempty"`
-	CreatedAt     primitive.DateTime  `bson:"created_at" json:"created_at"`
-	UpdatedAt     primitive.DateTime  `bson:"updated_at" json:"updated_at"`
+	// Anchors are the internal links with the text they are shown with.
+	Anchors       []Anchor           `bson:"anchors,omitempty" json:"anchors,omitempty"`
+	ExternalLinks []string           `bson:"external_links" json:"external_links"`
+	ErrorString   string             `bson:"error_string,omitempty" json:"error_string,omitempty"`
+	CreatedAt     primitive.DateTime `bson:"created_at" json:"created_at"`
+	UpdatedAt     primitive.DateTime `bson:"updated_at" json:"updated_at"`
+
+	HTMLLang        string `bson:"html_lang,omitempty" json:"html_lang,omitempty"`
+	ContentLanguage string `bson:"content_language,omitempty" json:"content_language,omitempty"`
+	LanguageHint    string `bson:"language_hint,omitempty" json:"language_hint,omitempty"`
+
+	SourceQuality float64 `bson:"source_quality,omitempty" json:"source_quality,omitempty"`
+
+	CanonicalURL string `bson:"canonical_url,omitempty" json:"canonical_url,omitempty"`
+
+	Structured *StructuredData `bson:"structured,omitempty" json:"structured,omitempty"`
+
+	// NoIndex and NoFollow come from the page's robots meta tag or
+	// X-Robots-Tag header.
+	NoIndex  bool `bson:"noindex,omitempty" json:"noindex,omitempty"`
+	NoFollow bool `bson:"nofollow,omitempty" json:"nofollow,omitempty"`
+
+	Fetch *FetchMetadata `bson:"fetch,omitempty" json:"fetch,omitempty"`
+}
+
+type FetchMetadata struct {
+	StatusCode    int               `bson:"status_code" json:"status_code"`
+	FinalURL      string            `bson:"final_url" json:"final_url"`
+	RedirectChain []string          `bson:"redirect_chain,omitempty" json:"redirect_chain,omitempty"`
+	ContentLength int64             `bson:"content_length" json:"content_length"`
+	DurationMs    int64             `bson:"duration_ms" json:"duration_ms"`
+	Headers       map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
+}
+
+// StructuredData holds the OpenGraph and JSON-LD metadata a page publishes
+// about itself.
+type StructuredData struct {
+	OGTitle       string           `bson:"og_title,omitempty" json:"og_title,omitempty"`
+	OGDescription string           `bson:"og_description,omitempty" json:"og_description,omitempty"`
+	OGImage       string           `bson:"og_image,omitempty" json:"og_image,omitempty"`
+	OGType        string           `bson:"og_type,omitempty" json:"og_type,omitempty"`
+	PublishedTime *time.Time       `bson:"published_time,omitempty" json:"published_time,omitempty"`
+	JSONLD        []map[string]any `bson:"json_ld,omitempty" json:"json_ld,omitempty"`
+}
+
+// Anchor is a link's normalized target URL and its visible text.
+type Anchor struct {
+	URL  string `bson:"url" json:"url"`
+	Text string `bson:"text" json:"text"`
+}
+
+// IsErrorStatus reports whether the page was fetched with a 4xx/5xx status.
+func (p *WebPage) IsErrorStatus() bool {
+	return p.Fetch != nil && p.Fetch.StatusCode >= 400
 }
@@ -4,9 +4,33 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
+const DefaultSourceQuality = 1.0
+
+type Seed struct {
+	URL     string
+	Quality float64
+}
+
 func LoadSeedURLs(filename string) ([]string, error) {
+	seeds, err := LoadSeeds(filename)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(seeds))
+	for i, seed := range seeds {
+		urls[i] = seed.URL
+	}
+	return urls, nil
+}
+
+// LoadSeeds reads the seed CSV. The Domain column is required; an optional
+// Quality column rates the source (e.g. 1.5 for official studio sites, 0.5 for
+// gossip blogs) and defaults to DefaultSourceQuality.
+func LoadSeeds(filename string) ([]Seed, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read seed file %v", err)
@@ -20,22 +44,31 @@ func LoadSeedURLs(filename string) ([]string, error) {
 	if len(records) == 0 {
 		return nil, fmt.Errorf("seed file is empty")
 	}
-	var domainIDX = -1
+	var domainIDX, qualityIDX = -1, -1
 	header := records[0]
 	for i, col := range header {
-		if col == "Domain" {
+		switch col {
+		case "Domain":
 			domainIDX = i
-			break
+		case "Quality":
+			qualityIDX = i
 		}
 	}
 	if domainIDX == -1 {
 		return nil, fmt.Errorf("failed to find the domain col in seed file")
 	}
-	var urls []string
+	var seeds []Seed
 	for _, row := range records[1:] {
-		if len(row) > domainIDX {
-			urls = append(urls, row[domainIDX])
+		if len(row) <= domainIDX {
+			continue
 		}
+		seed := Seed{URL: row[domainIDX], Quality: DefaultSourceQuality}
+		if qualityIDX != -1 && len(row) > qualityIDX {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(row[qualityIDX]), 64); err == nil && q > 0 {
+				seed.Quality = q
+			}
+		}
+		seeds = append(seeds, seed)
 	}
-	return urls, nil
+	return seeds, nil
 }
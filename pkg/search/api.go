@@ -2,24 +2,60 @@ package search
 
 import (
 	"context"
+	"crypto/subtle"
 	"github.com/amankumarsingh77/search_engine/config"
+	"github.com/amankumarsingh77/search_engine/internal/indexer"
 	"github.com/amankumarsingh77/search_engine/internal/query"
+	"github.com/amankumarsingh77/search_engine/internal/telemetry"
+	"github.com/amankumarsingh77/search_engine/internal/textproc"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"strconv"
+	"strings"
+	"time"
 )
 
+var tracer = telemetry.Tracer("search")
+
 type SearchAPI struct {
-	engine *query.QueryEngine
+	engine      *query.QueryEngine
+	adminAPIKey string
+	throttle    *queryThrottle
+	ready       *readiness
+	index       *indexer.Storage
 }
 
-func NewSearchAPI(dbPool *pgxpool.Pool, cfg *config.QueryEngineConfig) *SearchAPI {
-	engine := query.NewQueryEngine(dbPool, cfg)
-	return &SearchAPI{engine: engine}
+func NewSearchAPI(engine *query.QueryEngine, cfg *config.SearchAPIConfig) *SearchAPI {
+	return &SearchAPI{
+		engine:      engine,
+		adminAPIKey: cfg.AdminAPIKey,
+		throttle:    newQueryThrottle(cfg.DuplicateQueryLimit, cfg.DuplicateQueryWindow, cfg.DuplicateQueryMaxDelay),
+		ready:       newReadiness(cfg.WarmCache, cfg.WarmupTimeout),
+	}
+}
+
+// isAdmin reports whether the request carries the configured admin API key.
+// With no key configured, admin-only features are disabled entirely.
+func (api *SearchAPI) isAdmin(c *fiber.Ctx) bool {
+	if api.adminAPIKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Get("X-API-Key")), []byte(api.adminAPIKey)) == 1
 }
 
 func (api *SearchAPI) RegisterRoutes(app *fiber.App) {
 	app.Get("/search", api.searchHandler)
+	app.Get("/v1/search", api.searchV1Handler)
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Get("/readyz", api.readyHandler)
+	app.Post("/warmup", api.warmupHandler)
+	app.Post("/admin/invalidate", api.invalidateHandler)
+	app.Get("/admin/index-stats", api.indexStatsHandler)
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.Render("index", fiber.Map{
 			"Title": "Welcome",
@@ -27,32 +63,182 @@ func (api *SearchAPI) RegisterRoutes(app *fiber.App) {
 	})
 }
 
-func (api *SearchAPI) searchHandler(c *fiber.Ctx) error {
-	queryStr := c.Query("q", "")
+// apiError is a client-facing failure with the HTTP status to send it with.
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+type searchRequest struct {
+	query    string
+	page     int
+	pageSize int
+	opts     query.SearchOptions
+}
+
+// searchPayload is the version-independent outcome of a search, rendered by
+// each API version into its own response shape.
+type searchPayload struct {
+	Query        string
+	Page         int
+	PageSize     int
+	Field        string
+	Total        int
+	TotalPages   int
+	Results      []query.SearchResult
+	Suggestion   string
+	ResponseTime float64
+	CacheStatus  string
+	TimedOut     bool
+}
+
+func (api *SearchAPI) parseSearchRequest(c *fiber.Ctx) (*searchRequest, *apiError) {
+	req := &searchRequest{query: c.Query("q", "")}
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page < 1 {
 		page = 1
 	}
+	req.page = page
 
 	pageSize, err := strconv.Atoi(c.Query("page_size", "10"))
 	if err != nil || pageSize < 1 || pageSize > 100 {
 		pageSize = 10
 	}
+	req.pageSize = pageSize
+
+	req.opts = query.SearchOptions{
+		Field:    c.Query("in", query.FieldAll),
+		Passages: c.QueryBool("passages", false),
+		Sort:     c.Query("sort", query.SortRelevance),
+
+		MinimumShouldMatch: c.Query("minimum_should_match"),
+		AllowPartial:       c.QueryBool("partial", false),
+		Ranker:             c.Query("ranker"),
+	}
+	if req.opts.Field != query.FieldAll && textproc.FieldIndex(req.opts.Field) < 0 {
+		return nil, newAPIError(CodeInvalidQuery, "in must be all or one of "+strings.Join(textproc.Fields, ", "))
+	}
+	if req.opts.Sort != query.SortRelevance && req.opts.Sort != query.SortDate {
+		return nil, newAPIError(CodeInvalidQuery, "sort must be relevance or date")
+	}
+	if err := query.CheckMinimumShouldMatch(req.opts.MinimumShouldMatch); err != nil {
+		return nil, newAPIError(CodeInvalidQuery, err.Error())
+	}
+	if err := query.CheckRanker(req.opts.Ranker); err != nil {
+		return nil, newAPIError(CodeInvalidQuery, err.Error())
+	}
+	if c.Query("cache") == "false" {
+		if !api.isAdmin(c) {
+			return nil, newAPIError(CodeForbidden, "cache=false requires a valid admin API key")
+		}
+		req.opts.BypassCache = true
+	}
+	return req, nil
+}
+
+// runSearch executes req, or replays the client's previous response when it
+// is repeating itself, and sets the cache headers common to every version.
+func (api *SearchAPI) runSearch(c *fiber.Ctx, req *searchRequest) (*searchPayload, *apiError) {
+	key := throttleKey(c.IP(), req.query, strconv.Itoa(req.page), strconv.Itoa(req.pageSize), req.opts.Field, strconv.FormatBool(req.opts.Passages), req.opts.Sort, req.opts.MinimumShouldMatch, strconv.FormatBool(req.opts.AllowPartial), req.opts.Ranker)
+	if !req.opts.BypassCache {
+		if cached, delay, ok := api.throttle.check(key); ok {
+			time.Sleep(delay)
+			c.Set("X-Cache", query.CacheHit)
+			c.Set("X-Throttled", "true")
+			return cached, nil
+		}
+	}
 
-	results, total, timeTaken, err := api.engine.Search(context.Background(), queryStr, page, pageSize)
+	// Continue the caller's trace if it sent a traceparent header.
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(c.GetReqHeaders()))
+	ctx, span := tracer.Start(ctx, c.Method()+" "+c.Path(), trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("search.request_id", requestID(c))))
+	defer span.End()
+	ctx = query.WithRequestID(ctx, requestID(c))
+	resp, err := api.engine.SearchWithOptions(ctx, req.query, req.page, req.pageSize, req.opts)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Search failed: " + err.Error(),
-		})
+		apiErr := classifySearchError(err)
+		span.SetAttributes(attribute.Int("http.response.status_code", apiErr.Status))
+		return nil, apiErr
+	}
+
+	c.Set("X-Cache", resp.CacheStatus)
+	if resp.CacheStatus == query.CacheHit {
+		c.Set("Age", strconv.Itoa(int(resp.CacheAge.Seconds())))
+	} else {
+		c.Set("Age", "0")
+	}
+
+	payload := &searchPayload{
+		Query:        req.query,
+		Page:         req.page,
+		PageSize:     req.pageSize,
+		Field:        req.opts.Field,
+		Total:        resp.Total,
+		TotalPages:   (resp.Total + req.pageSize - 1) / req.pageSize,
+		Results:      resp.Results,
+		Suggestion:   resp.Suggestion,
+		ResponseTime: resp.TimeTaken,
+		CacheStatus:  resp.CacheStatus,
+		TimedOut:     resp.TimedOut,
+	}
+	if !resp.TimedOut {
+		api.throttle.remember(key, payload)
+	}
+	return payload, nil
+}
+
+// searchHandler serves the original, unversioned /search response shape.
+// It is deprecated in favour of /v1/search, but clients can already opt in to
+// the versioned shape here through the Accept header.
+func (api *SearchAPI) searchHandler(c *fiber.Ctx) error {
+	version, ok := negotiateVersion(c.Get(fiber.HeaderAccept))
+	if !ok {
+		return legacyError(c, newAPIError(CodeUnsupportedVersion, "unsupported API version; supported versions: "+supportedVersions()))
+	}
+	if version != "" {
+		return api.searchV1Handler(c)
 	}
+	c.Set("Deprecation", "true")
+	c.Set("Link", `</v1/search>; rel="successor-version"`)
+
+	req, apiErr := api.parseSearchRequest(c)
+	if apiErr == nil {
+		var payload *searchPayload
+		if payload, apiErr = api.runSearch(c, req); apiErr == nil {
+			resp := fiber.Map{
+				"query":         payload.Query,
+				"page":          payload.Page,
+				"page_size":     payload.PageSize,
+				"in":            payload.Field,
+				"total":         payload.Total,
+				"total_pages":   payload.TotalPages,
+				"results":       payload.Results,
+				"response_time": payload.ResponseTime,
+			}
+			if payload.Suggestion != "" {
+				resp["suggestion"] = payload.Suggestion
+			}
+			if payload.TimedOut {
+				resp["timed_out"] = true
+			}
+			return c.JSON(resp)
+		}
+	}
+	return legacyError(c, apiErr)
+}
 
-	return c.JSON(fiber.Map{
-		"query":         queryStr,
-		"page":          page,
-		"page_size":     pageSize,
-		"total":         total,
-		"total_pages":   (total + pageSize - 1) / pageSize,
-		"results":       results,
-		"response_time": timeTaken,
+// legacyError keeps the unversioned "error" string and adds the code and
+// request ID alongside it.
+func legacyError(c *fiber.Ctx, apiErr *apiError) error {
+	return c.Status(apiErr.Status).JSON(fiber.Map{
+		"error":      apiErr.Message,
+		"code":       apiErr.Code,
+		"request_id": requestID(c),
 	})
 }
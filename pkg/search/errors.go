@@ -0,0 +1,80 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/amankumarsingh77/search_engine/internal/query"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Error codes returned to API clients. They are part of the public contract:
+// clients branch on the code, while the message is free text for humans.
+const (
+	CodeInvalidQuery       = "INVALID_QUERY"
+	CodeForbidden          = "FORBIDDEN"
+	CodeUnsupportedVersion = "UNSUPPORTED_VERSION"
+	CodeTimeout            = "TIMEOUT"
+	CodeOverloaded         = "OVERLOADED"
+	CodeBackendUnavailable = "BACKEND_UNAVAILABLE"
+	CodeInternal           = "INTERNAL"
+)
+
+var codeStatus = map[string]int{
+	CodeInvalidQuery:       fiber.StatusBadRequest,
+	CodeForbidden:          fiber.StatusForbidden,
+	CodeUnsupportedVersion: fiber.StatusNotAcceptable,
+	CodeTimeout:            fiber.StatusGatewayTimeout,
+	CodeOverloaded:         fiber.StatusServiceUnavailable,
+	CodeBackendUnavailable: fiber.StatusServiceUnavailable,
+	CodeInternal:           fiber.StatusInternalServerError,
+}
+
+func newAPIError(code, message string) *apiError {
+	status, ok := codeStatus[code]
+	if !ok {
+		status = fiber.StatusInternalServerError
+	}
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+// classifySearchError maps an engine failure onto the error taxonomy. Only
+// the code is derived from err; backend details stay in the server log.
+func classifySearchError(err error) *apiError {
+	var pgErr *pgconn.PgError
+	var connErr *pgconn.ConnectError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, query.ErrInvalidFilter):
+		// The message only quotes the client's own query back.
+		msg := err.Error()
+		if i := strings.Index(msg, query.ErrInvalidFilter.Error()); i >= 0 {
+			msg = msg[i:]
+		}
+		return newAPIError(CodeInvalidQuery, msg)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return newAPIError(CodeTimeout, "the search took too long to complete")
+	case errors.As(err, &pgErr) && pgErr.Code == "57014":
+		// query_canceled is how statement_timeout surfaces.
+		return newAPIError(CodeTimeout, "the search took too long to complete")
+	case errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "53"):
+		// Class 53: insufficient resources, e.g. too_many_connections.
+		return newAPIError(CodeOverloaded, "the search service is overloaded, retry later")
+	case errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "57P"):
+		return newAPIError(CodeBackendUnavailable, "the search index is unavailable")
+	case errors.As(err, &connErr), errors.As(err, &netErr):
+		return newAPIError(CodeBackendUnavailable, "the search index is unavailable")
+	default:
+		return newAPIError(CodeInternal, "the search failed unexpectedly")
+	}
+}
+
+// requestID returns the ID the requestid middleware assigned to the request,
+// which it also echoes in the X-Request-ID response header.
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
@@ -0,0 +1,30 @@
+package search
+
+import (
+	"log"
+
+	"github.com/amankumarsingh77/search_engine/internal/indexer"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetIndexStorage enables /admin/index-stats, read through storage.
+func (api *SearchAPI) SetIndexStorage(storage *indexer.Storage) {
+	api.index = storage
+}
+
+// indexStatsHandler reports index size and growth. It scans postings, so it
+// is admin-only.
+func (api *SearchAPI) indexStatsHandler(c *fiber.Ctx) error {
+	if !api.isAdmin(c) {
+		return legacyError(c, newAPIError(CodeForbidden, "index stats require a valid admin API key"))
+	}
+	if api.index == nil {
+		return legacyError(c, newAPIError(CodeBackendUnavailable, "index stats are not enabled"))
+	}
+	stats, err := api.index.IndexStats(c.UserContext(), c.QueryInt("top"))
+	if err != nil {
+		log.Printf("index stats failed: %v", err)
+		return legacyError(c, classifySearchError(err))
+	}
+	return c.JSON(stats)
+}
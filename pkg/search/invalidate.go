@@ -0,0 +1,23 @@
+package search
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+type invalidateRequest struct {
+	DocIDs []int64 `json:"doc_ids"`
+}
+
+// invalidateHandler drops cached data for documents the indexer removed, so
+// deletions show up before the caches would expire on their own.
+func (api *SearchAPI) invalidateHandler(c *fiber.Ctx) error {
+	if !api.isAdmin(c) {
+		return legacyError(c, newAPIError(CodeForbidden, "cache invalidation requires a valid admin API key"))
+	}
+	var req invalidateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return legacyError(c, newAPIError(CodeInvalidQuery, "body must be a JSON object with doc_ids"))
+	}
+	api.engine.InvalidateDocuments(req.DocIDs)
+	return c.JSON(fiber.Map{"invalidated": len(req.DocIDs)})
+}
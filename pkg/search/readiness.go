@@ -0,0 +1,84 @@
+package search
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultWarmupTimeout = 2 * time.Minute
+	warmupTopTerms       = 1000
+)
+
+// readiness gates /readyz until the node can serve at acceptable latency:
+// corpus statistics are loaded and the cache warm-up has finished. Past the
+// warm-up timeout only the statistics are required, so a slow warm-up never
+// keeps the node out of rotation for good.
+type readiness struct {
+	warmed   atomic.Bool
+	warming  atomic.Bool
+	deadline time.Time
+}
+
+func newReadiness(warmCache bool, timeout time.Duration) *readiness {
+	if timeout <= 0 {
+		timeout = defaultWarmupTimeout
+	}
+	r := &readiness{deadline: time.Now().Add(timeout)}
+	r.warmed.Store(!warmCache)
+	return r
+}
+
+// Warmup warms the engine's caches in the background. It is a no-op while a
+// warm-up is already running and reports whether one was started.
+func (api *SearchAPI) Warmup(ctx context.Context) bool {
+	if !api.ready.warming.CompareAndSwap(false, true) {
+		return false
+	}
+	go func() {
+		defer api.ready.warming.Store(false)
+		start := time.Now()
+		log.Println("Warming up query cache...")
+		if err := api.engine.WarmCache(ctx, warmupTopTerms); err != nil {
+			log.Printf("Cache warm-up failed: %v", err)
+		} else {
+			log.Printf("Cache warm-up finished in %v", time.Since(start))
+		}
+		api.ready.warmed.Store(true)
+	}()
+	return true
+}
+
+func (api *SearchAPI) isReady() (bool, string) {
+	if !api.engine.StatsLoaded() {
+		return false, "index statistics not loaded"
+	}
+	if api.ready.warmed.Load() {
+		return true, "ready"
+	}
+	if time.Now().After(api.ready.deadline) {
+		return true, "cache warm-up timed out"
+	}
+	return false, "cache warm-up in progress"
+}
+
+func (api *SearchAPI) readyHandler(c *fiber.Ctx) error {
+	ready, reason := api.isReady()
+	status := fiber.StatusOK
+	if !ready {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(fiber.Map{"ready": ready, "reason": reason})
+}
+
+func (api *SearchAPI) warmupHandler(c *fiber.Ctx) error {
+	if !api.isAdmin(c) {
+		return legacyError(c, newAPIError(CodeForbidden, "warmup requires a valid admin API key"))
+	}
+	started := api.Warmup(context.Background())
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"started": started})
+}
@@ -0,0 +1,124 @@
+package search
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDuplicateQueryWindow   = time.Minute
+	defaultDuplicateQueryMaxDelay = 5 * time.Second
+	duplicateQueryBaseDelay       = 100 * time.Millisecond
+)
+
+type throttleEntry struct {
+	count    int
+	first    time.Time
+	response *searchPayload
+}
+
+// queryThrottle spots a single client repeating the exact same request within
+// a short window, which is what scripted loops look like. Past the limit the
+// client is answered from its last response after a delay that doubles with
+// every further repeat, so the query never reaches the engine again and
+// clients issuing a query a handful of times are not affected at all.
+type queryThrottle struct {
+	limit    int
+	window   time.Duration
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+// newQueryThrottle returns nil when limit is not positive, which disables
+// throttling.
+func newQueryThrottle(limit int, window, maxDelay time.Duration) *queryThrottle {
+	if limit <= 0 {
+		return nil
+	}
+	if window <= 0 {
+		window = defaultDuplicateQueryWindow
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultDuplicateQueryMaxDelay
+	}
+	t := &queryThrottle{
+		limit:    limit,
+		window:   window,
+		maxDelay: maxDelay,
+		entries:  make(map[string]*throttleEntry),
+	}
+	go t.cleanup()
+	return t
+}
+
+// throttleKey identifies a client's request by its parts with their
+// whitespace collapsed. Case is kept: AND, OR, NOT and NEAR are operators
+// only in capitals, so queries differing in case can match differently.
+func throttleKey(client string, parts ...string) string {
+	parts = append([]string{client}, parts...)
+	for i := range parts {
+		parts[i] = strings.Join(strings.Fields(parts[i]), " ")
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// check records a request and, once the client is over the limit, returns
+// the response to replay and how long to hold it back. ok is false when the
+// request should be executed normally.
+func (t *queryThrottle) check(key string) (response *searchPayload, delay time.Duration, ok bool) {
+	if t == nil {
+		return nil, 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	e, found := t.entries[key]
+	if !found || now.Sub(e.first) > t.window {
+		t.entries[key] = &throttleEntry{count: 1, first: now}
+		return nil, 0, false
+	}
+	e.count++
+	if e.count <= t.limit || e.response == nil {
+		return nil, 0, false
+	}
+
+	delay = t.maxDelay
+	if excess := e.count - t.limit - 1; excess < 32 {
+		if d := duplicateQueryBaseDelay << excess; d < t.maxDelay {
+			delay = d
+		}
+	}
+	return e.response, delay, true
+}
+
+// remember stores the response served for key so later repeats can replay it.
+func (t *queryThrottle) remember(key string, response *searchPayload) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if e, ok := t.entries[key]; ok {
+		e.response = response
+	}
+	t.mu.Unlock()
+}
+
+func (t *queryThrottle) cleanup() {
+	ticker := time.NewTicker(t.window)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		t.mu.Lock()
+		for key, e := range t.entries {
+			if now.Sub(e.first) > t.window {
+				delete(t.entries, key)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,74 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	apiVersionV1   = "v1"
+	vendorMimeBase = "application/vnd.searchyfy."
+)
+
+var apiVersions = []string{apiVersionV1}
+
+// envelope is the response shape of every versioned endpoint. New response
+// sections (facets, suggestions, explain) go into meta or data without
+// changing the top-level shape.
+type envelope struct {
+	Data   any            `json:"data"`
+	Meta   map[string]any `json:"meta"`
+	Errors []*apiError    `json:"errors,omitempty"`
+}
+
+// negotiateVersion picks the API version requested through the Accept header
+// (application/vnd.searchyfy.v1+json). It returns "" when no versioned media
+// type was asked for, and ok=false when only unknown versions were.
+func negotiateVersion(accept string) (version string, ok bool) {
+	requested := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if !strings.HasPrefix(mediaType, vendorMimeBase) {
+			continue
+		}
+		requested = true
+		v := strings.TrimSuffix(strings.TrimPrefix(mediaType, vendorMimeBase), "+json")
+		for _, known := range apiVersions {
+			if v == known {
+				return v, true
+			}
+		}
+	}
+	return "", !requested
+}
+
+func supportedVersions() string {
+	return strings.Join(apiVersions, ", ")
+}
+
+func (api *SearchAPI) searchV1Handler(c *fiber.Ctx) error {
+	c.Set("API-Version", apiVersionV1)
+	meta := map[string]any{"api_version": apiVersionV1, "request_id": requestID(c)}
+
+	req, apiErr := api.parseSearchRequest(c)
+	if apiErr == nil {
+		var payload *searchPayload
+		if payload, apiErr = api.runSearch(c, req); apiErr == nil {
+			meta["query"] = payload.Query
+			meta["page"] = payload.Page
+			meta["page_size"] = payload.PageSize
+			meta["in"] = payload.Field
+			meta["total"] = payload.Total
+			meta["total_pages"] = payload.TotalPages
+			meta["response_time"] = payload.ResponseTime
+			meta["cache"] = payload.CacheStatus
+			meta["timed_out"] = payload.TimedOut
+			if payload.Suggestion != "" {
+				meta["suggestion"] = payload.Suggestion
+			}
+			return c.JSON(envelope{Data: payload.Results, Meta: meta}, vendorMimeBase+apiVersionV1+"+json")
+		}
+	}
+	return c.Status(apiErr.Status).JSON(envelope{Meta: meta, Errors: []*apiError{apiErr}})
+}